@@ -2,13 +2,23 @@ package gophersocket
 
 import (
 	"errors"
+	"sort"
 	"sync"
 )
 
 var (
 	ErrorWaiterNotFound = errors.New("Waiter not found")
+	ErrorAckCancelled   = errors.New("Ack cancelled")
 )
 
+/**
+Waiter of ack response, cancel gets error if the wait is cancelled
+*/
+type ackWaiter struct {
+	result chan string
+	cancel chan error
+}
+
 /**
 Processes functions that require answers, also known as acknowledge or ack
 */
@@ -33,8 +43,11 @@ func (a *ackProcessor) getNextId() int {
 Just before the ack function called, the waiter should be added
 to wait and receive response to ack call
 */
-func (a *ackProcessor) addWaiter(id int, w chan string) {
-	a.resultWaitersMap.Store(id, w)
+func (a *ackProcessor) addWaiter(id int, w chan string) <-chan error {
+	cancel := make(chan error, 1)
+	a.resultWaitersMap.Store(id, ackWaiter{result: w, cancel: cancel})
+
+	return cancel
 }
 
 /**
@@ -49,7 +62,49 @@ check if waiter with given ack id is exists, and returns it
 */
 func (a *ackProcessor) getWaiter(id int) (chan string, error) {
 	if waiter, ok := a.resultWaitersMap.Load(id); ok {
-		return waiter.(chan string), nil
+		return waiter.(ackWaiter).result, nil
 	}
 	return nil, ErrorWaiterNotFound
 }
+
+/**
+Get ids of acks waiting for response, in ascending order
+*/
+func (a *ackProcessor) pendingIds() []int {
+	var ids []int
+	a.resultWaitersMap.Range(func(id, _ interface{}) bool {
+		ids = append(ids, id.(int))
+		return true
+	})
+	sort.Ints(ids)
+
+	return ids
+}
+
+/**
+Remove waiter and release it with given error
+*/
+func (a *ackProcessor) cancelWaiter(id int, err error) error {
+	waiter, ok := a.resultWaitersMap.LoadAndDelete(id)
+	if !ok {
+		return ErrorWaiterNotFound
+	}
+	waiter.(ackWaiter).cancel <- err
+
+	return nil
+}
+
+/**
+Get ids of ack requests sent by the channel and waiting for response
+*/
+func (c *Channel) PendingAcks() []int {
+	return c.ack.pendingIds()
+}
+
+/**
+Stop waiting for response to ack request with given id, the waiting
+call returns ErrorAckCancelled. Late response is ignored
+*/
+func (c *Channel) CancelAck(id int) error {
+	return c.ack.cancelWaiter(id, ErrorAckCancelled)
+}
@@ -0,0 +1,73 @@
+package gophersocket
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type ackTestResult struct {
+	event  string
+	result string
+	err    error
+}
+
+func TestPendingAcksCancel(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	results := make(chan ackTestResult, 3)
+	ids := map[string]int{}
+	for _, event := range []string{"a", "b", "c"} {
+		go func(event string) {
+			result, err := h.Channel.Ack(event, nil, 5*time.Second)
+			results <- ackTestResult{event, result, err}
+		}(event)
+
+		id, err := strconv.Atoi(waitAckRequest(t, h, event))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[event] = id
+	}
+
+	pending := h.Channel.PendingAcks()
+	if fmt.Sprint(pending) != fmt.Sprint([]int{ids["a"], ids["b"], ids["c"]}) {
+		t.Fatal("pending", pending, ids)
+	}
+
+	if err := h.Channel.CancelAck(ids["b"]); err != nil {
+		t.Fatal(err)
+	}
+	res := <-results
+	if res.event != "b" || !errors.Is(res.err, ErrorAckCancelled) {
+		t.Fatal(res)
+	}
+	if err := h.Channel.CancelAck(ids["b"]); !errors.Is(err, ErrorWaiterNotFound) {
+		t.Fatal("cancelled twice:", err)
+	}
+
+	//late response of cancelled ack is ignored, others still complete
+	if err := h.Feed(fmt.Sprintf(`43%d["late"]`, ids["b"])); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(fmt.Sprintf(`43%d["ok"]`, ids["a"])); err != nil {
+		t.Fatal(err)
+	}
+	res = <-results
+	if res.event != "a" || res.err != nil || res.result != `"ok"` {
+		t.Fatal(res)
+	}
+
+	pending = h.Channel.PendingAcks()
+	if len(pending) != 1 || pending[0] != ids["c"] {
+		t.Fatal("pending", pending)
+	}
+	h.Channel.CancelAck(ids["c"])
+	if res = <-results; res.event != "c" || !errors.Is(res.err, ErrorAckCancelled) {
+		t.Fatal(res)
+	}
+	if pending = h.Channel.PendingAcks(); len(pending) != 0 {
+		t.Fatal("pending", pending)
+	}
+}
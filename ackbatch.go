@@ -0,0 +1,209 @@
+package gophersocket
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/codec"
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Event carrying batches flushed before the handler returns,
+	the last batch is sent as the ack itself
+	*/
+	AckBatchEvent = "__ack_batch"
+
+	DefaultAckBatchSize     = 100
+	DefaultAckBatchInterval = 100 * time.Millisecond
+)
+
+var (
+	ErrorAckBatchClosed = errors.New("Ack batch already flushed")
+)
+
+/**
+Result of one sub operation
+*/
+type AckBatchEntry struct {
+	Index  int         `json:"index"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+/**
+Payload of batch flushed by AckBatcher, entries are ordered by index.
+Final is set on the last batch of the event, Error on handler panic
+*/
+type AckBatch struct {
+	Id      int             `json:"id"`
+	Results []AckBatchEntry `json:"results"`
+	Final   bool            `json:"final,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type ackBatching struct {
+	size     int
+	interval time.Duration
+}
+
+/**
+Set thresholds of AckBatcher: batch is flushed when it has size results,
+or interval after its first result. Zero or negative values use defaults
+*/
+func (m *methods) SetAckBatching(size int, interval time.Duration) {
+	m.ackBatching.Store(ackBatching{size: size, interval: interval})
+}
+
+func (m *methods) getAckBatching() ackBatching {
+	b, _ := m.ackBatching.Load().(ackBatching)
+	if b.size <= 0 {
+		b.size = DefaultAckBatchSize
+	}
+	if b.interval <= 0 {
+		b.interval = DefaultAckBatchInterval
+	}
+
+	return b
+}
+
+/**
+Collector of sub results of one event, sending them in a few batches
+instead of one message per result. Safe for concurrent use
+*/
+type AckBatcher struct {
+	c        *Channel
+	id       int
+	settings ackBatching
+
+	pending []AckBatchEntry
+	timer   Timer
+	closed  bool
+	lock    sync.Mutex
+}
+
+/**
+Get batcher of the event, created on the first call. Results left when
+handlers return are sent as the ack, unless a handler returned a value
+*/
+func (e *EventContext) AckBatcher() *AckBatcher {
+	if e.batcher == nil {
+		e.batcher = &AckBatcher{
+			c:        e.channel,
+			id:       e.ackId,
+			settings: e.channel.shared.getAckBatching(),
+		}
+	}
+
+	return e.batcher
+}
+
+/**
+Add result of sub operation with given index, returns ErrorAckBatchClosed
+if handlers of the event already returned
+*/
+func (b *AckBatcher) Add(index int, result interface{}) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return ErrorAckBatchClosed
+	}
+
+	b.pending = append(b.pending, AckBatchEntry{Index: index, Result: result})
+	if len(b.pending) >= b.settings.size {
+		b.flushLocked()
+		return nil
+	}
+	if b.timer == nil {
+		b.timer = b.c.clock().AfterFunc(b.settings.interval, b.Flush)
+	}
+
+	return nil
+}
+
+/**
+Send results collected so far as AckBatchEvent
+*/
+func (b *AckBatcher) Flush() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.closed {
+		b.flushLocked()
+	}
+}
+
+func (b *AckBatcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+
+	b.c.Emit(AckBatchEvent, b.take())
+}
+
+/**
+Take pending results as batch ordered by index
+*/
+func (b *AckBatcher) take() AckBatch {
+	results := b.pending
+	b.pending = nil
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+	if results == nil {
+		results = []AckBatchEntry{}
+	}
+
+	return AckBatch{Id: b.id, Results: results}
+}
+
+/**
+Close the batcher after handlers returned, and get the last batch
+*/
+func (b *AckBatcher) finish(err error) AckBatch {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.closed = true
+
+	batch := b.take()
+	batch.Final = true
+	if err != nil {
+		batch.Error = err.Error()
+	}
+
+	return batch
+}
+
+/**
+Send the last batch of the event: as the ack, if it was requested and
+handlers returned no value, otherwise as AckBatchEvent
+*/
+func (m *methods) finishAckBatch(ctx *EventContext, msg *protocol.Message, res *dispatchResult, shared codec.Codec) {
+	if ctx.batcher == nil {
+		return
+	}
+
+	var err error
+	if ctx.panicked {
+		err = ErrorCallerPanic
+	}
+	batch := ctx.batcher.finish(err)
+
+	if msg.Type == protocol.MessageTypeAckRequest && !res.hasResult {
+		res.value, res.codec, res.hasResult = batch, shared, true
+		return
+	}
+	ctx.channel.Emit(AckBatchEvent, batch)
+}
@@ -0,0 +1,74 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAckBatcherSizeFlush(t *testing.T) {
+	s := newTestServer()
+	s.SetAckBatching(2, time.Hour)
+	s.On("job", func(ctx *EventContext) {
+		batcher := ctx.AckBatcher()
+		batcher.Add(3, "c")
+		batcher.Add(1, "a")
+		batcher.Add(2, "b")
+	})
+	h := newOpenHarness(s)
+
+	if err := h.Feed(`425["job"]`); err != nil {
+		t.Fatal(err)
+	}
+	//full batch is flushed ordered by index, the rest is the ack
+	expectFrames(t, h,
+		`42["__ack_batch",{"id":5,"results":[{"index":1,"result":"a"},{"index":3,"result":"c"}]}]`,
+		`435[{"id":5,"results":[{"index":2,"result":"b"}],"final":true}]`)
+}
+
+func TestAckBatcherIntervalFlush(t *testing.T) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	s.SetAckBatching(10, time.Second)
+	release := make(chan struct{})
+	s.On("job", func(ctx *EventContext) {
+		ctx.AckBatcher().Add(0, "a")
+		<-release
+	})
+	h := newOpenHarness(s)
+
+	done := make(chan error, 1)
+	go func() { done <- h.Feed(`42["job"]`) }()
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	waitFrame(t, h, `42["__ack_batch",{"id":0,"results":[{"index":0,"result":"a"}]}]`)
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	//without ack request the last batch is an event too
+	expectFrames(t, h, `42["__ack_batch",{"id":0,"results":[],"final":true}]`)
+}
+
+func TestAckBatcherClosedAfterReturn(t *testing.T) {
+	s := newTestServer()
+	var batcher *AckBatcher
+	s.On("job", func(ctx *EventContext) string {
+		batcher = ctx.AckBatcher()
+		batcher.Add(0, "a")
+		return "done"
+	})
+	h := newOpenHarness(s)
+
+	if err := h.Feed(`421["job"]`); err != nil {
+		t.Fatal(err)
+	}
+	//value returned by the handler is the ack, batch goes as event
+	expectFrames(t, h,
+		`42["__ack_batch",{"id":1,"results":[{"index":0,"result":"a"}],"final":true}]`,
+		`431["done"]`)
+	if err := batcher.Add(1, "b"); err != ErrorAckBatchClosed {
+		t.Fatal("add after return", err)
+	}
+}
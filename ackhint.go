@@ -0,0 +1,49 @@
+package gophersocket
+
+import (
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Field of the object appended to ack requests as the last argument,
+	with time in milliseconds the sender waits for the response
+	*/
+	AckTimeoutField = "__timeout"
+)
+
+/**
+Append timeout hint to ack requests sent by Ack and EmitAck with
+deadline, so cooperative peers know how long the response is waited
+for. The sender keeps enforcing its own timeout. Disabled by default,
+as handlers of the peer get one more argument
+*/
+func (m *methods) SetAckTimeoutHint(enabled bool) {
+	m.ackTimeoutHint.Store(enabled)
+}
+
+func (c *Channel) ackTimeoutHint() bool {
+	if c.shared == nil {
+		return false
+	}
+	enabled, _ := c.shared.ackTimeoutHint.Load().(bool)
+	return enabled
+}
+
+/**
+Send ack request, with timeout hint if it is enabled
+*/
+func sendAckRequest(msg *protocol.Message, c *Channel, args interface{}, timeout time.Duration) error {
+	if timeout <= 0 || !c.ackTimeoutHint() {
+		return send(msg, c, args)
+	}
+
+	hint := map[string]int64{AckTimeoutField: int64(timeout / time.Millisecond)}
+	if args == nil {
+		return sendArgs(msg, c, []interface{}{hint})
+	}
+
+	return sendArgs(msg, c, []interface{}{args, hint})
+}
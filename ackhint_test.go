@@ -0,0 +1,64 @@
+package gophersocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+/**
+Pump the harness until a frame is written, returns it
+*/
+func waitAnyFrame(t testing.TB, h *LoopHarness) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		h.Pump()
+		if frames := h.Frames(); len(frames) > 0 {
+			return frames[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no frame written")
+	return ""
+}
+
+func TestAckTimeoutHint(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckTimeoutHint(true)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.Ack("ev", "a", 1500*time.Millisecond)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	if frame := waitAnyFrame(t, h); frame != `421["ev","a",{"__timeout":1500}]` {
+		t.Fatal("frame", frame)
+	}
+
+	//the hint is advisory, the server times out on its own
+	clock.waitTimer(t, 1500*time.Millisecond)
+	clock.Advance(1500 * time.Millisecond)
+	if res := <-results; !errors.Is(res.err, ErrorSendTimeout) {
+		t.Fatal(res)
+	}
+}
+
+func TestAckTimeoutHintDisabled(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	h := newOpenHarness(s)
+
+	go h.Channel.Ack("ev", "a", time.Second)
+	if frame := waitAnyFrame(t, h); frame != `421["ev","a"]` {
+		t.Fatal("frame", frame)
+	}
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+}
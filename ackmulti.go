@@ -0,0 +1,90 @@
+package gophersocket
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"github.com/whiterabb17/gopher-socket/codec"
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+/**
+Send ack request with positional arguments and wait for response until
+ctx is done. Positional ack arguments are decoded into results pointers
+one by one, pointers without argument are set to zero value. If the last
+of results is *[]json.RawMessage, it gets arguments left over
+*/
+func (c *Channel) AckMulti(ctx context.Context, method string, args []interface{}, results ...interface{}) error {
+	msg := protocol.NewAckRequest("", c.ack.getNextId(), method, nil)
+
+	result, err := c.waitAckContext(ctx, msg, func() error {
+		return sendArgs(msg, c, args)
+	})
+	if err != nil {
+		return err
+	}
+
+	return decodeAckResults(c.codec(), result, results)
+}
+
+/**
+Decode positional ack arguments into results
+*/
+func decodeAckResults(cd codec.Codec, result string, results []interface{}) error {
+	var extra *[]json.RawMessage
+	if n := len(results); n > 0 {
+		if e, ok := results[n-1].(*[]json.RawMessage); ok {
+			extra, results = e, results[:n-1]
+		}
+	}
+
+	var parts []json.RawMessage
+	if result != "" {
+		var err error
+		if parts, err = splitArgs(result); err != nil {
+			return err
+		}
+	}
+
+	for i, target := range results {
+		if i < len(parts) {
+			if err := cd.Unmarshal(parts[i], target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if v := reflect.ValueOf(target); v.Kind() == reflect.Ptr && !v.IsNil() {
+			v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		}
+	}
+
+	if extra != nil {
+		*extra = nil
+		if len(parts) > len(results) {
+			*extra = parts[len(results):]
+		}
+	}
+
+	return nil
+}
+
+/**
+Get positional ack arguments from results of a function,
+errors are sent as their message, or null
+*/
+func ackValues(out []reflect.Value) []interface{} {
+	values := make([]interface{}, len(out))
+	for i, v := range out {
+		if v.Type() == errorType {
+			if err, _ := v.Interface().(error); err != nil {
+				values[i] = err.Error()
+			}
+			continue
+		}
+		values[i] = v.Interface()
+	}
+
+	return values
+}
@@ -0,0 +1,161 @@
+package gophersocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiValueHandlerResult(t *testing.T) {
+	s := newTestServer()
+	s.On("pair", func(c *Channel, v string) (int, string) { return 1, v })
+	s.On("check", func(c *Channel, v string) (error, string) {
+		if v == "" {
+			return errors.New("empty"), ""
+		}
+		return nil, v
+	})
+	h := newOpenHarness(s)
+
+	for _, frame := range []string{`421["pair","a"]`, `422["check",""]`, `423["check","b"]`} {
+		if err := h.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//errors go as their message, or null, as in cb(err, data)
+	expectFrames(t, h, `431[1,"a"]`, `432["empty",""]`, `433[null,"b"]`)
+}
+
+func TestAckMulti(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	type result struct {
+		n     int
+		s     string
+		extra []json.RawMessage
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.n, r.s = -1, "x"
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r.err = h.Channel.AckMulti(ctx, "get", []interface{}{"a", 2}, &r.n, &r.s, &r.extra)
+		done <- r
+	}()
+
+	waitFrame(t, h, `421["get","a",2]`)
+	if err := h.Feed(`431[5,"five",true,{"k":1}]`); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil || r.n != 5 || r.s != "five" || len(r.extra) != 2 || string(r.extra[1]) != `{"k":1}` {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestAckMultiMissingArgs(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	done := make(chan error, 1)
+	n, s := 7, "x"
+	var extra []json.RawMessage
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- h.Channel.AckMulti(ctx, "get", nil, &n, &s, &extra)
+	}()
+
+	waitFrame(t, h, `421["get"]`)
+	if err := h.Feed(`431[3]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	//pointers without argument get zero value
+	if n != 3 || s != "" || extra != nil {
+		t.Fatal(n, s, extra)
+	}
+}
+
+func TestMultiValueHandlerTrailingError(t *testing.T) {
+	s := newTestServer()
+	s.On("load", func(c *Channel, v string) (string, error) {
+		if v == "" {
+			return "", errors.New("empty")
+		}
+		return v, nil
+	})
+	h := newOpenHarness(s)
+
+	for _, frame := range []string{`421["load","a"]`, `422["load",""]`} {
+		if err := h.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectFrames(t, h, `431["a",null]`, `432["","empty"]`)
+}
+
+func TestAckMultiMoreArgs(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	done := make(chan error, 1)
+	var n int
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- h.Channel.AckMulti(ctx, "get", nil, &n)
+	}()
+
+	waitFrame(t, h, `421["get"]`)
+	if err := h.Feed(`431[4,"left",true]`); err != nil {
+		t.Fatal(err)
+	}
+	//arguments with no pointer and no *[]json.RawMessage are dropped
+	if err := <-done; err != nil || n != 4 {
+		t.Fatal(err, n)
+	}
+}
+
+func TestAckMultiDecodeError(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	done := make(chan error, 1)
+	var n int
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- h.Channel.AckMulti(ctx, "get", nil, &n)
+	}()
+
+	waitFrame(t, h, `421["get"]`)
+	if err := h.Feed(`431["four"]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("mismatched argument decoded")
+	}
+}
+
+func TestAckMultiTimeout(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var n int
+	if err := h.Channel.AckMulti(ctx, "get", nil, &n); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	//late response is ignored
+	if err := h.Feed(`431[1]`); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,133 @@
+package gophersocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Timeout of Ack called without timeout, if event has no policy
+	*/
+	DefaultAckTimeout = 10 * time.Second
+)
+
+/**
+Timeout and retries of ack requests of one event
+*/
+type AckPolicy struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+/**
+Set policy of ack requests of given event sent by Ack and AckIdempotent.
+Timeout is used when Ack is called with zero timeout, so resolution is:
+explicit timeout, then timeout of the event policy, then DefaultAckTimeout.
+Request timed out is sent again up to retries times after backoff, with
+new ack id and the same idempotency key, if any. Retries stop at once
+when the channel is closed
+*/
+func (m *methods) SetAckPolicy(event string, timeout time.Duration, retries int, backoff time.Duration) {
+	m.ackPolicies.Store(event, AckPolicy{
+		Timeout: timeout,
+		Retries: retries,
+		Backoff: backoff,
+	})
+}
+
+func (m *methods) getAckPolicy(event string) AckPolicy {
+	policy, _ := m.ackPolicies.Load(event)
+	p, _ := policy.(AckPolicy)
+
+	return p
+}
+
+/**
+Run ack attempts following policy of the event
+*/
+func (c *Channel) ackWithPolicy(method string, timeout time.Duration,
+	attempt func(timeout time.Duration) (string, error)) (string, error) {
+
+	var policy AckPolicy
+	if c.shared != nil {
+		policy = c.shared.getAckPolicy(method)
+	}
+	if timeout <= 0 {
+		timeout = policy.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	for retry := 0; ; retry++ {
+		result, err := attempt(timeout)
+		if !errors.Is(err, ErrorSendTimeout) || retry >= policy.Retries {
+			return result, err
+		}
+
+		select {
+		case <-c.closed:
+			return "", ErrorChannelClosed
+		case <-c.clock().After(policy.Backoff):
+		}
+	}
+}
+
+/**
+Send ack request and wait for response until ctx is done, sending it
+again up to maxRetries times when an attempt times out. Attempts wait
+for timeout of the event policy, or DefaultAckTimeout, with backoff of
+the policy between them. Each attempt has new ack id, response to an
+earlier one arriving after its timeout is dropped, so the result always
+answers the last request sent. Returns ErrorSendTimeout when all
+attempts timed out
+*/
+func (c *Channel) EmitAckRetry(ctx context.Context, method string, args interface{}, maxRetries int) (string, error) {
+	var policy AckPolicy
+	if c.shared != nil {
+		policy = c.shared.getAckPolicy(method)
+	}
+	timeout := policy.Timeout
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+
+	for retry := 0; ; retry++ {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		timer := c.clock().AfterFunc(timeout, cancel)
+
+		msg := protocol.NewAckRequest("", c.ack.getNextId(), method, nil)
+		result, err := c.waitAckContext(attemptCtx, msg, func() error {
+			return sendAckRequest(msg, c, args, timeout)
+		})
+		timer.Stop()
+		cancel()
+
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		//attempt context is only canceled by its timer
+		if !errors.Is(err, context.Canceled) {
+			return "", err
+		}
+		if retry >= maxRetries {
+			return "", ErrorSendTimeout
+		}
+
+		select {
+		case <-c.closed:
+			return "", ErrorChannelClosed
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-c.clock().After(policy.Backoff):
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package gophersocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAckPolicyRetries(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", 2*time.Second, 1, 500*time.Millisecond)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		//zero timeout takes the one of the policy
+		result, err := h.Channel.Ack("ev", nil, 0)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	first := waitAckRequest(t, h, "ev")
+	clock.waitTimer(t, 2*time.Second)
+	clock.Advance(2 * time.Second)
+	clock.waitTimer(t, 500*time.Millisecond)
+	clock.Advance(500 * time.Millisecond)
+
+	second := waitAckRequest(t, h, "ev")
+	if second == first {
+		t.Fatal("retry sent with the same ack id", first)
+	}
+
+	//response to the timed out attempt is dropped
+	if err := h.Feed(fmt.Sprintf(`43%s["late"]`, first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(fmt.Sprintf(`43%s["ok"]`, second)); err != nil {
+		t.Fatal(err)
+	}
+	res := <-results
+	if res.err != nil || res.result != `"ok"` {
+		t.Fatal(res)
+	}
+}
+
+func TestAckPolicyRetriesExhausted(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", time.Second, 1, 0)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.Ack("ev", nil, 0)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		waitAckRequest(t, h, "ev")
+		clock.waitTimer(t, time.Second)
+		clock.Advance(time.Second)
+		if attempt == 0 {
+			clock.waitTimer(t, 0)
+			clock.Advance(0)
+		}
+	}
+	if res := <-results; !errors.Is(res.err, ErrorSendTimeout) {
+		t.Fatal(res)
+	}
+}
+
+func TestAckPolicyExplicitTimeoutWins(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", time.Hour, 0, 0)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.Ack("ev", nil, time.Second)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	waitAckRequest(t, h, "ev")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	if res := <-results; !errors.Is(res.err, ErrorSendTimeout) {
+		t.Fatal(res)
+	}
+}
+
+func TestAckPolicyStopsOnClose(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", time.Second, 5, time.Minute)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.Ack("ev", nil, 0)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	waitAckRequest(t, h, "ev")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	//waiting for backoff
+	clock.waitTimer(t, time.Minute)
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+
+	select {
+	case res := <-results:
+		if !errors.Is(res.err, ErrorChannelClosed) {
+			t.Fatal(res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retries not stopped by close")
+	}
+}
+
+func TestEmitAckRetry(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", time.Second, 0, 0)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.EmitAckRetry(context.Background(), "ev", 1, 1)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	first := waitAckRequest(t, h, "ev")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	clock.waitTimer(t, 0)
+	clock.Advance(0)
+
+	second := waitAckRequest(t, h, "ev")
+	if second == first {
+		t.Fatal("retry sent with the same ack id", first)
+	}
+	if err := h.Feed(fmt.Sprintf(`43%s[2]`, second)); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; res.err != nil || res.result != "2" {
+		t.Fatal(res)
+	}
+}
+
+func TestEmitAckRetryLateResponseDropped(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", time.Second, 0, 0)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.EmitAckRetry(context.Background(), "ev", 1, 2)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	first := waitAckRequest(t, h, "ev")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	clock.waitTimer(t, 0)
+	clock.Advance(0)
+	second := waitAckRequest(t, h, "ev")
+
+	if err := h.Feed(fmt.Sprintf(`43%s["late"]`, first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(fmt.Sprintf(`43%s["current"]`, second)); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; res.err != nil || res.result != `"current"` {
+		t.Fatal(res)
+	}
+}
+
+func TestEmitAckRetryExhausted(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("ev", time.Second, 0, 0)
+	h := newOpenHarness(s)
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.EmitAckRetry(context.Background(), "ev", 1, 1)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		waitAckRequest(t, h, "ev")
+		clock.waitTimer(t, time.Second)
+		clock.Advance(time.Second)
+		if attempt == 0 {
+			clock.waitTimer(t, 0)
+			clock.Advance(0)
+		}
+	}
+	if res := <-results; !errors.Is(res.err, ErrorSendTimeout) {
+		t.Fatal(res)
+	}
+}
+
+func TestEmitAckRetryContextCanceled(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	results := make(chan ackTestResult, 1)
+	go func() {
+		result, err := h.Channel.EmitAckRetry(ctx, "ev", 1, 5)
+		results <- ackTestResult{"ev", result, err}
+	}()
+
+	waitAckRequest(t, h, "ev")
+	cancel()
+	if res := <-results; res.err != context.Canceled {
+		t.Fatal(res)
+	}
+}
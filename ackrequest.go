@@ -0,0 +1,106 @@
+package gophersocket
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+var (
+	ErrorThenSignature = errors.New("f should be func(T, error)")
+)
+
+/**
+Ack request built by EmitAck, sent when Then is called:
+
+	c.EmitAck("getData", req).WithAck(ctx).Then(func(resp MyResp, err error) {
+		...
+	})
+*/
+type AckRequest struct {
+	c      *Channel
+	method string
+	args   interface{}
+	ctx    context.Context
+}
+
+/**
+Start building ack request with given event and arguments
+*/
+func (c *Channel) EmitAck(method string, args interface{}) *AckRequest {
+	return &AckRequest{
+		c:      c,
+		method: method,
+		args:   args,
+	}
+}
+
+/**
+Wait for response until ctx is done, without it timeout and retries
+follow policy of the event, see SetAckPolicy
+*/
+func (r *AckRequest) WithAck(ctx context.Context) *AckRequest {
+	r.ctx = ctx
+	return r
+}
+
+/**
+Send the request and call f with first argument of the response decoded
+into T, f is called exactly once in its own goroutine. err is non nil
+on send failure, timeout, ctx done, channel close or decode failure.
+Returns ErrorThenSignature without sending, if f is not func(T, error)
+*/
+func (r *AckRequest) Then(f interface{}) error {
+	fVal := reflect.ValueOf(f)
+	if fVal.Kind() != reflect.Func {
+		return ErrorThenSignature
+	}
+	fType := fVal.Type()
+	if fType.IsVariadic() || fType.NumIn() != 2 || fType.NumOut() != 0 ||
+		fType.In(1) != errorType || !decodableType(fType.In(0)) {
+		return ErrorThenSignature
+	}
+
+	go func() {
+		result := reflect.New(fType.In(0))
+		err := r.wait(result.Interface())
+
+		errVal := reflect.Zero(errorType)
+		if err != nil {
+			errVal = reflect.ValueOf(err)
+		}
+		fVal.Call([]reflect.Value{result.Elem(), errVal})
+	}()
+
+	return nil
+}
+
+/**
+Send the request and decode first response argument into result
+*/
+func (r *AckRequest) wait(result interface{}) error {
+	c := r.c
+
+	var response string
+	var err error
+	if r.ctx != nil {
+		msg := protocol.NewAckRequest("", c.ack.getNextId(), r.method, nil)
+		response, err = c.waitAckContext(r.ctx, msg, func() error {
+			var timeout time.Duration
+			if deadline, ok := r.ctx.Deadline(); ok {
+				timeout = deadline.Sub(c.clock().Now())
+			}
+			return sendAckRequest(msg, c, r.args, timeout)
+		})
+	} else {
+		response, err = c.Ack(r.method, r.args, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	return decodeAckResults(c.codec(), response, []interface{}{result})
+}
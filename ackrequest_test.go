@@ -0,0 +1,133 @@
+package gophersocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type ackTestResponse struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type typedAckResult struct {
+	resp ackTestResponse
+	err  error
+}
+
+func thenTyped(t *testing.T, r *AckRequest) chan typedAckResult {
+	t.Helper()
+
+	results := make(chan typedAckResult, 1)
+	err := r.Then(func(resp ackTestResponse, err error) {
+		results <- typedAckResult{resp, err}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func receiveTyped(t *testing.T, results chan typedAckResult) typedAckResult {
+	t.Helper()
+
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not called")
+	}
+	return typedAckResult{}
+}
+
+func TestEmitAckTyped(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	results := thenTyped(t, h.Channel.EmitAck("getData", "req").WithAck(context.Background()))
+	id := waitAckRequest(t, h, "getData")
+	if err := h.Feed(fmt.Sprintf(`43%s[{"name":"a","count":2}]`, id)); err != nil {
+		t.Fatal(err)
+	}
+
+	res := receiveTyped(t, results)
+	if res.err != nil || res.resp != (ackTestResponse{"a", 2}) {
+		t.Fatal(res)
+	}
+}
+
+func TestEmitAckDecodeError(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	results := thenTyped(t, h.Channel.EmitAck("getData", nil).WithAck(context.Background()))
+	id := waitAckRequest(t, h, "getData")
+	if err := h.Feed(fmt.Sprintf(`43%s["not an object"]`, id)); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := receiveTyped(t, results); res.err == nil {
+		t.Fatal("decode failure not reported")
+	}
+}
+
+func TestEmitAckContextDone(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := thenTyped(t, h.Channel.EmitAck("getData", nil).WithAck(ctx))
+	waitAckRequest(t, h, "getData")
+	cancel()
+
+	if res := receiveTyped(t, results); !errors.Is(res.err, context.Canceled) {
+		t.Fatal(res)
+	}
+}
+
+func TestEmitAckTimeout(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAckPolicy("getData", time.Second, 0, 0)
+	h := newOpenHarness(s)
+
+	//without WithAck the event policy applies
+	results := thenTyped(t, h.Channel.EmitAck("getData", nil))
+	waitAckRequest(t, h, "getData")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+
+	if res := receiveTyped(t, results); !errors.Is(res.err, ErrorSendTimeout) {
+		t.Fatal(res)
+	}
+}
+
+func TestEmitAckChannelClosed(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	results := thenTyped(t, h.Channel.EmitAck("getData", nil).WithAck(context.Background()))
+	waitAckRequest(t, h, "getData")
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+
+	if res := receiveTyped(t, results); res.err == nil {
+		t.Fatal("close not reported")
+	}
+}
+
+func TestEmitAckThenSignature(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	for _, f := range []interface{}{
+		nil,
+		func(resp ackTestResponse) {},
+		func(resp ackTestResponse, err error) error { return nil },
+		func(resp ackTestResponse, other string) {},
+	} {
+		if err := h.Channel.EmitAck("getData", nil).Then(f); err != ErrorThenSignature {
+			t.Fatalf("%T accepted: %v", f, err)
+		}
+	}
+	//nothing sent
+	expectFrames(t, h)
+}
@@ -0,0 +1,139 @@
+package gophersocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	/**
+	Handshakes refused at connection limit, labeled by reason: full
+	when admission queue is full, timeout when no slot freed in time
+	*/
+	MetricAdmissionRejected = "admission_rejected_total"
+
+	/**
+	Handshakes which waited in admission queue
+	*/
+	MetricAdmissionQueued = "admission_queued_total"
+)
+
+var (
+	ErrorServerFull = errors.New("Server is at connection limit")
+)
+
+/**
+Connection slots, handshakes wait for one in bounded queue
+*/
+type admission struct {
+	slots     chan struct{}
+	queued    int32
+	queueSize int32
+	wait      time.Duration
+}
+
+type admissionHolder struct {
+	*admission
+}
+
+/**
+Limit amount of connections served by ServeHTTP and ServeConn. Handshake
+over the limit waits up to wait for a slot to free, up to queueSize
+handshakes wait at once, the rest are refused at once. Refused handshake
+gets 503 response with Retry-After. Handshake whose client goes away
+while waiting leaves the queue. Zero max disables the limit, the new limit
+applies to handshakes after the call, open connections free slots of
+the limit they were admitted by
+*/
+func (s *Server) SetMaxConnections(max, queueSize int, wait time.Duration) {
+	if max <= 0 {
+		s.admission.Store(admissionHolder{})
+		return
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	s.admission.Store(admissionHolder{&admission{
+		slots:     make(chan struct{}, max),
+		queueSize: int32(queueSize),
+		wait:      wait,
+	}})
+}
+
+/**
+Take connection slot, waiting in the queue if there is none,
+returns function freeing it
+*/
+func (s *Server) admit(ctx context.Context) (func(), error) {
+	holder, _ := s.admission.Load().(admissionHolder)
+	a := holder.admission
+	if a == nil {
+		return func() {}, nil
+	}
+	release := func() { <-a.slots }
+
+	select {
+	case a.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if atomic.AddInt32(&a.queued, 1) > a.queueSize {
+		atomic.AddInt32(&a.queued, -1)
+		s.metricAdd(MetricAdmissionRejected, 1, "reason", "full")
+		return nil, ErrorServerFull
+	}
+	defer atomic.AddInt32(&a.queued, -1)
+	s.metricAdd(MetricAdmissionQueued, 1)
+
+	timer := s.getClock().NewTimer(a.wait)
+	defer timer.Stop()
+
+	select {
+	case a.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C():
+		s.metricAdd(MetricAdmissionRejected, 1, "reason", "timeout")
+		return nil, ErrorServerFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/**
+Time client refused at connection limit should wait before retrying
+*/
+func (s *Server) admissionRetry() time.Duration {
+	holder, _ := s.admission.Load().(admissionHolder)
+	if holder.admission == nil || holder.wait < time.Second {
+		return time.Second
+	}
+
+	return holder.wait
+}
+
+/**
+Refuse handshake at connection limit
+*/
+func (s *Server) refuseFull(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", retryAfter(s.admissionRetry()))
+	http.Error(w, ErrorServerFull.Error(), http.StatusServiceUnavailable)
+}
+
+/**
+Refuse handshake of connection accepted outside of net/http
+at connection limit
+*/
+func (s *Server) refuseFullConn(conn net.Conn) {
+	bw := bufio.NewWriter(conn)
+	fmt.Fprintf(bw, "HTTP/1.1 %d %s\r\nRetry-After: %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable), retryAfter(s.admissionRetry()))
+	bw.Flush()
+}
@@ -0,0 +1,145 @@
+package gophersocket
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+type admitResult struct {
+	release func()
+	err     error
+}
+
+func admitAsync(s *Server, ctx context.Context) chan admitResult {
+	result := make(chan admitResult, 1)
+	go func() {
+		release, err := s.admit(ctx)
+		result <- admitResult{release, err}
+	}()
+	return result
+}
+
+/**
+Server with one connection slot, taken, and manual clock
+*/
+func newFullServer(t *testing.T, queueSize int) (*Server, *manualClock, *counterMetrics, func()) {
+	t.Helper()
+
+	clock := newManualClock()
+	metrics := &counterMetrics{}
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetMetrics(metrics)
+	s.SetMaxConnections(1, queueSize, time.Minute)
+
+	release, err := s.admit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, clock, metrics, release
+}
+
+func TestAdmissionQueuedProceeds(t *testing.T) {
+	s, clock, metrics, release := newFullServer(t, 1)
+
+	result := admitAsync(s, context.Background())
+	clock.waitTimer(t, time.Minute)
+	release()
+
+	if res := <-result; res.err != nil {
+		t.Fatal(res.err)
+	}
+	if metrics.get(MetricAdmissionQueued) != 1 {
+		t.Fatal("queued", metrics.get(MetricAdmissionQueued))
+	}
+}
+
+func TestAdmissionTimeout(t *testing.T) {
+	s, clock, metrics, _ := newFullServer(t, 1)
+
+	result := admitAsync(s, context.Background())
+	clock.waitTimer(t, time.Minute)
+	clock.Advance(time.Minute)
+
+	if res := <-result; res.err != ErrorServerFull {
+		t.Fatal(res.err)
+	}
+	if metrics.get(MetricAdmissionRejected, "reason", "timeout") != 1 {
+		t.Fatal("not counted as timeout")
+	}
+}
+
+func TestAdmissionQueueFull(t *testing.T) {
+	s, clock, metrics, release := newFullServer(t, 1)
+
+	queued := admitAsync(s, context.Background())
+	clock.waitTimer(t, time.Minute)
+	if _, err := s.admit(context.Background()); err != ErrorServerFull {
+		t.Fatal(err)
+	}
+	if metrics.get(MetricAdmissionRejected, "reason", "full") != 1 {
+		t.Fatal("not counted as full")
+	}
+
+	release()
+	if res := <-queued; res.err != nil {
+		t.Fatal(res.err)
+	}
+}
+
+func TestAdmissionClientGone(t *testing.T) {
+	s, clock, _, release := newFullServer(t, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gone := admitAsync(s, ctx)
+	clock.waitTimer(t, time.Minute)
+	cancel()
+	if res := <-gone; res.err != context.Canceled {
+		t.Fatal(res.err)
+	}
+
+	//gone handshake left the queue
+	queued := admitAsync(s, context.Background())
+	clock.waitTimer(t, time.Minute)
+	release()
+	if res := <-queued; res.err != nil {
+		t.Fatal(res.err)
+	}
+}
+
+func TestMaxConnectionsRefused(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxConnections(1, 0, 0)
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	first, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Dial(url, transport.GetDefaultWebsocketTransport())
+	if !errors.Is(err, ErrorHandshakeFailed) || !strings.Contains(err.Error(), "503") {
+		t.Fatal("dial over limit", err)
+	}
+
+	//slot is freed once the channel closes on the server
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client, err := Dial(url, transport.GetDefaultWebsocketTransport())
+		if err == nil {
+			client.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slot not freed", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
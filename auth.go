@@ -0,0 +1,144 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	/**
+	Ack request the server sends to demand fresh credentials,
+	answered by the function set with Client.OnAuthRefresh
+	*/
+	AuthRefreshEvent = "__auth_refresh"
+
+	DefaultAuthGrace = 10 * time.Second
+)
+
+var (
+	ErrorUnauthorized   = errors.New("Unauthorized")
+	ErrorAuthNotEnabled = errors.New("Auth verifier not set")
+)
+
+/**
+Set function verifying credentials sent by the client on re-authentication,
+see Channel.RefreshAuth. Client not answering within grace is rejected,
+rejected channel is disconnected with DisconnectUnauthorized after grace,
+unless it re-authenticates meanwhile. Zero grace uses DefaultAuthGrace
+*/
+func (s *Server) SetAuthVerifier(verify func(c *Channel, auth json.RawMessage) error, grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultAuthGrace
+	}
+
+	s.authVerify = verify
+	s.authGrace = grace
+}
+
+/**
+Re-authenticate every channel each d after its last authentication,
+zero disables it, applies to connections open after the call
+*/
+func (s *Server) SetAuthRefreshInterval(d time.Duration) {
+	s.authRefreshInterval = d
+}
+
+/**
+Get time of the last successful authentication: the handshake,
+or the last re-authentication
+*/
+func (c *Channel) AuthenticatedAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.authenticatedAt))
+}
+
+/**
+Demand fresh credentials from the client and verify them. Returns error
+of the verifier, or ErrorUnauthorized if the client did not answer,
+the channel is disconnected after grace period then
+*/
+func (c *Channel) RefreshAuth() error {
+	s := c.server
+	if s == nil {
+		return ErrorServerNotSet
+	}
+	if s.authVerify == nil {
+		return ErrorAuthNotEnabled
+	}
+
+	result, err := c.Ack(AuthRefreshEvent, nil, s.authGrace)
+	if errors.Is(err, ErrorChannelClosed) {
+		return err
+	}
+
+	var auth json.RawMessage
+	if err == nil {
+		parts, splitErr := splitArgs(result)
+		if splitErr == nil && len(parts) > 0 {
+			auth = parts[0]
+		}
+		err = s.authVerify(c, auth)
+	} else {
+		err = ErrorUnauthorized
+	}
+
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+
+	if err != nil {
+		//grace runs from the first rejection, later ones do not extend it
+		if c.authRevoke == nil {
+			cause := err
+			c.authRevoke = c.clock().AfterFunc(s.authGrace, func() {
+				c.disconnect(&s.methods, DisconnectUnauthorized, CloseKicked, cause)
+			})
+		}
+		return err
+	}
+
+	if c.authRevoke != nil {
+		c.authRevoke.Stop()
+		c.authRevoke = nil
+	}
+	atomic.StoreInt64(&c.authenticatedAt, c.clock().Now().UnixNano())
+	return nil
+}
+
+/**
+Mark new channel authenticated and start its re-authentication timer,
+if the interval is set
+*/
+func (s *Server) startAuth(c *Channel) {
+	atomic.StoreInt64(&c.authenticatedAt, s.getClock().Now().UnixNano())
+	if s.authRefreshInterval <= 0 || s.authVerify == nil {
+		return
+	}
+
+	ticker := s.getClock().NewTicker(s.authRefreshInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closed:
+				return
+			case <-ticker.C():
+				c.RefreshAuth()
+			}
+		}
+	}()
+}
+
+/**
+Set function supplying fresh credentials when the server demands them,
+error sends no credentials, so the server rejects the client
+*/
+func (c *Client) OnAuthRefresh(f func() (interface{}, error)) error {
+	return c.On(AuthRefreshEvent, func(ch *Channel) interface{} {
+		auth, err := f()
+		if err != nil {
+			return nil
+		}
+		return auth
+	})
+}
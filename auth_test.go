@@ -0,0 +1,139 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+/**
+Server accepting "fresh" token only, with manual clock
+*/
+func newAuthServer(grace time.Duration) (*Server, *manualClock) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	s.SetAuthVerifier(func(c *Channel, auth json.RawMessage) error {
+		if string(auth) != `"fresh"` {
+			return ErrorUnauthorized
+		}
+		return nil
+	}, grace)
+
+	return s, clock
+}
+
+/**
+Refresh auth of harness channel answering with given token
+*/
+func refreshAuth(t *testing.T, h *LoopHarness, token string) error {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() { result <- h.Channel.RefreshAuth() }()
+	id := waitAckRequest(t, h, AuthRefreshEvent)
+	if err := h.Feed("43" + id + `["` + token + `"]`); err != nil {
+		t.Fatal(err)
+	}
+	return <-result
+}
+
+func TestRefreshAuthAccepted(t *testing.T) {
+	s, clock := newAuthServer(time.Second)
+	h := newOpenHarness(s)
+	if !h.Channel.AuthenticatedAt().Equal(clock.Now()) {
+		t.Fatal("not authenticated at handshake", h.Channel.AuthenticatedAt())
+	}
+
+	clock.Advance(time.Minute)
+	if err := refreshAuth(t, h, "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Channel.AuthenticatedAt().Equal(clock.Now()) {
+		t.Fatal("authenticated at", h.Channel.AuthenticatedAt())
+	}
+}
+
+func TestRefreshAuthRejectedAfterGrace(t *testing.T) {
+	s, clock := newAuthServer(time.Second)
+	h := newOpenHarness(s)
+	authenticated := h.Channel.AuthenticatedAt()
+
+	if err := refreshAuth(t, h, "stale"); err != ErrorUnauthorized {
+		t.Fatal("stale token accepted", err)
+	}
+	if !h.Channel.IsAlive() || !h.Channel.AuthenticatedAt().Equal(authenticated) {
+		t.Fatal("rejected before grace")
+	}
+
+	//second rejection does not extend grace
+	clock.Advance(500 * time.Millisecond)
+	refreshAuth(t, h, "stale")
+	stop := pumpInBackground(h)
+	defer stop()
+	clock.Advance(500 * time.Millisecond)
+	waitClosed(t, h.Channel)
+	expectReason(t, h.Channel, DisconnectUnauthorized, ErrorUnauthorized)
+}
+
+func TestRefreshAuthWithinGrace(t *testing.T) {
+	s, clock := newAuthServer(time.Second)
+	h := newOpenHarness(s)
+
+	refreshAuth(t, h, "stale")
+	if err := refreshAuth(t, h, "fresh"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if !h.Channel.IsAlive() {
+		t.Fatal("disconnected after successful refresh")
+	}
+}
+
+func TestRefreshAuthNotEnabled(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	if err := h.Channel.RefreshAuth(); err != ErrorAuthNotEnabled {
+		t.Fatal(err)
+	}
+}
+
+func TestClientOnAuthRefresh(t *testing.T) {
+	s := newTestServer()
+	s.SetAuthVerifier(func(c *Channel, auth json.RawMessage) error {
+		if string(auth) != `"fresh"` {
+			return ErrorUnauthorized
+		}
+		return nil
+	}, time.Second)
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	client, done := dialTestServer(t, s)
+	defer done()
+	client.OnAuthRefresh(func() (interface{}, error) { return "fresh", nil })
+
+	if err := (<-connected).RefreshAuth(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthRefreshInterval(t *testing.T) {
+	s, clock := newAuthServer(time.Second)
+	s.SetAuthRefreshInterval(time.Minute)
+	h := newOpenHarness(s)
+
+	clock.waitTimer(t, time.Minute)
+	clock.Advance(time.Minute)
+	id := waitAckRequest(t, h, AuthRefreshEvent)
+	if err := h.Feed("43" + id + `["fresh"]`); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !h.Channel.AuthenticatedAt().Equal(clock.Now()) {
+		if time.Now().After(deadline) {
+			t.Fatal("not refreshed", h.Channel.AuthenticatedAt())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
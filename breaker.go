@@ -0,0 +1,231 @@
+package gophersocket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	MetricHandshakesShortCircuited = "handshakes_short_circuited_total"
+)
+
+var (
+	ErrorCircuitOpen = errors.New("Circuit breaker open")
+)
+
+/**
+Failures of one source within the window, and time handshakes
+are refused until
+*/
+type breakerSource struct {
+	failures  []time.Time
+	openUntil time.Time
+}
+
+/**
+Refuses handshakes from sources failing too often
+*/
+type circuitBreaker struct {
+	maxFailures int
+	window      time.Duration
+	cooldown    time.Duration
+	key         func(r *http.Request) string
+
+	sources   map[string]*breakerSource
+	lastSweep int
+	lock      sync.Mutex
+}
+
+/**
+Refuse handshakes from the source with 503 and Retry-After for cooldown,
+once it has maxFailures failed connections within window. Failed are
+handshakes which could not be completed and connections closed with
+DisconnectTransportError, i.e. failed reads or writes, or overflooded
+out queue. Sources are ips by default, see SetCircuitBreakerKey.
+Zero maxFailures disables it. Should be set before serving
+*/
+func (s *Server) SetCircuitBreaker(maxFailures int, window, cooldown time.Duration) {
+	if maxFailures <= 0 {
+		s.breaker = nil
+		return
+	}
+
+	var key func(r *http.Request) string
+	if s.breaker != nil {
+		key = s.breaker.key
+	}
+	s.breaker = &circuitBreaker{
+		maxFailures: maxFailures,
+		window:      window,
+		cooldown:    cooldown,
+		key:         key,
+		sources:     make(map[string]*breakerSource),
+	}
+}
+
+/**
+Set function giving circuit breaker source of handshake request,
+e.g. the one given to SetSessionIDGenerator to key it by sid.
+Nil restores keying by ip. Call it after SetCircuitBreaker
+*/
+func (s *Server) SetCircuitBreakerKey(f func(r *http.Request) string) {
+	if s.breaker != nil {
+		s.breaker.key = f
+	}
+}
+
+/**
+Get circuit breaker source of request, empty if breaker is disabled
+*/
+func (s *Server) breakerKey(remoteAddr string, r *http.Request) string {
+	if s.breaker == nil {
+		return ""
+	}
+	if s.breaker.key != nil && r != nil {
+		if key := s.breaker.key(r); key != "" {
+			return key
+		}
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+/**
+Check if handshakes from source are refused, returns time to retry after
+*/
+func (s *Server) breakerOpen(key string) (time.Duration, bool) {
+	if s.breaker == nil || key == "" {
+		return 0, false
+	}
+
+	b := s.breaker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	src, ok := b.sources[key]
+	if !ok {
+		return 0, false
+	}
+	wait := src.openUntil.Sub(s.getClock().Now())
+	if wait <= 0 {
+		return 0, false
+	}
+
+	return wait, true
+}
+
+/**
+Record failed connection of source, opens the breaker if there are
+too many of them within window
+*/
+func (s *Server) breakerFailure(key string) {
+	if s.breaker == nil || key == "" {
+		return
+	}
+
+	b := s.breaker
+	now := s.getClock().Now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	src, ok := b.sources[key]
+	if !ok {
+		src = &breakerSource{}
+		b.sources[key] = src
+	}
+	src.failures = append(pruneFailures(src.failures, now.Add(-b.window)), now)
+	if len(src.failures) >= b.maxFailures {
+		src.failures = nil
+		src.openUntil = now.Add(b.cooldown)
+	}
+
+	//forget sources quiet for a while, once the map doubled since last time
+	if len(b.sources) > 2*b.lastSweep {
+		for k, src := range b.sources {
+			src.failures = pruneFailures(src.failures, now.Add(-b.window))
+			if len(src.failures) == 0 && !src.openUntil.After(now) {
+				delete(b.sources, k)
+			}
+		}
+		b.lastSweep = len(b.sources)
+	}
+}
+
+/**
+Drop failures older than since
+*/
+func pruneFailures(failures []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(failures) && failures[i].Before(since) {
+		i++
+	}
+
+	return failures[i:]
+}
+
+/**
+Count connection failure of channel to its source, once it is closed
+*/
+func (s *Server) watchBreaker(c *Channel, key string) {
+	if key == "" {
+		return
+	}
+
+	c.OnClosed(func() {
+		if c.CloseReason() == DisconnectTransportError {
+			s.breakerFailure(key)
+		}
+	})
+}
+
+/**
+Seconds for Retry-After header, rounded up
+*/
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+/**
+Refuse handshake request, if its source breaker is open
+*/
+func (s *Server) shortCircuit(w http.ResponseWriter, key string) bool {
+	wait, open := s.breakerOpen(key)
+	if !open {
+		return false
+	}
+
+	s.metricAdd(MetricHandshakesShortCircuited, 1)
+	w.Header().Set("Retry-After", retryAfter(wait))
+	http.Error(w, ErrorCircuitOpen.Error(), http.StatusServiceUnavailable)
+	return true
+}
+
+/**
+Refuse handshake of connection accepted outside of net/http,
+if its source breaker is open
+*/
+func (s *Server) shortCircuitConn(conn net.Conn, key string) bool {
+	wait, open := s.breakerOpen(key)
+	if !open {
+		return false
+	}
+
+	s.metricAdd(MetricHandshakesShortCircuited, 1)
+	bw := bufio.NewWriter(conn)
+	fmt.Fprintf(bw, "HTTP/1.1 %d %s\r\nRetry-After: %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable), retryAfter(wait))
+	bw.Flush()
+	return true
+}
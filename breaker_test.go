@@ -0,0 +1,106 @@
+package gophersocket
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+/**
+Send plain http request to the socket.io endpoint, a failed handshake
+*/
+func failHandshake(t testing.TB, url string) *http.Response {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCircuitBreakerOpensAndCloses(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetCircuitBreaker(3, time.Minute, 30*time.Second)
+	hs, _ := serveTestServer(s)
+	defer hs.Close()
+	url := hs.URL + socketioUrl
+
+	for i := 0; i < 3; i++ {
+		if resp := failHandshake(t, url); resp.Header.Get("Retry-After") != "" {
+			t.Fatalf("refused after %d failures", i)
+		}
+	}
+
+	resp := failHandshake(t, url)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "30" {
+		t.Fatal("not refused:", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	clock.Advance(10 * time.Second)
+	if resp := failHandshake(t, url); resp.Header.Get("Retry-After") != "20" {
+		t.Fatal("retry after", resp.Header.Get("Retry-After"))
+	}
+
+	clock.Advance(20 * time.Second)
+	if resp := failHandshake(t, url); resp.Header.Get("Retry-After") != "" {
+		t.Fatal("refused after cooldown")
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetCircuitBreaker(2, time.Minute, time.Minute)
+
+	s.breakerFailure("src")
+	clock.Advance(2 * time.Minute)
+	s.breakerFailure("src")
+	if _, open := s.breakerOpen("src"); open {
+		t.Fatal("failure out of window counted")
+	}
+
+	s.breakerFailure("src")
+	if _, open := s.breakerOpen("src"); !open {
+		t.Fatal("not open after failures within window")
+	}
+	if _, open := s.breakerOpen("other"); open {
+		t.Fatal("open for other source")
+	}
+}
+
+func TestCircuitBreakerCountsTransportErrors(t *testing.T) {
+	s := newTestServer()
+	s.SetCircuitBreaker(2, time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		h := NewLoopHarness(s)
+		h.FailWrites(errTestWrite)
+		h.Pump()
+		waitClosed(t, h.Channel)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, open := s.breakerOpen("harness"); open {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, open := s.breakerOpen("harness"); !open {
+		t.Fatal("transport errors not counted")
+	}
+
+	//closed on purpose, not a failure
+	other := NewLoopHarnessWithOptions(s, HarnessOptions{RemoteAddr: "10.0.0.1:1000"})
+	closeChannel(other.Channel, other.methods, DisconnectServer, CloseKicked, nil)
+	time.Sleep(10 * time.Millisecond)
+	s.breakerFailure("10.0.0.1")
+	if _, open := s.breakerOpen("10.0.0.1"); open {
+		t.Fatal("server close counted as failure")
+	}
+}
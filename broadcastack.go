@@ -0,0 +1,89 @@
+package gophersocket
+
+import (
+	"context"
+	"sync"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+/**
+Send ack request to every member of the room and wait for their
+responses until ctx is done. Returns results by sid, and sids of members
+which did not respond: timed out, disconnected or failed to send.
+Members with the event muted are skipped
+*/
+func (s *Server) BroadcastAck(ctx context.Context, room, method string, args ...interface{}) (map[string]string, []string, error) {
+	if allowed, err := s.allowBroadcast(room, method); !allowed {
+		return nil, nil, err
+	}
+
+	//arguments are encoded once, only ack id differs between members
+	encoded := protocol.NewAckRequest("", 0, method, nil)
+	if _, err := encodeArgs(s.getCodec(), encoded, args); err != nil {
+		return nil, nil, err
+	}
+
+	members := s.List(room)
+	results := make(map[string]string, len(members))
+	var failed []string
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, c := range members {
+		if c.Muted(method) {
+			continue
+		}
+		wg.Add(1)
+		go func(c *Channel) {
+			defer wg.Done()
+
+			msg := protocol.NewAckRequest("", c.ack.getNextId(), method, nil)
+			msg.Args = encoded.Args
+			result, err := c.waitAckContext(ctx, msg, func() error {
+				command, err := protocol.Encode(msg)
+				if err != nil {
+					return err
+				}
+				return c.enqueue(command)
+			})
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				failed = append(failed, c.Id())
+			} else {
+				results[c.Id()] = result
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return results, failed, nil
+}
+
+/**
+Same as waitAck, but waits until ctx is done or the channel is closed
+*/
+func (c *Channel) waitAckContext(ctx context.Context, msg *protocol.Message, sendFunc func() error) (string, error) {
+	//buffered, so late response does not block when waiter is gone
+	waiter := make(chan string, 1)
+	cancel := c.ack.addWaiter(msg.AckId, waiter)
+	defer c.ack.removeWaiter(msg.AckId)
+
+	if err := sendFunc(); err != nil {
+		return "", err
+	}
+
+	select {
+	case result := <-waiter:
+		return result, nil
+	case err := <-cancel:
+		return "", err
+	case <-c.closed:
+		return "", ErrorChannelClosed
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,103 @@
+package gophersocket
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Wait for ack request of given event written by the harness,
+returns its ack id
+*/
+func waitAckRequest(t testing.TB, h *LoopHarness, event string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		h.Pump()
+		for _, frame := range h.Frames() {
+			i := strings.Index(frame, `["`+event+`"`)
+			if strings.HasPrefix(frame, "42") && i > 2 {
+				return frame[2:i]
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("ack request %s not written", event)
+	return ""
+}
+
+type broadcastAckResult struct {
+	results map[string]string
+	failed  []string
+	err     error
+}
+
+func startBroadcastAck(s *Server, timeout time.Duration, room, event string, args ...interface{}) chan broadcastAckResult {
+	done := make(chan broadcastAckResult, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		results, failed, err := s.BroadcastAck(ctx, room, event, args...)
+		sort.Strings(failed)
+		done <- broadcastAckResult{results, failed, err}
+	}()
+	return done
+}
+
+func TestBroadcastAckTimeout(t *testing.T) {
+	s := newTestServer()
+	var members []*LoopHarness
+	for _, sid := range []string{"a", "b", "c"} {
+		h := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: sid})
+		h.Channel.Join("game")
+		members = append(members, h)
+	}
+
+	done := startBroadcastAck(s, 200*time.Millisecond, "game", "start", 1)
+	for _, h := range members[:2] {
+		id := waitAckRequest(t, h, "start")
+		if err := h.Feed("43" + id + `["ready-` + h.Channel.Id() + `"]`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitAckRequest(t, members[2], "start")
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if len(res.results) != 2 || res.results["a"] != `"ready-a"` || res.results["b"] != `"ready-b"` {
+		t.Fatal("results:", res.results)
+	}
+	if len(res.failed) != 1 || res.failed[0] != "c" {
+		t.Fatal("not responded:", res.failed)
+	}
+}
+
+func TestBroadcastAckDisconnected(t *testing.T) {
+	s := newTestServer()
+	stays := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "stays"})
+	leaves := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "leaves"})
+	stays.Channel.Join("game")
+	leaves.Channel.Join("game")
+
+	done := startBroadcastAck(s, 5*time.Second, "game", "start")
+	id := waitAckRequest(t, stays, "start")
+	waitAckRequest(t, leaves, "start")
+	leaves.Feed("41")
+	stays.Feed("43" + id + `["ok"]`)
+
+	//returns once every member responded or disconnected
+	res := <-done
+	if len(res.results) != 1 || res.results["stays"] != `"ok"` {
+		t.Fatal("results:", res.results)
+	}
+	if len(res.failed) != 1 || res.failed[0] != "leaves" {
+		t.Fatal("not responded:", res.failed)
+	}
+}
@@ -0,0 +1,105 @@
+package gophersocket
+
+import (
+	"sync"
+)
+
+const (
+	/**
+	Events not put to a full subscription of the event bus, labeled by event
+	*/
+	MetricBusDropped = "bus_dropped_total"
+
+	busBuffer = 100
+)
+
+/**
+Incoming event copied to subscribers of the bus, see Server.Subscribe
+*/
+type BusEvent struct {
+	Event
+
+	//channel the event came from
+	Channel *Channel
+}
+
+/**
+Subscriptions to incoming events by event name
+*/
+type eventBus struct {
+	subs map[string][]chan BusEvent
+	lock sync.RWMutex
+}
+
+/**
+Get copy of every incoming event with given name, OnAny subscribes to
+all events. Events are copied once dispatched to handlers, whether they
+handled them or not. Subscription keeps up to 100 events, while it is
+full new ones are dropped and counted, so a slow subscriber never
+delays dispatch. Order is the one of processing, see Channel.Events.
+The channel is closed by Unsubscribe
+*/
+func (s *Server) Subscribe(event string) <-chan BusEvent {
+	sub := make(chan BusEvent, busBuffer)
+
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	if s.bus.subs == nil {
+		s.bus.subs = make(map[string][]chan BusEvent)
+	}
+	s.bus.subs[event] = append(s.bus.subs[event], sub)
+
+	return sub
+}
+
+/**
+Stop subscription returned by Subscribe and close its channel
+*/
+func (s *Server) Unsubscribe(sub <-chan BusEvent) {
+	s.bus.lock.Lock()
+	defer s.bus.lock.Unlock()
+
+	for event, subs := range s.bus.subs {
+		for i, cur := range subs {
+			if cur != sub {
+				continue
+			}
+			//copy, so publishing under read lock keeps its slice
+			rest := make([]chan BusEvent, 0, len(subs)-1)
+			rest = append(append(rest, subs[:i]...), subs[i+1:]...)
+			if len(rest) == 0 {
+				delete(s.bus.subs, event)
+			} else {
+				s.bus.subs[event] = rest
+			}
+			close(cur)
+			return
+		}
+	}
+}
+
+/**
+Copy incoming event to its subscribers, arguments are decoded once
+for all of them
+*/
+func (s *Server) publish(c *Channel, name, args string) {
+	s.bus.lock.RLock()
+	defer s.bus.lock.RUnlock()
+
+	named, all := s.bus.subs[name], s.bus.subs[OnAny]
+	if len(named) == 0 && len(all) == 0 {
+		return
+	}
+
+	ev := BusEvent{Event: decodeEvent(c, name, args), Channel: c}
+	for _, subs := range [][]chan BusEvent{named, all} {
+		for _, sub := range subs {
+			select {
+			case sub <- ev:
+			default:
+				s.metricAdd(MetricBusDropped, 1, "event", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package gophersocket
+
+import (
+	"testing"
+)
+
+func TestBusReceivesAlongsideHandler(t *testing.T) {
+	s := newTestServer()
+	handled := make(chan string, 1)
+	s.On("chat", func(c *Channel, v string) { handled <- v })
+	chat, all := s.Subscribe("chat"), s.Subscribe(OnAny)
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, "chat", "hi")
+	feedEvent(t, h, "other", 1)
+
+	if v := <-handled; v != "hi" {
+		t.Fatal("handled", v)
+	}
+	ev := <-chat
+	if ev.Name != "chat" || ev.Channel != h.Channel || len(ev.Args) != 1 || ev.Args[0] != "hi" {
+		t.Fatalf("got %+v", ev)
+	}
+	if ev := <-all; ev.Name != "chat" {
+		t.Fatal("first of all", ev.Name)
+	}
+	if ev := <-all; ev.Name != "other" || ev.Args[0] != float64(1) {
+		t.Fatalf("got %+v", ev)
+	}
+	select {
+	case ev := <-chat:
+		t.Fatal("other event in named subscription", ev.Name)
+	default:
+	}
+}
+
+func TestBusSlowSubscriberDrops(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	handled := 0
+	s.On("tick", func(c *Channel, v int) { handled++ })
+	sub := s.Subscribe("tick")
+	h := newOpenHarness(s)
+
+	for i := 0; i < busBuffer+5; i++ {
+		feedEvent(t, h, "tick", i)
+	}
+	if handled != busBuffer+5 {
+		t.Fatal("handled", handled)
+	}
+	if len(sub) != busBuffer || metrics.get(MetricBusDropped, "event", "tick") != 5 {
+		t.Fatal("buffered", len(sub), "dropped", metrics.get(MetricBusDropped, "event", "tick"))
+	}
+	if ev := <-sub; ev.Args[0] != float64(0) {
+		t.Fatal("first buffered", ev.Args)
+	}
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	s := newTestServer()
+	sub := s.Subscribe("chat")
+	h := newOpenHarness(s)
+
+	s.Unsubscribe(sub)
+	if _, ok := <-sub; ok {
+		t.Fatal("subscription not closed")
+	}
+	feedEvent(t, h, "chat", "hi")
+}
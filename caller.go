@@ -2,54 +2,110 @@ package gophersocket
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"reflect"
+
+	"github.com/whiterabb17/gopher-socket/codec"
 )
 
 type caller struct {
-	Func        reflect.Value
-	Args        reflect.Type
-	ArgsPresent bool
-	Out         bool
+	Func         reflect.Value
+	Args         reflect.Type
+	ArgsPresent  bool
+	Out          bool
+	MultiOut     bool
+	ReturnsError bool
+	Context      bool
+
+	//nil means codec of server or client
+	Codec codec.Codec
+
+	//namespace of handler bound with Namespace.On, empty if it serves all
+	Namespace string
 }
 
 var (
 	ErrorCallerNotFunc     = errors.New("f is not function")
 	ErrorCallerNot2Args    = errors.New("f should have 1 or 2 args")
 	ErrorCallerMaxOneValue = errors.New("f should return not more than one value")
+	ErrorCallerFirstArg    = errors.New("f first arg should be *Channel or *EventContext")
+	ErrorCallerPanic       = errors.New("f panicked")
+	ErrorCallerVariadic    = errors.New("f should not be variadic")
+	ErrorCallerArgType     = errors.New("f second arg type can not be decoded")
+)
+
+var (
+	channelType      = reflect.TypeOf(&Channel{})
+	eventContextType = reflect.TypeOf(&EventContext{})
 )
 
 /**
 Parses function passed by using reflection, and stores its representation
-for further call on message or ack
+for further call on message or ack. Errors name the event and parameter
 */
-func newCaller(f interface{}) (*caller, error) {
+func newCaller(method string, f interface{}) (*caller, error) {
+	c, err := parseCaller(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: handler of event %q is %T", err, method, f)
+	}
+
+	return c, nil
+}
+
+func parseCaller(f interface{}) (*caller, error) {
 	fVal := reflect.ValueOf(f)
 	if fVal.Kind() != reflect.Func {
 		return nil, ErrorCallerNotFunc
 	}
 
 	fType := fVal.Type()
-	if fType.NumOut() > 1 {
-		return nil, ErrorCallerMaxOneValue
+	if fType.IsVariadic() {
+		return nil, ErrorCallerVariadic
 	}
-
 	curCaller := &caller{
-		Func: fVal,
-		Out:  fType.NumOut() == 1,
+		Func:     fVal,
+		Out:      fType.NumOut() > 0,
+		MultiOut: fType.NumOut() > 1,
 	}
+	curCaller.ReturnsError = fType.NumOut() == 1 && fType.Out(0) == errorType
 	if fType.NumIn() == 1 {
 		curCaller.Args = nil
 		curCaller.ArgsPresent = false
 	} else if fType.NumIn() == 2 {
 		curCaller.Args = fType.In(1)
 		curCaller.ArgsPresent = true
+		if !decodableType(curCaller.Args) {
+			return nil, ErrorCallerArgType
+		}
 	} else {
 		return nil, ErrorCallerNot2Args
 	}
 
+	switch fType.In(0) {
+	case channelType:
+		curCaller.Context = false
+	case eventContextType:
+		curCaller.Context = true
+	default:
+		return nil, ErrorCallerFirstArg
+	}
+
 	return curCaller, nil
 }
 
+/**
+Check that values of the type may be decoded from message arguments
+*/
+func decodableType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		return false
+	}
+
+	return true
+}
+
 /**
 returns function parameter as it is present in it using reflection
 */
@@ -60,16 +116,35 @@ func (c *caller) getArgs() interface{} {
 /**
 calls function with given arguments from its representation using reflection
 */
-func (c *caller) callFunc(h *Channel, args interface{}) []reflect.Value {
+func (c *caller) callFunc(ctx *EventContext, args interface{}) []reflect.Value {
+	first := reflect.ValueOf(ctx.channel)
+	if c.Context {
+		first = reflect.ValueOf(ctx)
+	}
+
+	if !c.ArgsPresent {
+		return c.Func.Call([]reflect.Value{first})
+	}
+
 	//nil is untyped, so use the default empty value of correct type
 	if args == nil {
 		args = c.getArgs()
 	}
 
-	a := []reflect.Value{reflect.ValueOf(h), reflect.ValueOf(args).Elem()}
-	if !c.ArgsPresent {
-		a = a[0:1]
-	}
+	return c.Func.Call([]reflect.Value{first, reflect.ValueOf(args).Elem()})
+}
+
+/**
+calls function same as callFunc, but recovers from panic inside of it,
+so the rest of handlers are still able to run
+*/
+func (c *caller) safeCallFunc(ctx *EventContext, args interface{}) (result []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("socket.io handler panic: ", r)
+			result, err = nil, ErrorCallerPanic
+		}
+	}()
 
-	return c.Func.Call(a)
+	return c.callFunc(ctx, args), nil
 }
@@ -0,0 +1,145 @@
+package gophersocket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type callerTestArgs struct {
+	Name string `json:"name"`
+}
+
+func TestCallerSupportedSignatures(t *testing.T) {
+	for _, f := range []interface{}{
+		func(c *Channel) {},
+		func(ctx *EventContext) {},
+		func(c *Channel, v string) {},
+		func(c *Channel, v int) {},
+		func(c *Channel, v float64) {},
+		func(c *Channel, v bool) {},
+		func(c *Channel, v []int) {},
+		func(c *Channel, v map[string]interface{}) {},
+		func(c *Channel, v callerTestArgs) {},
+		func(c *Channel, v *callerTestArgs) {},
+		func(c *Channel, v interface{}) {},
+		func(c *Channel, v DisconnectReason) {},
+		func(ctx *EventContext, v string) {},
+		func(c *Channel) string { return "" },
+		func(c *Channel, v int) int { return v },
+		func(c *Channel, v string) error { return nil },
+		func(ctx *EventContext, v string) (string, error) { return "", nil },
+	} {
+		if _, err := newCaller("ev", f); err != nil {
+			t.Errorf("%T: %v", f, err)
+		}
+	}
+}
+
+func TestCallerUnsupportedSignatures(t *testing.T) {
+	for _, tc := range []struct {
+		f   interface{}
+		err error
+	}{
+		{nil, ErrorCallerNotFunc},
+		{"handler", ErrorCallerNotFunc},
+		{func() {}, ErrorCallerNot2Args},
+		{func(c *Channel, a, b string) {}, ErrorCallerNot2Args},
+		{func(c *Channel, v ...string) {}, ErrorCallerVariadic},
+		{func(v string) {}, ErrorCallerFirstArg},
+		{func(c **Channel) {}, ErrorCallerFirstArg},
+		{func(v string, c *Channel) {}, ErrorCallerFirstArg},
+		{func(c *Channel, v chan int) {}, ErrorCallerArgType},
+		{func(c *Channel, v func()) {}, ErrorCallerArgType},
+		{func(c *Channel, v complex128) {}, ErrorCallerArgType},
+	} {
+		_, err := newCaller("ev", tc.f)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%T: got %v, want %v", tc.f, err, tc.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), `"ev"`) {
+			t.Errorf("%T: event not named in %q", tc.f, err)
+		}
+	}
+
+	//On reports the error at registration
+	s := newTestServer()
+	if err := s.On("ev", func(c *Channel, v chan int) {}); !errors.Is(err, ErrorCallerArgType) {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeErrorReported(t *testing.T) {
+	s := newTestServer()
+	called := false
+	s.On("count", func(c *Channel, n int) { called = true })
+	reported := make(chan error, 2)
+	s.SetErrorFormatter(func(c *Channel, event string, err error) interface{} {
+		reported <- err
+		return map[string]string{"event": event, "code": err.(interface{ Code() string }).Code()}
+	})
+
+	h := newOpenHarness(s)
+	long := strings.Repeat("x", 2*decodeErrorPayloadSize)
+	feedEvent(t, h, "count", long)
+	if called {
+		t.Fatal("handler called with undecodable arguments")
+	}
+
+	var decodeErr *DecodeError
+	if err := <-reported; !errors.As(err, &decodeErr) {
+		t.Fatal(err)
+	}
+	if decodeErr.Event != "count" || decodeErr.Expected != "int" || decodeErr.Err == nil {
+		t.Fatal(decodeErr)
+	}
+	if decodeErr.Payload != `"`+long[:decodeErrorPayloadSize-1]+"..." {
+		t.Fatal("payload", decodeErr.Payload)
+	}
+	expectFrames(t, h, `42["error",{"code":"decode_error","event":"count"}]`)
+}
+
+func TestDecodeErrorHook(t *testing.T) {
+	s := newTestServer()
+	s.On("count", func(c *Channel, n int) {})
+	hooked := make(chan error, 1)
+	s.On(OnError, func(c *Channel, err error) { hooked <- err })
+	plain := 0
+	s.On(OnError, func(c *Channel) { plain++ })
+	payloads := 0
+	s.On(OnError, func(c *Channel, payload ErrorPayload) { payloads++ })
+
+	h := newOpenHarness(s)
+	feedEvent(t, h, "count", "x")
+
+	var decodeErr *DecodeError
+	select {
+	case err := <-hooked:
+		if !errors.As(err, &decodeErr) || decodeErr.Event != "count" || decodeErr.Expected != "int" {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("error hook not called")
+	}
+	if plain != 1 || payloads != 0 {
+		t.Fatal("plain hook called", plain, "error event handler called", payloads)
+	}
+}
+
+func TestDecodeErrorAck(t *testing.T) {
+	s := newTestServer()
+	s.On("count", func(c *Channel, n int) int { return n })
+	s.ExposeErrors(true)
+
+	h := newOpenHarness(s)
+	if err := h.Feed(`421["count","x"]`); err != nil {
+		t.Fatal(err)
+	}
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 1 || !strings.HasPrefix(frames[0], "431[") ||
+		!strings.Contains(frames[0], `"code":"decode_error"`) {
+		t.Fatal(frames)
+	}
+}
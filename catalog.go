@@ -0,0 +1,224 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+/**
+Description of handlers bound to one event, see EventCatalog
+*/
+type EventDescriptor struct {
+	Event     string `json:"event"`
+	Namespace string `json:"namespace"`
+
+	//connection, disconnection or stream lost, not sent by clients
+	LoopEvent bool `json:"loopEvent,omitempty"`
+
+	//some handler returns a value, sent as ack result
+	Acks bool `json:"acks"`
+
+	Handlers []HandlerDescriptor `json:"handlers"`
+}
+
+/**
+Description of one handler, in registration order
+*/
+type HandlerDescriptor struct {
+	//Go type of the argument, empty if the handler takes none
+	Param string `json:"param,omitempty"`
+
+	//JSON schema of the argument as encoding/json reads it,
+	//handlers with custom codec may expect other encoding
+	Schema map[string]interface{} `json:"schema,omitempty"`
+
+	CustomCodec bool `json:"customCodec,omitempty"`
+
+	//handler takes *EventContext instead of *Channel
+	Context bool `json:"context,omitempty"`
+
+	//Go types of returned values
+	Results []string `json:"results,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+/**
+Describe events with shared handlers, sorted by name and namespace,
+handlers bound with Namespace.On are described under their namespace,
+the ones serving all namespaces under the root one. Built from the
+current handler table on each call, so it follows On, ReplaceHandler
+and SwapHandlers. Handlers local to channels are not included
+*/
+func (m *methods) EventCatalog() []EventDescriptor {
+	table := m.getHandlers()
+
+	catalog := make([]EventDescriptor, 0, len(table))
+	for event, callers := range table {
+		//handlers serving all namespaces are described as root ones
+		byNamespace := make(map[string]int)
+		for _, c := range callers {
+			nsp := c.Namespace
+			if nsp == "" {
+				nsp = "/"
+			}
+			i, ok := byNamespace[nsp]
+			if !ok {
+				i = len(catalog)
+				byNamespace[nsp] = i
+				catalog = append(catalog, EventDescriptor{
+					Event:     event,
+					Namespace: nsp,
+					LoopEvent: event == OnConnection || event == OnDisconnection || event == OnStreamLost,
+					Handlers:  make([]HandlerDescriptor, 0, 1),
+				})
+			}
+
+			desc := &catalog[i]
+			desc.Acks = desc.Acks || (c.Out && !c.ReturnsError)
+			desc.Handlers = append(desc.Handlers, describeCaller(c))
+		}
+	}
+	sort.Slice(catalog, func(i, j int) bool {
+		if catalog[i].Event != catalog[j].Event {
+			return catalog[i].Event < catalog[j].Event
+		}
+		return catalog[i].Namespace < catalog[j].Namespace
+	})
+
+	return catalog
+}
+
+/**
+Get handler serving EventCatalog as JSON
+*/
+func (m *methods) EventCatalogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.EventCatalog())
+	})
+}
+
+func describeCaller(c *caller) HandlerDescriptor {
+	desc := HandlerDescriptor{
+		Context:     c.Context,
+		CustomCodec: c.Codec != nil,
+	}
+	if c.ArgsPresent {
+		desc.Param = c.Args.String()
+		desc.Schema = typeSchema(c.Args, make(map[reflect.Type]bool))
+	}
+
+	fType := c.Func.Type()
+	for i := 0; i < fType.NumOut(); i++ {
+		desc.Results = append(desc.Results, fType.Out(i).String())
+	}
+
+	return desc
+}
+
+/**
+Build JSON schema of values of the type, as encoding/json encodes them.
+Recursive types are described as plain objects at the second visit
+*/
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		//own encoding, shape is unknown
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := make(map[string]interface{})
+		var required []string
+		structSchema(t, seen, properties, &required)
+
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	}
+
+	//interfaces accept any value
+	return map[string]interface{}{}
+}
+
+/**
+Add properties of struct fields, fields of embedded structs are
+promoted as encoding/json does
+*/
+func structSchema(t reflect.Type, seen map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := tag, ""
+		if comma := strings.IndexByte(tag, ','); comma >= 0 {
+			name, options = tag[:comma], tag[comma+1:]
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			structSchema(fieldType, seen, properties, required)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, seen)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
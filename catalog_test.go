@@ -0,0 +1,124 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type catalogBase struct {
+	Id string `json:"id"`
+}
+
+type catalogNode struct {
+	catalogBase
+	Name     string          `json:"name,omitempty"`
+	Created  time.Time       `json:"created"`
+	Raw      json.RawMessage `json:"raw,omitempty"`
+	Data     []byte          `json:"data,omitempty"`
+	Children []*catalogNode  `json:"children,omitempty"`
+	Hidden   string          `json:"-"`
+	internal int
+}
+
+func schemaJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestEventCatalog(t *testing.T) {
+	s := newTestServer()
+	s.On(OnConnection, func(c *Channel) {})
+	s.On("node", func(c *Channel, n catalogNode) (int, error) { return 0, nil })
+	s.On("node", func(ctx *EventContext, n catalogNode) {})
+	s.On("ping", func(c *Channel) string { return "pong" })
+
+	catalog := s.EventCatalog()
+	if len(catalog) != 3 || catalog[0].Event != OnConnection || catalog[1].Event != "node" ||
+		catalog[2].Event != "ping" {
+
+		t.Fatalf("catalog %+v", catalog)
+	}
+	if !catalog[0].LoopEvent || catalog[0].Acks {
+		t.Fatal("connection", catalog[0])
+	}
+	if !catalog[2].Acks || catalog[2].Handlers[0].Param != "" ||
+		len(catalog[2].Handlers[0].Results) != 1 || catalog[2].Handlers[0].Results[0] != "string" {
+
+		t.Fatal("ping", catalog[2])
+	}
+
+	//value and error of the first handler is an ack result
+	node := catalog[1]
+	if node.Namespace != "/" || !node.Acks || len(node.Handlers) != 2 || node.Handlers[0].Context ||
+		!node.Handlers[1].Context || node.Handlers[0].Param != "gophersocket.catalogNode" {
+
+		t.Fatal("node", node)
+	}
+	want := `{"properties":{` +
+		`"children":{"items":{"type":"object"},"type":"array"},` +
+		`"created":{"format":"date-time","type":"string"},` +
+		`"data":{"contentEncoding":"base64","type":"string"},` +
+		`"id":{"type":"string"},` +
+		`"name":{"type":"string"},` +
+		`"raw":{}},` +
+		`"required":["created","id"],"type":"object"}`
+	if got := schemaJSON(t, node.Handlers[0].Schema); got != want {
+		t.Fatalf("schema\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEventCatalogNamespace(t *testing.T) {
+	s := newTestServer()
+	s.On("msg", func(c *Channel, v string) {})
+	s.Of("/chat").On("msg", func(c *Channel, v int) int { return v })
+
+	catalog := s.EventCatalog()
+	if len(catalog) != 2 || catalog[0].Namespace != "/" || catalog[1].Namespace != "/chat" {
+		t.Fatalf("catalog %+v", catalog)
+	}
+	if catalog[0].Acks || catalog[0].Handlers[0].Param != "string" {
+		t.Fatal("root", catalog[0])
+	}
+	if !catalog[1].Acks || len(catalog[1].Handlers) != 1 || catalog[1].Handlers[0].Param != "int" {
+		t.Fatal("namespace", catalog[1])
+	}
+}
+
+func TestEventCatalogFollowsReplace(t *testing.T) {
+	s := newTestServer()
+	s.On("ev", func(c *Channel, v int) {})
+
+	s.ReplaceHandler("ev", func(c *Channel, v string) string { return v })
+	catalog := s.EventCatalog()
+	if len(catalog) != 1 || catalog[0].Handlers[0].Param != "string" || !catalog[0].Acks {
+		t.Fatalf("catalog %+v", catalog)
+	}
+
+	s.ReplaceHandler("ev", nil)
+	if catalog := s.EventCatalog(); len(catalog) != 0 {
+		t.Fatalf("catalog %+v", catalog)
+	}
+}
+
+func TestEventCatalogHandler(t *testing.T) {
+	s := newTestServer()
+	s.On("ev", func(c *Channel, v map[string]float64) {})
+
+	w := httptest.NewRecorder()
+	s.EventCatalogHandler().ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("content type", w.Header())
+	}
+	want := `[{"event":"ev","namespace":"/","acks":false,"handlers":[{"param":"map[string]float64",` +
+		`"schema":{"additionalProperties":{"type":"number"},"type":"object"}}]}]` + "\n"
+	if w.Body.String() != want {
+		t.Fatalf("served\n%s\nwant\n%s", w.Body.String(), want)
+	}
+}
@@ -0,0 +1,151 @@
+package gophersocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/codec"
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Size of data read from the reader for one chunk of EmitStream
+	*/
+	StreamChunkSize = 32 * 1024
+
+	/**
+	Streams received larger than this are dropped
+	*/
+	DefaultMaxStreamBytes = 64 * 1024 * 1024
+
+	streamChunkEvent = "__chunk"
+)
+
+var (
+	ErrorStreamTooLarge = errors.New("Stream too large")
+)
+
+/**
+Chunk of payload sent by EmitStream, the last one has event name
+*/
+type streamChunk struct {
+	Id   string `json:"id"`
+	Data []byte `json:"data,omitempty"`
+	End  string `json:"end,omitempty"`
+}
+
+/**
+Send payload read from r as the argument of event, in chunks, so the
+frame is never built whole. Receiver reassembles the payload and calls
+handlers of the event with it as []byte argument. Sending waits while
+out queue is over half full, so reading follows the connection speed.
+Peer should be this library
+*/
+func (c *Channel) EmitStream(event string, r io.Reader) error {
+	if c.Muted(event) {
+		return nil
+	}
+
+	id := strconv.FormatUint(uint64(atomic.AddUint32(&c.chunkStreamId, 1)), 10)
+	buf := make([]byte, StreamChunkSize)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := c.sendChunk(streamChunk{Id: id, Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.sendChunk(streamChunk{Id: id, End: event})
+}
+
+/**
+Enqueue chunk, waiting for out queue to be written while it is over
+half full
+*/
+func (c *Channel) sendChunk(chunk streamChunk) error {
+	command, err := encode(codec.JSONCodec{}, protocol.NewEvent("", streamChunkEvent, nil), chunk)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if len(c.out) < cap(c.out)/2 {
+			err = c.enqueue(command)
+			if !errors.Is(err, ErrorSocketOverflood) {
+				return err
+			}
+		}
+		if err := c.Flush(context.Background()); err != nil {
+			return err
+		}
+	}
+}
+
+/**
+Collect chunk of incoming stream, and dispatch the event once it is
+complete. Returns false if message is not a chunk
+*/
+func (c *Channel) acceptChunk(m *methods, msg *protocol.Message, received time.Time) bool {
+	if msg.Type != protocol.MessageTypeEmit || msg.Method != streamChunkEvent {
+		return false
+	}
+
+	var chunk streamChunk
+	if err := json.Unmarshal([]byte(msg.Args), &chunk); err != nil {
+		return true
+	}
+
+	c.chunkStreamsLock.Lock()
+	defer c.chunkStreamsLock.Unlock()
+
+	if c.chunkStreams == nil {
+		c.chunkStreams = make(map[string][]byte)
+	}
+	data, ok := c.chunkStreams[chunk.Id]
+	if chunk.End == "" {
+		if ok && data == nil {
+			//dropped as too large
+			return true
+		}
+		if len(data)+len(chunk.Data) > DefaultMaxStreamBytes {
+			c.chunkStreams[chunk.Id] = nil
+			m.emitHandlerError(c, streamChunkEvent, ErrorStreamTooLarge)
+			return true
+		}
+		c.chunkStreams[chunk.Id] = append(data, chunk.Data...)
+		return true
+	}
+
+	delete(c.chunkStreams, chunk.Id)
+	if ok && data == nil {
+		return true
+	}
+	args, err := json.Marshal(data)
+	if err != nil {
+		return true
+	}
+
+	complete := protocol.NewEvent("", chunk.End, []json.RawMessage{args})
+	atomic.AddInt32(&c.inFlight, 1)
+	m.getExecutor().Submit(func() {
+		defer atomic.AddInt32(&c.inFlight, -1)
+		m.processIncomingMessage(c, complete, received)
+	})
+
+	return true
+}
@@ -0,0 +1,118 @@
+package gophersocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmitStreamRoundTrip(t *testing.T) {
+	s := newTestServer()
+	got := make(chan []byte, 1)
+	s.On("big", func(c *Channel, data []byte) { got <- data })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 3*StreamChunkSize/16)
+	payload = append(payload, "tail"...)
+	if err := client.EmitStream("big", bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case data := <-got:
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("got %d bytes, want %d", len(data), len(payload))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not received")
+	}
+}
+
+func TestEmitStreamChunks(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	payload := bytes.Repeat([]byte{'x'}, StreamChunkSize+1)
+	if err := h.Channel.EmitStream("big", bytes.NewReader(payload)); err != nil {
+		t.Fatal(err)
+	}
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want two chunks and end", len(frames))
+	}
+
+	var sizes []int
+	for i, frame := range frames {
+		prefix := `42["` + streamChunkEvent + `",`
+		if !strings.HasPrefix(frame, prefix) {
+			t.Fatalf("frame %d is not a chunk: %.40q", i, frame)
+		}
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(frame[len(prefix):len(frame)-1]), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Id != "1" {
+			t.Fatalf("chunk of stream %q", chunk.Id)
+		}
+		sizes = append(sizes, len(chunk.Data))
+		if i == len(frames)-1 && chunk.End != "big" {
+			t.Fatalf("last chunk ends %q", chunk.End)
+		}
+	}
+	if sizes[0] != StreamChunkSize || sizes[1] != 1 || sizes[2] != 0 {
+		t.Fatal("chunk sizes", sizes)
+	}
+}
+
+func TestEmitStreamInterleaved(t *testing.T) {
+	s := newTestServer()
+	got := make(chan string, 2)
+	s.On("a", func(c *Channel, data []byte) { got <- "a:" + string(data) })
+	s.On("b", func(c *Channel, data []byte) { got <- "b:" + string(data) })
+	h := newOpenHarness(s)
+
+	for _, chunk := range []streamChunk{
+		{Id: "1", Data: []byte("he")},
+		{Id: "2", Data: []byte("wor")},
+		{Id: "1", Data: []byte("llo")},
+		{Id: "2", End: "b"},
+		{Id: "2", Data: []byte("ld")},
+		{Id: "1", End: "a"},
+	} {
+		feedEvent(t, h, streamChunkEvent, chunk)
+	}
+
+	//handlers may run in any order
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-got:
+			received[v] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream not dispatched")
+		}
+	}
+	if !received["a:hello"] || !received["b:wor"] {
+		t.Fatal("got", received)
+	}
+	//chunk after end starts new stream with the same id, never ended
+	select {
+	case v := <-got:
+		t.Fatal("unexpected", v)
+	default:
+	}
+}
+
+func TestEmitStreamMuted(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.Mute("big")
+
+	if err := h.Channel.EmitStream("big", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h)
+}
@@ -1,9 +1,17 @@
 package gophersocket
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/whiterabb17/gopher-socket/protocol"
 	"github.com/whiterabb17/gopher-socket/transport"
 )
 
@@ -11,8 +19,72 @@ const (
 	webSocketProtocol       = "ws://"
 	webSocketSecureProtocol = "wss://"
 	socketioUrl             = "/socket.io/?EIO=3&transport=websocket"
+
+	handshakePacketSnippet = 64
+)
+
+var (
+	ErrorHandshakeFailed    = errors.New("Handshake failed")
+	ErrorHandshakeRejected  = errors.New("Handshake rejected")
+	ErrorHeaderNotSupported = errors.New("Transport does not support request headers")
 )
 
+/**
+Options of client connection
+*/
+type DialOptions struct {
+	/**
+	Headers of upgrade request, e.g. Authorization, added to the ones
+	configured on transport
+	*/
+	Header http.Header
+
+	/**
+	Check server identity after open packet is received, with response
+	to upgrade request if transport keeps it. Error aborts Dial with
+	ErrorHandshakeRejected, before OnConnection is called
+	*/
+	VerifyHandshake func(h Header, resp *http.Response) error
+
+	/**
+	Clock of the client, nil means the real one, see SetClock
+	*/
+	Clock Clock
+
+	/**
+	Dial again when connection is lost, instead of closing the channel.
+	Delays follow Backoff, nil means NewDecorrelatedJitter with defaults
+	*/
+	Reconnect bool
+	Backoff   BackoffStrategy
+
+	/**
+	Called before each reconnection attempt, and once when Backoff
+	gives up, right before the channel is closed
+	*/
+	OnReconnectAttempt func(attempt int, delay time.Duration)
+	OnReconnectFailed  func(err error)
+
+	/**
+	Ping interval requested from the server, which may clamp it.
+	The client pings with the interval advertised in the handshake.
+	Zero uses the transport interval without asking
+	*/
+	PingInterval time.Duration
+
+	/**
+	Frame transform of the connection from the start, including the
+	handshake, see Channel.SetFrameTransform
+	*/
+	FrameOut func([]byte) ([]byte, error)
+	FrameIn  func([]byte) ([]byte, error)
+
+	/**
+	Close the client once it is done, see Channel.BindContext
+	*/
+	Context context.Context
+}
+
 /**
 Socket.io client representation
 */
@@ -37,32 +109,195 @@ func GetUrl(host string, port int, secure bool, tags string) string {
 /**
 connect to host and initialise socket.io protocol
 
+If the server does not upgrade the connection, or does not send a valid
+open packet, ErrorHandshakeFailed with details is returned
+
 The correct ws protocol url example:
 ws://myserver.com/socket.io/?EIO=3&transport=websocket
 
 You can use GetUrlByHost for generating correct url
 */
 func Dial(url string, tr transport.Transport) (*Client, error) {
+	return DialWithOptions(url, tr, DialOptions{})
+}
+
+/**
+Same as Dial, with given options
+*/
+func DialWithOptions(url string, tr transport.Transport, opts DialOptions) (*Client, error) {
 	c := &Client{}
-	c.initChannel()
 	c.initMethods()
+	c.shared = &c.methods
+	c.SetClock(opts.Clock)
+	c.initChannel(queueBufferSize)
+	if opts.FrameOut != nil || opts.FrameIn != nil {
+		c.SetFrameTransform(opts.FrameOut, opts.FrameIn)
+	}
 
-	var err error
-	c.conn, err = tr.Connect(url)
+	conn, header, err := dialConn(url, tr, opts)
 	if err != nil {
 		return nil, err
 	}
+	c.setHeader(header)
+	atomic.StoreInt64(&c.maxPayload, header.MaxPayload)
+	c.setConn(conn)
+	c.setTransport(tr)
 
-	go inLoop(&c.Channel, &c.methods)
-	go outLoop(&c.Channel, &c.methods)
-	go pinger(&c.Channel)
+	if opts.Reconnect {
+		c.reconnect = newReconnector(c, url, tr, opts)
+	}
+
+	c.startLoops(&c.methods)
+	if opts.Context != nil {
+		c.BindContext(opts.Context)
+	}
 
 	return c, nil
 }
 
 /**
-Close client connection
+Connect, receive open packet and verify it
+*/
+func dialConn(url string, tr transport.Transport, opts DialOptions) (transport.Connection, Header, error) {
+	if opts.PingInterval > 0 {
+		withPing, err := withPingInterval(url, opts.PingInterval)
+		if err != nil {
+			return nil, Header{}, err
+		}
+		url = withPing
+	}
+
+	conn, err := connect(url, tr, opts)
+	if errors.Is(err, transport.ErrorHttpUpgradeFailed) {
+		return nil, Header{}, fmt.Errorf("%w: %v", ErrorHandshakeFailed, err)
+	}
+	if err != nil {
+		return nil, Header{}, err
+	}
+
+	header, err := handshake(conn, tr, opts.FrameIn)
+	if err != nil {
+		conn.Close()
+		return nil, header, err
+	}
+
+	if opts.VerifyHandshake != nil {
+		var resp *http.Response
+		if provider, ok := conn.(transport.ResponseProvider); ok {
+			resp = provider.Response()
+		}
+		if err := opts.VerifyHandshake(header, resp); err != nil {
+			conn.Close()
+			return nil, header, fmt.Errorf("%w: %v", ErrorHandshakeRejected, err)
+		}
+	}
+
+	if opts.PingInterval > 0 {
+		applyServerPing(conn, header)
+	}
+
+	return conn, header, nil
+}
+
+/**
+Connect with transport, passing request headers if there are any
+*/
+func connect(url string, tr transport.Transport, opts DialOptions) (transport.Connection, error) {
+	if len(opts.Header) == 0 {
+		return tr.Connect(url)
+	}
+
+	connector, ok := tr.(transport.HeaderConnector)
+	if !ok {
+		return nil, ErrorHeaderNotSupported
+	}
+
+	return connector.ConnectWithHeader(url, opts.Header)
+}
+
+/**
+Receive engine.io open packet, it should be the first one sent by server,
+and check that connection options are acceptable
+
+Upgrades listed by server should contain the dialed transport, if any
+*/
+func handshake(conn transport.Connection, tr transport.Transport,
+	frameIn func([]byte) ([]byte, error)) (header Header, err error) {
+
+	pkg, err := conn.GetMessage()
+	if err == nil && frameIn != nil {
+		var data []byte
+		data, err = frameIn([]byte(pkg))
+		pkg = string(data)
+	}
+	if err != nil {
+		return header, fmt.Errorf("%w: %v", ErrorHandshakeFailed, err)
+	}
+
+	msg, err := protocol.Decode(pkg)
+	if err != nil || msg.Type != protocol.MessageTypeOpen {
+		if len(pkg) > handshakePacketSnippet {
+			pkg = pkg[:handshakePacketSnippet]
+		}
+		return header, fmt.Errorf("%w: open packet expected, got %q", ErrorHandshakeFailed, pkg)
+	}
+
+	if header, err = parseHeader([]byte(msg.Args)); err != nil {
+		return header, fmt.Errorf("%w: %v: %v", ErrorHandshakeFailed, ErrorWrongHeader, err)
+	}
+	if header.Sid == "" {
+		return header, fmt.Errorf("%w: %v: no sid", ErrorHandshakeFailed, ErrorWrongHeader)
+	}
+
+	named, ok := tr.(transport.Named)
+	if !ok || len(header.Upgrades) == 0 {
+		return header, nil
+	}
+	for _, upgrade := range header.Upgrades {
+		if upgrade == named.Name() {
+			return header, nil
+		}
+	}
+
+	return header, fmt.Errorf("%w: transport %s is not supported, server upgrades are %v",
+		ErrorHandshakeFailed, named.Name(), header.Upgrades)
+}
+
+/**
+Parse open packet payload, fields unknown to Header are kept in Raw.
+Missing fields stay zero, which disables what they configure
+*/
+func parseHeader(data []byte) (header Header, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return header, err
+	}
+	if err = json.Unmarshal(data, &header); err != nil {
+		return header, err
+	}
+
+	for _, known := range []string{"sid", "upgrades", "pingInterval", "pingTimeout",
+		"connectionStateRecovery", "maxPayload"} {
+		delete(fields, known)
+	}
+	if len(fields) > 0 {
+		header.Raw = fields
+	}
+
+	return header, nil
+}
+
+/**
+Add message processing function shared by the client, use Channel.On
+to bind it to the underlying channel only
+*/
+func (c *Client) On(method string, f interface{}) error {
+	return c.methods.On(method, f)
+}
+
+/**
+Close client connection, server is sent disconnect packet first
 */
 func (c *Client) Close() {
-	closeChannel(&c.Channel, &c.methods)
+	c.disconnect(&c.methods, DisconnectClient, CloseClientClose, nil)
 }
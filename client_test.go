@@ -0,0 +1,341 @@
+package gophersocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func TestDialWithHeader(t *testing.T) {
+	s := newTestServer()
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + socketioUrl
+
+	_, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if !errors.Is(err, ErrorHandshakeFailed) {
+		t.Fatal("dial without header:", err)
+	}
+
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		Header: http.Header{"Authorization": {"Bearer token"}},
+	})
+	if err != nil {
+		t.Fatal("dial with header:", err)
+	}
+	defer client.Close()
+
+	if !client.IsAlive() {
+		t.Fatal("client not connected")
+	}
+}
+
+/**
+Serve websocket endpoint writing given frames right after the upgrade
+*/
+func serveRawFrames(frames ...string) (*httptest.Server, string) {
+	upgrader := websocket.Upgrader{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, frame := range frames {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+		conn.ReadMessage()
+	}))
+
+	return hs, "ws" + strings.TrimPrefix(hs.URL, "http") + socketioUrl
+}
+
+func TestDialPlainHttpEndpoint(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + socketioUrl
+
+	_, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if !errors.Is(err, ErrorHandshakeFailed) {
+		t.Fatal(err)
+	}
+	if !strings.Contains(err.Error(), "status 200") || !strings.Contains(err.Error(), "hello") {
+		t.Fatal("no status and body in", err)
+	}
+}
+
+func TestDialInvalidOpenPacket(t *testing.T) {
+	for _, frame := range []string{`42["ev"]`, `0{"sid":`, `0{"upgrades":[]}`} {
+		hs, url := serveRawFrames(frame)
+		_, err := Dial(url, transport.GetDefaultWebsocketTransport())
+		hs.Close()
+
+		if !errors.Is(err, ErrorHandshakeFailed) {
+			t.Fatalf("frame %q: %v", frame, err)
+		}
+	}
+}
+
+func TestDialUnsupportedUpgrades(t *testing.T) {
+	hs, url := serveRawFrames(`0{"sid":"s","upgrades":["polling"],"pingInterval":25000,"pingTimeout":5000}`, "40")
+	defer hs.Close()
+
+	_, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if !errors.Is(err, ErrorHandshakeFailed) || !strings.Contains(err.Error(), "polling") {
+		t.Fatal(err)
+	}
+}
+
+func TestDialBodySnippetLimited(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, body, http.StatusNotFound)
+	}))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + socketioUrl
+
+	_, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if !errors.Is(err, ErrorHandshakeFailed) || !strings.Contains(err.Error(), "status 404") {
+		t.Fatal(err)
+	}
+	if strings.Contains(err.Error(), body) {
+		t.Fatal("whole body in error")
+	}
+}
+
+func TestDialFailureClosesConnection(t *testing.T) {
+	closed := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage,
+			[]byte(`0{"sid":"s","upgrades":["polling"],"pingInterval":25000,"pingTimeout":60000}`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + socketioUrl
+
+	//fails right away instead of waiting for the ping timeout
+	start := time.Now()
+	if _, err := Dial(url, transport.GetDefaultWebsocketTransport()); !errors.Is(err, ErrorHandshakeFailed) {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatal("dial took", took)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection of failed dial kept open")
+	}
+}
+
+func TestDialValidRawHandshake(t *testing.T) {
+	hs, url := serveRawFrames(`0{"sid":"s","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}`, "40")
+	defer hs.Close()
+
+	client, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if client.Id() != "s" {
+		t.Fatal("sid", client.Id())
+	}
+}
+
+func TestDialHeaderFixtures(t *testing.T) {
+	for _, test := range []struct {
+		name, open string
+		want       Header
+		raw        []string
+	}{
+		{
+			name: "v3",
+			open: `0{"sid":"lv_VI97HAXpY6yYWAAAC","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":5000}`,
+			want: Header{Sid: "lv_VI97HAXpY6yYWAAAC", Upgrades: []string{"websocket"}, PingInterval: 25000, PingTimeout: 5000},
+		},
+		{
+			name: "v4",
+			open: `0{"sid":"Z2BDFcRTh7M-eXvdAAAB","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}`,
+			want: Header{Sid: "Z2BDFcRTh7M-eXvdAAAB", Upgrades: []string{}, PingInterval: 25000, PingTimeout: 20000, MaxPayload: 1000000},
+		},
+		{
+			name: "extension",
+			open: `0{"sid":"s","pingInterval":25000,"pingTimeout":20000,"region":"eu","limits":{"rooms":5}}`,
+			want: Header{Sid: "s", PingInterval: 25000, PingTimeout: 20000},
+			raw:  []string{"limits", "region"},
+		},
+	} {
+		hs, url := serveRawFrames(test.open, "40")
+		client, err := Dial(url, transport.GetDefaultWebsocketTransport())
+		if err != nil {
+			hs.Close()
+			t.Fatal(test.name, err)
+		}
+
+		got := client.Handshake()
+		raw := got.Raw
+		got.Raw = nil
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+		if len(raw) != len(test.raw) {
+			t.Fatalf("%s: raw fields %v", test.name, raw)
+		}
+		for _, field := range test.raw {
+			if _, ok := raw[field]; !ok {
+				t.Fatalf("%s: raw field %s missing", test.name, field)
+			}
+		}
+		if test.raw != nil && string(raw["region"]) != `"eu"` {
+			t.Fatalf("%s: region %s", test.name, raw["region"])
+		}
+
+		client.Close()
+		hs.Close()
+	}
+}
+
+func TestDialMaxPayload(t *testing.T) {
+	hs, url := serveRawFrames(`0{"sid":"s","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":32}`, "40")
+	defer hs.Close()
+
+	client, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Emit("ev", "short"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Emit("ev", strings.Repeat("a", 32)); err != ErrorPayloadTooLarge {
+		t.Fatal("large message", err)
+	}
+}
+
+func TestDialVerifyHandshake(t *testing.T) {
+	s := newTestServer()
+	s.AddHeader("X-Node", "node-1")
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	var seen Header
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		VerifyHandshake: func(h Header, resp *http.Response) error {
+			seen = h
+			if resp == nil || resp.Header.Get("X-Node") != "node-1" {
+				return errors.New("unknown node")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if seen.Sid == "" || seen.Sid != client.Id() {
+		t.Fatal("verified header", seen, client.Id())
+	}
+}
+
+func TestDialVerifyHandshakeRejects(t *testing.T) {
+	s := newTestServer()
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	_, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		VerifyHandshake: func(h Header, resp *http.Response) error {
+			return errors.New("unknown node")
+		},
+	})
+	if !errors.Is(err, ErrorHandshakeRejected) || !strings.Contains(err.Error(), "unknown node") {
+		t.Fatal(err)
+	}
+
+	//server side channel is closed with the connection
+	deadline := time.Now().Add(5 * time.Second)
+	for s.AmountOfSids() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := s.AmountOfSids(); n != 0 {
+		t.Fatal("server channels", n)
+	}
+}
+
+func TestClientPingTimeoutFromOutLoop(t *testing.T) {
+	//server which never answers pings
+	upgrader := websocket.Upgrader{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(`0{"sid":"s","upgrades":[],"pingInterval":1000,"pingTimeout":1000}`))
+		conn.WriteMessage(websocket.TextMessage, []byte("40"))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer hs.Close()
+
+	tr := transport.GetDefaultWebsocketTransport()
+	tr.PingInterval, tr.PingTimeout = time.Second, time.Second
+	clock := newManualClock()
+	client, err := DialWithOptions("ws"+strings.TrimPrefix(hs.URL, "http")+socketioUrl, tr, DialOptions{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	//within interval and timeout the client only pings
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if !client.IsAlive() {
+		t.Fatal("closed before ping timeout")
+	}
+
+	clock.Advance(2 * time.Second)
+	waitClosed(t, &client.Channel)
+	if client.CloseReason() != DisconnectPingTimeout {
+		t.Fatal("reason", client.CloseReason())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Stats().Goroutines != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := client.Stats().Goroutines; n != 0 {
+		t.Fatal("goroutines after close", n)
+	}
+}
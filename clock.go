@@ -0,0 +1,107 @@
+package gophersocket
+
+import (
+	"time"
+)
+
+/**
+Source of time and timers used by the library, replaceable with a fake
+one in tests, so timeouts can be triggered without waiting for them
+*/
+type Clock interface {
+	Now() time.Time
+
+	/**
+	Same as time.NewTimer, time.NewTicker, time.After and time.AfterFunc
+	*/
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+/**
+Timer created by Clock, C is nil for timers of AfterFunc
+*/
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+/**
+Ticker created by Clock
+*/
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+/**
+Clock of package time
+*/
+type realClock struct{}
+
+type realTimer struct {
+	*time.Timer
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+/**
+Set clock used for timeouts, intervals and activity times of channels,
+nil restores the real one. Should be set before connections are made,
+client channel gets its clock with DialOptions.Clock
+*/
+func (m *methods) SetClock(clock Clock) {
+	storeHeld(&m.clock, clock)
+}
+
+func (m *methods) getClock() Clock {
+	if clock, ok := loadHeld(&m.clock).(Clock); ok {
+		return clock
+	}
+
+	return realClock{}
+}
+
+/**
+Get clock of the channel
+*/
+func (c *Channel) clock() Clock {
+	if c.shared != nil {
+		return c.shared.getClock()
+	}
+
+	return realClock{}
+}
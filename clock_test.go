@@ -0,0 +1,411 @@
+package gophersocket
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Clock whose time moves only with Advance, timers due by then fire
+*/
+type manualClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock  *manualClock
+	c      chan time.Time
+	f      func()
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+type manualTicker struct {
+	*manualTimer
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1000, 0)}
+}
+
+func (mc *manualClock) Now() time.Time {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	return mc.now
+}
+
+func (mc *manualClock) add(d time.Duration, f func(), period time.Duration) *manualTimer {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	timer := &manualTimer{clock: mc, c: make(chan time.Time, 1), f: f,
+		at: mc.now.Add(d), period: period, active: true}
+	mc.timers = append(mc.timers, timer)
+	return timer
+}
+
+func (mc *manualClock) NewTimer(d time.Duration) Timer {
+	return mc.add(d, nil, 0)
+}
+
+func (mc *manualClock) NewTicker(d time.Duration) Ticker {
+	return manualTicker{mc.add(d, nil, d)}
+}
+
+func (mc *manualClock) After(d time.Duration) <-chan time.Time {
+	return mc.add(d, nil, 0).c
+}
+
+func (mc *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	return mc.add(d, f, 0)
+}
+
+/**
+Move time forward, firing timers due by then, each at most once
+*/
+func (mc *manualClock) Advance(d time.Duration) {
+	mc.lock.Lock()
+	mc.now = mc.now.Add(d)
+	now := mc.now
+	var due []*manualTimer
+	for _, timer := range mc.timers {
+		if !timer.active || timer.at.After(now) {
+			continue
+		}
+		due = append(due, timer)
+		if timer.period > 0 {
+			timer.at = timer.at.Add(timer.period)
+		} else {
+			timer.active = false
+		}
+	}
+	mc.lock.Unlock()
+
+	for _, timer := range due {
+		if timer.f != nil {
+			go timer.f()
+			continue
+		}
+		select {
+		case timer.c <- now:
+		default:
+		}
+	}
+}
+
+/**
+Wait until somebody waits for timer due in d from now,
+so Advance does not run ahead of it
+*/
+func (mc *manualClock) waitTimer(t testing.TB, d time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mc.lock.Lock()
+		at := mc.now.Add(d)
+		for _, timer := range mc.timers {
+			if timer.active && timer.at.Equal(at) {
+				mc.lock.Unlock()
+				return
+			}
+		}
+		mc.lock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no timer due in", d)
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	active := t.active
+	t.active, t.at = true, t.clock.now.Add(d)
+	return active
+}
+
+func (t manualTicker) Stop() {
+	t.manualTimer.Stop()
+}
+
+/**
+Backoff waiting delay before each of given amount of attempts
+*/
+type fixedBackoff struct {
+	delay    time.Duration
+	attempts int
+}
+
+func (b fixedBackoff) NextDelay(attempt int, lastErr error) (time.Duration, bool) {
+	return b.delay, attempt <= b.attempts
+}
+
+/**
+Start client channel on pipe connection with given clock,
+as DialWithOptions does after the handshake
+*/
+func newPipeClient(conn *pipeConn, clock Clock) *Client {
+	c := &Client{}
+	c.initMethods()
+	c.shared = &c.methods
+	c.SetClock(clock)
+	c.initChannel(queueBufferSize)
+	c.setConn(conn)
+	c.startLoops(&c.methods)
+
+	return c
+}
+
+func expectPing(t testing.TB, conn *pipeConn) {
+	t.Helper()
+
+	select {
+	case frame := <-conn.out:
+		if frame != "2" {
+			t.Fatal("written", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping not written")
+	}
+}
+
+func TestClockPingTimeout(t *testing.T) {
+	clock := newManualClock()
+	conn := newPipeConn()
+	c := newPipeClient(conn, clock)
+	defer c.Close()
+	interval, timeout := conn.PingParams()
+	clock.waitTimer(t, interval)
+
+	//peer answering pings keeps the channel alive
+	for i := 0; i < 3; i++ {
+		clock.Advance(interval)
+		expectPing(t, conn)
+		conn.in <- "3"
+		//pong is read before the next tick
+		for c.idleFor() >= interval {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	//silent peer is pinged until interval and timeout pass
+	clock.Advance(interval)
+	expectPing(t, conn)
+	clock.Advance(timeout)
+	expectPing(t, conn)
+	if !c.IsAlive() {
+		t.Fatal("closed before timeout passed")
+	}
+	clock.Advance(interval)
+	waitClosed(t, &c.Channel)
+	expectReason(t, &c.Channel, DisconnectPingTimeout, nil)
+	expectCause(t, &c.Channel, ClosePingTimeout)
+}
+
+func TestClockAckTimeout(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	h := NewLoopHarness(s)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := h.Channel.Ack("slow", nil, time.Minute)
+		result <- err
+	}()
+	clock.waitTimer(t, time.Minute)
+
+	clock.Advance(time.Minute - time.Second)
+	select {
+	case err := <-result:
+		t.Fatal("ack finished early:", err)
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrorSendTimeout) {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ack not timed out")
+	}
+}
+
+func TestClockReconnectBackoff(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 2)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	clock := newManualClock()
+	attempts := make(chan time.Duration, 10)
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		Clock:              clock,
+		Reconnect:          true,
+		Backoff:            fixedBackoff{delay: time.Minute, attempts: 3},
+		OnReconnectAttempt: func(attempt int, delay time.Duration) { attempts <- delay },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sc := <-connected
+
+	//connection is lost without disconnect packet
+	sc.connection().Close()
+	select {
+	case delay := <-attempts:
+		if delay != time.Minute {
+			t.Fatal("delay", delay)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnection attempt")
+	}
+	clock.waitTimer(t, time.Minute)
+
+	clock.Advance(time.Minute - time.Second)
+	select {
+	case <-connected:
+		t.Fatal("reconnected before delay passed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reconnected after delay")
+	}
+	if !client.IsAlive() {
+		t.Fatal("client closed")
+	}
+}
+
+func TestClockHandlerTiming(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetSlowHandlerThreshold(time.Second)
+	var took time.Duration
+	s.OnSlowHandler(func(c *Channel, event string, d time.Duration) { took = d })
+
+	var connectedAt, receivedAt time.Time
+	var queueDelay time.Duration
+	s.On(OnConnection, func(ctx *EventContext) { connectedAt = ctx.ReceivedAt() })
+	s.On("work", func(ctx *EventContext) {
+		receivedAt, queueDelay = ctx.ReceivedAt(), ctx.QueueDelay()
+		clock.Advance(3 * time.Second)
+	})
+
+	h := NewLoopHarness(s)
+	if !connectedAt.Equal(clock.Now()) {
+		t.Fatalf("connection handled at %v, want clock time %v", connectedAt, clock.Now())
+	}
+
+	start := clock.Now()
+	feedEvent(t, h, "work")
+	if !receivedAt.Equal(start) || queueDelay != 0 {
+		t.Fatalf("received at %v after %v, want clock time %v", receivedAt, queueDelay, start)
+	}
+	if took != 3*time.Second {
+		t.Fatalf("handler took %v, want time the clock moved", took)
+	}
+}
+
+func TestSlowHandlerLogged(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetSlowHandlerThreshold(time.Second)
+	s.On("fast", func(c *Channel) { clock.Advance(time.Second) })
+	s.On("slow", func(c *Channel) { clock.Advance(3 * time.Second) })
+	s.On("failing", func(c *Channel) {
+		clock.Advance(2 * time.Second)
+		panic("handler failed")
+	})
+	h := NewLoopHarness(s)
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	feedEvent(t, h, "fast")
+	if strings.Contains(out.String(), "slow handler") {
+		t.Fatal("handler within threshold logged", out.String())
+	}
+
+	feedEvent(t, h, "slow")
+	if !strings.Contains(out.String(), "slow handler:  slow 3s") {
+		t.Fatal("slow handler not logged", out.String())
+	}
+
+	//panicked handler is timed too
+	feedEvent(t, h, "failing")
+	if !strings.Contains(out.String(), "slow handler:  failing 2s") {
+		t.Fatal("panicked slow handler not logged", out.String())
+	}
+
+	//hook replaces the log line
+	out.Reset()
+	var reported []string
+	s.OnSlowHandler(func(c *Channel, event string, took time.Duration) {
+		reported = append(reported, event+" "+took.String())
+	})
+	feedEvent(t, h, "slow")
+	if strings.Contains(out.String(), "slow handler") {
+		t.Fatal("logged with hook set", out.String())
+	}
+	if len(reported) != 1 || reported[0] != "slow 3s" {
+		t.Fatal("reported", reported)
+	}
+
+	s.SetSlowHandlerThreshold(0)
+	feedEvent(t, h, "slow")
+	if len(reported) != 1 {
+		t.Fatal("reported with threshold disabled", reported)
+	}
+}
+
+func TestClockQueueResidency(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	h := newOpenHarness(s)
+
+	h.Channel.Emit("msg", "a")
+	clock.Advance(2 * time.Second)
+	expectFrames(t, h, `42["msg","a"]`)
+
+	if stats := h.Channel.Stats(); stats.ResidencyMax != 2*time.Second {
+		t.Fatalf("residency %v, want time the clock moved", stats.ResidencyMax)
+	}
+}
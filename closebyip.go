@@ -0,0 +1,47 @@
+package gophersocket
+
+import (
+	"fmt"
+	"log"
+	"net"
+)
+
+/**
+Close every channel connected from given ip, reason is logged.
+Ip matches remote address of the connection with or without its port.
+Returns amount of channels closed. Channels connecting while it runs
+may be missed, call it again after blocking the ip
+*/
+func (s *Server) CloseByIP(ip string, reason string) int {
+	var matched []*Channel
+
+	s.sidsLock.RLock()
+	for _, c := range s.sids {
+		if c.IsAlive() && matchIP(c.ip, ip) {
+			matched = append(matched, c)
+		}
+	}
+	s.sidsLock.RUnlock()
+
+	closed := 0
+	for _, c := range matched {
+		//somebody else may close it meanwhile, count only own closes
+		if !c.IsAlive() {
+			continue
+		}
+		log.Println("socket.io closing channel by ip: ", c.Id(), ip, reason)
+		c.disconnectByServer(fmt.Errorf("%w: %s", ErrorClosedByIP, reason))
+		closed++
+	}
+
+	return closed
+}
+
+func matchIP(remoteAddr, ip string) bool {
+	if remoteAddr == ip {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	return err == nil && host == ip
+}
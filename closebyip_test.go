@@ -0,0 +1,98 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func TestCloseByIP(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 3)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	var channels []*Channel
+	for i := 0; i < 3; i++ {
+		_, closeClient := dialTestServer(t, s)
+		defer closeClient()
+		channels = append(channels, <-connected)
+	}
+	other := NewLoopHarnessWithOptions(s, HarnessOptions{RemoteAddr: "10.0.0.1:5000"})
+
+	if n := s.CloseByIP("127.0.0.1", "abuse"); n != 3 {
+		t.Fatal("closed", n)
+	}
+	for _, c := range channels {
+		waitClosed(t, c)
+	}
+	if !other.Channel.IsAlive() {
+		t.Fatal("channel of other ip closed")
+	}
+
+	//nothing left to close
+	if n := s.CloseByIP("127.0.0.1", "abuse"); n != 0 {
+		t.Fatal("closed again", n)
+	}
+}
+
+func TestCloseByIPMatchesPort(t *testing.T) {
+	for _, tc := range []struct {
+		remoteAddr, ip string
+		match          bool
+	}{
+		{"10.0.0.1:5000", "10.0.0.1", true},
+		{"10.0.0.1", "10.0.0.1", true},
+		{"[::1]:5000", "::1", true},
+		{"10.0.0.10:5000", "10.0.0.1", false},
+		{"10.0.0.1:5000", "10.0.0.1:6000", false},
+	} {
+		if matchIP(tc.remoteAddr, tc.ip) != tc.match {
+			t.Error(tc.remoteAddr, tc.ip)
+		}
+	}
+}
+
+func TestCloseByIPConcurrentConnects(t *testing.T) {
+	s := newTestServer()
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				client, err := Dial(url, transport.GetDefaultWebsocketTransport())
+				if err == nil {
+					client.Close()
+				}
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		s.CloseByIP("127.0.0.1", "abuse")
+	}
+	close(stop)
+	wg.Wait()
+
+	//once connects stop a last pass leaves nothing alive
+	s.CloseByIP("127.0.0.1", "abuse")
+	s.sidsLock.RLock()
+	defer s.sidsLock.RUnlock()
+	for _, c := range s.sids {
+		if c.IsAlive() {
+			t.Fatal("channel left alive", c.Id())
+		}
+	}
+}
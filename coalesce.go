@@ -0,0 +1,75 @@
+package gophersocket
+
+import (
+	"time"
+)
+
+/**
+State of coalesced broadcasts of one event of one room to one channel
+*/
+type coalescedEvent struct {
+	command  string
+	lastSent time.Time
+	pending  bool
+}
+
+/**
+Coalesce broadcasts to given room, each channel gets not more than one
+message of each event per interval, the latest one. Zero disables it
+*/
+func (s *Server) SetRoomCoalescing(room string, interval time.Duration) {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	if interval <= 0 {
+		delete(s.roomCoalescing, room)
+		return
+	}
+	s.roomCoalescing[room] = interval
+}
+
+/**
+Put encoded broadcast to out queue now if nothing was sent during
+the interval, otherwise keep it to be queued at the end of interval,
+replacing the kept one. Queued as broadcasts are, within broadcast
+share of the out queue
+*/
+func (c *Channel) emitCoalesced(room, method, command string, interval time.Duration) {
+	c.coalescedLock.Lock()
+	defer c.coalescedLock.Unlock()
+
+	if c.coalesced == nil {
+		c.coalesced = make(map[string]*coalescedEvent)
+	}
+	key := room + "\x00" + method
+	ev, ok := c.coalesced[key]
+	if !ok {
+		ev = &coalescedEvent{}
+		c.coalesced[key] = ev
+	}
+
+	ev.command = command
+	if ev.pending {
+		return
+	}
+
+	clock := c.clock()
+	wait := interval - clock.Now().Sub(ev.lastSent)
+	if wait <= 0 {
+		ev.lastSent = clock.Now()
+		c.enqueueBroadcast(command)
+		return
+	}
+
+	ev.pending = true
+	clock.AfterFunc(wait, func() {
+		c.coalescedLock.Lock()
+		defer c.coalescedLock.Unlock()
+
+		ev.pending = false
+		ev.lastSent = clock.Now()
+		if c.IsAlive() {
+			c.enqueueBroadcast(ev.command)
+		}
+	})
+}
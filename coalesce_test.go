@@ -0,0 +1,92 @@
+package gophersocket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRoomCoalescingLatestOnly(t *testing.T) {
+	s := newTestServer()
+	s.SetRoomCoalescing("metrics", 100*time.Millisecond)
+
+	h := newOpenHarness(s)
+	h.Channel.Join("metrics")
+
+	for i := 1; i <= 100; i++ {
+		s.BroadcastTo("metrics", "cpu", i)
+		s.BroadcastTo("metrics", "mem", -i)
+	}
+
+	//first update of each event goes at once, the latest one at the end
+	//of the interval, everything in between is dropped
+	time.Sleep(300 * time.Millisecond)
+	h.Pump()
+	got := map[string]bool{}
+	for _, frame := range h.Frames() {
+		got[frame] = true
+	}
+	want := []string{`42["cpu",1]`, `42["cpu",100]`, `42["mem",-1]`, `42["mem",-100]`}
+	if len(got) != len(want) {
+		t.Fatalf("got frames %v, want %v", got, want)
+	}
+	for _, frame := range want {
+		if !got[frame] {
+			t.Fatalf("got frames %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRoomCoalescingDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetRoomCoalescing("metrics", time.Second)
+	s.SetRoomCoalescing("metrics", 0)
+
+	h := newOpenHarness(s)
+	h.Channel.Join("metrics")
+
+	var want []string
+	for i := 1; i <= 5; i++ {
+		s.BroadcastTo("metrics", "cpu", i)
+		want = append(want, fmt.Sprintf(`42["cpu",%d]`, i))
+	}
+	expectFrames(t, h, want...)
+}
+
+func TestRoomCoalescingQueuedAsBroadcast(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetRoomCoalescing("metrics", time.Second)
+	h := newOpenHarness(s)
+	h.Channel.Join("metrics")
+	direct := h.Channel.Stats().DirectEnqueued
+
+	//queued before BroadcastTo returns, ahead of the following emit
+	s.BroadcastTo("metrics", "cpu", 1)
+	h.Channel.Emit("direct", 1)
+	s.BroadcastTo("metrics", "cpu", 2)
+	s.BroadcastTo("metrics", "cpu", 3)
+	expectFrames(t, h, `42["cpu",1]`, `42["direct",1]`)
+
+	clock.Advance(time.Second)
+	waitFrame(t, h, `42["cpu",3]`)
+
+	stats := h.Channel.Stats()
+	if stats.BroadcastEnqueued != 2 || stats.DirectEnqueued != direct+1 {
+		t.Fatalf("broadcast %d, direct %d enqueued", stats.BroadcastEnqueued, stats.DirectEnqueued-direct)
+	}
+}
+
+func TestRoomCoalescingPerRoom(t *testing.T) {
+	s := newTestServer()
+	s.SetRoomCoalescing("a", time.Minute)
+	s.SetRoomCoalescing("b", time.Minute)
+	h := newOpenHarness(s)
+	h.Channel.Join("a")
+	h.Channel.Join("b")
+
+	s.BroadcastTo("a", "cpu", 1)
+	s.BroadcastTo("b", "cpu", 2)
+	expectFrames(t, h, `42["cpu",1]`, `42["cpu",2]`)
+}
@@ -0,0 +1,58 @@
+package gophersocket
+
+import (
+	"github.com/whiterabb17/gopher-socket/codec"
+)
+
+/**
+Set codec used for arguments of emitted messages, acks and handler
+arguments, nil restores the default encoding/json one
+*/
+func (m *methods) SetCodec(cd codec.Codec) {
+	storeHeld(&m.codec, cd)
+}
+
+func (m *methods) getCodec() codec.Codec {
+	if cd, ok := loadHeld(&m.codec).(codec.Codec); ok {
+		return cd
+	}
+
+	return codec.JSONCodec{}
+}
+
+/**
+Same as On, but arguments of the function and its ack result
+are processed with given codec instead of the shared one
+*/
+func (m *methods) OnWithCodec(method string, f interface{}, cd codec.Codec) error {
+	c, err := newCaller(method, f)
+	if err != nil {
+		return err
+	}
+	c.Codec = cd
+
+	m.addCaller(method, c)
+	return nil
+}
+
+/**
+Get codec of given handler, falling back to the shared one
+*/
+func (c *caller) getCodec(shared codec.Codec) codec.Codec {
+	if c.Codec != nil {
+		return c.Codec
+	}
+
+	return shared
+}
+
+/**
+Get codec the channel encodes arguments with
+*/
+func (c *Channel) codec() codec.Codec {
+	if c.shared != nil {
+		return c.shared.getCodec()
+	}
+
+	return codec.JSONCodec{}
+}
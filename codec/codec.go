@@ -0,0 +1,35 @@
+package codec
+
+import (
+	"encoding/json"
+)
+
+/**
+Converts arguments of messages to Go values and back, independently of
+packet framing. Produced data is put to JSON array of the packet as is,
+so it should be valid JSON
+*/
+type Codec interface {
+	/**
+	Encode given value
+	*/
+	Marshal(v interface{}) ([]byte, error)
+
+	/**
+	Decode data into value given pointer points to
+	*/
+	Unmarshal(data []byte, v interface{}) error
+}
+
+/**
+Default codec, uses encoding/json
+*/
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,50 @@
+package protojson
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+/**
+Codec for protobuf generated types, using protojson options,
+values which are not protobuf messages are processed by encoding/json
+*/
+type Codec struct {
+	MarshalOptions   protojson.MarshalOptions
+	UnmarshalOptions protojson.UnmarshalOptions
+}
+
+func (c Codec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return c.MarshalOptions.Marshal(m)
+	}
+
+	return json.Marshal(v)
+}
+
+/**
+Decode data into protobuf message, v may be the message itself, or
+pointer to message pointer, as handler argument of message type gives
+*/
+func (c Codec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return c.UnmarshalOptions.Unmarshal(data, m)
+	}
+
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() == reflect.Ptr && !ptr.IsNil() && ptr.Elem().Kind() == reflect.Ptr {
+		elem := reflect.New(ptr.Elem().Type().Elem())
+		if m, ok := elem.Interface().(proto.Message); ok {
+			if err := c.UnmarshalOptions.Unmarshal(data, m); err != nil {
+				return err
+			}
+			ptr.Elem().Set(elem)
+			return nil
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
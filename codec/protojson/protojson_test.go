@@ -0,0 +1,95 @@
+package protojson
+
+import (
+	"testing"
+
+	gophersocket "github.com/whiterabb17/gopher-socket"
+	"github.com/whiterabb17/gopher-socket/transport"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var cd Codec
+	data, err := cd.Marshal(wrapperspb.String("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"hi"` {
+		t.Fatal(string(data))
+	}
+
+	//handler argument of message type gives pointer to message pointer
+	var msg *wrapperspb.StringValue
+	if err := cd.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.GetValue() != "hi" {
+		t.Fatal(msg)
+	}
+
+	direct := &wrapperspb.StringValue{}
+	if err := cd.Unmarshal(data, direct); err != nil || direct.GetValue() != "hi" {
+		t.Fatal(direct, err)
+	}
+}
+
+func TestNonMessageValues(t *testing.T) {
+	var cd Codec
+	data, err := cd.Marshal(map[string]int{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"a":1}` {
+		t.Fatal(string(data))
+	}
+
+	var v map[string]int
+	if err := cd.Unmarshal(data, &v); err != nil || v["a"] != 1 {
+		t.Fatal(v, err)
+	}
+}
+
+func TestMessageDecodeError(t *testing.T) {
+	var cd Codec
+
+	//value of other type is not decoded into message
+	var msg *wrapperspb.StringValue
+	if err := cd.Unmarshal([]byte(`{"value":1}`), &msg); err == nil {
+		t.Fatal("decoded", msg)
+	}
+	if err := cd.Unmarshal([]byte(`1`), &wrapperspb.StringValue{}); err == nil {
+		t.Fatal("decoded number into string message")
+	}
+
+	if _, err := cd.Marshal(make(chan int)); err == nil {
+		t.Fatal("encoded value json can not encode")
+	}
+}
+
+func TestHandlerCodecOverride(t *testing.T) {
+	s := gophersocket.NewServer(transport.GetDefaultWebsocketTransport())
+	echo := func(c *gophersocket.Channel, v *wrapperspb.StringValue) *wrapperspb.StringValue {
+		return v
+	}
+	if err := s.OnWithCodec("proto", echo, Codec{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.On("json", echo); err != nil {
+		t.Fatal(err)
+	}
+	h := gophersocket.NewLoopHarness(s)
+	h.Pump()
+	h.Frames()
+
+	for _, frame := range []string{`421["proto","hi"]`, `422["json",{"value":"hi"}]`} {
+		if err := h.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Pump()
+	//shared encoding/json codec sees the message as a struct
+	frames := h.Frames()
+	if len(frames) != 2 || frames[0] != `431["hi"]` || frames[1] != `432[{"value":"hi"}]` {
+		t.Fatal(frames)
+	}
+}
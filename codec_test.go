@@ -0,0 +1,77 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/whiterabb17/gopher-socket/codec"
+)
+
+/**
+Codec prefixing string values, to tell which codec processed them
+*/
+type prefixCodec struct{}
+
+func (prefixCodec) Marshal(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return json.Marshal("p:" + s)
+	}
+	return json.Marshal(v)
+}
+
+func (prefixCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if s, ok := v.(*string); ok {
+		*s = strings.TrimPrefix(*s, "p:")
+	}
+	return nil
+}
+
+func TestSharedCodec(t *testing.T) {
+	s := newTestServer()
+	s.SetCodec(prefixCodec{})
+	var got []string
+	s.On("echo", func(c *Channel, v string) string {
+		got = append(got, v)
+		return v
+	})
+	h := newOpenHarness(s)
+
+	if err := h.Feed(`421["echo","a"]`); err != nil {
+		t.Fatal(err)
+	}
+	h.Channel.Emit("ev", "b")
+	expectFrames(t, h, `431["p:a"]`, `42["ev","p:b"]`)
+	if len(got) != 1 || got[0] != "a" {
+		t.Fatal(got)
+	}
+
+	//nil restores encoding/json
+	s.SetCodec(nil)
+	h.Channel.Emit("ev", "b")
+	expectFrames(t, h, `42["ev","b"]`)
+}
+
+func TestHandlerCodec(t *testing.T) {
+	s := newTestServer()
+	s.SetCodec(prefixCodec{})
+	var got []string
+	if err := s.OnWithCodec("raw", func(c *Channel, v string) string {
+		got = append(got, v)
+		return v
+	}, codec.JSONCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	h := newOpenHarness(s)
+
+	if err := h.Feed(`421["raw","p:a"]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `431["p:a"]`)
+	if len(got) != 1 || got[0] != "p:a" {
+		t.Fatal(got)
+	}
+}
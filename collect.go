@@ -0,0 +1,245 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Readings of collected events dropped as the collect function
+	was behind, labeled by event
+	*/
+	MetricCollectDropped = "collect_dropped_total"
+
+	DefaultCollectMaxBatch = 1000
+)
+
+var (
+	ErrorCollectFunc = errors.New("Collect function is not set")
+)
+
+/**
+One reading of collected event
+*/
+type IncomingEvent struct {
+	Sid string
+
+	//first argument of the event, nil if there is none
+	Payload json.RawMessage
+
+	//when the packet was read
+	Timestamp time.Time
+}
+
+/**
+Options of CollectWithOptions
+*/
+type CollectOptions struct {
+	//batch is passed to the function after window since its first reading,
+	//zero passes only full batches
+	Window time.Duration
+
+	//or earlier once it has MaxBatch readings, DefaultCollectMaxBatch if zero
+	MaxBatch int
+
+	//dispatch events to handlers too, they are skipped by default
+	Dispatch bool
+}
+
+/**
+Merger of one event from all channels into batches. At most one batch
+waits while the function runs, readings arriving when both it and the
+batch being filled are full are dropped, so a slow function lowers
+the accepted rate instead of growing memory
+*/
+type collector struct {
+	event string
+	opts  CollectOptions
+	m     *methods
+
+	current []IncomingEvent
+	timer   Timer
+	stopped bool
+	lock    sync.Mutex
+
+	ready chan []IncomingEvent
+	done  chan struct{}
+}
+
+/**
+Merge given event from all channels into batches passed to fn, see
+CollectWithOptions. Handlers of the event are skipped
+*/
+func (s *Server) Collect(event string, window time.Duration, maxBatch int, fn func(batch []IncomingEvent)) error {
+	return s.CollectWithOptions(event, fn, CollectOptions{Window: window, MaxBatch: maxBatch})
+}
+
+/**
+Merge given event from all channels into batches passed to fn, once per
+window or MaxBatch readings. Events are taken before handler goroutines
+are started, ack requests are dispatched as usual. fn is called from one
+goroutine, when it is slow, up to one batch waits for it and following
+readings are dropped, counting them. Replaces previous collection of
+the event, its readings are passed to its function first
+*/
+func (s *Server) CollectWithOptions(event string, fn func(batch []IncomingEvent), opts CollectOptions) error {
+	if fn == nil {
+		return ErrorCollectFunc
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = DefaultCollectMaxBatch
+	}
+
+	col := &collector{
+		event: event,
+		opts:  opts,
+		m:     &s.methods,
+		ready: make(chan []IncomingEvent, 1),
+		done:  make(chan struct{}),
+	}
+	go col.run(fn)
+
+	if old, ok := s.collectors.Load(event); ok {
+		old.(*collector).stop()
+	}
+	s.collectors.Store(event, col)
+
+	return nil
+}
+
+/**
+Stop collecting given event, readings collected so far are passed
+to the function, following events go to handlers
+*/
+func (s *Server) StopCollect(event string) {
+	if old, ok := s.collectors.Load(event); ok {
+		s.collectors.Delete(event)
+		old.(*collector).stop()
+	}
+}
+
+/**
+Pass event to its collector, returns true if handlers should be skipped
+*/
+func (s *Server) collect(c *Channel, msg *protocol.Message, received time.Time) bool {
+	if msg.Type != protocol.MessageTypeEmit {
+		return false
+	}
+	found, ok := s.collectors.Load(msg.Method)
+	if !ok {
+		return false
+	}
+	col := found.(*collector)
+
+	var payload json.RawMessage
+	if args, err := msg.ArgList(); err == nil && len(args) > 0 {
+		payload = args[0]
+	}
+	col.add(IncomingEvent{Sid: c.Id(), Payload: payload, Timestamp: received})
+
+	return !col.opts.Dispatch
+}
+
+func (col *collector) add(ev IncomingEvent) {
+	col.lock.Lock()
+	defer col.lock.Unlock()
+
+	if col.stopped {
+		return
+	}
+	if len(col.current) >= col.opts.MaxBatch && !col.flushLocked() {
+		col.m.metricAdd(MetricCollectDropped, 1, "event", col.event)
+		return
+	}
+
+	if col.current == nil {
+		col.current = make([]IncomingEvent, 0, col.opts.MaxBatch)
+	}
+	col.current = append(col.current, ev)
+	if len(col.current) >= col.opts.MaxBatch {
+		col.flushLocked()
+		return
+	}
+	if col.timer == nil && col.opts.Window > 0 {
+		col.timer = col.m.getClock().AfterFunc(col.opts.Window, col.flush)
+	}
+}
+
+func (col *collector) flush() {
+	col.lock.Lock()
+	defer col.lock.Unlock()
+
+	col.timer = nil
+	if !col.stopped && !col.flushLocked() {
+		//function is behind, try again next window
+		col.timer = col.m.getClock().AfterFunc(col.opts.Window, col.flush)
+	}
+}
+
+/**
+Hand current batch to the function goroutine, returns false
+if the previous one is still waiting
+*/
+func (col *collector) flushLocked() bool {
+	if len(col.current) == 0 {
+		return true
+	}
+
+	select {
+	case col.ready <- col.current:
+	default:
+		return false
+	}
+
+	col.current = nil
+	if col.timer != nil {
+		col.timer.Stop()
+		col.timer = nil
+	}
+
+	return true
+}
+
+func (col *collector) run(fn func(batch []IncomingEvent)) {
+	for {
+		select {
+		case batch := <-col.ready:
+			fn(batch)
+		case <-col.done:
+			//batch handed before stop, then the rest
+			select {
+			case batch := <-col.ready:
+				fn(batch)
+			default:
+			}
+			col.lock.Lock()
+			rest := col.current
+			col.current = nil
+			col.lock.Unlock()
+			if len(rest) > 0 {
+				fn(rest)
+			}
+			return
+		}
+	}
+}
+
+func (col *collector) stop() {
+	col.lock.Lock()
+	defer col.lock.Unlock()
+
+	if col.stopped {
+		return
+	}
+	col.stopped = true
+	if col.timer != nil {
+		col.timer.Stop()
+		col.timer = nil
+	}
+	close(col.done)
+}
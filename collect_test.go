@@ -0,0 +1,120 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+func receiveBatch(t testing.TB, batches chan []IncomingEvent) []IncomingEvent {
+	t.Helper()
+
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not collected")
+		return nil
+	}
+}
+
+func TestCollectFullBatch(t *testing.T) {
+	s := newTestServer()
+	handled := make(chan struct{}, 2)
+	s.On("tick", func(c *Channel, v int) { handled <- struct{}{} })
+	batches := make(chan []IncomingEvent, 1)
+	s.Collect("tick", 0, 2, func(batch []IncomingEvent) { batches <- batch })
+
+	a := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "a"})
+	b := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "b"})
+	feedEvent(t, a, "tick", 1)
+	feedEvent(t, b, "tick", 2)
+
+	batch := receiveBatch(t, batches)
+	if len(batch) != 2 || batch[0].Sid != "a" || string(batch[0].Payload) != "1" ||
+		batch[1].Sid != "b" || string(batch[1].Payload) != "2" {
+
+		t.Fatalf("batch %+v", batch)
+	}
+	select {
+	case <-handled:
+		t.Fatal("collected event dispatched")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestCollectWindow(t *testing.T) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	handled := make(chan struct{}, 1)
+	s.On("tick", func(c *Channel, v int) { handled <- struct{}{} })
+	batches := make(chan []IncomingEvent, 1)
+	s.CollectWithOptions("tick", func(batch []IncomingEvent) { batches <- batch },
+		CollectOptions{Window: time.Second, Dispatch: true})
+
+	h := newOpenHarness(s)
+	feedEvent(t, h, "tick", 1)
+	received := clock.Now()
+	<-handled
+
+	clock.Advance(time.Second)
+	batch := receiveBatch(t, batches)
+	if len(batch) != 1 || !batch[0].Timestamp.Equal(received) {
+		t.Fatalf("batch %+v", batch)
+	}
+}
+
+func TestCollectSlowFunctionDrops(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	started, release := make(chan struct{}, 4), make(chan struct{})
+	batches := make(chan []IncomingEvent, 4)
+	s.Collect("tick", 0, 1, func(batch []IncomingEvent) {
+		started <- struct{}{}
+		<-release
+		batches <- batch
+	})
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, "tick", 1)
+	<-started
+	//one batch waits for the function, one is being filled, the rest drops
+	for i := 2; i <= 4; i++ {
+		feedEvent(t, h, "tick", i)
+	}
+	if n := metrics.get(MetricCollectDropped, "event", "tick"); n != 1 {
+		t.Fatalf("%v dropped, want 1", n)
+	}
+
+	close(release)
+	s.StopCollect("tick")
+	for i := 1; i <= 3; i++ {
+		if batch := receiveBatch(t, batches); string(batch[0].Payload) != string(rune('0'+i)) {
+			t.Fatalf("batch %d: %+v", i, batch)
+		}
+	}
+}
+
+func TestStopCollect(t *testing.T) {
+	s := newTestServer()
+	handled := make(chan int, 1)
+	s.On("tick", func(c *Channel, v int) { handled <- v })
+	batches := make(chan []IncomingEvent, 1)
+	s.Collect("tick", time.Hour, 10, func(batch []IncomingEvent) { batches <- batch })
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, "tick", 1)
+	s.StopCollect("tick")
+	if batch := receiveBatch(t, batches); len(batch) != 1 {
+		t.Fatalf("rest %+v", batch)
+	}
+
+	feedEvent(t, h, "tick", 2)
+	if v := <-handled; v != 2 {
+		t.Fatal("handled", v)
+	}
+	if err := s.Collect("tick", 0, 1, nil); err != ErrorCollectFunc {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package gophersocket
+
+import (
+	"sync/atomic"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Set whether messages of the event are compressed, overriding compression
+negotiated for the connection. Has effect only on connections with
+compression enabled, whose transport implements CompressionWriter
+*/
+func (m *methods) SetEventCompression(event string, compress bool) {
+	if _, loaded := m.eventCompression.LoadOrStore(event, compress); loaded {
+		m.eventCompression.Store(event, compress)
+		return
+	}
+	atomic.AddInt32(&m.eventCompressionCount, 1)
+}
+
+/**
+Remove setting of the event, its messages follow the connection again
+*/
+func (m *methods) ResetEventCompression(event string) {
+	if _, loaded := m.eventCompression.LoadAndDelete(event); loaded {
+		atomic.AddInt32(&m.eventCompressionCount, -1)
+	}
+}
+
+/**
+Get compression setting of the event the packet belongs to,
+ok is false if there is none
+*/
+func (m *methods) packetCompression(data string) (compress, ok bool) {
+	if atomic.LoadInt32(&m.eventCompressionCount) == 0 {
+		return false, false
+	}
+
+	msg, err := protocol.Decode(data)
+	if err != nil || msg.Method == "" {
+		return false, false
+	}
+	setting, ok := m.eventCompression.Load(msg.Method)
+	if !ok {
+		return false, false
+	}
+
+	return setting.(bool), true
+}
+
+/**
+Write packet to the connection, following compression setting of its event
+*/
+func writePacket(m *methods, conn transport.Connection, data string) error {
+	if writer, ok := conn.(transport.CompressionWriter); ok {
+		if compress, ok := m.packetCompression(data); ok {
+			return writer.WriteMessageCompressed(data, compress)
+		}
+	}
+
+	return conn.WriteMessage(data)
+}
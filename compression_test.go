@@ -0,0 +1,97 @@
+package gophersocket
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Network connection counting bytes read from it
+*/
+type countingConn struct {
+	net.Conn
+	read int64
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	atomic.AddInt64(&cc.read, int64(n))
+	return n, err
+}
+
+func readRawFrame(t testing.TB, socket *websocket.Conn) string {
+	t.Helper()
+
+	socket.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := socket.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestEventCompression(t *testing.T) {
+	tr := transport.GetDefaultWebsocketTransport()
+	tr.EnableCompression = true
+	s := NewServer(tr)
+	s.SetEventCompression("packed", true)
+	s.SetEventCompression("plain", false)
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	var counter *countingConn
+	dialer := websocket.Dialer{
+		EnableCompression: true,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			counter = &countingConn{Conn: conn}
+			return counter, err
+		},
+	}
+	socket, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	c := <-connected
+	//open packet and connect
+	readRawFrame(t, socket)
+	readRawFrame(t, socket)
+
+	payload := strings.Repeat("compressible ", 1000)
+	//bytes read off the network for one event with the payload
+	wireSize := func(event string) int64 {
+		before := atomic.LoadInt64(&counter.read)
+		if err := c.Emit(event, payload); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(readRawFrame(t, socket), payload) {
+			t.Fatal("payload not received")
+		}
+		return atomic.LoadInt64(&counter.read) - before
+	}
+
+	if size := wireSize("packed"); size > int64(len(payload))/4 {
+		t.Fatalf("compressed event took %d bytes", size)
+	}
+	if size := wireSize("plain"); size < int64(len(payload)) {
+		t.Fatalf("uncompressed event took %d bytes", size)
+	}
+	//no setting follows the connection
+	if size := wireSize("other"); size > int64(len(payload))/4 {
+		t.Fatalf("event without setting took %d bytes", size)
+	}
+
+	s.ResetEventCompression("plain")
+	if size := wireSize("plain"); size > int64(len(payload))/4 {
+		t.Fatalf("reset event took %d bytes", size)
+	}
+}
@@ -0,0 +1,112 @@
+package gophersocket
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Event of client called when server rejects the connection,
+	function may take ConnectError as second argument
+	*/
+	OnConnectError = "connect_error"
+
+	//time to write connect error packet before the connection is closed
+	connectErrorTimeout = time.Second
+)
+
+var connectErrorType = reflect.TypeOf(ConnectError{})
+
+/**
+Error sent to client in connect error packet, guard may return it
+to pass data along with the message
+*/
+type ConnectError struct {
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *ConnectError) Error() string {
+	return e.Message
+}
+
+/**
+Set function checking new connection before OnConnection, non-nil error
+rejects it: client is sent connect error packet with the error, and the
+connection is closed. OnConnection and OnDisconnection are not called
+for rejected connections. The guard may tell client versions apart
+by c.Subprotocol()
+*/
+func (s *Server) SetConnectGuard(guard func(c *Channel) error) {
+	s.connectGuard = guard
+}
+
+/**
+Send open and connect error packets to rejected channel, and close it
+once they are written
+*/
+func (s *Server) rejectConnect(c *Channel, err error) {
+	cerr, ok := err.(*ConnectError)
+	if !ok {
+		cerr = &ConnectError{Message: err.Error()}
+	}
+	formatted := s.systemMessage(c, SystemMessageConnectRejected, map[string]interface{}{
+		"message": cerr.Message,
+		"data":    cerr.Data,
+		"error":   err,
+	}, cerr)
+	payload, jsonErr := json.Marshal(formatted)
+	if jsonErr != nil {
+		payload, _ = json.Marshal(&ConnectError{Message: cerr.Message})
+	}
+
+	c.connectRejected = true
+	s.sendOpenPacket(c)
+	c.enqueue(protocol.MustEncode(protocol.NewConnectError("", payload)))
+
+	c.startLoops(&s.methods)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), connectErrorTimeout)
+		c.Flush(ctx)
+		cancel()
+
+		closeChannel(c, &s.methods, DisconnectServer, CloseBadHandshake, err)
+	}()
+}
+
+/**
+Call connect error handlers with error received from server,
+payload is error object or, from older servers, a plain string
+*/
+func (m *methods) callConnectError(c *Channel, payload string) *ConnectError {
+	cerr := ConnectError{}
+	if err := json.Unmarshal([]byte(payload), &cerr); err != nil {
+		if err := json.Unmarshal([]byte(payload), &cerr.Message); err != nil {
+			cerr.Message = payload
+		}
+	}
+
+	callers, ok := m.findChannelMethod(c, OnConnectError)
+	if ok {
+		ctx := &EventContext{channel: c, event: OnConnectError, received: c.clock().Now()}
+		for _, f := range callers {
+			if ctx.Stopped() {
+				break
+			}
+			if f.ArgsPresent && f.Args == connectErrorType {
+				arg := cerr
+				f.safeCallFunc(ctx, &arg)
+				continue
+			}
+			f.safeCallFunc(ctx, nil)
+		}
+	}
+
+	return &cerr
+}
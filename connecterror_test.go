@@ -0,0 +1,101 @@
+package gophersocket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectGuardRejects(t *testing.T) {
+	s := newTestServer()
+	errDenied := &ConnectError{Message: "unauthorized", Data: map[string]int{"code": 401}}
+	s.SetConnectGuard(func(c *Channel) error { return errDenied })
+	connected := false
+	s.On(OnConnection, func(c *Channel) { connected = true })
+
+	h := NewLoopHarness(s)
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 2 || !strings.HasPrefix(frames[0], "0{") ||
+		frames[1] != `44{"message":"unauthorized","data":{"code":401}}` {
+		t.Fatal(frames)
+	}
+
+	waitClosed(t, h.Channel)
+	if connected {
+		t.Fatal("OnConnection called for rejected channel")
+	}
+	if h.Channel.CloseReason() != DisconnectServer || !errors.Is(h.Channel.CloseError(), errDenied) {
+		t.Fatal(h.Channel.CloseReason(), h.Channel.CloseError())
+	}
+}
+
+func TestConnectGuardPlainError(t *testing.T) {
+	s := newTestServer()
+	s.SetConnectGuard(func(c *Channel) error { return errors.New("banned") })
+
+	h := NewLoopHarness(s)
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 2 || frames[1] != `44{"message":"banned"}` {
+		t.Fatal(frames)
+	}
+	waitClosed(t, h.Channel)
+}
+
+func TestConnectErrorNamespaceRejected(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	if err := h.Feed("40/admin,"); err != nil {
+		t.Fatal(err)
+	}
+
+	//rejected namespace keeps the root connection
+	expectFrames(t, h, `44/admin,{"message":"Invalid namespace"}`)
+	if !h.Channel.IsAlive() {
+		t.Fatal("channel closed")
+	}
+}
+
+func TestConnectErrorClientHandler(t *testing.T) {
+	conn := newPipeConn()
+	client := newPipeClient(conn, nil)
+	got := make(chan ConnectError, 1)
+	client.On(OnConnectError, func(c *Channel, cerr ConnectError) { got <- cerr })
+
+	conn.in <- `44{"message":"unauthorized","data":{"code":401}}`
+	select {
+	case cerr := <-got:
+		data, ok := cerr.Data.(map[string]interface{})
+		if cerr.Message != "unauthorized" || !ok || data["code"] != float64(401) {
+			t.Fatal(cerr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connect error handler not called")
+	}
+
+	waitClosed(t, &client.Channel)
+	var cerr *ConnectError
+	if client.CloseReason() != DisconnectServer || !errors.As(client.CloseError(), &cerr) ||
+		cerr.Message != "unauthorized" {
+		t.Fatal(client.CloseReason(), client.CloseError())
+	}
+}
+
+func TestConnectErrorClientPlainString(t *testing.T) {
+	conn := newPipeConn()
+	client := newPipeClient(conn, nil)
+	got := make(chan string, 1)
+	client.On(OnConnectError, func(c *Channel, cerr ConnectError) { got <- cerr.Message })
+
+	//older servers send the message only
+	conn.in <- `44"Not authorized"`
+	select {
+	case msg := <-got:
+		if msg != "Not authorized" {
+			t.Fatal(msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connect error handler not called")
+	}
+}
@@ -0,0 +1,84 @@
+package gophersocket
+
+const (
+	/**
+	Gauge of connected channels, labeled by transport and eio version
+	*/
+	MetricConnectionsActive = "connections_active"
+
+	//label of unknown transport or eio version
+	unknownKind = "unknown"
+)
+
+/**
+Transport and EIO version of connection
+*/
+type connKind struct {
+	transport string
+	eio       string
+}
+
+func (k connKind) labels() (string, string) {
+	transport, eio := k.transport, k.eio
+	if transport == "" {
+		transport = unknownKind
+	}
+	if eio == "" {
+		eio = unknownKind
+	}
+
+	return transport, eio
+}
+
+/**
+Count connected channel by its transport and EIO version,
+until it is closed
+*/
+func (s *Server) countConnection(c *Channel) {
+	c.transportLock.Lock()
+	s.addConnection(connKind{c.transportName, c.eio}, 1)
+	c.transportLock.Unlock()
+
+	c.OnClosed(func() {
+		c.transportLock.Lock()
+		defer c.transportLock.Unlock()
+
+		s.addConnection(connKind{c.transportName, c.eio}, -1)
+	})
+}
+
+func (s *Server) addConnection(kind connKind, delta int) {
+	s.connMixLock.Lock()
+	if s.connMix == nil {
+		s.connMix = make(map[connKind]int)
+	}
+	s.connMix[kind] += delta
+	count := s.connMix[kind]
+	if count == 0 {
+		delete(s.connMix, kind)
+	}
+	s.connMixLock.Unlock()
+
+	if metrics := s.getMetrics(); metrics != nil {
+		transport, eio := kind.labels()
+		metrics.Set(MetricConnectionsActive, float64(count), "transport", transport, "eio", eio)
+	}
+}
+
+/**
+Get amounts of connected channels by transport and by EIO version
+*/
+func (s *Server) connectionMix() (map[string]int, map[string]int) {
+	s.connMixLock.Lock()
+	defer s.connMixLock.Unlock()
+
+	transports := make(map[string]int)
+	versions := make(map[string]int)
+	for kind, count := range s.connMix {
+		transport, eio := kind.labels()
+		transports[transport] += count
+		versions[eio] += count
+	}
+
+	return transports, versions
+}
@@ -0,0 +1,58 @@
+package gophersocket
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+/**
+Connect harness channel with upgrade request of given EIO version
+*/
+func harnessWithEIO(s *Server, eio string) *LoopHarness {
+	return NewLoopHarnessWithOptions(s, HarnessOptions{
+		Request: httptest.NewRequest("GET", "/socket.io/?EIO="+eio+"&transport=websocket", nil),
+	})
+}
+
+func TestConnectionMix(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+
+	v3 := harnessWithEIO(s, "3")
+	harnessWithEIO(s, "3")
+	v4 := harnessWithEIO(s, "4")
+	NewLoopHarness(s)
+
+	if v3.Channel.Transport() != "websocket" {
+		t.Fatal("transport", v3.Channel.Transport())
+	}
+	stats := s.Stats()
+	if stats.Transports["websocket"] != 4 || len(stats.Transports) != 1 {
+		t.Fatal("transports", stats.Transports)
+	}
+	if stats.EIOVersions["3"] != 2 || stats.EIOVersions["4"] != 1 || stats.EIOVersions[unknownKind] != 1 {
+		t.Fatal("versions", stats.EIOVersions)
+	}
+	if v := metrics.gauge(MetricConnectionsActive, "transport", "websocket", "eio", "3"); v != 2 {
+		t.Fatal("gauge of EIO 3", v)
+	}
+
+	closeChannel(v4.Channel, v4.methods, DisconnectServer, CloseKicked, nil)
+	closeChannel(v3.Channel, v3.methods, DisconnectServer, CloseKicked, nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for versions := s.Stats().EIOVersions; (versions["4"] != 0 || versions["3"] != 1) &&
+		time.Now().Before(deadline); versions = s.Stats().EIOVersions {
+
+		time.Sleep(time.Millisecond)
+	}
+	stats = s.Stats()
+	if _, ok := stats.EIOVersions["4"]; ok || stats.EIOVersions["3"] != 1 {
+		t.Fatal("versions after close", stats.EIOVersions)
+	}
+	if v := metrics.gauge(MetricConnectionsActive, "transport", "websocket", "eio", "4"); v != 0 {
+		t.Fatal("gauge of closed EIO 4", v)
+	}
+}
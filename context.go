@@ -0,0 +1,152 @@
+package gophersocket
+
+import (
+	"context"
+	"time"
+)
+
+/**
+Context of one event dispatch, shared by all handlers called for the event
+
+Handlers may take *EventContext instead of *Channel as the first argument
+*/
+type EventContext struct {
+	channel *Channel
+	event   string
+	stopped bool
+
+	//ack id of the request, batcher collecting its sub results
+	ackId    int
+	batcher  *AckBatcher
+	panicked bool
+
+	received   time.Time
+	dispatched time.Time
+}
+
+/**
+Get channel the event came from
+*/
+func (e *EventContext) Channel() *Channel {
+	return e.channel
+}
+
+/**
+Get name of the event being processed
+*/
+func (e *EventContext) Event() string {
+	return e.event
+}
+
+/**
+Stop propagation, handlers registered after the current one are not called
+*/
+func (e *EventContext) Stop() {
+	e.stopped = true
+}
+
+/**
+Checks that propagation was stopped by one of the handlers
+*/
+func (e *EventContext) Stopped() bool {
+	return e.stopped
+}
+
+/**
+Get time the packet of the event was received
+*/
+func (e *EventContext) ReceivedAt() time.Time {
+	return e.received
+}
+
+/**
+Get time the packet waited from receipt to start of its handlers
+*/
+func (e *EventContext) QueueDelay() time.Duration {
+	if e.dispatched.IsZero() {
+		return 0
+	}
+
+	return e.dispatched.Sub(e.received)
+}
+
+/**
+Get context cancelled when the channel closes, for long running handlers
+to give up on work nobody will receive. Ack result of the event is not
+encoded, sent nor stored for idempotent retry once it is cancelled
+*/
+func (e *EventContext) Context() context.Context {
+	return e.channel.Context()
+}
+
+/**
+Get context cancelled when the channel closes. It is backed by
+the channel itself, no goroutine or timer is started for it
+*/
+func (c *Channel) Context() context.Context {
+	return channelContext{c}
+}
+
+type channelContext struct {
+	c *Channel
+}
+
+func (ctx channelContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (ctx channelContext) Done() <-chan struct{} {
+	return ctx.c.closed
+}
+
+func (ctx channelContext) Err() error {
+	select {
+	case <-ctx.c.closed:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+func (ctx channelContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+/**
+Close the channel once ctx is done, with DisconnectContextDone reason
+and error of ctx. Peer is sent disconnect packet first, so it does not
+reconnect. Watching stops when the channel closes
+*/
+func (c *Channel) BindContext(ctx context.Context) {
+	done := ctx.Done()
+	if done == nil || c.shared == nil {
+		return
+	}
+
+	go func() {
+		select {
+		case <-done:
+			c.disconnect(c.shared, DisconnectContextDone, ownCloseCause(c), ctx.Err())
+		case <-c.closed:
+		}
+	}()
+}
+
+/**
+Set function giving context each accepted connection is bound to, see
+Channel.BindContext, e.g. to close channels of a subsystem shutting down.
+Called before OnConnection, nil result leaves the channel unbound
+*/
+func (s *Server) SetChannelContext(f func(c *Channel) context.Context) {
+	s.channelContext = f
+}
+
+func (s *Server) bindChannelContext(c *Channel) {
+	if s.channelContext == nil {
+		return
+	}
+
+	if ctx := s.channelContext(c); ctx != nil {
+		c.BindContext(ctx)
+	}
+}
@@ -0,0 +1,126 @@
+package gophersocket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func TestBindContext(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	ctx, cancel := context.WithCancel(context.Background())
+	h.Channel.BindContext(ctx)
+
+	cancel()
+	pumpUntilClosed(t, h)
+	if h.Channel.CloseReason() != DisconnectContextDone || h.Channel.CloseError() != context.Canceled {
+		t.Fatal(h.Channel.CloseReason(), h.Channel.CloseError())
+	}
+	if frames := h.Frames(); len(frames) != 1 || frames[0] != "41" {
+		t.Fatalf("got frames %q, want disconnect", frames)
+	}
+}
+
+func TestBindContextAfterClose(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.Channel.BindContext(ctx)
+
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	cancel()
+	if h.Channel.CloseReason() != DisconnectServer {
+		t.Fatal("reason", h.Channel.CloseReason())
+	}
+}
+
+func TestServerChannelContext(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServer()
+	s.SetChannelContext(func(c *Channel) context.Context { return parent })
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	sc := <-connected
+
+	cancel()
+	waitClosed(t, sc)
+	waitClosed(t, &client.Channel)
+	if sc.CloseReason() != DisconnectContextDone {
+		t.Fatal("server reason", sc.CloseReason())
+	}
+	if client.CloseReason() != DisconnectServer {
+		t.Fatal("client reason", client.CloseReason())
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	s := newTestServer()
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cancel()
+	waitClosed(t, &client.Channel)
+	if client.CloseReason() != DisconnectContextDone {
+		t.Fatal("reason", client.CloseReason())
+	}
+}
+
+func TestHandlerContextCancelledOnClose(t *testing.T) {
+	s := newTestServer()
+	started := make(chan struct{})
+	cause := make(chan error, 1)
+	s.On("work", func(ctx *EventContext) string {
+		if err := ctx.Context().Err(); err != nil {
+			t.Error("cancelled while open", err)
+		}
+		close(started)
+		<-ctx.Context().Done()
+		cause <- ctx.Context().Err()
+		return "late"
+	})
+	h := newOpenHarness(s)
+
+	go func() {
+		<-started
+		closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	}()
+	if err := h.Feed(`421["work"]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-cause; err != context.Canceled {
+		t.Fatal("context error", err)
+	}
+	//result of the closed channel is not queued
+	h.Pump()
+	for _, frame := range h.Frames() {
+		if frame == `431["late"]` {
+			t.Fatal("ack sent after close")
+		}
+	}
+}
+
+func TestChannelContextValues(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	ctx := h.Channel.Context()
+
+	if _, ok := ctx.Deadline(); ok || ctx.Value("key") != nil {
+		t.Fatal("deadline or value of channel context")
+	}
+	select {
+	case <-ctx.Done():
+		t.Fatal("done while open")
+	default:
+	}
+}
@@ -0,0 +1,126 @@
+package gophersocket
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	/**
+	Room broadcasts skipped because the same packet was just sent
+	*/
+	MetricBroadcastsDeduped = "broadcasts_deduped_total"
+)
+
+/**
+Last packet broadcast of deduplicated event to one room
+*/
+type dedupeEntry struct {
+	hash   uint64
+	length int
+	sent   time.Time
+}
+
+/**
+Broadcast deduplication state of the server
+*/
+type broadcastDedupe struct {
+	maxAge  map[string]time.Duration
+	last    map[string]map[string]dedupeEntry
+	skipped int64
+	lock    sync.Mutex
+}
+
+/**
+Skip room broadcasts of given event which are byte-identical to the
+previous one sent to the same room, until maxAge passed since that
+one was sent by the clock of the server, so channels relying on
+periodic refresh still get it.
+Zero maxAge never repeats, negative disables deduplication of the event.
+Broadcasts excluding a channel are not deduplicated
+*/
+func (s *Server) SetBroadcastDedupe(event string, maxAge time.Duration) {
+	d := &s.dedupe
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.maxAge == nil {
+		d.maxAge = make(map[string]time.Duration)
+		d.last = make(map[string]map[string]dedupeEntry)
+	}
+
+	if maxAge < 0 {
+		delete(d.maxAge, event)
+		for _, byEvent := range d.last {
+			delete(byEvent, event)
+		}
+		return
+	}
+	d.maxAge[event] = maxAge
+}
+
+/**
+Get amount of room broadcasts skipped by deduplication
+*/
+func (s *Server) BroadcastsDeduped() int64 {
+	s.dedupe.lock.Lock()
+	defer s.dedupe.lock.Unlock()
+
+	return s.dedupe.skipped
+}
+
+/**
+Check whether encoded broadcast repeats the previous one and should be
+skipped, remembering it otherwise
+*/
+func (s *Server) dedupeBroadcast(room, event, command string) bool {
+	d := &s.dedupe
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	maxAge, ok := d.maxAge[event]
+	if !ok {
+		return false
+	}
+
+	now := s.getClock().Now()
+	hash := hashPacket(command)
+	last, seen := d.last[room][event]
+	if seen && last.hash == hash && last.length == len(command) &&
+		(maxAge == 0 || now.Sub(last.sent) < maxAge) {
+
+		d.skipped++
+		s.metricAdd(MetricBroadcastsDeduped, 1, "event", event)
+		return true
+	}
+
+	if d.last[room] == nil {
+		d.last[room] = make(map[string]dedupeEntry)
+	}
+	d.last[room][event] = dedupeEntry{hash: hash, length: len(command), sent: now}
+	return false
+}
+
+/**
+Forget deduplication state of removed room
+*/
+func (s *Server) forgetDedupe(room string) {
+	d := &s.dedupe
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.last, room)
+}
+
+/**
+FNV-1a hash of the packet, computed over the string in place
+*/
+func hashPacket(data string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(data); i++ {
+		hash ^= uint64(data[i])
+		hash *= 1099511628211
+	}
+
+	return hash
+}
@@ -0,0 +1,108 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroadcastDedupe(t *testing.T) {
+	s := newTestServer()
+	s.SetBroadcastDedupe("state", 0)
+	h := newOpenHarness(s)
+	h.Channel.Join("game")
+
+	s.BroadcastTo("game", "state", 1)
+	s.BroadcastTo("game", "state", 1)
+	s.BroadcastTo("game", "state", 2)
+	s.BroadcastTo("game", "state", 1)
+	//other events are not deduplicated
+	s.BroadcastTo("game", "chat", "hi")
+	s.BroadcastTo("game", "chat", "hi")
+	expectFrames(t, h, `42["state",1]`, `42["state",2]`, `42["state",1]`,
+		`42["chat","hi"]`, `42["chat","hi"]`)
+
+	if n := s.BroadcastsDeduped(); n != 1 {
+		t.Fatal("deduped", n)
+	}
+}
+
+func TestBroadcastDedupeMaxAge(t *testing.T) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	s.SetBroadcastDedupe("state", time.Minute)
+	h := newOpenHarness(s)
+	h.Channel.Join("game")
+
+	s.BroadcastTo("game", "state", 1)
+	//inside the window
+	clock.Advance(time.Minute - time.Second)
+	s.BroadcastTo("game", "state", 1)
+	expectFrames(t, h, `42["state",1]`)
+
+	//window is counted from the last sent one
+	clock.Advance(time.Second)
+	s.BroadcastTo("game", "state", 1)
+	s.BroadcastTo("game", "state", 1)
+	expectFrames(t, h, `42["state",1]`)
+	if n := s.BroadcastsDeduped(); n != 2 {
+		t.Fatal("deduped", n)
+	}
+}
+
+func TestBroadcastDedupePerRoom(t *testing.T) {
+	s := newTestServer()
+	s.SetBroadcastDedupe("state", 0)
+	a, b := newOpenHarness(s), newOpenHarness(s)
+	a.Channel.Join("a")
+	b.Channel.Join("b")
+
+	s.BroadcastTo("a", "state", 1)
+	s.BroadcastTo("b", "state", 1)
+	expectFrames(t, a, `42["state",1]`)
+	expectFrames(t, b, `42["state",1]`)
+
+	//payload differing in length only is not a duplicate
+	s.BroadcastTo("a", "state", 10)
+	s.BroadcastTo("a", "state", 1)
+	expectFrames(t, a, `42["state",10]`, `42["state",1]`)
+}
+
+func TestBroadcastDedupeSkipsExcept(t *testing.T) {
+	s := newTestServer()
+	s.SetBroadcastDedupe("state", 0)
+	sender, member := newOpenHarness(s), newOpenHarness(s)
+	sender.Channel.Join("game")
+	member.Channel.Join("game")
+
+	//broadcasts excluding the caller are always sent
+	sender.Channel.BroadcastToRoom("game", "state", 1)
+	sender.Channel.BroadcastToRoom("game", "state", 1)
+	expectFrames(t, member, `42["state",1]`, `42["state",1]`)
+}
+
+func TestBroadcastDedupeForgottenWithRoom(t *testing.T) {
+	s := newTestServer()
+	s.SetBroadcastDedupe("state", 0)
+	h := newOpenHarness(s)
+	h.Channel.Join("game")
+	s.BroadcastTo("game", "state", 1)
+
+	//room emptied and created again starts fresh
+	h.Channel.Leave("game")
+	h.Channel.Join("game")
+	s.BroadcastTo("game", "state", 1)
+	expectFrames(t, h, `42["state",1]`, `42["state",1]`)
+}
+
+func TestBroadcastDedupeDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetBroadcastDedupe("state", 0)
+	s.SetBroadcastDedupe("state", -1)
+	h := newOpenHarness(s)
+	h.Channel.Join("game")
+
+	s.BroadcastTo("game", "state", 1)
+	s.BroadcastTo("game", "state", 1)
+	expectFrames(t, h, `42["state",1]`, `42["state",1]`)
+}
@@ -0,0 +1,291 @@
+package gophersocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Cause of channel disconnection, see Channel.CloseReason.
+Values are the reason strings of socket.io
+*/
+type DisconnectReason string
+
+const (
+	/**
+	Server disconnected the channel, with Channel.Close or its registry
+	defense. Peer is told with disconnect packet, so it reports the same
+	*/
+	DisconnectServer DisconnectReason = "io server disconnect"
+
+	/**
+	Client disconnected with Client.Close, reported by the client
+	*/
+	DisconnectClient DisconnectReason = "io client disconnect"
+
+	/**
+	Client disconnected, reported by the server
+	*/
+	DisconnectClientNamespace DisconnectReason = "client namespace disconnect"
+
+	/**
+	Nothing was received from the peer within receive timeout
+	*/
+	DisconnectPingTimeout DisconnectReason = "ping timeout"
+
+	/**
+	Peer closed the connection without disconnecting
+	*/
+	DisconnectTransportClose DisconnectReason = "transport close"
+
+	/**
+	Reading or writing failed, or out queue overflowed,
+	see Channel.CloseError
+	*/
+	DisconnectTransportError DisconnectReason = "transport error"
+
+	/**
+	Wrong packet was received
+	*/
+	DisconnectParseError DisconnectReason = "parse error"
+
+	/**
+	Connection was open longer than allowed by SetMaxConnectionLifetime,
+	not a socket.io reason, client reports transport close
+	*/
+	DisconnectMaxLifetime DisconnectReason = "max lifetime"
+
+	/**
+	Message sent with EmitOrClose did not fit to out queue,
+	not a socket.io reason, client reports transport close
+	*/
+	DisconnectDeliveryFailed DisconnectReason = "delivery failed"
+
+	/**
+	Client failed re-authentication, see Channel.RefreshAuth,
+	not a socket.io reason, client reports server disconnect
+	*/
+	DisconnectUnauthorized DisconnectReason = "unauthorized"
+
+	/**
+	Frame transform failed, see Channel.SetFrameTransform,
+	not a socket.io reason, client reports transport close
+	*/
+	DisconnectTransformError DisconnectReason = "transform error"
+
+	/**
+	Context the channel was bound to is done, see Channel.BindContext,
+	not a socket.io reason, peer reports server or client disconnect
+	*/
+	DisconnectContextDone DisconnectReason = "context done"
+
+	//time to write disconnect packet before the connection is closed
+	disconnectFlushTimeout = time.Second
+)
+
+/**
+What closed the channel, see Channel.CloseCause. Finer than
+DisconnectReason, which keeps the socket.io strings told to the peer
+*/
+type CloseCause string
+
+const (
+	/**
+	Out queue overflowed, or message of EmitOrClose did not fit to it
+	*/
+	CloseOverflow CloseCause = "overflow"
+
+	/**
+	Connection was rejected by the server, or client received connect error
+	*/
+	CloseBadHandshake CloseCause = "bad handshake"
+
+	/**
+	Writing to the connection or out frame transform failed
+	*/
+	CloseWriteError CloseCause = "write error"
+
+	/**
+	Reading from the connection or in frame transform failed,
+	or wrong packet was received
+	*/
+	CloseReadError CloseCause = "read error"
+
+	/**
+	Channel was inactive longer than the registry idle timeout
+	*/
+	CloseIdle CloseCause = "idle"
+
+	/**
+	Nothing was received from the peer within receive timeout
+	*/
+	ClosePingTimeout CloseCause = "ping timeout"
+
+	/**
+	Client closed the channel, with disconnect packet, by closing
+	the connection or with Client.Close
+	*/
+	CloseClientClose CloseCause = "client close"
+
+	/**
+	Server closed the channel, with Channel.Close, CloseByIP, registry
+	capacity, session replacement, lifetime, authentication or context
+	*/
+	CloseKicked CloseCause = "kicked"
+)
+
+var (
+	ErrorChannelIdle   = errors.New("Channel idle")
+	ErrorOverCapacity  = errors.New("Registry over capacity")
+	ErrorClosedByIP    = errors.New("Closed by ip")
+	disconnectArgsType = reflect.TypeOf(DisconnectReason(""))
+)
+
+/**
+Get reason the channel was closed with, empty while it is alive.
+It is set before OnDisconnection is called, whose handlers may also
+get it as second argument
+*/
+func (c *Channel) CloseReason() DisconnectReason {
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+
+	return c.closeReason
+}
+
+/**
+Get what closed the channel, empty while it is alive
+*/
+func (c *Channel) CloseCause() CloseCause {
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+
+	return c.closeCause
+}
+
+/**
+Get error which caused the disconnection, nil when it was closed
+on purpose by either side
+*/
+func (c *Channel) CloseError() error {
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+
+	return c.closeErr
+}
+
+/**
+Mark channel not alive with given reason and cause,
+returns false if it was closed already
+*/
+func (c *Channel) markClosed(reason DisconnectReason, cause CloseCause, err error) bool {
+	c.aliveLock.Lock()
+	defer c.aliveLock.Unlock()
+
+	if !c.alive {
+		return false
+	}
+	c.alive = false
+	c.closeReason = reason
+	c.closeCause = cause
+	c.closeErr = err
+
+	return true
+}
+
+/**
+Send disconnect packet, wait for it to be written for a while,
+and close the channel with given reason and cause
+*/
+func (c *Channel) disconnect(m *methods, reason DisconnectReason, cause CloseCause, err error) {
+	if c.push(protocol.DisconnectMessage) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), disconnectFlushTimeout)
+		c.Flush(ctx)
+		cancel()
+	}
+
+	closeChannel(c, m, reason, cause, err)
+}
+
+/**
+Disconnect server channel, err tells why if not closed on request
+*/
+func (c *Channel) disconnectByServer(err error) {
+	if c.server == nil {
+		return
+	}
+	cause := CloseKicked
+	if errors.Is(err, ErrorChannelIdle) {
+		cause = CloseIdle
+	}
+	c.disconnect(&c.server.methods, DisconnectServer, cause, err)
+}
+
+/**
+Get disconnect reason for disconnect packet received from the peer
+*/
+func peerDisconnectReason(c *Channel) DisconnectReason {
+	if c.server != nil {
+		return DisconnectClientNamespace
+	}
+
+	return DisconnectServer
+}
+
+/**
+Get close cause for the peer closing the channel, with disconnect
+packet or by closing the connection
+*/
+func peerCloseCause(c *Channel) CloseCause {
+	if c.server != nil {
+		return CloseClientClose
+	}
+
+	return CloseKicked
+}
+
+/**
+Get close cause for the channel closed on purpose by its own side
+*/
+func ownCloseCause(c *Channel) CloseCause {
+	if c.server != nil {
+		return CloseKicked
+	}
+
+	return CloseClientClose
+}
+
+/**
+Get close cause for failed read from the connection
+*/
+func readErrorCause(c *Channel, err error) CloseCause {
+	switch readErrorReason(err) {
+	case DisconnectPingTimeout:
+		return ClosePingTimeout
+	case DisconnectTransportClose:
+		return peerCloseCause(c)
+	}
+
+	return CloseReadError
+}
+
+/**
+Get disconnect reason for failed read from the connection
+*/
+func readErrorReason(err error) DisconnectReason {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return DisconnectPingTimeout
+	}
+	if errors.Is(err, transport.ErrorClosedByPeer) {
+		return DisconnectTransportClose
+	}
+
+	return DisconnectTransportError
+}
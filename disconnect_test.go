@@ -0,0 +1,275 @@
+package gophersocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Net error which timed out, as read deadline fails
+*/
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func expectReason(t testing.TB, c *Channel, reason DisconnectReason, err error) {
+	t.Helper()
+
+	if c.IsAlive() {
+		t.Fatal("channel alive")
+	}
+	if c.CloseReason() != reason {
+		t.Fatalf("reason %q, want %q", c.CloseReason(), reason)
+	}
+	if !errors.Is(c.CloseError(), err) || (err == nil && c.CloseError() != nil) {
+		t.Fatalf("error %v, want %v", c.CloseError(), err)
+	}
+}
+
+func expectCause(t testing.TB, c *Channel, cause CloseCause) {
+	t.Helper()
+
+	if c.CloseCause() != cause {
+		t.Fatalf("cause %q, want %q", c.CloseCause(), cause)
+	}
+}
+
+func TestCloseReasonPackets(t *testing.T) {
+	for _, tc := range []struct {
+		frame  string
+		reason DisconnectReason
+	}{
+		{"41", DisconnectClientNamespace},
+		{"1", DisconnectTransportClose},
+	} {
+		h := NewLoopHarness(newTestServer())
+		if h.Channel.CloseReason() != "" || h.Channel.CloseCause() != "" {
+			t.Fatal("reason of alive channel:", h.Channel.CloseReason(), h.Channel.CloseCause())
+		}
+		h.Feed(tc.frame)
+		expectReason(t, h.Channel, tc.reason, nil)
+		expectCause(t, h.Channel, CloseClientClose)
+	}
+}
+
+func TestCloseReasonParseError(t *testing.T) {
+	h := NewLoopHarness(newTestServer())
+	if err := h.Feed("x"); err == nil {
+		t.Fatal("wrong packet accepted")
+	}
+	if h.Channel.CloseReason() != DisconnectParseError || h.Channel.CloseError() == nil {
+		t.Fatal(h.Channel.CloseReason(), h.Channel.CloseError())
+	}
+	expectCause(t, h.Channel, CloseReadError)
+}
+
+func TestCloseReasonWriteError(t *testing.T) {
+	h := NewLoopHarness(newTestServer())
+	h.Pump()
+	h.FailWrites(errTestWrite)
+	h.Channel.Emit("ev", 1)
+	h.Pump()
+	expectReason(t, h.Channel, DisconnectTransportError, errTestWrite)
+	expectCause(t, h.Channel, CloseWriteError)
+}
+
+func TestCloseReasonOverflow(t *testing.T) {
+	h := NewLoopHarness(newTestServer())
+	for h.Channel.Emit("ev", 1) == nil {
+	}
+	h.Pump()
+	expectReason(t, h.Channel, DisconnectTransportError, ErrorSocketOverflood)
+	expectCause(t, h.Channel, CloseOverflow)
+}
+
+func TestCloseReasonReadError(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		reason DisconnectReason
+	}{
+		{timeoutError{}, DisconnectPingTimeout},
+		{fmt.Errorf("read: %w", timeoutError{}), DisconnectPingTimeout},
+		{transport.ErrorClosedByPeer, DisconnectTransportClose},
+		{errTestWrite, DisconnectTransportError},
+		{&net.OpError{Op: "read", Err: errTestWrite}, DisconnectTransportError},
+	} {
+		if reason := readErrorReason(tc.err); reason != tc.reason {
+			t.Errorf("%v: reason %q, want %q", tc.err, reason, tc.reason)
+		}
+	}
+
+	//connection failing on read
+	c, conn := pipeChannel(t, newTestServer())
+	conn.Close()
+	waitClosed(t, c)
+	expectReason(t, c, DisconnectTransportError, errTestWrite)
+	expectCause(t, c, CloseReadError)
+}
+
+func TestCloseReasonServerAndClient(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 2)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	reasons := make(chan DisconnectReason, 2)
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { reasons <- reason })
+
+	//server closes, client is told with disconnect packet
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	sc := <-connected
+	sc.Close()
+	expectReason(t, sc, DisconnectServer, nil)
+	expectCause(t, sc, CloseKicked)
+	waitClosed(t, &client.Channel)
+	expectReason(t, &client.Channel, DisconnectServer, nil)
+	expectCause(t, &client.Channel, CloseKicked)
+
+	//client closes
+	client, closeClient = dialTestServer(t, s)
+	defer closeClient()
+	sc = <-connected
+	client.Close()
+	expectReason(t, &client.Channel, DisconnectClient, nil)
+	expectCause(t, &client.Channel, CloseClientClose)
+	waitClosed(t, sc)
+	expectReason(t, sc, DisconnectClientNamespace, nil)
+	expectCause(t, sc, CloseClientClose)
+
+	for _, want := range []DisconnectReason{DisconnectServer, DisconnectClientNamespace} {
+		select {
+		case reason := <-reasons:
+			if reason != want {
+				t.Fatalf("OnDisconnection got %q, want %q", reason, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnDisconnection not called")
+		}
+	}
+}
+
+func TestCloseReasonFirstWins(t *testing.T) {
+	h := NewLoopHarness(newTestServer())
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for _, reason := range []DisconnectReason{DisconnectTransportClose, DisconnectPingTimeout,
+		DisconnectParseError, DisconnectTransportError} {
+
+		wg.Add(1)
+		go func(reason DisconnectReason) {
+			defer wg.Done()
+			<-start
+			closeChannel(h.Channel, h.methods, reason, CloseKicked, nil)
+		}(reason)
+	}
+	close(start)
+	wg.Wait()
+
+	reason := h.Channel.CloseReason()
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, errTestWrite)
+	expectReason(t, h.Channel, reason, nil)
+}
+
+func TestCloseCauseOfServerDisconnect(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		cause CloseCause
+	}{
+		{nil, CloseKicked},
+		{ErrorChannelIdle, CloseIdle},
+		{ErrorOverCapacity, CloseKicked},
+		{ErrorClosedByIP, CloseKicked},
+	} {
+		h := newOpenHarness(newTestServer())
+		go h.Channel.disconnectByServer(tc.err)
+		pumpUntilClosed(t, h)
+		expectReason(t, h.Channel, DisconnectServer, tc.err)
+		expectCause(t, h.Channel, tc.cause)
+	}
+}
+
+/**
+Read frames of raw websocket connection until it is closed
+*/
+func readRawFrames(t testing.TB, conn *websocket.Conn) []string {
+	t.Helper()
+
+	var frames []string
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				t.Fatal("connection not closed")
+			}
+			return frames
+		}
+		frames = append(frames, string(data))
+	}
+}
+
+func TestCloseReasonSeenByRawClient(t *testing.T) {
+	s := newTestServer()
+	s.On(OnConnection, func(c *Channel) { c.Close() })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	//peer speaking the wire protocol only, as a stock socket.io client
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	frames := readRawFrames(t, conn)
+	if len(frames) < 3 || frames[1] != "40" || frames[len(frames)-1] != "41" {
+		t.Fatalf("got frames %q, want open, connect and disconnect packets", frames)
+	}
+}
+
+func TestCloseReasonOfRawClientDisconnect(t *testing.T) {
+	s := newTestServer()
+	reasons := make(chan DisconnectReason, 2)
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { reasons <- reason })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	for _, tc := range []struct {
+		frame  string
+		reason DisconnectReason
+	}{
+		{"41", DisconnectClientNamespace},
+		{"1", DisconnectTransportClose},
+		{"", DisconnectTransportClose},
+	} {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.frame != "" {
+			conn.WriteMessage(websocket.TextMessage, []byte(tc.frame))
+		} else {
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		}
+
+		select {
+		case reason := <-reasons:
+			if reason != tc.reason {
+				t.Fatalf("%q: got %q, want %q", tc.frame, reason, tc.reason)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q: OnDisconnection not called", tc.frame)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package gophersocket
+
+import (
+	"strings"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+/**
+Events collected by EmitBatch, sent when its function returns
+*/
+type Batch struct {
+	c    *Channel
+	msgs []outMessage
+	err  error
+}
+
+/**
+Add event to the batch, same as Emit of the channel. Encoding error
+is returned and fails the whole batch
+*/
+func (b *Batch) Emit(method string, args interface{}) error {
+	msg := protocol.NewEvent("", method, nil)
+	if skip, err := b.c.skipMuted(msg); skip {
+		return err
+	}
+
+	command, err := encode(b.c.codec(), msg, args)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return err
+	}
+
+	b.msgs = append(b.msgs, newOutMessage(command))
+	return nil
+}
+
+/**
+Send events emitted by f as one contiguous sequence, no message of other
+goroutines gets between them. The whole batch is queued, or none of it:
+on overflow by count or size ErrorSocketOverflood is returned. Batches
+emitted within f are sent before the enclosing one
+*/
+func (c *Channel) EmitBatch(f func(b *Batch)) error {
+	b := &Batch{c: c}
+	f(b)
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.msgs) == 0 {
+		return nil
+	}
+
+	if stream := c.getStream(); stream != nil {
+		return stream.sendBatch(c, b.msgs)
+	}
+
+	return c.pushBatch(b.msgs)
+}
+
+/**
+Number and queue messages at once, same as send
+*/
+func (st *reliableStream) sendBatch(c *Channel, msgs []outMessage) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	seq := st.seq
+	entries := make([]reliableEntry, 0, len(msgs))
+	for i, msg := range msgs {
+		if !strings.HasPrefix(msg.data, reliableEmitPrefix) {
+			continue
+		}
+		seq++
+		msgs[i].data = st.tag(msg.data, seq)
+		entries = append(entries, reliableEntry{seq, msgs[i].data})
+	}
+
+	if err := c.pushBatch(msgs); err != nil {
+		return err
+	}
+
+	st.seq = seq
+	st.history = append(st.history, entries...)
+	if len(st.history) > st.size {
+		st.history = st.history[len(st.history)-st.size:]
+	}
+
+	return nil
+}
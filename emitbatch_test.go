@@ -0,0 +1,110 @@
+package gophersocket
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEmitBatch(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.Mute("typing")
+
+	err := h.Channel.EmitBatch(func(b *Batch) {
+		b.Emit("a", 1)
+		b.Emit("typing", true)
+		//nested batch goes before the enclosing one
+		h.Channel.EmitBatch(func(inner *Batch) { inner.Emit("inner", 0) })
+		b.Emit("b", 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["inner",0]`, `42["a",1]`, `42["b",2]`)
+
+	if err := h.Channel.EmitBatch(func(b *Batch) {}); err != nil {
+		t.Fatal("empty batch", err)
+	}
+	expectFrames(t, h)
+}
+
+func TestEmitBatchEncodeError(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	err := h.Channel.EmitBatch(func(b *Batch) {
+		b.Emit("a", 1)
+		if err := b.Emit("bad", func() {}); !errors.Is(err, ErrorEncodeArgs) {
+			t.Error("encode error", err)
+		}
+		b.Emit("b", 2)
+	})
+	if !errors.Is(err, ErrorEncodeArgs) {
+		t.Fatal(err)
+	}
+	expectFrames(t, h)
+}
+
+func TestEmitBatchAllOrNone(t *testing.T) {
+	h := newOpenHarness(newQueueServer(4))
+	h.Channel.Emit("first", 0)
+
+	err := h.Channel.EmitBatch(func(b *Batch) {
+		for i := 0; i < 4; i++ {
+			b.Emit("ev", i)
+		}
+	})
+	if err != ErrorSocketOverflood {
+		t.Fatal("batch over free space", err)
+	}
+	expectFrames(t, h, `42["first",0]`)
+
+	s := newTestServer()
+	s.SetMaxOutBytes(20)
+	h = newOpenHarness(s)
+	h.Pump()
+	h.Frames()
+	err = h.Channel.EmitBatch(func(b *Batch) {
+		b.Emit("ev", "0123456789")
+		b.Emit("ev", "0123456789")
+	})
+	if err != ErrorSocketOverflood {
+		t.Fatal("batch over byte limit", err)
+	}
+	expectFrames(t, h)
+}
+
+func TestEmitBatchContiguous(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				h.Channel.Emit("x", i)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		h.Channel.EmitBatch(func(b *Batch) {
+			b.Emit("b1", i)
+			b.Emit("b2", i)
+			b.Emit("b3", i)
+		})
+	}
+	wg.Wait()
+
+	h.Pump()
+	frames := h.Frames()
+	for i, frame := range frames {
+		if strings.HasPrefix(frame, `42["b1"`) {
+			if i+2 >= len(frames) || !strings.HasPrefix(frames[i+1], `42["b2"`) ||
+				!strings.HasPrefix(frames[i+2], `42["b3"`) {
+
+				t.Fatalf("batch split at %d: %q", i, frames[i:])
+			}
+		}
+	}
+}
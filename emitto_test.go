@@ -0,0 +1,90 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestEmitTo(t *testing.T) {
+	s := newTestServer()
+	h := newOpenHarness(s)
+
+	if err := s.EmitTo("unknown", "msg", "a"); err != ErrorConnectionNotFound {
+		t.Fatal("unknown sid", err)
+	}
+	if err := s.EmitTo(h.Channel.Id(), "msg", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["msg","a",1]`)
+
+	sid := h.Channel.Id()
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	if err := s.EmitTo(sid, "msg", "b"); err != ErrorChannelClosed && err != ErrorConnectionNotFound {
+		t.Fatal("closed channel", err)
+	}
+	waitRegistry(t, s, func(stats RegistryStats) bool { return stats.Size == 0 })
+	if err := s.EmitTo(sid, "msg", "b"); err != ErrorConnectionNotFound {
+		t.Fatal("removed channel", err)
+	}
+}
+
+func TestEmitToConcurrentWithDisconnects(t *testing.T) {
+	s := newTestServer()
+
+	var lock sync.Mutex
+	var sids []string
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				lock.Lock()
+				targets := append([]string(nil), sids...)
+				lock.Unlock()
+				for _, sid := range targets {
+					err := s.EmitTo(sid, "msg", "a")
+					if err != nil && err != ErrorChannelClosed && err != ErrorConnectionNotFound {
+						select {
+						case errs <- err:
+						default:
+						}
+					}
+				}
+				s.BroadcastTo("room", "msg", "b")
+				s.BroadcastToAll("msg", "c")
+			}
+		}()
+	}
+
+	for i := 0; i < 60; i++ {
+		h := newOpenHarness(s)
+		h.Channel.Join("room")
+		lock.Lock()
+		sids = append(sids, h.Channel.Id())
+		lock.Unlock()
+		h.Pump()
+		closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	}
+	close(done)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		t.Fatal("emit failed", err)
+	default:
+	}
+	waitRegistry(t, s, func(stats RegistryStats) bool { return stats.Size == 0 })
+	if s.Amount("room") != 0 {
+		t.Fatal("members left in room", s.Amount("room"))
+	}
+}
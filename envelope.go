@@ -0,0 +1,131 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrorWrongEnvelope = errors.New("Event payload is not an array starting with event name")
+)
+
+//event and ack request packets, followed by namespace, ack id and payload
+const eventPacketPrefix = "42"
+
+/**
+Form of event packet payload, standard one is JSON array of event name
+and arguments. Engine.io and socket.io framing, namespace and ack id,
+are kept as is, both peers should use the same envelope
+*/
+type EventEnvelope interface {
+	Wrap(event string, args []json.RawMessage) ([]byte, error)
+	Unwrap(payload []byte) (event string, args []json.RawMessage, err error)
+}
+
+/**
+Set envelope of event and ack request payloads, for peers which do not
+use the standard array form, e.g. {"event": name, "data": args}. It is
+applied to frames right before frame transform on write and after it
+on read, failure closes the channel with DisconnectTransformError.
+Queued frames keep the standard form, so reliable delivery numbers
+them first and its offset goes to the envelope as the last argument.
+Nil restores the standard form
+*/
+func (m *methods) SetEventEnvelope(envelope EventEnvelope) {
+	storeHeld(&m.envelope, envelope)
+}
+
+func (c *Channel) getEnvelope() EventEnvelope {
+	if c.shared == nil {
+		return nil
+	}
+
+	envelope, _ := loadHeld(&c.shared.envelope).(EventEnvelope)
+	return envelope
+}
+
+/**
+Split event packet to framing and payload, false if it is not one
+*/
+func splitEventPacket(frame string) (prefix, payload string, ok bool) {
+	if !strings.HasPrefix(frame, eventPacketPrefix) {
+		return "", "", false
+	}
+
+	pos := len(eventPacketPrefix)
+	if pos < len(frame) && frame[pos] == '/' {
+		comma := strings.IndexByte(frame[pos:], ',')
+		if comma == -1 {
+			return "", "", false
+		}
+		pos += comma + 1
+	}
+	for pos < len(frame) && frame[pos] >= '0' && frame[pos] <= '9' {
+		pos++
+	}
+
+	return frame[:pos], frame[pos:], true
+}
+
+/**
+Put event packet payload to the envelope
+*/
+func (c *Channel) wrapEnvelope(frame string) (string, error) {
+	envelope := c.getEnvelope()
+	if envelope == nil {
+		return frame, nil
+	}
+	prefix, payload, ok := splitEventPacket(frame)
+	if !ok {
+		return frame, nil
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &parts); err != nil || len(parts) == 0 {
+		return "", ErrorWrongEnvelope
+	}
+	var event string
+	if err := json.Unmarshal(parts[0], &event); err != nil {
+		return "", ErrorWrongEnvelope
+	}
+
+	data, err := envelope.Wrap(event, parts[1:])
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + string(data), nil
+}
+
+/**
+Get event packet payload out of the envelope, in the standard form
+*/
+func (c *Channel) unwrapEnvelope(frame string) (string, error) {
+	envelope := c.getEnvelope()
+	if envelope == nil {
+		return frame, nil
+	}
+	prefix, payload, ok := splitEventPacket(frame)
+	if !ok {
+		return frame, nil
+	}
+
+	event, args, err := envelope.Unwrap([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	name, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, string(name))
+	for _, arg := range args {
+		parts = append(parts, string(arg))
+	}
+
+	return fmt.Sprintf("%s[%s]", prefix, strings.Join(parts, ",")), nil
+}
@@ -0,0 +1,138 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+/**
+Envelope of the form {"event": name, "data": [args]}
+*/
+type objectEnvelope struct{}
+
+type objectPayload struct {
+	Event string            `json:"event"`
+	Data  []json.RawMessage `json:"data"`
+}
+
+func (objectEnvelope) Wrap(event string, args []json.RawMessage) ([]byte, error) {
+	return json.Marshal(objectPayload{event, args})
+}
+
+func (objectEnvelope) Unwrap(payload []byte) (string, []json.RawMessage, error) {
+	var p objectPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", nil, err
+	}
+	if p.Event == "" {
+		return "", nil, errors.New("no event")
+	}
+	return p.Event, p.Data, nil
+}
+
+func TestEventEnvelopeOnWire(t *testing.T) {
+	s := newTestServer()
+	s.SetEventEnvelope(objectEnvelope{})
+	s.On("echo", func(c *Channel, v string) string { return "re:" + v })
+	h := newOpenHarness(s)
+
+	h.Channel.Emit("msg", "a")
+	expectFrames(t, h, `42{"event":"msg","data":["a"]}`)
+
+	if err := h.Feed(`425{"event":"echo","data":["x"]}`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `435["re:x"]`)
+}
+
+func TestEventEnvelopeRoundTrip(t *testing.T) {
+	s := newTestServer()
+	s.SetEventEnvelope(objectEnvelope{})
+	s.On("echo", func(c *Channel, v string) string { return "re:" + v })
+	s.On("ping", func(c *Channel, v string) { c.Emit("pong", v) })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	client.SetEventEnvelope(objectEnvelope{})
+	got := make(chan string, 1)
+	client.On("pong", func(c *Channel, v string) { got <- v })
+
+	reply, err := client.Ack("echo", "hi", 5*time.Second)
+	if err != nil || reply != `"re:hi"` {
+		t.Fatal(reply, err)
+	}
+	client.Emit("ping", "p")
+	select {
+	case v := <-got:
+		if v != "p" {
+			t.Fatal("got", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not received")
+	}
+}
+
+func TestEventEnvelopeMismatchCloses(t *testing.T) {
+	s := newTestServer()
+	s.SetEventEnvelope(objectEnvelope{})
+	h := newOpenHarness(s)
+
+	if err := h.Feed(`42["ev","a"]`); !errors.Is(err, ErrorFrameTransform) {
+		t.Fatal(err)
+	}
+	waitClosed(t, h.Channel)
+	if h.Channel.CloseReason() != DisconnectTransformError {
+		t.Fatal("reason", h.Channel.CloseReason())
+	}
+}
+
+func TestEventEnvelopeWithRecovery(t *testing.T) {
+	s := newTestServer()
+	s.EnableConnectionStateRecovery(time.Minute)
+	s.SetEventEnvelope(objectEnvelope{})
+	connected := make(chan *Channel, 2)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	//offset is added before the envelope, as the last of its arguments
+	h := NewLoopHarness(s)
+	h.Pump()
+	h.Frames()
+	h.Channel.Emit("n", 0)
+	expectFrames(t, h, `42{"event":"n","data":[0,"1"]}`)
+	<-connected
+
+	got := make(chan int, 10)
+	first, closeFirst := dialTestServer(t, s)
+	first.SetEventEnvelope(objectEnvelope{})
+	first.On("n", func(c *Channel, v int) { got <- v })
+	first.SetExecutor(syncExecutor{})
+	sc := <-connected
+
+	sc.Emit("n", 1)
+	if v := receiveInt(t, got); v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	state := first.ReliableState()
+	if state.Seq != 1 {
+		t.Fatal("offset not taken out of the envelope", state)
+	}
+	closeFirst()
+	waitClosed(t, sc)
+	sc.Emit("n", 2)
+
+	second, closeSecond := dialTestServer(t, s)
+	defer closeSecond()
+	second.SetEventEnvelope(objectEnvelope{})
+	second.On("n", func(c *Channel, v int) { got <- v })
+	second.SetExecutor(syncExecutor{})
+	<-connected
+	waitStream(t, second)
+	if err := second.Resume(state); err != nil {
+		t.Fatal(err)
+	}
+	if v := receiveInt(t, got); v != 2 {
+		t.Fatalf("got %d, want missed 2", v)
+	}
+}
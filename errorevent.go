@@ -0,0 +1,218 @@
+package gophersocket
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+const (
+	DefaultErrorEvent   = OnError
+	DefaultErrorMessage = "Internal error"
+
+	//payload kept in DecodeError is truncated to this length
+	decodeErrorPayloadSize = 128
+)
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+/**
+Payload of error event sent to the client, when handler returns error
+or with EmitError. Event is the one whose handler failed, Code is
+decimal for errors sent with EmitError
+*/
+type ErrorPayload struct {
+	Event   string      `json:"event,omitempty"`
+	Message string      `json:"message"`
+	Code    string      `json:"code,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *ErrorPayload) Error() string {
+	return e.Message
+}
+
+/**
+Get code sent with EmitError, ok is false if the code is not a number
+*/
+func (e *ErrorPayload) IntCode() (code int, ok bool) {
+	code, err := strconv.Atoi(e.Code)
+	return code, err == nil
+}
+
+/**
+Error of handler whose arguments could not be decoded from the message,
+reported same as error returned by handler, with code "decode_error",
+and passed to OnError handlers of the server
+*/
+type DecodeError struct {
+	Event    string
+	Expected string
+
+	/**
+	Arguments of the message, truncated
+	*/
+	Payload string
+	Err     error
+}
+
+func newDecodeError(event string, expected reflect.Type, payload string, err error) *DecodeError {
+	if len(payload) > decodeErrorPayloadSize {
+		payload = payload[:decodeErrorPayloadSize] + "..."
+	}
+
+	return &DecodeError{
+		Event:    event,
+		Expected: expected.String(),
+		Payload:  payload,
+		Err:      err,
+	}
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("can not decode arguments of event %q into %s: %v, payload %s",
+		e.Event, e.Expected, e.Err, e.Payload)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Code() string {
+	return "decode_error"
+}
+
+/**
+Set event sent to the channel, when handler of emit returns error,
+empty event disables it
+*/
+func (m *methods) SetErrorEvent(event string) {
+	m.errorEvent.Store(event)
+}
+
+/**
+Set function building payload of error event from handler error,
+nil payload means the event is not sent
+*/
+func (m *methods) SetErrorFormatter(f func(c *Channel, event string, err error) interface{}) {
+	m.errorFormatter.Store(errorFormatter(f))
+}
+
+/**
+Allow sending error text to the client by the default formatter,
+otherwise DefaultErrorMessage is sent
+*/
+func (m *methods) ExposeErrors(expose bool) {
+	m.exposeErrors.Store(expose)
+}
+
+type errorFormatter func(c *Channel, event string, err error) interface{}
+
+/**
+Build payload of error event with formatter set, or with default one
+*/
+func (m *methods) errorPayload(c *Channel, event string, err error) interface{} {
+	if f, _ := m.errorFormatter.Load().(errorFormatter); f != nil {
+		return f(c, event, err)
+	}
+
+	payload := ErrorPayload{
+		Event:   event,
+		Message: DefaultErrorMessage,
+	}
+	if expose, _ := m.exposeErrors.Load().(bool); expose {
+		payload.Message = err.Error()
+	}
+	if coded, ok := err.(interface{ Code() string }); ok {
+		payload.Code = coded.Code()
+	}
+
+	return m.systemMessage(c, SystemMessageHandlerError, map[string]interface{}{
+		"event":   event,
+		"message": payload.Message,
+		"code":    payload.Code,
+		"error":   err,
+	}, payload)
+}
+
+/**
+Send error event with the code, message and details to the channel,
+see SetErrorEvent
+*/
+func (c *Channel) EmitError(code int, message string, details interface{}) error {
+	payload := ErrorPayload{
+		Message: message,
+		Code:    strconv.Itoa(code),
+		Details: details,
+	}
+
+	errorEvent := DefaultErrorEvent
+	if c.shared != nil {
+		errorEvent = c.shared.getErrorEvent()
+	}
+	if errorEvent == "" {
+		return nil
+	}
+
+	return c.Emit(errorEvent, payload)
+}
+
+/**
+Set function called with payload of error events received by the client,
+sent by EmitError or on handler error, see SetErrorEvent
+*/
+func (c *Client) OnErrorEvent(f func(c *Channel, payload ErrorPayload)) error {
+	return c.On(c.getErrorEvent(), f)
+}
+
+func (m *methods) getErrorEvent() string {
+	if custom, ok := m.errorEvent.Load().(string); ok {
+		return custom
+	}
+
+	return DefaultErrorEvent
+}
+
+/**
+Send error of emit handler to the channel
+*/
+func (m *methods) emitHandlerError(c *Channel, event string, err error) {
+	errorEvent := m.getErrorEvent()
+	if errorEvent == "" {
+		return
+	}
+
+	if payload := m.errorPayload(c, event, err); payload != nil {
+		c.Emit(errorEvent, payload)
+	}
+}
+
+/**
+Call OnError handlers of the server with err, those taking error as
+second argument get it. Other handlers of the event serve error events
+sent by the peer, so they are skipped
+*/
+func (m *methods) callErrorHook(c *Channel, err error) {
+	if c.server == nil {
+		return
+	}
+	callers, ok := m.findChannelMethod(c, OnError)
+	if !ok {
+		return
+	}
+
+	ctx := &EventContext{channel: c, event: OnError, received: c.clock().Now()}
+	for _, f := range callers {
+		if ctx.Stopped() {
+			return
+		}
+		switch {
+		case !f.ArgsPresent:
+			f.safeCallFunc(ctx, nil)
+		case f.Args == errorType:
+			f.safeCallFunc(ctx, &err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package gophersocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func receiveErrorPayload(t *testing.T, got chan ErrorPayload) ErrorPayload {
+	t.Helper()
+
+	select {
+	case payload := <-got:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("error event not received")
+	}
+	return ErrorPayload{}
+}
+
+func TestEmitErrorRoundTrip(t *testing.T) {
+	s := newTestServer()
+	s.On("get", func(c *Channel, id int) {
+		c.EmitError(404, "not found", map[string]interface{}{"id": id})
+	})
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	got := make(chan ErrorPayload, 1)
+	if err := client.OnErrorEvent(func(c *Channel, payload ErrorPayload) { got <- payload }); err != nil {
+		t.Fatal(err)
+	}
+	client.Emit("get", 7)
+
+	payload := receiveErrorPayload(t, got)
+	if code, ok := payload.IntCode(); !ok || code != 404 {
+		t.Fatal("code", payload.Code)
+	}
+	if payload.Message != "not found" || payload.Event != "" || payload.Error() != "not found" {
+		t.Fatalf("got %+v", payload)
+	}
+	details, ok := payload.Details.(map[string]interface{})
+	if !ok || details["id"] != float64(7) {
+		t.Fatal("details", payload.Details)
+	}
+}
+
+func TestHandlerErrorEvent(t *testing.T) {
+	s := newTestServer()
+	s.SetErrorEvent("failure")
+	s.On("work", func(c *Channel, v string) error { return errors.New("secret") })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	client.SetErrorEvent("failure")
+	got := make(chan ErrorPayload, 1)
+	client.OnErrorEvent(func(c *Channel, payload ErrorPayload) { got <- payload })
+	client.Emit("work", "a")
+
+	//error text is not exposed by default
+	payload := receiveErrorPayload(t, got)
+	if payload.Event != "work" || payload.Message != DefaultErrorMessage || payload.Code != "" {
+		t.Fatalf("got %+v", payload)
+	}
+	if _, ok := payload.IntCode(); ok {
+		t.Fatal("handler error has numeric code")
+	}
+}
+
+func TestEmitErrorDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetErrorEvent("")
+	h := newOpenHarness(s)
+
+	if err := h.Channel.EmitError(500, "failed", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h)
+}
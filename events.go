@@ -0,0 +1,112 @@
+package gophersocket
+
+const (
+	/**
+	Events not put to a full event stream of a channel
+	*/
+	MetricEventsDropped = "event_stream_dropped_total"
+
+	eventStreamBuffer = 100
+)
+
+/**
+Incoming event read from Channel.Events
+*/
+type Event struct {
+	Name string
+
+	/**
+	Arguments decoded with the codec of the channel into generic values,
+	and arguments as received
+	*/
+	Args []interface{}
+	Raw  string
+}
+
+/**
+Get stream of incoming events, an alternative to handlers. By default
+only events not processed by any handler are put to it, see StreamAllEvents
+
+Stream keeps up to 100 events, while it is full new events are dropped,
+so reading should keep up with the peer. Events come in order they are
+processed, which is arrival order only with a sequential executor.
+It is closed after the channel is closed and its loops returned
+*/
+func (c *Channel) Events() <-chan Event {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	if c.events == nil {
+		c.events = make(chan Event, eventStreamBuffer)
+		if c.eventsClosed {
+			close(c.events)
+		}
+	}
+
+	return c.events
+}
+
+/**
+Put all incoming events to the event stream, including ones
+processed by handlers
+*/
+func (c *Channel) StreamAllEvents(all bool) {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	c.eventsAll = all
+}
+
+/**
+Put event to the stream if somebody reads it, handled tells whether
+handlers processed the event
+*/
+func (m *methods) streamEvent(c *Channel, name, args string, handled bool) {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	if c.events == nil || c.eventsClosed || (handled && !c.eventsAll) {
+		return
+	}
+
+	select {
+	case c.events <- decodeEvent(c, name, args):
+	default:
+		m.metricAdd(MetricEventsDropped, 1)
+	}
+}
+
+/**
+Build event with arguments decoded with the codec of the channel
+*/
+func decodeEvent(c *Channel, name, args string) Event {
+	ev := Event{Name: name, Raw: args}
+	if args != "" {
+		parts, err := splitArgs(args)
+		if err == nil {
+			cd := c.codec()
+			ev.Args = make([]interface{}, len(parts))
+			for i, part := range parts {
+				cd.Unmarshal(part, &ev.Args[i])
+			}
+		}
+	}
+
+	return ev
+}
+
+/**
+Close event stream, nothing is put to it anymore
+*/
+func (c *Channel) closeEvents() {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	if c.eventsClosed {
+		return
+	}
+	c.eventsClosed = true
+	if c.events != nil {
+		close(c.events)
+	}
+}
@@ -0,0 +1,178 @@
+package gophersocket
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Metrics summing counters and keeping last gauge values by name and labels
+*/
+type counterMetrics struct {
+	lock     sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+}
+
+func (cm *counterMetrics) Add(name string, delta float64, labels ...string) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if cm.counters == nil {
+		cm.counters = map[string]float64{}
+	}
+	cm.counters[counterKey(name, labels)] += delta
+}
+
+func (cm *counterMetrics) Set(name string, value float64, labels ...string) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if cm.gauges == nil {
+		cm.gauges = map[string]float64{}
+	}
+	cm.gauges[counterKey(name, labels)] = value
+}
+
+func (cm *counterMetrics) Observe(name string, value float64, labels ...string) {}
+
+func (cm *counterMetrics) get(name string, labels ...string) float64 {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.counters[counterKey(name, labels)]
+}
+
+func (cm *counterMetrics) gauge(name string, labels ...string) float64 {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.gauges[counterKey(name, labels)]
+}
+
+func counterKey(name string, labels []string) string {
+	return strings.Join(append([]string{name}, labels...), " ")
+}
+
+/**
+Read next event off the stream
+*/
+func readEvent(t testing.TB, evs <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-evs:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event on stream")
+	}
+	return Event{}
+}
+
+func TestEventsStream(t *testing.T) {
+	s := newTestServer()
+	s.On("handled", func(c *Channel) {})
+
+	h := NewLoopHarness(s)
+	evs := h.Channel.Events()
+	feedEvent(t, h, "a", map[string]int{"x": 1})
+	feedEvent(t, h, "handled")
+	feedEvent(t, h, "b", 1, "two")
+	feedEvent(t, h, "c")
+
+	ev := readEvent(t, evs)
+	if ev.Name != "a" || len(ev.Args) != 1 || ev.Raw != `{"x":1}` {
+		t.Fatal(ev)
+	}
+	if m, ok := ev.Args[0].(map[string]interface{}); !ok || m["x"] != float64(1) {
+		t.Fatal(ev.Args)
+	}
+
+	//handled events are not streamed by default
+	ev = readEvent(t, evs)
+	if ev.Name != "b" || len(ev.Args) != 2 || ev.Args[0] != float64(1) || ev.Args[1] != "two" {
+		t.Fatal(ev)
+	}
+	ev = readEvent(t, evs)
+	if ev.Name != "c" || len(ev.Args) != 0 {
+		t.Fatal(ev)
+	}
+	if len(evs) != 0 {
+		t.Fatal("unexpected events:", len(evs))
+	}
+}
+
+func TestEventsStreamAll(t *testing.T) {
+	s := newTestServer()
+	handled := 0
+	s.On("handled", func(c *Channel) { handled++ })
+
+	h := NewLoopHarness(s)
+	h.Channel.StreamAllEvents(true)
+	evs := h.Channel.Events()
+	feedEvent(t, h, "handled")
+	feedEvent(t, h, "other")
+
+	if handled != 1 {
+		t.Fatal("handler not called")
+	}
+	if ev := readEvent(t, evs); ev.Name != "handled" {
+		t.Fatal(ev)
+	}
+	if ev := readEvent(t, evs); ev.Name != "other" {
+		t.Fatal(ev)
+	}
+}
+
+func TestEventsStreamFull(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	h := NewLoopHarness(s)
+	evs := h.Channel.Events()
+	for i := 0; i < eventStreamBuffer+10; i++ {
+		feedEvent(t, h, "ev", i)
+	}
+
+	//the newest events are dropped, not the oldest
+	if len(evs) != eventStreamBuffer {
+		t.Fatal("buffered", len(evs))
+	}
+	if ev := readEvent(t, evs); ev.Args[0] != float64(0) {
+		t.Fatal(ev)
+	}
+	if got := metrics.get(MetricEventsDropped); got != 10 {
+		t.Fatal("dropped", got)
+	}
+}
+
+func TestEventsStreamClosed(t *testing.T) {
+	s := newTestServer()
+	h := NewLoopHarness(s)
+	evs := h.Channel.Events()
+	feedEvent(t, h, "ev")
+	h.Feed("41")
+
+	//events put before close are still read, then the stream ends
+	if ev := readEvent(t, evs); ev.Name != "ev" {
+		t.Fatal(ev)
+	}
+	select {
+	case _, ok := <-evs:
+		if ok {
+			t.Fatal("event after close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed")
+	}
+
+	//stream requested after close is closed at once
+	if _, ok := <-h.Channel.Events(); ok {
+		t.Fatal("event on closed channel")
+	}
+}
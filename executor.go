@@ -0,0 +1,36 @@
+package gophersocket
+
+/**
+Runs handlers of incoming messages, e.g. on worker pool or serial queue
+*/
+type Executor interface {
+	/**
+	Run given function, may block to apply backpressure to the reading loop
+	*/
+	Submit(f func())
+}
+
+/**
+Default executor, runs each function in its own goroutine
+*/
+type goExecutor struct{}
+
+func (goExecutor) Submit(f func()) {
+	go f()
+}
+
+/**
+Set executor running handlers of incoming messages, nil restores
+the default one, starting goroutine per message
+*/
+func (m *methods) SetExecutor(e Executor) {
+	storeHeld(&m.executor, e)
+}
+
+func (m *methods) getExecutor() Executor {
+	if e, ok := loadHeld(&m.executor).(Executor); ok {
+		return e
+	}
+
+	return goExecutor{}
+}
@@ -0,0 +1,89 @@
+package gophersocket
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+Executor running functions one by one on a single goroutine
+*/
+type serialExecutor struct {
+	queue     chan func()
+	submitted int64
+}
+
+func newSerialExecutor() *serialExecutor {
+	e := &serialExecutor{queue: make(chan func(), 100)}
+	go func() {
+		for f := range e.queue {
+			f()
+		}
+	}()
+	return e
+}
+
+func (e *serialExecutor) Submit(f func()) {
+	atomic.AddInt64(&e.submitted, 1)
+	e.queue <- f
+}
+
+func TestExecutorRunsAllDispatches(t *testing.T) {
+	s := newTestServer()
+	executor := newSerialExecutor()
+	defer close(executor.queue)
+	s.SetExecutor(executor)
+
+	const total = 50
+	got := make(chan int, total)
+	s.On("ev", func(c *Channel, v int) { got <- v })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+
+	for i := 0; i < total; i++ {
+		if err := client.Emit("ev", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//serial executor keeps the order messages were read in
+	for i := 0; i < total; i++ {
+		select {
+		case v := <-got:
+			if v != i {
+				t.Fatalf("got %d, want %d", v, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d events handled", i, total)
+		}
+	}
+	if submitted := atomic.LoadInt64(&executor.submitted); submitted != total {
+		t.Fatalf("%d of %d dispatches submitted to executor", submitted, total)
+	}
+}
+
+func TestExecutorDefaultRestored(t *testing.T) {
+	s := newTestServer()
+	executor := newSerialExecutor()
+	defer close(executor.queue)
+	s.SetExecutor(executor)
+	s.SetExecutor(nil)
+
+	got := make(chan struct{}, 1)
+	s.On("ev", func(c *Channel) { got <- struct{}{} })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+
+	client.Emit("ev", nil)
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not handled by default executor")
+	}
+	if submitted := atomic.LoadInt64(&executor.submitted); submitted != 0 {
+		t.Fatalf("%d dispatches submitted to removed executor", submitted)
+	}
+}
@@ -0,0 +1,180 @@
+package gophersocket
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Messages put to out queues and rejected as the queue, or broadcast
+	share of it, was full, labeled by origin: direct or broadcast
+	*/
+	MetricOutEnqueued = "out_enqueued_total"
+	MetricOutDropped  = "out_dropped_total"
+)
+
+/**
+Reserve share of out queue of each channel for direct emits, from 0 to 1.
+Broadcasts are rejected once they fill the rest, by count of messages
+or by bytes limited with SetMaxOutBytes, so a broadcast storm does not
+starve messages sent to the channel itself. Zero, the default, reserves
+nothing
+*/
+func (s *Server) SetDirectEmitShare(share float64) {
+	if share < 0 {
+		share = 0
+	} else if share > 1 {
+		share = 1
+	}
+
+	s.directShare.Store(share)
+}
+
+func (s *Server) getDirectShare() float64 {
+	share, _ := s.directShare.Load().(float64)
+	return share
+}
+
+/**
+Spread fan-out of each broadcast over up to workers goroutines, so
+channels late in a large room do not wait for all the others. Putting
+to out queue never blocks, full queue rejects the message, so a slow
+channel only delays the ones after it by its own locking. Broadcast
+returns after all workers are done, which keeps messages of one
+goroutine in order. Zero or one, the default, fans out in the
+broadcasting goroutine
+*/
+func (s *Server) SetBroadcastWorkers(workers int) {
+	s.broadcastWorkers.Store(workers)
+}
+
+/**
+Call f for each channel, in parallel if broadcast workers are set
+*/
+func (s *Server) fanOut(channels []*Channel, f func(c *Channel)) {
+	workers, _ := s.broadcastWorkers.Load().(int)
+	if workers > len(channels) {
+		workers = len(channels)
+	}
+	if workers <= 1 {
+		for _, c := range channels {
+			f(c)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	part := (len(channels) + workers - 1) / workers
+	for start := 0; start < len(channels); start += part {
+		end := start + part
+		if end > len(channels) {
+			end = len(channels)
+		}
+
+		wg.Add(1)
+		go func(channels []*Channel) {
+			defer wg.Done()
+			for _, c := range channels {
+				f(c)
+			}
+		}(channels[start:end])
+	}
+	wg.Wait()
+}
+
+/**
+Put broadcast message to out queue, within broadcast share of it
+*/
+func (c *Channel) enqueueBroadcast(data string) error {
+	msg := newOutMessage(data)
+	msg.broadcast = true
+
+	return c.enqueueMessage(msg)
+}
+
+/**
+Same as Emit, for messages sent to many channels
+*/
+func (c *Channel) emitBroadcast(method string, args interface{}) error {
+	msg := protocol.NewEvent("", method, nil)
+	if skip, err := c.skipMuted(msg); skip {
+		return err
+	}
+
+	command, err := encode(c.codec(), msg, args)
+	if err != nil {
+		return err
+	}
+
+	return c.enqueueBroadcast(command)
+}
+
+/**
+Account broadcast message of given size as queued, returns false
+leaving nothing accounted if it exceeds broadcast share
+*/
+func (c *Channel) admitBroadcast(size int64) bool {
+	count := atomic.AddInt64(&c.outBroadcast, 1)
+	bytes := atomic.AddInt64(&c.outBroadcastBytes, size)
+	if c.server == nil {
+		return true
+	}
+
+	share := c.server.getDirectShare()
+	if share <= 0 {
+		return true
+	}
+
+	over := count > int64(float64(cap(c.out)-1)*(1-share))
+	if maxBytes := c.maxOutBytes(); maxBytes > 0 && bytes > int64(float64(maxBytes)*(1-share)) {
+		over = true
+	}
+	if over {
+		atomic.AddInt64(&c.outBroadcast, -1)
+		atomic.AddInt64(&c.outBroadcastBytes, -size)
+		return false
+	}
+
+	return true
+}
+
+/**
+Release share taken by message leaving out queue
+*/
+func (c *Channel) releaseOut(msg outMessage) {
+	if msg.broadcast {
+		atomic.AddInt64(&c.outBroadcast, -1)
+		atomic.AddInt64(&c.outBroadcastBytes, -int64(len(msg.data)))
+	}
+}
+
+/**
+Count messages put to out queue, or rejected on overflow
+*/
+func (c *Channel) countPushed(broadcast bool, amount int, err error) {
+	if err != nil && !errors.Is(err, ErrorSocketOverflood) {
+		return
+	}
+
+	origin := "direct"
+	enqueued, dropped := &c.directEnqueued, &c.directDropped
+	if broadcast {
+		origin = "broadcast"
+		enqueued, dropped = &c.broadcastEnqueued, &c.broadcastDropped
+	}
+
+	metric := MetricOutEnqueued
+	if err != nil {
+		metric = MetricOutDropped
+		atomic.AddInt64(dropped, int64(amount))
+	} else {
+		atomic.AddInt64(enqueued, int64(amount))
+	}
+	if c.shared != nil {
+		c.shared.metricAdd(metric, float64(amount), "origin", origin)
+	}
+}
@@ -0,0 +1,230 @@
+package gophersocket
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Server with out queues of given size
+*/
+func newQueueServer(size int) *Server {
+	s := newTestServer()
+	s.SetAdaptiveOutQueue(1<<40, size, size)
+	return s
+}
+
+func TestDirectEmitShare(t *testing.T) {
+	s := newQueueServer(20)
+	s.SetDirectEmitShare(0.5)
+	h := newOpenHarness(s)
+	h.Channel.Join("room")
+	before := h.Channel.Stats()
+
+	for i := 0; i < 15; i++ {
+		s.BroadcastTo("room", "tick", i)
+	}
+	for i := 0; i < 9; i++ {
+		if err := h.Channel.Emit("direct", i); err != nil {
+			t.Fatal("direct emit", i, err)
+		}
+	}
+
+	stats := h.Channel.Stats()
+	if stats.BroadcastEnqueued-before.BroadcastEnqueued != 9 || stats.BroadcastDropped != 6 {
+		t.Fatalf("broadcast enqueued %d, dropped %d", stats.BroadcastEnqueued-before.BroadcastEnqueued, stats.BroadcastDropped)
+	}
+	if stats.DirectEnqueued-before.DirectEnqueued != 9 || stats.DirectDropped != 0 {
+		t.Fatalf("direct enqueued %d, dropped %d", stats.DirectEnqueued-before.DirectEnqueued, stats.DirectDropped)
+	}
+
+	//written broadcasts release their share
+	if written := h.Pump(); written != 18 {
+		t.Fatal("written", written)
+	}
+	h.Frames()
+	s.BroadcastTo("room", "tick", 15)
+	if stats := h.Channel.Stats(); stats.BroadcastEnqueued-before.BroadcastEnqueued != 10 {
+		t.Fatal("broadcast after write", stats.BroadcastEnqueued)
+	}
+}
+
+func TestDirectEmitShareDisabled(t *testing.T) {
+	s := newQueueServer(20)
+	h := newOpenHarness(s)
+	h.Channel.Join("room")
+
+	for i := 0; i < 15; i++ {
+		s.BroadcastTo("room", "tick", i)
+	}
+	if stats := h.Channel.Stats(); stats.BroadcastEnqueued != 15 || stats.BroadcastDropped != 0 {
+		t.Fatalf("broadcast enqueued %d, dropped %d", stats.BroadcastEnqueued, stats.BroadcastDropped)
+	}
+
+	//broadcasts took the queue, direct emits overflow it
+	var dropped int
+	for i := 0; i < 9; i++ {
+		if err := h.Channel.Emit("direct", i); err == ErrorSocketOverflood {
+			dropped++
+		}
+	}
+	if dropped == 0 || h.Channel.Stats().DirectDropped != int64(dropped) {
+		t.Fatal("direct dropped", dropped, h.Channel.Stats().DirectDropped)
+	}
+}
+
+func TestBroadcastSkipsFullChannel(t *testing.T) {
+	for _, workers := range []int{0, 4} {
+		s := newQueueServer(8)
+		s.SetBroadcastWorkers(workers)
+		rooms := make([][]string, 50)
+		for i := range rooms {
+			rooms[i] = []string{"room"}
+		}
+		hs := joinedHarnesses(s, rooms...)
+		full := hs[17]
+		for full.Channel.Emit("fill", 1) == nil {
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.BroadcastTo("room", "tick", 1)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("broadcast blocked by full channel, workers", workers)
+		}
+
+		for _, h := range hs {
+			if h != full {
+				expectFrames(t, h, `42["tick",1]`)
+			}
+		}
+		if full.Channel.Stats().BroadcastDropped != 1 {
+			t.Fatal("full channel dropped", full.Channel.Stats().BroadcastDropped, "workers", workers)
+		}
+	}
+}
+
+func TestFanOutEachChannelOnce(t *testing.T) {
+	s := newTestServer()
+	s.SetBroadcastWorkers(3)
+	channels := make([]*Channel, 10)
+	for i := range channels {
+		channels[i] = &Channel{}
+	}
+
+	var lock sync.Mutex
+	seen := make(map[*Channel]int)
+	s.fanOut(channels, func(c *Channel) {
+		lock.Lock()
+		seen[c]++
+		lock.Unlock()
+	})
+	for _, c := range channels {
+		if seen[c] != 1 {
+			t.Fatal("channel fanned out", seen[c], "times")
+		}
+	}
+}
+
+/**
+Cost of broadcast admission: broadcast to a room of 1000 members,
+with and without share reserved for direct emits
+*/
+func BenchmarkBroadcastDirectShare(b *testing.B) {
+	for _, share := range []float64{0, 0.25} {
+		b.Run(fmt.Sprintf("share=%v", share), func(b *testing.B) {
+			s := newQueueServer(64)
+			s.SetDirectEmitShare(share)
+			rooms := make([][]string, 1000)
+			for i := range rooms {
+				rooms[i] = []string{"room"}
+			}
+			hs := joinedHarnesses(s, rooms...)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.BroadcastTo("room", "tick", i)
+
+				b.StopTimer()
+				for _, h := range hs {
+					h.Pump()
+					h.Frames()
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+/**
+Direct emits to one channel of a 10k member room flooded by broadcasts
+from another goroutine, each sent once broadcasts filled their share of
+its queue. Queued-ahead is the amount of messages written before each
+direct one, bounded by the broadcast share. With no share reserved the
+direct emit would overflow the full queue instead
+*/
+func BenchmarkDirectEmitDuringBroadcastFlood(b *testing.B) {
+	for _, share := range []float64{0.25, 0.5} {
+		b.Run(fmt.Sprintf("share=%v", share), func(b *testing.B) {
+			s := newQueueServer(64)
+			s.SetDirectEmitShare(share)
+			rooms := make([][]string, 10000)
+			for i := range rooms {
+				rooms[i] = []string{"room"}
+			}
+			target := joinedHarnesses(s, rooms...)[0]
+
+			stop, flooding := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(flooding)
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+						s.BroadcastTo("room", "tick", i)
+					}
+				}
+			}()
+
+			var ahead, dropped int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				full := target.Channel.Stats().BroadcastDropped
+				for target.Channel.Stats().BroadcastDropped == full {
+					runtime.Gosched()
+				}
+
+				if err := target.Channel.Emit("direct", i); err != nil {
+					dropped++
+					continue
+				}
+				frame := fmt.Sprintf(`42["direct",%d]`, i)
+			pump:
+				for {
+					target.Pump()
+					for _, f := range target.Frames() {
+						if f == frame {
+							break pump
+						}
+						ahead++
+					}
+				}
+			}
+			b.StopTimer()
+			close(stop)
+			<-flooding
+
+			b.ReportMetric(float64(ahead)/float64(b.N), "queued-ahead/op")
+			b.ReportMetric(float64(dropped)/float64(b.N), "dropped/op")
+		})
+	}
+}
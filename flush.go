@@ -0,0 +1,68 @@
+package gophersocket
+
+import (
+	"context"
+)
+
+/**
+Flush call waiting for the message with given sequence to be written
+*/
+type flushWaiter struct {
+	seq  uint64
+	done chan struct{}
+}
+
+/**
+Wait until everything enqueued before the call is written to transport,
+fails if ctx is done or the channel is closed before that, or already
+*/
+func (c *Channel) Flush(ctx context.Context) error {
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	c.pushLock.Lock()
+	target := c.pushedSeq
+	c.pushLock.Unlock()
+
+	c.flushLock.Lock()
+	if c.writtenSeq >= target {
+		c.flushLock.Unlock()
+		return nil
+	}
+	waiter := flushWaiter{seq: target, done: make(chan struct{})}
+	c.flushWaiters = append(c.flushWaiters, waiter)
+	c.flushLock.Unlock()
+
+	select {
+	case <-waiter.done:
+		return nil
+	case <-c.closed:
+		return ErrorChannelClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/**
+Record written message, release Flush calls waiting for it
+*/
+func (c *Channel) markWritten(seq uint64) {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+
+	c.writtenSeq = seq
+	if len(c.flushWaiters) == 0 {
+		return
+	}
+
+	waiting := c.flushWaiters[:0]
+	for _, waiter := range c.flushWaiters {
+		if waiter.seq <= seq {
+			close(waiter.done)
+		} else {
+			waiting = append(waiting, waiter)
+		}
+	}
+	c.flushWaiters = waiting
+}
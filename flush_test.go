@@ -0,0 +1,145 @@
+package gophersocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Check that the harness connection got given frame, keeping written frames
+*/
+func (h *LoopHarness) wrote(frame string) bool {
+	h.conn.lock.Lock()
+	defer h.conn.lock.Unlock()
+
+	for _, f := range h.conn.frames {
+		if f == frame {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Pump the harness in background until stop is called
+*/
+func pumpInBackground(h *LoopHarness) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			h.Pump()
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func TestFlushConcurrent(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	stop := pumpInBackground(h)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			for i := 0; i < 50; i++ {
+				h.Channel.Emit("x", []int{g, i})
+			}
+			h.Channel.EmitBatch(func(b *Batch) {
+				b.Emit("batch", []int{g, 1})
+				b.Emit("batch", []int{g, 2})
+			})
+			if err := h.Channel.Flush(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+
+			//everything enqueued before Flush is written once it returns
+			for _, frame := range []string{
+				fmt.Sprintf(`42["x",[%d,49]]`, g),
+				fmt.Sprintf(`42["batch",[%d,2]]`, g),
+			} {
+				if !h.wrote(frame) {
+					t.Errorf("flush returned before %s was written", frame)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestFlushEmptyQueue(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Channel.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushClosedChannel(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+
+	if err := h.Channel.Flush(context.Background()); err != ErrorChannelClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushClosedWhileWaiting(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.Emit("x", 1)
+
+	result := make(chan error, 1)
+	go func() { result <- h.Channel.Flush(context.Background()) }()
+	select {
+	case err := <-result:
+		t.Fatal("returned before the queue was written", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	select {
+	case err := <-result:
+		if err != ErrorChannelClosed {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush not returned on close")
+	}
+}
+
+func TestFlushContextExpired(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.Emit("x", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Channel.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	//written later, flush succeeds
+	h.Pump()
+	if err := h.Channel.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/whiterabb17/gopher-socket
 
 go 1.18
 
-require github.com/gorilla/websocket v1.5.0
+require (
+	github.com/gorilla/websocket v1.5.0
+	google.golang.org/protobuf v1.28.1
+)
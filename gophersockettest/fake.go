@@ -0,0 +1,314 @@
+package gophersockettest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	gophersocket "github.com/whiterabb17/gopher-socket"
+	"github.com/whiterabb17/gopher-socket/codec"
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+const (
+	DefaultIp = "127.0.0.1"
+)
+
+var (
+	ErrorNoAck = errors.New("No ack response sent by handlers")
+	ErrorNoArg = errors.New("No argument with given index")
+)
+
+/**
+Event emitted to the fake channel
+*/
+type Emitted struct {
+	Event string
+	Args  []json.RawMessage
+
+	//non zero if the event was sent as ack request
+	AckId int
+
+	codec codec.Codec
+}
+
+/**
+Decode argument with given index into v, with codec of the server
+*/
+func (e Emitted) Decode(i int, v interface{}) error {
+	if i < 0 || i >= len(e.Args) {
+		return ErrorNoArg
+	}
+
+	return e.codec.Unmarshal(e.Args[i], v)
+}
+
+/**
+Connection params of fake channel
+*/
+type Options struct {
+	//sid of the channel, generated by the server if empty
+	Id string
+
+	//DefaultIp if empty
+	Ip string
+
+	//request header, e.g. to test connect guard
+	Header http.Header
+}
+
+/**
+Server channel connected through fake connection, for unit tests of
+handlers. All Channel methods work on it as on a real one, and events
+are dispatched by the server as for real channels, with its handlers,
+codec, error events and ack replies:
+
+	c := gophersockettest.NewFakeChannel(s)
+	var reply string
+	c.DeliverAck("echo", "hi", &reply)
+	c.AssertEmitted(t, "greeting", gophersockettest.WithArgs("hi"))
+
+Not safe for concurrent Deliver calls
+*/
+type FakeChannel struct {
+	*gophersocket.Channel
+
+	harness *gophersocket.LoopHarness
+	nextAck int
+
+	emitted []Emitted
+	acks    map[int][]json.RawMessage
+	lock    sync.Mutex
+}
+
+/**
+Connect fake channel to given server, nil creates a server
+with default websocket transport
+*/
+func NewFakeChannel(s *gophersocket.Server) *FakeChannel {
+	return NewFakeChannelWithOptions(s, Options{})
+}
+
+/**
+Connect fake channel with given id, ip and request header
+*/
+func NewFakeChannelWithOptions(s *gophersocket.Server, opts Options) *FakeChannel {
+	if s == nil {
+		s = gophersocket.NewServer(transport.GetDefaultWebsocketTransport())
+	}
+	if opts.Ip == "" {
+		opts.Ip = DefaultIp
+	}
+	if opts.Header == nil {
+		opts.Header = make(http.Header)
+	}
+
+	r := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: "/socket.io/", RawQuery: "EIO=3&transport=websocket"},
+		Header:     opts.Header,
+		RemoteAddr: opts.Ip,
+	}
+	h := gophersocket.NewLoopHarnessWithOptions(s, gophersocket.HarnessOptions{
+		Sid:        opts.Id,
+		RemoteAddr: opts.Ip,
+		Request:    r,
+	})
+
+	f := &FakeChannel{
+		Channel: h.Channel,
+		harness: h,
+		acks:    make(map[int][]json.RawMessage),
+	}
+	f.collect()
+
+	return f
+}
+
+/**
+Dispatch event from the client to handlers, nil payload sends
+no arguments. Returns error of processing the packet
+*/
+func (f *FakeChannel) Deliver(event string, payload interface{}) error {
+	args, err := f.encodeArgs(payload)
+	if err != nil {
+		return err
+	}
+
+	return f.feed(protocol.NewEvent("", event, args))
+}
+
+/**
+Dispatch event with ack request, and decode first argument of the
+ack reply into result, if it is not nil. Returns ErrorNoAck if handlers
+did not reply
+*/
+func (f *FakeChannel) DeliverAck(event string, payload interface{}, result interface{}) error {
+	args, err := f.encodeArgs(payload)
+	if err != nil {
+		return err
+	}
+
+	f.nextAck++
+	id := f.nextAck
+	if err := f.feed(protocol.NewAckRequest("", id, event, args)); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	reply, ok := f.acks[id]
+	delete(f.acks, id)
+	f.lock.Unlock()
+	if !ok {
+		return ErrorNoAck
+	}
+
+	if result == nil || len(reply) == 0 {
+		return nil
+	}
+	return f.harness.Codec().Unmarshal(reply[0], result)
+}
+
+/**
+Get events emitted to the channel so far
+*/
+func (f *FakeChannel) Emitted() []Emitted {
+	f.collect()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]Emitted(nil), f.emitted...)
+}
+
+/**
+Forget events emitted so far
+*/
+func (f *FakeChannel) Reset() {
+	f.collect()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.emitted = nil
+}
+
+/**
+Fail the test if no emitted event with given name matches,
+nil matcher accepts any arguments
+*/
+func (f *FakeChannel) AssertEmitted(t testing.TB, event string, match func(e Emitted) bool) {
+	t.Helper()
+
+	for _, e := range f.Emitted() {
+		if e.Event == event && (match == nil || match(e)) {
+			return
+		}
+	}
+	t.Errorf("event %q with matching arguments was not emitted", event)
+}
+
+/**
+Fail the test if an event with given name was emitted
+*/
+func (f *FakeChannel) AssertNotEmitted(t testing.TB, event string) {
+	t.Helper()
+
+	for _, e := range f.Emitted() {
+		if e.Event == event {
+			t.Errorf("event %q was emitted", event)
+			return
+		}
+	}
+}
+
+/**
+Matcher of events with arguments equal to given ones,
+compared as JSON values
+*/
+func WithArgs(args ...interface{}) func(e Emitted) bool {
+	return func(e Emitted) bool {
+		if len(args) != len(e.Args) {
+			return false
+		}
+		for i, arg := range args {
+			want, err := json.Marshal(arg)
+			if err != nil || !jsonEqual(want, e.Args[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func jsonEqual(a, b []byte) bool {
+	var av, bv interface{}
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(av, bv)
+}
+
+func (f *FakeChannel) encodeArgs(payload interface{}) ([]json.RawMessage, error) {
+	if payload == nil {
+		return nil, nil
+	}
+
+	data, err := f.harness.Codec().Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return []json.RawMessage{data}, nil
+}
+
+func (f *FakeChannel) feed(msg *protocol.Message) error {
+	frame, err := protocol.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	err = f.harness.Feed(frame)
+	f.collect()
+	return err
+}
+
+/**
+Write out queue of the channel and record written events and acks
+*/
+func (f *FakeChannel) collect() {
+	f.harness.Pump()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, frame := range f.harness.Frames() {
+		msg, err := protocol.Decode(frame)
+		if err != nil {
+			continue
+		}
+		args, err := msg.ArgList()
+		if err != nil {
+			continue
+		}
+
+		switch msg.Type {
+		case protocol.MessageTypeEmit, protocol.MessageTypeAckRequest:
+			f.emitted = append(f.emitted, Emitted{
+				Event: msg.Method,
+				Args:  args,
+				AckId: msg.AckId,
+				codec: f.harness.Codec(),
+			})
+		case protocol.MessageTypeAckResponse:
+			f.acks[msg.AckId] = args
+		}
+	}
+}
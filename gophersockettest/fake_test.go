@@ -0,0 +1,113 @@
+package gophersockettest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	gophersocket "github.com/whiterabb17/gopher-socket"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func newServer() *gophersocket.Server {
+	return gophersocket.NewServer(transport.GetDefaultWebsocketTransport())
+}
+
+/**
+Test recording failures instead of failing
+*/
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestDeliverAck(t *testing.T) {
+	s := newServer()
+	s.On("echo", func(c *gophersocket.Channel, v string) string { return "re:" + v })
+	s.On("silent", func(c *gophersocket.Channel, v string) {})
+	f := NewFakeChannel(s)
+
+	var reply string
+	if err := f.DeliverAck("echo", "hi", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply != "re:hi" {
+		t.Fatal("reply", reply)
+	}
+
+	if err := f.DeliverAck("silent", "hi", &reply); err != ErrorNoAck {
+		t.Fatal("no reply", err)
+	}
+}
+
+func TestAssertEmitted(t *testing.T) {
+	s := newServer()
+	s.On("hello", func(c *gophersocket.Channel, name string) {
+		c.Emit("greeting", map[string]string{"text": "hi " + name})
+	})
+	f := NewFakeChannel(s)
+
+	if err := f.Deliver("hello", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	f.AssertEmitted(t, "greeting", nil)
+	f.AssertEmitted(t, "greeting", WithArgs(map[string]string{"text": "hi bob"}))
+	f.AssertNotEmitted(t, "other")
+
+	emitted := f.Emitted()
+	var greeting struct{ Text string }
+	if len(emitted) != 1 || emitted[0].Decode(0, &greeting) != nil || greeting.Text != "hi bob" {
+		t.Fatal("emitted", emitted)
+	}
+	if err := emitted[0].Decode(1, &greeting); err != ErrorNoArg {
+		t.Fatal("decode of missing argument", err)
+	}
+
+	rec := &recordingT{TB: t}
+	f.AssertEmitted(rec, "greeting", WithArgs(map[string]string{"text": "hi alice"}))
+	f.AssertEmitted(rec, "other", nil)
+	f.AssertNotEmitted(rec, "greeting")
+	if len(rec.errors) != 3 {
+		t.Fatal("failures", rec.errors)
+	}
+
+	f.Reset()
+	if len(f.Emitted()) != 0 {
+		t.Fatal("emitted after reset")
+	}
+}
+
+func TestOptionsDefaults(t *testing.T) {
+	f := NewFakeChannel(nil)
+
+	if f.Id() == "" {
+		t.Fatal("id not generated")
+	}
+	if f.Ip() != DefaultIp {
+		t.Fatal("ip", f.Ip())
+	}
+	if f.RequestHeader() == nil {
+		t.Fatal("no request header")
+	}
+	if other := NewFakeChannel(nil); other.Id() == f.Id() {
+		t.Fatal("same id generated twice")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	f := NewFakeChannelWithOptions(newServer(), Options{
+		Id:     "abc",
+		Ip:     "10.0.0.1",
+		Header: http.Header{"X-Test": {"yes"}},
+	})
+
+	if f.Id() != "abc" || f.Ip() != "10.0.0.1" || f.RequestHeader().Get("X-Test") != "yes" {
+		t.Fatal(f.Id(), f.Ip(), f.RequestHeader())
+	}
+}
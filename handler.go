@@ -1,10 +1,11 @@
 package gophersocket
 
 import (
-	"encoding/json"
-	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/whiterabb17/gopher-socket/codec"
 	"github.com/whiterabb17/gopher-socket/protocol"
 )
 
@@ -12,8 +13,19 @@ const (
 	OnConnection    = "connection"
 	OnDisconnection = "disconnection"
 	OnError         = "error"
+
+	/**
+	Catch-all handlers, called for every incoming event after the ones
+	bound to the event itself
+	*/
+	OnAny = "*"
 )
 
+/**
+Event processing function, see On for supported signatures
+*/
+type Handler interface{}
+
 /**
 System handler function for internal event processing
 */
@@ -23,11 +35,52 @@ type systemHandler func(c *Channel)
 Contains maps of message processing functions
 */
 type methods struct {
-	messageHandlers     sync.Map
+	messageHandlers     atomic.Value
 	messageHandlersLock sync.RWMutex
 
 	onConnection    systemHandler
 	onDisconnection systemHandler
+
+	metrics atomic.Value
+
+	errorEvent     atomic.Value
+	errorFormatter atomic.Value
+	exposeErrors   atomic.Value
+
+	systemFormatter atomic.Value
+
+	executor atomic.Value
+
+	codec    atomic.Value
+	envelope atomic.Value
+
+	maxOutBytes atomic.Value
+
+	slowHandler     atomic.Value
+	slowHandlerLock sync.Mutex
+
+	onPing atomic.Value
+
+	clock atomic.Value
+
+	//runs periodic emits of channels, created on first EmitEvery
+	emitWheel     *timerWheel
+	emitWheelOnce sync.Once
+
+	ackPolicies sync.Map
+
+	ackBatching atomic.Value
+
+	eventCompression      sync.Map
+	eventCompressionCount int32
+
+	writeRetry atomic.Value
+
+	ackTimeoutHint atomic.Value
+
+	loopEventModes sync.Map
+
+	loopEvents *loopEventQueue
 }
 
 /**
@@ -39,26 +92,179 @@ func (m *methods) initMethods() {
 
 /**
 Add message processing function, and bind it to given method
+
+Several functions may be bound to the same method, they are called
+one by one in order of registration, within one goroutine per message.
+First argument of the function is *Channel or *EventContext, the latter
+allows to stop propagation to the rest of functions with ctx.Stop().
+Panic in one function is recovered and does not prevent the rest from
+running. On ack request, result of the first function returning a value
+is sent back
+
+Function returning error reports it to the client: as error event on emit
+(see SetErrorEvent), or as ack result on ack, if no other result present
 */
 func (m *methods) On(method string, f interface{}) error {
-	c, err := newCaller(f)
+	c, err := newCaller(method, f)
 	if err != nil {
 		return err
 	}
 
-	m.messageHandlers.Store(method, c)
+	m.addCaller(method, c)
 	return nil
 }
 
 /**
-Find message processing function associated with given method
+Shared handlers by method, never modified once published,
+so dispatch in progress keeps using the table it started with
+*/
+type handlerTable map[string][]*caller
+
+/**
+Get current table of shared handlers
+*/
+func (m *methods) getHandlers() handlerTable {
+	table, _ := m.messageHandlers.Load().(handlerTable)
+	return table
+}
+
+/**
+Publish copy of the table with caller appended to the method
 */
-func (m *methods) findMethod(method string) (*caller, bool) {
-	if f, ok := m.messageHandlers.Load(method); ok {
-		return f.(*caller), true
+func (m *methods) addCaller(method string, c *caller) {
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+
+	old := m.getHandlers()
+	table := make(handlerTable, len(old)+1)
+	for name, callers := range old {
+		table[name] = callers
+	}
+	table[method] = appendCaller(old[method], c)
+
+	m.messageHandlers.Store(table)
+}
+
+/**
+Find message processing functions for given channel, handlers local to
+the channel take precedence over the shared ones
+*/
+func (m *methods) findChannelMethod(c *Channel, method string) ([]*caller, bool) {
+	return m.getHandlers().findChannel(c, method)
+}
+
+/**
+Same as findChannelMethod, with shared handlers of this table
+*/
+func (t handlerTable) findChannel(c *Channel, method string) ([]*caller, bool) {
+	return t.find(c.localHandlers(), method)
+}
+
+/**
+Find functions for the method in local handlers of a channel,
+or in shared ones of this table
+*/
+func (t handlerTable) find(local handlerTable, method string) ([]*caller, bool) {
+	if f, ok := local[method]; ok {
+		return f, true
+	}
+
+	f, ok := t[method]
+	return f, ok
+}
+
+/**
+Get callers serving events of given namespace: the ones serving all
+and the ones bound to it
+*/
+func forNamespace(callers []*caller, nsp string) []*caller {
+	if isRootNamespace(nsp) {
+		nsp = "/"
+	}
+
+	scoped := false
+	for _, c := range callers {
+		if c.Namespace != "" {
+			scoped = true
+			break
+		}
+	}
+	if !scoped {
+		return callers
+	}
+
+	result := make([]*caller, 0, len(callers))
+	for _, c := range callers {
+		if c.Namespace == "" || c.Namespace == nsp {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+/**
+Atomically replace the whole set of handlers local to this channel,
+nil or empty map removes them, so only shared handlers are used
+*/
+func (c *Channel) SetHandlers(handlers map[string]Handler) error {
+	callers := make(handlerTable, len(handlers))
+	for method, f := range handlers {
+		curCaller, err := newCaller(method, f)
+		if err != nil {
+			return err
+		}
+		callers[method] = []*caller{curCaller}
+	}
+
+	c.handlersLock.Lock()
+	c.handlers = callers
+	c.handlersLock.Unlock()
+
+	return nil
+}
+
+/**
+Add message processing function local to this channel, it is used
+instead of the ones bound to the same method on server or client.
+Signatures and call order are the same as for shared handlers
+*/
+func (c *Channel) On(method string, f interface{}) error {
+	curCaller, err := newCaller(method, f)
+	if err != nil {
+		return err
+	}
+
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+
+	//copy, so dispatch in progress keeps the set it started with
+	table := make(handlerTable, len(c.handlers)+1)
+	for name, callers := range c.handlers {
+		table[name] = callers
 	}
+	table[method] = appendCaller(c.handlers[method], curCaller)
+	c.handlers = table
+
+	return nil
+}
+
+/**
+Get handlers registered on this channel only, never modified once set
+*/
+func (c *Channel) localHandlers() handlerTable {
+	c.handlersLock.RLock()
+	defer c.handlersLock.RUnlock()
 
-	return nil, false
+	return c.handlers
+}
+
+/**
+Append caller to a copy of given slice
+*/
+func appendCaller(callers []*caller, c *caller) []*caller {
+	result := make([]*caller, len(callers), len(callers)+1)
+	copy(result, callers)
+	return append(result, c)
 }
 
 func (m *methods) callLoopEvent(c *Channel, event string) {
@@ -69,66 +275,221 @@ func (m *methods) callLoopEvent(c *Channel, event string) {
 		m.onDisconnection(c)
 	}
 
-	f, ok := m.findMethod(event)
-	if !ok {
+	if m.loopEvents != nil {
+		m.loopEvents.push(c, event)
 		return
 	}
 
-	f.callFunc(c, &struct{}{})
+	switch m.getLoopEventMode(event) {
+	case LoopEventOrdered:
+		c.runOrdered(func() { m.runLoopEvent(c, event) })
+	case LoopEventUnordered:
+		go m.runLoopEvent(c, event)
+	case LoopEventBuffered:
+		m.runBufferedConnection(c)
+	default:
+		m.runLoopEvent(c, event)
+	}
 }
 
 /**
-Check incoming message
-On ack_resp - look for waiter
-On ack_req - look for processing function and send ack_resp
-On emit - look for processing function
+Call handlers of loop event
 */
-func (m *methods) processIncomingMessage(c *Channel, msg *protocol.Message) {
-	switch msg.Type {
-	case protocol.MessageTypeEmit:
-		f, ok := m.findMethod(msg.Method)
-		if !ok {
+func (m *methods) runLoopEvent(c *Channel, event string) {
+	if event == OnConnection {
+		c.markConnectStarted()
+	}
+
+	callers, ok := m.findChannelMethod(c, event)
+	if !ok {
+		return
+	}
+
+	var args interface{}
+	if event == OnDisconnection {
+		reason := c.CloseReason()
+		args = &reason
+	}
+
+	ctx := &EventContext{channel: c, event: event, received: c.clock().Now()}
+	for _, f := range callers {
+		if ctx.Stopped() {
 			return
 		}
+		//disconnection handlers may take the reason as second argument
+		if args != nil && f.ArgsPresent && f.Args == disconnectArgsType {
+			f.safeCallFunc(ctx, args)
+			continue
+		}
+		f.safeCallFunc(ctx, nil)
+	}
+}
+
+/**
+Result of dispatching a message to its handlers
+*/
+type dispatchResult struct {
+	value     interface{}
+	codec     codec.Codec
+	hasResult bool
+	err       error
 
-		if !f.ArgsPresent {
-			f.callFunc(c, &struct{}{})
+	//value holds positional ack arguments of function with several results
+	multi bool
+}
+
+/**
+Call given functions one by one until propagation is stopped,
+returns result of the first function which has one, with codec
+to encode it, and the first error returned by functions returning error
+*/
+func (m *methods) dispatch(ctx *EventContext, callers []*caller, args string, shared codec.Codec) (res dispatchResult) {
+	for _, f := range callers {
+		if ctx.Stopped() {
 			return
 		}
 
-		data := f.getArgs()
-		err := json.Unmarshal([]byte(msg.Args), &data)
+		cd := f.getCodec(shared)
+
+		//no args sent, zero value of the type is used
+		var data interface{}
+		if f.ArgsPresent && args != "" {
+			//data type should be defined for unmarshall
+			data = f.getArgs()
+			if err := cd.Unmarshal([]byte(args), data); err != nil {
+				decodeErr := newDecodeError(ctx.event, f.Args, args, err)
+				m.callErrorHook(ctx.channel, decodeErr)
+				if res.err == nil {
+					res.err = decodeErr
+				}
+				continue
+			}
+		}
+
+		start := m.getClock().Now()
+		out, err := f.safeCallFunc(ctx, data)
+		m.observeHandler(ctx, m.getClock().Now().Sub(start))
 		if err != nil {
-			return
+			ctx.panicked = true
+		}
+		if err != nil || !f.Out {
+			continue
 		}
 
-		f.callFunc(c, data)
+		if f.ReturnsError {
+			if err, _ := out[0].Interface().(error); err != nil && res.err == nil {
+				res.err = err
+			}
+			continue
+		}
 
-	case protocol.MessageTypeAckRequest:
-		f, ok := m.findMethod(msg.Method)
-		if !ok || !f.Out {
-			return
+		if res.hasResult {
+			continue
 		}
+		res.codec, res.hasResult = cd, true
+		if f.MultiOut {
+			res.value, res.multi = ackValues(out), true
+		} else {
+			res.value = out[0].Interface()
+		}
+	}
 
-		var result []reflect.Value
-		if f.ArgsPresent {
-			//data type should be defined for unmarshall
-			data := f.getArgs()
-			err := json.Unmarshal([]byte(msg.Args), &data)
-			if err != nil {
+	return
+}
+
+/**
+Check incoming message
+On ack_resp - look for waiter
+On ack_req - look for processing functions and send ack_resp
+On emit - look for processing functions
+*/
+func (m *methods) processIncomingMessage(c *Channel, msg *protocol.Message, received time.Time) {
+	switch msg.Type {
+	case protocol.MessageTypeEmit, protocol.MessageTypeAckRequest:
+		ack := protocol.NewAck(msg.Namespace, msg.AckId)
+
+		//retry of already processed ack request is answered with stored result
+		key, args, idempotent := c.idempotencyKey(msg)
+		resultStored := false
+		var store IdempotencyStore
+		if idempotent {
+			store = c.server.getIdempotency()
+		}
+		if store != nil {
+			session := c.SessionId()
+			stored, call, ok := c.server.startIdempotent(store, session, key)
+			if call == nil {
+				if ok {
+					ack.Args = stored
+					send(ack, c, nil)
+				}
 				return
 			}
+			//result of handlers cancelled by close is not stored, they may
+			//have stopped early, so the retry on the next connection runs them
+			defer func() {
+				c.server.finishIdempotent(store, session, key, call, ack.Args, resultStored)
+			}()
+		}
 
-			result = f.callFunc(c, data)
-		} else {
-			result = f.callFunc(c, &struct{}{})
+		ctx := &EventContext{channel: c, event: msg.Method, ackId: msg.AckId, received: received, dispatched: c.clock().Now()}
+		m.metricObserve(MetricHandlerQueueDelay, ctx.QueueDelay().Seconds(), "event", msg.Method)
+
+		shared := m.getCodec()
+
+		//one snapshot of shared and local handlers for the message,
+		//so a swap or SetHandlers does not mix handler sets
+		handlers, local := m.getHandlers(), c.localHandlers()
+		callers, _ := handlers.find(local, msg.Method)
+		callers = forNamespace(callers, msg.Namespace)
+		res := m.dispatch(ctx, callers, args, shared)
+
+		anyCallers, _ := handlers.find(local, OnAny)
+		anyCallers = forNamespace(anyCallers, msg.Namespace)
+		anyRes := m.dispatch(ctx, anyCallers, args, shared)
+
+		m.streamEvent(c, msg.Method, args, len(callers) > 0 || len(anyCallers) > 0)
+		if c.server != nil {
+			c.server.publish(c, msg.Method, args)
+		}
+		if !res.hasResult {
+			res.value, res.codec, res.hasResult = anyRes.value, anyRes.codec, anyRes.hasResult
+			res.multi = anyRes.multi
+		}
+		if res.err == nil {
+			res.err = anyRes.err
+		}
+		m.finishAckBatch(ctx, msg, &res, shared)
+
+		//error is sent as error event on emit, and as ack result on ack
+		if res.err != nil && msg.Type == protocol.MessageTypeEmit {
+			m.emitHandlerError(c, msg.Method, res.err)
+		}
+		if res.err != nil && !res.hasResult {
+			res.value = m.errorPayload(c, msg.Method, res.err)
+			res.codec, res.hasResult = shared, res.value != nil
 		}
 
-		ack := &protocol.Message{
-			Type:  protocol.MessageTypeAckResponse,
-			AckId: msg.AckId,
+		if msg.Type != protocol.MessageTypeAckRequest || !res.hasResult {
+			return
+		}
+		//channel closed while handlers ran, nobody gets the result
+		if ctx.Context().Err() != nil {
+			return
+		}
+
+		var command string
+		var err error
+		if res.multi {
+			command, err = encodeArgs(res.codec, ack, res.value.([]interface{}))
+		} else {
+			command, err = encode(res.codec, ack, res.value)
+		}
+		if err != nil {
+			return
 		}
-		send(ack, c, result[0].Interface())
+		resultStored = true
+		c.enqueue(command)
 
 	case protocol.MessageTypeAckResponse:
 		waiter, err := c.ack.getWaiter(msg.AckId)
@@ -0,0 +1,335 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func newTestServer() *Server {
+	return NewServer(transport.GetDefaultWebsocketTransport())
+}
+
+/**
+Serve s over http, returns url to dial
+*/
+func serveTestServer(s *Server) (*httptest.Server, string) {
+	hs := httptest.NewServer(s)
+	return hs, "ws" + strings.TrimPrefix(hs.URL, "http") + socketioUrl
+}
+
+/**
+Serve s over http and dial it, close stops both
+*/
+func dialTestServer(t testing.TB, s *Server) (client *Client, close func()) {
+	t.Helper()
+
+	hs, url := serveTestServer(s)
+	client, err := Dial(url, transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		hs.Close()
+		t.Fatal(err)
+	}
+
+	return client, func() {
+		client.Close()
+		hs.Close()
+	}
+}
+
+/**
+Dispatch event packet with given arguments to handlers of the channel
+as the in loop does, but in the calling goroutine
+*/
+func dispatchEvent(t testing.TB, c *Channel, event string, args ...interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := protocol.Decode("42" + string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.server.processIncomingMessage(c, msg, time.Now())
+}
+
+/**
+Feed event packet with given arguments to the harness channel
+*/
+func feedEvent(t testing.TB, h *LoopHarness, event string, args ...interface{}) {
+	t.Helper()
+
+	data, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed("42" + string(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetHandlersSwapMidSession(t *testing.T) {
+	s := newTestServer()
+	var got []string
+	s.On("move", func(c *Channel, v string) { got = append(got, "shared:"+v) })
+
+	c, _ := pipeChannel(t, s)
+	dispatchEvent(t, c, "move", "a")
+
+	err := c.SetHandlers(map[string]Handler{
+		"move": func(c *Channel, v string) { got = append(got, "lobby:"+v) },
+		"join": func(c *Channel, v string) { got = append(got, "lobby-join:"+v) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatchEvent(t, c, "move", "b")
+	dispatchEvent(t, c, "join", "c")
+
+	err = c.SetHandlers(map[string]Handler{
+		"fire": func(c *Channel, v string) { got = append(got, "game:"+v) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//join is not in the new set, move falls back to shared handler
+	dispatchEvent(t, c, "join", "d")
+	dispatchEvent(t, c, "move", "e")
+	dispatchEvent(t, c, "fire", "f")
+
+	if err := c.SetHandlers(nil); err != nil {
+		t.Fatal(err)
+	}
+	dispatchEvent(t, c, "fire", "g")
+	dispatchEvent(t, c, "move", "h")
+
+	want := []string{"shared:a", "lobby:b", "lobby-join:c", "shared:e", "game:f", "shared:h"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSetHandlersInvalid(t *testing.T) {
+	c, _ := pipeChannel(t, newTestServer())
+	called := false
+	c.SetHandlers(map[string]Handler{"ev": func(c *Channel) { called = true }})
+
+	if err := c.SetHandlers(map[string]Handler{"ev": 5}); err == nil {
+		t.Fatal("expected error for non function handler")
+	}
+	//failed swap keeps the previous set
+	dispatchEvent(t, c, "ev")
+	if !called {
+		t.Fatal("previous handlers were replaced by invalid set")
+	}
+}
+
+func TestSetHandlersDuringDispatch(t *testing.T) {
+	c, _ := pipeChannel(t, newTestServer())
+	var got []string
+	err := c.SetHandlers(map[string]Handler{
+		"move": func(c *Channel, v string) {
+			got = append(got, "lobby:"+v)
+			c.SetHandlers(map[string]Handler{
+				OnAny: func(c *Channel) { got = append(got, "game:any") },
+			})
+		},
+		OnAny: func(c *Channel) { got = append(got, "lobby:any") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//catch-all handler comes from the set the message started with
+	dispatchEvent(t, c, "move", "a")
+	dispatchEvent(t, c, "move", "b")
+
+	want := []string{"lobby:a", "lobby:any", "game:any"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSetHandlersConcurrentDispatch(t *testing.T) {
+	s := newTestServer()
+	c, _ := pipeChannel(t, s)
+
+	//both handlers of a set record the same generation,
+	//so a half updated table would show up as mixed pairs
+	var lock sync.Mutex
+	var pairs [][2]int
+	last := -1
+	set := func(gen int) map[string]Handler {
+		return map[string]Handler{
+			"a": func(c *Channel) {
+				lock.Lock()
+				last = gen
+				lock.Unlock()
+			},
+			"b": func(c *Channel) {
+				lock.Lock()
+				pairs = append(pairs, [2]int{last, gen})
+				lock.Unlock()
+			},
+		}
+	}
+	c.SetHandlers(set(0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for gen := 1; gen <= 200; gen++ {
+			if err := c.SetHandlers(set(gen)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 500; i++ {
+		dispatchEvent(t, c, "a")
+		dispatchEvent(t, c, "b")
+	}
+	<-done
+
+	if len(pairs) != 500 {
+		t.Fatalf("dispatched %d of 500", len(pairs))
+	}
+	for _, p := range pairs {
+		if p[0] > p[1] {
+			t.Fatalf("handler of older set %d ran after set %d", p[1], p[0])
+		}
+	}
+}
+
+func TestHandlersOrderStopAndPanic(t *testing.T) {
+	s := newTestServer()
+	var got []string
+	record := func(name string) func(c *Channel, v string) {
+		return func(c *Channel, v string) { got = append(got, name+":"+v) }
+	}
+	s.On("ev", record("first"))
+	s.On("ev", func(c *Channel, v string) { panic("handler failed") })
+	s.On("ev", func(ctx *EventContext, v string) {
+		got = append(got, "third:"+v)
+		if v == "stop" {
+			ctx.Stop()
+		}
+	})
+	s.On("ev", record("fourth"))
+	s.On(OnAny, func(ctx *EventContext) { got = append(got, "any:"+ctx.Event()) })
+
+	c, _ := pipeChannel(t, s)
+	dispatchEvent(t, c, "ev", "go")
+	dispatchEvent(t, c, "ev", "stop")
+	dispatchEvent(t, c, "other", "x")
+
+	want := []string{
+		"first:go", "third:go", "fourth:go", "any:ev",
+		"first:stop", "third:stop",
+		"any:other",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestHandlersOrderAnyStops(t *testing.T) {
+	s := newTestServer()
+	var got []string
+	s.On(OnAny, func(ctx *EventContext) {
+		got = append(got, "any1")
+		ctx.Stop()
+	})
+	s.On(OnAny, func(c *Channel) { got = append(got, "any2") })
+	s.On("ev", func(c *Channel) { got = append(got, "ev") })
+
+	c, _ := pipeChannel(t, s)
+	dispatchEvent(t, c, "ev")
+
+	//named handlers run before catch-all ones
+	if strings.Join(got, " ") != "ev any1" {
+		t.Fatal(got)
+	}
+}
+
+func TestClientHandlersOrder(t *testing.T) {
+	s := newTestServer()
+	s.On("send", func(c *Channel, v string) { c.Emit("ev", v) })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+
+	got := make(chan string, 10)
+	client.On("ev", func(c *Channel, v string) { got <- "first:" + v })
+	client.On("ev", func(c *Channel, v string) { panic("handler failed") })
+	client.On("ev", func(ctx *EventContext, v string) {
+		got <- "third:" + v
+		if v == "stop" {
+			ctx.Stop()
+		}
+	})
+	client.On("ev", func(c *Channel, v string) { got <- "fourth:" + v })
+	client.On(OnAny, func(ctx *EventContext) { got <- "any:" + ctx.Event() })
+
+	//messages are dispatched concurrently, so one at a time
+	expect := func(v string, want ...string) {
+		t.Helper()
+
+		client.Emit("send", v)
+		for _, name := range want {
+			select {
+			case call := <-got:
+				if call != name {
+					t.Fatalf("got %s, want %s", call, name)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler not called:", name)
+			}
+		}
+		select {
+		case call := <-got:
+			t.Fatal("unexpected call:", call)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	expect("go", "first:go", "third:go", "fourth:go", "any:ev")
+	expect("stop", "first:stop", "third:stop")
+}
+
+func TestChannelOnPerChannelHandlers(t *testing.T) {
+	s := newTestServer()
+	var got []string
+	s.On("ev", func(c *Channel, v string) { got = append(got, "shared:"+v) })
+
+	c1, _ := pipeChannel(t, s)
+	c2, _ := pipeChannel(t, s)
+	c3, _ := pipeChannel(t, s)
+	c1.On("ev", func(c *Channel, v string) { got = append(got, "one:"+v) })
+	c2.On("ev", func(c *Channel, v string) { got = append(got, "two:"+v) })
+
+	dispatchEvent(t, c1, "ev", "a")
+	dispatchEvent(t, c2, "ev", "b")
+	dispatchEvent(t, c3, "ev", "c")
+
+	if strings.Join(got, " ") != "one:a two:b shared:c" {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,170 @@
+package gophersocket
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/codec"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Server channel whose loops are run step by step by the caller instead
+of goroutines, with fake connection recording written frames, for tests
+of the loop logic without sleeps:
+
+	h := NewLoopHarness(server)
+	h.Pump()
+	h.Frames() //open packet and connect
+	h.Feed("2")
+	h.Pump()
+	h.Frames() //[]string{"3"}
+
+Handlers run synchronously within Feed, so they should not wait
+for anything done by Pump, like ack responses
+*/
+type LoopHarness struct {
+	Channel *Channel
+
+	conn    *harnessConn
+	methods *methods
+}
+
+/**
+Connection of LoopHarness, loops never read from it
+*/
+type harnessConn struct {
+	frames   []string
+	writeErr error
+	lock     sync.Mutex
+}
+
+func (hc *harnessConn) GetMessage() (string, error) {
+	return "", io.EOF
+}
+
+func (hc *harnessConn) WriteMessage(message string) error {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if hc.writeErr != nil {
+		return hc.writeErr
+	}
+	hc.frames = append(hc.frames, message)
+	return nil
+}
+
+func (hc *harnessConn) Close() {}
+
+func (hc *harnessConn) PingParams() (interval, timeout time.Duration) {
+	return transport.WsDefaultPingInterval, transport.WsDefaultPingTimeout
+}
+
+/**
+Connection params of harness channel
+*/
+type HarnessOptions struct {
+	//sid of the channel, generated as usual if empty
+	Sid string
+
+	//"harness" if empty
+	RemoteAddr string
+
+	//upgrade request, Ip and RequestHeader need it
+	Request *http.Request
+}
+
+/**
+Connect harness channel to the server, as SetupEventLoop does,
+the open sequence is queued and written on first Pump
+*/
+func NewLoopHarness(s *Server) *LoopHarness {
+	return NewLoopHarnessWithOptions(s, HarnessOptions{})
+}
+
+/**
+Connect harness channel with given sid, address and request
+*/
+func NewLoopHarnessWithOptions(s *Server, opts HarnessOptions) *LoopHarness {
+	if opts.RemoteAddr == "" {
+		opts.RemoteAddr = "harness"
+	}
+
+	conn := &harnessConn{}
+	return &LoopHarness{
+		Channel: s.setupChannel(conn, opts.Sid, opts.RemoteAddr, opts.Request, true),
+		conn:    conn,
+		methods: &s.methods,
+	}
+}
+
+/**
+Get codec of the server, for encoding fed arguments and decoding
+written ones
+*/
+func (h *LoopHarness) Codec() codec.Codec {
+	return h.methods.getCodec()
+}
+
+/**
+Process frame as if it was read from the connection, returns
+error the in loop would stop with
+*/
+func (h *LoopHarness) Feed(frame string) error {
+	if !h.Channel.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	_, err := h.Channel.receivePacket(h.methods, frame, func(f func()) { f() })
+	return err
+}
+
+/**
+Write messages waiting in out queue, until it is empty or the out loop
+would stop, e.g. on write error or close. Returns amount written
+*/
+func (h *LoopHarness) Pump() int {
+	c := h.Channel
+	written := 0
+	for {
+		if done, _ := c.checkOverflow(h.methods); done {
+			c.finishOutLoop()
+			return written
+		}
+
+		select {
+		case msg := <-c.out:
+			if done, _ := c.writeOut(h.methods, msg); done {
+				c.finishOutLoop()
+				return written
+			}
+			written++
+		default:
+			return written
+		}
+	}
+}
+
+/**
+Get frames written since the previous call
+*/
+func (h *LoopHarness) Frames() []string {
+	h.conn.lock.Lock()
+	defer h.conn.lock.Unlock()
+
+	frames := h.conn.frames
+	h.conn.frames = nil
+	return frames
+}
+
+/**
+Make following writes fail with err, nil makes them succeed again
+*/
+func (h *LoopHarness) FailWrites(err error) {
+	h.conn.lock.Lock()
+	defer h.conn.lock.Unlock()
+
+	h.conn.writeErr = err
+}
@@ -0,0 +1,93 @@
+package gophersocket
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ExampleLoopHarness() {
+	h := NewLoopHarness(NewServer(nil))
+	h.Pump()
+	h.Frames()
+
+	h.Feed("2")
+	h.Pump()
+	fmt.Println(h.Frames())
+	// Output: [3]
+}
+
+func TestHarnessPingPongSynchronous(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	if err := h.Feed("2"); err != nil {
+		t.Fatal(err)
+	}
+	//nothing is written until pumped
+	if frames := h.Frames(); len(frames) != 0 {
+		t.Fatal("written before pump", frames)
+	}
+	if n := h.Pump(); n != 1 {
+		t.Fatalf("pumped %d, want 1", n)
+	}
+	expectFrames(t, h, "3")
+}
+
+func TestHarnessPumpOrder(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	for i := 0; i < 3; i++ {
+		h.Channel.Emit("ev", i)
+	}
+	if n := h.Pump(); n != 3 {
+		t.Fatalf("pumped %d, want 3", n)
+	}
+	expectFrames(t, h, `42["ev",0]`, `42["ev",1]`, `42["ev",2]`)
+	if n := h.Pump(); n != 0 {
+		t.Fatalf("pumped %d from empty queue", n)
+	}
+}
+
+func TestHarnessClose(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	if err := h.Feed("1"); err != nil {
+		t.Fatal(err)
+	}
+	if h.Channel.IsAlive() {
+		t.Fatal("channel alive after close packet")
+	}
+	if err := h.Feed("2"); err != ErrorChannelClosed {
+		t.Fatal("feed after close", err)
+	}
+	expectFrames(t, h)
+}
+
+func TestHarnessWriteError(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	h.FailWrites(errTestWrite)
+	h.Channel.Emit("ev", 1)
+	if n := h.Pump(); n != 0 {
+		t.Fatalf("pumped %d with failing writes", n)
+	}
+	waitClosed(t, h.Channel)
+	if h.Channel.CloseError() != errTestWrite {
+		t.Fatal("close error", h.Channel.CloseError())
+	}
+}
+
+func TestHarnessOverflow(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	for i := 0; i < queueBufferSize-1; i++ {
+		if err := h.Channel.Emit("ev", i); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if n := h.Pump(); n != 0 {
+		t.Fatalf("pumped %d from overflowed queue", n)
+	}
+	if h.Channel.IsAlive() || h.Channel.CloseError() != ErrorSocketOverflood {
+		t.Fatal("close error", h.Channel.CloseError())
+	}
+}
@@ -0,0 +1,159 @@
+package gophersocket
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+const (
+	/**
+	Query parameter of upgrade request with ping interval in milliseconds
+	requested by the client, see SetClientPingBounds
+	*/
+	PingIntervalParam = "pingInterval"
+)
+
+/**
+Events of application level heartbeat, see SetAppHeartbeat
+*/
+type appHeartbeatEvents struct {
+	request, reply string
+}
+
+/**
+Set function called on ping from server, with payload attached to it,
+returned data is attached to pong. Without it pong is sent bare
+*/
+func (c *Client) OnServerPing(f func(payload []byte) []byte) {
+	storeHeld(&c.onPing, f)
+}
+
+/**
+Set function called on ping from client, with payload attached to it,
+returned data is attached to pong. Without it pong is sent bare
+*/
+func (s *Server) OnClientPing(f func(payload []byte) []byte) {
+	storeHeld(&s.onPing, f)
+}
+
+/**
+Answer requestEvent from clients with replyEvent, without arguments,
+for applications with heartbeat of their own on top of engine.io one.
+It counts as activity, see LastActivity, and is not passed to handlers.
+Ack request is answered with empty ack instead. Empty requestEvent
+disables it
+*/
+func (s *Server) SetAppHeartbeat(requestEvent, replyEvent string) {
+	s.appHeartbeat.Store(appHeartbeatEvents{requestEvent, replyEvent})
+}
+
+/**
+Reply to application heartbeat, returns false if the message is not one
+*/
+func (s *Server) replyHeartbeat(c *Channel, msg *protocol.Message) bool {
+	events, _ := s.appHeartbeat.Load().(appHeartbeatEvents)
+	if events.request == "" || msg.Method != events.request {
+		return false
+	}
+
+	if msg.Type == protocol.MessageTypeAckRequest {
+		send(protocol.NewAck(msg.Namespace, msg.AckId), c, nil)
+	} else {
+		c.Emit(events.reply, nil)
+	}
+	return true
+}
+
+/**
+Get pong packet answering ping with given payload
+*/
+func (m *methods) pongFor(payload string) string {
+	hook, _ := loadHeld(&m.onPing).(func(payload []byte) []byte)
+	if hook == nil {
+		return protocol.PongMessage
+	}
+
+	return protocol.MustEncode(protocol.NewPong(string(hook([]byte(payload)))))
+}
+
+/**
+Honor ping interval requested by clients with PingIntervalParam, clamped
+to given bounds, it is advertised in the handshake instead of the
+transport one. Zero max ignores requests, as by default. Connections
+should implement transport.PingParamsSetter, requests are ignored
+on other ones
+*/
+func (s *Server) SetClientPingBounds(min, max time.Duration) {
+	if min > max {
+		min = max
+	}
+	s.clientPingMin, s.clientPingMax = min, max
+}
+
+/**
+Get ping params of new connection, applying interval requested
+by the client to the connection
+*/
+func (s *Server) negotiatePing(conn transport.Connection, r *http.Request) (interval, timeout time.Duration) {
+	interval, timeout = conn.PingParams()
+	if s.clientPingMax <= 0 || r == nil {
+		return interval, timeout
+	}
+	setter, ok := conn.(transport.PingParamsSetter)
+	if !ok {
+		return interval, timeout
+	}
+
+	ms, err := strconv.Atoi(r.URL.Query().Get(PingIntervalParam))
+	if err != nil || ms <= 0 {
+		return interval, timeout
+	}
+
+	interval = time.Duration(ms) * time.Millisecond
+	if interval < s.clientPingMin {
+		interval = s.clientPingMin
+	}
+	if interval > s.clientPingMax {
+		interval = s.clientPingMax
+	}
+	setter.SetPingParams(interval, timeout)
+
+	return interval, timeout
+}
+
+/**
+Add ping interval requested from the server to the connection url
+*/
+func withPingInterval(rawUrl string, interval time.Duration) (string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set(PingIntervalParam, strconv.FormatInt(int64(interval/time.Millisecond), 10))
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+/**
+Use ping params advertised by the server, if the client requested
+its own interval
+*/
+func applyServerPing(conn transport.Connection, header Header) {
+	setter, ok := conn.(transport.PingParamsSetter)
+	if !ok || header.PingInterval <= 0 {
+		return
+	}
+
+	setter.SetPingParams(
+		time.Duration(header.PingInterval)*time.Millisecond,
+		time.Duration(header.PingTimeout)*time.Millisecond,
+	)
+}
@@ -0,0 +1,213 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func TestClientPingPayload(t *testing.T) {
+	s := newTestServer()
+	s.OnClientPing(func(payload []byte) []byte {
+		return append([]byte("re:"), payload...)
+	})
+	h := newOpenHarness(s)
+
+	if err := h.Feed("2hello"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, "3re:hello")
+}
+
+func TestClientPingBarePong(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	//payload is dropped without hook
+	if err := h.Feed("2hello"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, "3")
+}
+
+func TestServerPingPayload(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	pinged := make(chan string, 1)
+	client.OnServerPing(func(payload []byte) []byte {
+		pinged <- string(payload)
+		return []byte("load")
+	})
+
+	c := <-connected
+	if !c.Ping(5 * time.Second) {
+		t.Fatal("ping with pong payload not answered")
+	}
+	if payload := <-pinged; payload != "" {
+		t.Fatalf("got ping payload %q", payload)
+	}
+}
+
+func TestServerPingPayloadRoundTrip(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	pinged := make(chan string, 2)
+	client.OnServerPing(func(payload []byte) []byte {
+		pinged <- string(payload)
+		return []byte("load")
+	})
+	c := <-connected
+
+	if err := send(protocol.NewPing("probe"), c, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-pinged:
+		if payload != "probe" {
+			t.Fatalf("got ping payload %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook not called")
+	}
+
+	//pong with payload completes the round trip
+	start := time.Now()
+	if !c.Ping(5 * time.Second) {
+		t.Fatal("ping not answered")
+	}
+	if rtt := time.Since(start); rtt <= 0 || rtt >= 5*time.Second {
+		t.Fatal("round trip", rtt)
+	}
+}
+
+func TestPongPayloadAnswersPing(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	answered := make(chan bool, 1)
+	go func() { answered <- h.Channel.Ping(5 * time.Second) }()
+	waitFrame(t, h, "2")
+	if err := h.Feed("3load"); err != nil {
+		t.Fatal(err)
+	}
+	if !<-answered {
+		t.Fatal("pong with payload not taken for answer")
+	}
+}
+
+/**
+Dial the server requesting given ping interval, returns the client
+and the server channel
+*/
+func dialWithPing(t *testing.T, s *Server, interval time.Duration) (*Client, *Channel, func()) {
+	t.Helper()
+
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		PingInterval: interval,
+	})
+	if err != nil {
+		hs.Close()
+		t.Fatal(err)
+	}
+
+	return client, <-connected, func() {
+		client.Close()
+		hs.Close()
+	}
+}
+
+func TestClientPingIntervalClamped(t *testing.T) {
+	for _, test := range []struct {
+		requested, negotiated time.Duration
+	}{
+		{5 * time.Minute, time.Minute},
+		{time.Second, 10 * time.Second},
+		{30 * time.Second, 30 * time.Second},
+	} {
+		s := newTestServer()
+		s.SetClientPingBounds(10*time.Second, time.Minute)
+		client, sc, done := dialWithPing(t, s, test.requested)
+
+		if got := time.Duration(client.Handshake().PingInterval) * time.Millisecond; got != test.negotiated {
+			t.Fatalf("requested %v, advertised %v, want %v", test.requested, got, test.negotiated)
+		}
+		if got, _ := sc.Conn().PingParams(); got != test.negotiated {
+			t.Fatalf("requested %v, server uses %v", test.requested, got)
+		}
+		if got, _ := client.Conn().PingParams(); got != test.negotiated {
+			t.Fatalf("requested %v, client uses %v", test.requested, got)
+		}
+		done()
+	}
+}
+
+func TestClientPingIntervalIgnored(t *testing.T) {
+	s := newTestServer()
+	client, sc, done := dialWithPing(t, s, 5*time.Minute)
+	defer done()
+
+	want := transport.WsDefaultPingInterval
+	if got := time.Duration(client.Handshake().PingInterval) * time.Millisecond; got != want {
+		t.Fatalf("advertised %v, want %v", got, want)
+	}
+	if got, _ := sc.Conn().PingParams(); got != want {
+		t.Fatalf("server uses %v", got)
+	}
+}
+
+func TestAppHeartbeat(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetAppHeartbeat("heartbeat", "heartbeat-ack")
+	handled := 0
+	s.On("heartbeat", func(c *Channel) { handled++ })
+	h := newOpenHarness(s)
+
+	clock.Advance(time.Second)
+	feedEvent(t, h, "heartbeat")
+	expectFrames(t, h, `42["heartbeat-ack"]`)
+	if !h.Channel.LastActivity().Equal(clock.Now()) {
+		t.Fatal("last activity not advanced", h.Channel.LastActivity())
+	}
+
+	if err := h.Feed(`425["heartbeat"]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `435[]`)
+	if handled != 0 {
+		t.Fatal("heartbeat passed to handler")
+	}
+
+	//engine.io heartbeat is untouched
+	if err := h.Feed("2"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, "3")
+}
+
+func TestAppHeartbeatDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetAppHeartbeat("heartbeat", "heartbeat-ack")
+	s.SetAppHeartbeat("", "")
+	handled := 0
+	s.On("heartbeat", func(c *Channel) { handled++ })
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, "heartbeat")
+	expectFrames(t, h)
+	if handled != 1 {
+		t.Fatal("handled", handled)
+	}
+}
@@ -0,0 +1,29 @@
+package gophersocket
+
+import (
+	"sync/atomic"
+)
+
+/**
+Wrapper of values kept in atomic.Value, which panics when stored values
+differ in concrete type or are nil. Hooks and interfaces, which may be set
+to any implementation or reset with nil, are always stored wrapped
+*/
+type holder struct {
+	value interface{}
+}
+
+/**
+Store value in v wrapped, nil included
+*/
+func storeHeld(v *atomic.Value, value interface{}) {
+	v.Store(holder{value})
+}
+
+/**
+Get value stored in v with storeHeld, nil if nothing was stored
+*/
+func loadHeld(v *atomic.Value) interface{} {
+	h, _ := v.Load().(holder)
+	return h.value
+}
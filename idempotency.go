@@ -0,0 +1,303 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Field of the trailing ack argument carrying idempotency key
+	*/
+	IdempotencyKeyField = "idempotencyKey"
+
+	DefaultIdempotencyMaxKeys = 1000
+	DefaultIdempotencyTTL     = 5 * time.Minute
+)
+
+/**
+Storage of ack results by session and idempotency key, implement it
+on top of shared storage for multi-node setups
+*/
+type IdempotencyStore interface {
+	/**
+	Get encoded ack result stored for given session and key
+	*/
+	Get(session, key string) (result string, ok bool)
+
+	/**
+	Store encoded ack result for given session and key
+	*/
+	Set(session, key string, result string)
+}
+
+type idempotencyEntry struct {
+	key    string
+	result string
+	stored time.Time
+}
+
+/**
+In-memory IdempotencyStore, keeps not more than maxKeys results
+per session, each not longer than ttl
+*/
+type MemoryIdempotencyStore struct {
+	maxKeys int
+	ttl     time.Duration
+	clock   Clock
+
+	sessions  map[string][]idempotencyEntry
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+/**
+Ack request with idempotency key being processed, retries of it
+wait for the result instead of running handlers again
+*/
+type idempotencyCall struct {
+	done   chan struct{}
+	result string
+	stored bool
+}
+
+/**
+Create in-memory store with given limits, zero values mean defaults
+*/
+func NewMemoryIdempotencyStore(maxKeys int, ttl time.Duration) *MemoryIdempotencyStore {
+	if maxKeys <= 0 {
+		maxKeys = DefaultIdempotencyMaxKeys
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+
+	return &MemoryIdempotencyStore{
+		maxKeys:   maxKeys,
+		ttl:       ttl,
+		clock:     realClock{},
+		sessions:  make(map[string][]idempotencyEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+/**
+Set clock the ttl is measured with, nil means the real one.
+SetIdempotencyStore sets the clock of the server
+*/
+func (s *MemoryIdempotencyStore) SetClock(clock Clock) {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.clock = clock
+	s.lastSweep = clock.Now()
+}
+
+func (s *MemoryIdempotencyStore) Get(session, key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	for _, entry := range s.sessions[session] {
+		if entry.key == key && now.Sub(entry.stored) < s.ttl {
+			return entry.result, true
+		}
+	}
+
+	return "", false
+}
+
+func (s *MemoryIdempotencyStore) Set(session, key string, result string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.clock.Now()
+	if now.Sub(s.lastSweep) > s.ttl {
+		s.sweep(now)
+	}
+
+	entries := s.expire(s.sessions[session], now)
+	if len(entries) >= s.maxKeys {
+		entries = entries[len(entries)-s.maxKeys+1:]
+	}
+	s.sessions[session] = append(entries, idempotencyEntry{key, result, now})
+}
+
+/**
+Remove expired entries of all sessions, should be called under lock
+*/
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	for session, entries := range s.sessions {
+		if entries = s.expire(entries, now); len(entries) == 0 {
+			delete(s.sessions, session)
+		} else {
+			s.sessions[session] = entries
+		}
+	}
+	s.lastSweep = now
+}
+
+/**
+Remove expired entries from the beginning of entries ordered by time
+*/
+func (s *MemoryIdempotencyStore) expire(entries []idempotencyEntry, now time.Time) []idempotencyEntry {
+	i := 0
+	for i < len(entries) && now.Sub(entries[i].stored) >= s.ttl {
+		i++
+	}
+
+	return entries[i:]
+}
+
+/**
+Enable idempotent processing of acks carrying idempotency key,
+handlers are not called again on retry, stored result is sent instead.
+Results are kept by SessionId, so a retry after the client resumed its
+session on a new connection is answered too. A retry arriving while
+the first request is still processed waits for its result. Result
+of handlers whose channel closed meanwhile is not stored. Store
+having SetClock, as MemoryIdempotencyStore, gets the clock of the
+server, which should be set first. nil store disables it
+*/
+func (s *Server) SetIdempotencyStore(store IdempotencyStore) {
+	if clocked, ok := store.(interface{ SetClock(Clock) }); ok {
+		clocked.SetClock(s.getClock())
+	}
+
+	s.idempotencyLock.Lock()
+	defer s.idempotencyLock.Unlock()
+
+	s.idempotency = store
+	s.idempotencyCalls = make(map[string]*idempotencyCall)
+}
+
+func (s *Server) getIdempotency() IdempotencyStore {
+	s.idempotencyLock.Lock()
+	defer s.idempotencyLock.Unlock()
+
+	return s.idempotency
+}
+
+/**
+Get result of ack request with given key of the session: stored one,
+or the one of the same request in flight, once it is done. If there is
+none, the request is started and nil result is returned, the caller
+processes it and passes its result to finishIdempotent
+*/
+func (s *Server) startIdempotent(store IdempotencyStore, session, key string) (string, *idempotencyCall, bool) {
+	id := session + "\x00" + key
+
+	s.idempotencyLock.Lock()
+	if call, ok := s.idempotencyCalls[id]; ok {
+		s.idempotencyLock.Unlock()
+		<-call.done
+		return call.result, nil, call.stored
+	}
+	call := &idempotencyCall{done: make(chan struct{})}
+	s.idempotencyCalls[id] = call
+	s.idempotencyLock.Unlock()
+
+	//checked after the call is registered, so a request finished
+	//meanwhile is not processed again
+	if result, ok := store.Get(session, key); ok {
+		s.finishIdempotent(store, session, key, call, result, true)
+		return result, nil, true
+	}
+
+	return "", call, false
+}
+
+/**
+Store result of ack request started with startIdempotent and pass it
+to retries waiting for it. Not stored result, e.g. handler returned
+nothing, is not passed, the retries get no answer either
+*/
+func (s *Server) finishIdempotent(store IdempotencyStore, session, key string, call *idempotencyCall, result string, stored bool) {
+	if stored {
+		store.Set(session, key, result)
+	}
+	call.result, call.stored = result, stored
+
+	s.idempotencyLock.Lock()
+	if s.idempotencyCalls[session+"\x00"+key] == call {
+		delete(s.idempotencyCalls, session+"\x00"+key)
+	}
+	s.idempotencyLock.Unlock()
+
+	close(call.done)
+}
+
+/**
+Same as Ack, but attaches idempotency key, so a retry with the same key
+is answered with stored result, if server enabled idempotency
+*/
+func (c *Channel) AckIdempotent(method, key string, args interface{}, timeout time.Duration) (string, error) {
+	keyArg := map[string]string{IdempotencyKeyField: key}
+
+	return c.ackWithPolicy(method, timeout, func(timeout time.Duration) (string, error) {
+		msg := protocol.NewAckRequest("", c.ack.getNextId(), method, nil)
+
+		return c.waitAck(msg, func() error {
+			return sendArgs(msg, c, []interface{}{args, keyArg})
+		}, timeout)
+	})
+}
+
+/**
+Get idempotency key of ack request, if server enabled idempotency,
+returns arguments without the key
+*/
+func (c *Channel) idempotencyKey(msg *protocol.Message) (key, args string, ok bool) {
+	if msg.Type != protocol.MessageTypeAckRequest || c.server == nil || c.server.getIdempotency() == nil {
+		return "", msg.Args, false
+	}
+
+	return extractIdempotencyKey(msg.Args)
+}
+
+/**
+Split positional arguments of a message
+*/
+func splitArgs(args string) ([]json.RawMessage, error) {
+	var parts []json.RawMessage
+	if err := json.Unmarshal([]byte("["+args+"]"), &parts); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+/**
+Get idempotency key from the trailing argument, if present,
+and return the rest of arguments
+*/
+func extractIdempotencyKey(args string) (key, rest string, ok bool) {
+	parts, err := splitArgs(args)
+	if err != nil || len(parts) == 0 {
+		return "", args, false
+	}
+
+	var keyArg map[string]string
+	if err := json.Unmarshal(parts[len(parts)-1], &keyArg); err != nil {
+		return "", args, false
+	}
+	key, ok = keyArg[IdempotencyKeyField]
+	if !ok || len(keyArg) != 1 {
+		return "", args, false
+	}
+
+	restParts := make([]string, len(parts)-1)
+	for i := range restParts {
+		restParts[i] = string(parts[i])
+	}
+
+	return key, strings.Join(restParts, ","), true
+}
@@ -0,0 +1,179 @@
+package gophersocket
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotentRetry(t *testing.T) {
+	s := newTestServer()
+	s.SetIdempotencyStore(NewMemoryIdempotencyStore(0, 0))
+	calls := 0
+	s.On("work", func(c *Channel, v string) string {
+		calls++
+		return "re:" + v
+	})
+	h := newOpenHarness(s)
+
+	if err := h.Feed(`421["work","a",{"idempotencyKey":"k"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(`422["work","b",{"idempotencyKey":"k"}]`); err != nil {
+		t.Fatal(err)
+	}
+	//other key, and no key at all, run the handler
+	if err := h.Feed(`423["work","c",{"idempotencyKey":"other"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(`424["work","d"]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `431["re:a"]`, `432["re:a"]`, `433["re:c"]`, `434["re:d"]`)
+	if calls != 3 {
+		t.Fatal("handler called", calls)
+	}
+}
+
+func TestIdempotentRetryExpires(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetIdempotencyStore(NewMemoryIdempotencyStore(0, time.Minute))
+	calls := 0
+	s.On("work", func(c *Channel, v string) string {
+		calls++
+		return "re:" + v
+	})
+	h := newOpenHarness(s)
+
+	h.Feed(`421["work","a",{"idempotencyKey":"k"}]`)
+	clock.Advance(time.Minute)
+	h.Feed(`422["work","b",{"idempotencyKey":"k"}]`)
+	expectFrames(t, h, `431["re:a"]`, `432["re:b"]`)
+	if calls != 2 {
+		t.Fatal("handler called", calls)
+	}
+}
+
+func TestIdempotentRetryAfterResume(t *testing.T) {
+	s := newTestServer()
+	s.EnableReliableDelivery(10, time.Minute)
+	s.SetIdempotencyStore(NewMemoryIdempotencyStore(0, 0))
+	calls := 0
+	s.On("work", func(c *Channel, v string) string {
+		calls++
+		return "re:" + v
+	})
+
+	first := NewLoopHarness(s)
+	first.Pump()
+	stream := announcedStream(t, first.Frames()).Stream
+	if err := first.Feed(`421["work","a",{"idempotencyKey":"k"}]`); err != nil {
+		t.Fatal(err)
+	}
+	//the ack is lost with the connection
+	first.Channel.Close()
+	first.Pump()
+
+	second := newOpenHarness(s)
+	feedEvent(t, second, reliableResumeEvent, ReliableState{Stream: stream})
+	if second.Channel.SessionId() != stream || second.Channel.Id() == first.Channel.Id() {
+		t.Fatal("session", second.Channel.SessionId(), stream)
+	}
+	second.Pump()
+	second.Frames()
+
+	if err := second.Feed(`421["work","a",{"idempotencyKey":"k"}]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, second, `431["re:a"]`)
+	if calls != 1 {
+		t.Fatal("handler called again after resume", calls)
+	}
+}
+
+func TestIdempotentCancelledNotStored(t *testing.T) {
+	s := newTestServer()
+	s.EnableReliableDelivery(10, time.Minute)
+	s.SetIdempotencyStore(NewMemoryIdempotencyStore(0, 0))
+	calls := 0
+	s.On("work", func(ctx *EventContext, v string) string {
+		calls++
+		if calls == 1 {
+			//connection lost while the handler runs
+			closeChannel(ctx.Channel(), &s.methods, DisconnectTransportClose, CloseClientClose, nil)
+		}
+		return "re:" + v
+	})
+
+	first := NewLoopHarness(s)
+	first.Pump()
+	stream := announcedStream(t, first.Frames()).Stream
+	if err := first.Feed(`421["work","a",{"idempotencyKey":"k"}]`); err != nil {
+		t.Fatal(err)
+	}
+	first.Pump()
+	for _, frame := range first.Frames() {
+		if strings.HasPrefix(frame, "431") {
+			t.Fatal("result of cancelled handler sent", frame)
+		}
+	}
+
+	second := newOpenHarness(s)
+	feedEvent(t, second, reliableResumeEvent, ReliableState{Stream: stream})
+	second.Pump()
+	second.Frames()
+
+	//cancelled result was not stored, the retry runs the handler again
+	if err := second.Feed(`421["work","a",{"idempotencyKey":"k"}]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, second, `431["re:a"]`)
+	if calls != 2 {
+		t.Fatal("handler called", calls)
+	}
+}
+
+func TestIdempotentConcurrentDuplicates(t *testing.T) {
+	s := newTestServer()
+	s.SetIdempotencyStore(NewMemoryIdempotencyStore(0, 0))
+	var calls int32
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	s.On("work", func(c *Channel, v string) string {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-release
+		return "re:" + v
+	})
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	errs := make([]error, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = client.AckIdempotent("work", "k", "a", 5*time.Second)
+		}(i)
+	}
+
+	<-started
+	//give the duplicate time to arrive while the first one runs
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil || results[i] != `"re:a"` {
+			t.Fatal("result", results[i], errs[i])
+		}
+	}
+	if calls != 1 {
+		t.Fatal("handler called", calls)
+	}
+}
@@ -0,0 +1,147 @@
+// Reference socket.io client run against the Go server.
+// Usage: node client.js <version> <url>
+// Prints one JSON result per line: {"scenario", "status", "detail"}.
+const version = process.argv[2];
+const url = process.argv[3];
+
+if (version !== 'v2') {
+  console.error('unknown version ' + version);
+  process.exit(2);
+}
+const io = require('socket.io-client-v2');
+
+const scenarioTimeout = 5000;
+
+function connect(opts) {
+  return io(url, Object.assign({
+    transports: ['websocket'],
+    forceNew: true,
+    reconnection: false,
+  }, opts));
+}
+
+function connected(socket) {
+  return new Promise((resolve, reject) => {
+    socket.on('connect', () => resolve(socket));
+    socket.on('connect_error', reject);
+  });
+}
+
+function ack(socket, event, ...args) {
+  return new Promise((resolve) => socket.emit(event, ...args, resolve));
+}
+
+function once(socket, event) {
+  return new Promise((resolve) => socket.once(event, (...args) => resolve(args)));
+}
+
+const scenarios = {
+  async connect() {
+    const s = await connected(connect());
+    s.close();
+    return s.id ? '' : 'no sid';
+  },
+
+  async 'multi-arg emit'() {
+    const s = await connected(connect());
+    const got = once(s, 'multi');
+    s.emit('multi', 'a');
+    const args = await got;
+    s.close();
+    const want = JSON.stringify(['a', 2, true]);
+    return JSON.stringify(args) === want ? '' : 'got ' + JSON.stringify(args);
+  },
+
+  async 'ack success'() {
+    const s = await connected(connect());
+    const res = await ack(s, 'echo', { x: 1 });
+    s.close();
+    return res && res.x === 1 ? '' : 'got ' + JSON.stringify(res);
+  },
+
+  async 'ack error'() {
+    const s = await connected(connect());
+    const res = await ack(s, 'fail', 'x');
+    s.close();
+    return res && res.message === 'bad request' ? '' : 'got ' + JSON.stringify(res);
+  },
+
+  async 'rooms via relay'() {
+    const a = await connected(connect());
+    const b = await connected(connect());
+    await ack(a, 'join', 'interop');
+    await ack(b, 'join', 'interop');
+    let echoed = false;
+    a.on('relayed', () => { echoed = true; });
+    const got = once(b, 'relayed');
+    a.emit('relay', { room: 'interop', msg: 'hi' });
+    const [msg] = await got;
+    a.close();
+    b.close();
+    if (msg !== 'hi') {
+      return 'got ' + JSON.stringify(msg);
+    }
+    return echoed ? 'sender got its own broadcast' : '';
+  },
+
+  async 'disconnect reasons'() {
+    const s = await connected(connect());
+    const reason = once(s, 'disconnect');
+    s.emit('kick');
+    const [byServer] = await reason;
+    if (byServer !== 'io server disconnect') {
+      return 'server disconnect reported as ' + byServer;
+    }
+
+    const c = await connected(connect());
+    c.close();
+    const probe = await connected(connect());
+    await new Promise((resolve) => setTimeout(resolve, 200));
+    const byClient = await ack(probe, 'lastReason', '');
+    probe.close();
+    return byClient === 'client namespace disconnect' ? '' : 'client disconnect reported as ' + byClient;
+  },
+
+  async reconnection() {
+    const s = await connected(connect({
+      path: '/lifetime/',
+      reconnection: true,
+      reconnectionDelay: 100,
+      reconnectionDelayMax: 100,
+    }));
+    const [reason] = await once(s, 'disconnect');
+    await once(s, 'reconnect');
+    s.close();
+    return reason === 'transport close' ? '' : 'disconnect reported as ' + reason;
+  },
+};
+
+const skipped = {
+  'binary events': 'binary attachments are not implemented',
+};
+
+function report(scenario, status, detail) {
+  console.log(JSON.stringify({ scenario, status, detail: detail || '' }));
+}
+
+function withTimeout(p) {
+  return Promise.race([
+    p,
+    new Promise((resolve) => setTimeout(() => resolve('timeout'), scenarioTimeout)),
+  ]);
+}
+
+(async () => {
+  for (const [name, run] of Object.entries(scenarios)) {
+    try {
+      const detail = await withTimeout(run());
+      report(name, detail ? 'FAIL' : 'PASS', detail);
+    } catch (err) {
+      report(name, 'FAIL', String(err));
+    }
+  }
+  for (const [name, reason] of Object.entries(skipped)) {
+    report(name, 'SKIP', reason);
+  }
+  process.exit(0);
+})();
@@ -0,0 +1,36 @@
+// Reference socket.io server driven by the Go client suite.
+// Usage: node server.js <version> <port>
+const version = process.argv[2];
+const port = parseInt(process.argv[3], 10);
+
+let io;
+if (version === 'v2') {
+  io = require('socket.io-v2')(port, { transports: ['websocket'] });
+} else if (version === 'v4') {
+  const { Server } = require('socket.io-v4');
+  io = new Server(port, { transports: ['websocket'], allowEIO3: true });
+} else {
+  console.error('unknown version ' + version);
+  process.exit(2);
+}
+
+let lastReason = '';
+
+io.on('connection', (socket) => {
+  socket.on('echo', (data, cb) => cb(data));
+  socket.on('sum', (a, b, c, cb) => cb(a + b + c));
+  socket.on('fail', (data, cb) => cb({ error: 'bad request' }));
+  socket.on('join', (room, cb) => {
+    socket.join(room);
+    cb('joined');
+  });
+  socket.on('relay', (data) => socket.to(data.room).emit('relayed', data.msg));
+  socket.on('kick', () => socket.disconnect(true));
+  socket.on('drop', () => socket.conn.close());
+  socket.on('lastReason', (data, cb) => cb(lastReason));
+  socket.on('disconnect', (reason) => {
+    lastReason = reason;
+  });
+});
+
+console.log('listening ' + port);
@@ -0,0 +1,556 @@
+//go:build interop
+// +build interop
+
+/**
+Interop harness, runs scenarios of the Go client against the reference
+socket.io server, and of the reference client against the Go server,
+for each protocol version, and prints which of them pass.
+
+	go run -tags interop ./interop -install
+
+Needs node and npm, reference peers are pinned in fixture/package.json
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	gophersocket "github.com/whiterabb17/gopher-socket"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+const (
+	StatusPass = "PASS"
+	StatusFail = "FAIL"
+	StatusSkip = "SKIP"
+
+	scenarioTimeout = 5 * time.Second
+	startTimeout    = 10 * time.Second
+)
+
+/**
+Outcome of one scenario
+*/
+type result struct {
+	Version   string `json:"-"`
+	Direction string `json:"-"`
+	Scenario  string `json:"scenario"`
+	Status    string `json:"status"`
+	Detail    string `json:"detail"`
+}
+
+/**
+Scenario of the Go client, returns empty string on success,
+otherwise what went wrong
+*/
+type scenario struct {
+	name string
+	skip string
+	run  func(url string) string
+}
+
+/**
+Reference client versions able to talk to the Go server, which speaks EIO3
+*/
+var clientVersions = map[string]bool{
+	"v2": true,
+}
+
+func main() {
+	fixture := flag.String("fixture", "interop/fixture", "directory of reference peers")
+	versions := flag.String("versions", "v2,v4", "comma separated socket.io versions of reference peers")
+	install := flag.Bool("install", false, "install reference peers with npm if missing")
+	flag.Parse()
+
+	if *install {
+		if err := installFixture(*fixture); err != nil {
+			fmt.Fprintln(os.Stderr, "install failed:", err)
+			os.Exit(2)
+		}
+	}
+
+	var results []result
+	for _, version := range strings.Split(*versions, ",") {
+		version = strings.TrimSpace(version)
+		results = append(results, goClientSuite(*fixture, version)...)
+		results = append(results, goServerSuite(*fixture, version)...)
+	}
+
+	if printReport(results) {
+		os.Exit(1)
+	}
+}
+
+/**
+Run npm install in fixture directory, unless it is done already
+*/
+func installFixture(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "node_modules")); err == nil {
+		return nil
+	}
+
+	cmd := exec.Command("npm", "install", "--no-audit", "--no-fund")
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+/**
+Get free local port
+*/
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+/**
+Mark every scenario of the suite with the same status
+*/
+func allWith(version, direction, status, detail string, names []string) []result {
+	results := make([]result, 0, len(names))
+	for _, name := range names {
+		results = append(results, result{
+			Version:   version,
+			Direction: direction,
+			Scenario:  name,
+			Status:    status,
+			Detail:    detail,
+		})
+	}
+
+	return results
+}
+
+/**
+Start reference server and run Go client scenarios against it
+*/
+func goClientSuite(fixture, version string) []result {
+	const direction = "go client -> node server"
+
+	scenarios := clientScenarios()
+	names := make([]string, 0, len(scenarios))
+	for _, sc := range scenarios {
+		names = append(names, sc.name)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return allWith(version, direction, StatusFail, err.Error(), names)
+	}
+
+	cmd := exec.Command("node", "server.js", version, fmt.Sprint(port))
+	cmd.Dir = fixture
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return allWith(version, direction, StatusFail, err.Error(), names)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	if err := waitListening(stdout); err != nil {
+		return allWith(version, direction, StatusFail, "server: "+err.Error(), names)
+	}
+
+	url := gophersocket.GetUrl("127.0.0.1", port, false, "")
+	results := make([]result, 0, len(scenarios))
+	for _, sc := range scenarios {
+		res := result{Version: version, Direction: direction, Scenario: sc.name, Status: StatusSkip, Detail: sc.skip}
+		if sc.skip == "" {
+			res.Detail = runScenario(sc, url)
+			res.Status = StatusPass
+			if res.Detail != "" {
+				res.Status = StatusFail
+			}
+		}
+		results = append(results, res)
+	}
+
+	return results
+}
+
+/**
+Wait for the line the reference server prints once it listens
+*/
+func waitListening(stdout io.Reader) error {
+	started := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		if scanner.Scan() && strings.HasPrefix(scanner.Text(), "listening") {
+			started <- nil
+			for scanner.Scan() {
+			}
+			return
+		}
+		started <- errors.New("exited before listening")
+	}()
+
+	select {
+	case err := <-started:
+		return err
+	case <-time.After(startTimeout):
+		return errors.New("not listening in time")
+	}
+}
+
+/**
+Run scenario with timeout
+*/
+func runScenario(sc scenario, url string) string {
+	done := make(chan string, 1)
+	go func() {
+		done <- sc.run(url)
+	}()
+
+	select {
+	case detail := <-done:
+		return detail
+	case <-time.After(scenarioTimeout):
+		return "timeout"
+	}
+}
+
+func dial(url string) (*gophersocket.Client, error) {
+	return gophersocket.Dial(url, transport.GetDefaultWebsocketTransport())
+}
+
+/**
+Wait until channel is closed
+*/
+func waitClosed(c *gophersocket.Client) {
+	closed := make(chan struct{})
+	c.OnClosed(func() { close(closed) })
+	<-closed
+}
+
+func clientScenarios() []scenario {
+	return []scenario{
+		{name: "connect", run: func(url string) string {
+			c, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer c.Close()
+
+			if !c.IsAlive() {
+				return "closed after connect"
+			}
+			return ""
+		}},
+
+		{name: "multi-arg emit", run: func(url string) string {
+			c, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer c.Close()
+
+			var total int
+			ctx, cancel := context.WithTimeout(context.Background(), scenarioTimeout)
+			defer cancel()
+			if err := c.AckMulti(ctx, "sum", []interface{}{1, 2, 3}, &total); err != nil {
+				return err.Error()
+			}
+			if total != 6 {
+				return fmt.Sprint("got ", total)
+			}
+			return ""
+		}},
+
+		{name: "ack success", run: func(url string) string {
+			c, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer c.Close()
+
+			res, err := c.Ack("echo", map[string]int{"x": 1}, scenarioTimeout)
+			if err != nil {
+				return err.Error()
+			}
+			var echoed map[string]int
+			if err := json.Unmarshal([]byte(res), &echoed); err != nil || echoed["x"] != 1 {
+				return "got " + res
+			}
+			return ""
+		}},
+
+		{name: "ack error", run: func(url string) string {
+			c, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer c.Close()
+
+			res, err := c.Ack("fail", "x", scenarioTimeout)
+			if err != nil {
+				return err.Error()
+			}
+			var failed struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(res), &failed); err != nil || failed.Error != "bad request" {
+				return "got " + res
+			}
+			return ""
+		}},
+
+		{name: "binary events", skip: "binary attachments are not implemented"},
+
+		{name: "rooms via relay", run: func(url string) string {
+			a, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer a.Close()
+			b, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer b.Close()
+
+			var echoed bool
+			var echoedLock sync.Mutex
+			a.On("relayed", func(c *gophersocket.Channel, msg string) {
+				echoedLock.Lock()
+				echoed = true
+				echoedLock.Unlock()
+			})
+			got := make(chan string, 1)
+			b.On("relayed", func(c *gophersocket.Channel, msg string) {
+				got <- msg
+			})
+
+			for _, c := range []*gophersocket.Client{a, b} {
+				if _, err := c.Ack("join", "interop", scenarioTimeout); err != nil {
+					return err.Error()
+				}
+			}
+			a.Emit("relay", map[string]string{"room": "interop", "msg": "hi"})
+
+			if msg := <-got; msg != "hi" {
+				return "got " + msg
+			}
+			echoedLock.Lock()
+			defer echoedLock.Unlock()
+			if echoed {
+				return "sender got its own broadcast"
+			}
+			return ""
+		}},
+
+		{name: "disconnect reasons", run: func(url string) string {
+			c, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			c.Emit("kick", nil)
+			waitClosed(c)
+			if reason := c.CloseReason(); reason != gophersocket.DisconnectServer {
+				return "server disconnect reported as " + string(reason)
+			}
+
+			c, err = dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			c.Close()
+
+			probe, err := dial(url)
+			if err != nil {
+				return err.Error()
+			}
+			defer probe.Close()
+			time.Sleep(200 * time.Millisecond)
+
+			res, err := probe.Ack("lastReason", "", scenarioTimeout)
+			if err != nil {
+				return err.Error()
+			}
+			var reason string
+			json.Unmarshal([]byte(res), &reason)
+			if reason != "client namespace disconnect" {
+				return "client disconnect reported as " + res
+			}
+			return ""
+		}},
+
+		{name: "reconnection", run: func(url string) string {
+			connected := make(chan string, 2)
+			c, err := gophersocket.DialWithOptions(url, transport.GetDefaultWebsocketTransport(),
+				gophersocket.DialOptions{Reconnect: true})
+			if err != nil {
+				return err.Error()
+			}
+			defer c.Close()
+			c.On(gophersocket.OnConnection, func(c *gophersocket.Channel) { connected <- c.Id() })
+
+			//connect of the first session may come after the handler is set
+			sid := c.Id()
+			c.Emit("drop", nil)
+			for reconnected := false; !reconnected; {
+				select {
+				case id := <-connected:
+					reconnected = id != sid
+				case <-time.After(scenarioTimeout):
+					return "not reconnected"
+				}
+			}
+
+			if _, err := c.Ack("echo", "x", scenarioTimeout); err != nil {
+				return "after reconnect: " + err.Error()
+			}
+			return ""
+		}},
+	}
+}
+
+/**
+Names of reference client scenarios, to report them when it can not run
+*/
+var serverScenarioNames = []string{
+	"connect", "multi-arg emit", "ack success", "ack error",
+	"binary events", "rooms via relay", "disconnect reasons", "reconnection",
+}
+
+/**
+Start Go server and run reference client scenarios against it
+*/
+func goServerSuite(fixture, version string) []result {
+	const direction = "node client -> go server"
+
+	if !clientVersions[version] {
+		return allWith(version, direction, StatusSkip,
+			"client of this version does not speak EIO3", serverScenarioNames)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return allWith(version, direction, StatusFail, err.Error(), serverScenarioNames)
+	}
+	srv := &http.Server{Handler: newServerMux()}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(serverScenarioNames)+1)*scenarioTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "node", "client.js", version, "http://"+l.Addr().String())
+	cmd.Dir = fixture
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return allWith(version, direction, StatusFail, "client: "+err.Error(), serverScenarioNames)
+	}
+
+	var results []result
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		var res result
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			continue
+		}
+		res.Version, res.Direction = version, direction
+		results = append(results, res)
+	}
+
+	return results
+}
+
+/**
+Go server handlers used by the reference client, and a second server
+with short connection lifetime for reconnection scenario
+*/
+func newServerMux() *http.ServeMux {
+	s := gophersocket.NewServer(transport.GetDefaultWebsocketTransport())
+	s.ExposeErrors(true)
+
+	var lastReason gophersocket.DisconnectReason
+	var lastReasonLock sync.Mutex
+
+	s.On(gophersocket.OnDisconnection, func(c *gophersocket.Channel, reason gophersocket.DisconnectReason) {
+		lastReasonLock.Lock()
+		lastReason = reason
+		lastReasonLock.Unlock()
+	})
+	s.On("lastReason", func(c *gophersocket.Channel) string {
+		lastReasonLock.Lock()
+		defer lastReasonLock.Unlock()
+		return string(lastReason)
+	})
+	s.On("echo", func(c *gophersocket.Channel, data map[string]interface{}) map[string]interface{} {
+		return data
+	})
+	s.On("multi", func(c *gophersocket.Channel, first string) {
+		c.EmitOrClose("multi", first, 2, true)
+	})
+	s.On("fail", func(c *gophersocket.Channel, data string) error {
+		return errors.New("bad request")
+	})
+	s.On("join", func(c *gophersocket.Channel, room string) string {
+		c.Join(room)
+		return "joined"
+	})
+	s.On("relay", func(c *gophersocket.Channel, data struct {
+		Room string `json:"room"`
+		Msg  string `json:"msg"`
+	}) {
+		c.BroadcastToRoom(data.Room, "relayed", data.Msg)
+	})
+	s.On("kick", func(c *gophersocket.Channel) {
+		c.Close()
+	})
+
+	short := gophersocket.NewServer(transport.GetDefaultWebsocketTransport())
+	short.SetMaxConnectionLifetime(300 * time.Millisecond)
+
+	mux := http.NewServeMux()
+	mux.Handle("/socket.io/", s)
+	mux.Handle("/lifetime/", short)
+	return mux
+}
+
+/**
+Print results grouped by version and direction, returns true
+if any scenario failed
+*/
+func printReport(results []result) bool {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDIRECTION\tSCENARIO\tSTATUS\tDETAIL")
+
+	counts := make(map[string]int)
+	for _, res := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", res.Version, res.Direction, res.Scenario, res.Status, res.Detail)
+		counts[res.Status]++
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", counts[StatusPass], counts[StatusFail], counts[StatusSkip])
+	return counts[StatusFail] > 0
+}
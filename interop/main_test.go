@@ -0,0 +1,106 @@
+//go:build interop
+// +build interop
+
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+Scenarios which do not depend on behavior of the reference server,
+so they pass against the Go server too
+*/
+var selfScenarios = map[string]bool{
+	"connect":            true,
+	"ack success":        true,
+	"rooms via relay":    true,
+	"disconnect reasons": true,
+}
+
+func TestClientScenariosAgainstGoServer(t *testing.T) {
+	hs := httptest.NewServer(newServerMux())
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/socket.io/?EIO=3&transport=websocket"
+
+	for _, sc := range clientScenarios() {
+		if !selfScenarios[sc.name] {
+			continue
+		}
+		t.Run(sc.name, func(t *testing.T) {
+			if detail := runScenario(sc, url); detail != "" {
+				t.Fatal(detail)
+			}
+		})
+	}
+}
+
+func TestWaitListening(t *testing.T) {
+	if err := waitListening(strings.NewReader("listening 1234\nmore output\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitListening(strings.NewReader("Error: cannot find module\n")); err == nil {
+		t.Fatal("server exited before listening accepted")
+	}
+}
+
+func TestSkippedSuiteOfVersion(t *testing.T) {
+	results := goServerSuite("fixture", "v4")
+	if len(results) != len(serverScenarioNames) {
+		t.Fatalf("got %d results, want %d", len(results), len(serverScenarioNames))
+	}
+	for _, res := range results {
+		if res.Status != StatusSkip || res.Version != "v4" {
+			t.Fatalf("got %+v, want skipped", res)
+		}
+	}
+}
+
+func TestPrintReportFailure(t *testing.T) {
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	failed := printReport(append(
+		allWith("v2", "d", StatusPass, "", []string{"a", "b"}),
+		result{Version: "v2", Direction: "d", Scenario: "c", Status: StatusFail, Detail: "timeout"},
+	))
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+
+	if !failed {
+		t.Fatal("failure not reported")
+	}
+	if !strings.Contains(string(out), "2 passed, 1 failed, 0 skipped") {
+		t.Fatalf("got report %q", out)
+	}
+}
+
+/**
+Both directions against the reference peers, needs node
+and the fixture installed with -install
+*/
+func TestReferencePeers(t *testing.T) {
+	if _, err := os.Stat(filepath.Join("fixture", "node_modules")); err != nil {
+		t.Skip("fixture not installed")
+	}
+
+	var results []result
+	for _, version := range []string{"v2", "v4"} {
+		results = append(results, goClientSuite("fixture", version)...)
+		results = append(results, goServerSuite("fixture", version)...)
+	}
+	for _, res := range results {
+		if res.Status == StatusFail {
+			t.Errorf("%s %s %s: %s", res.Version, res.Direction, res.Scenario, res.Detail)
+		}
+	}
+}
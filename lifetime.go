@@ -0,0 +1,35 @@
+package gophersocket
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrorMaxLifetime = errors.New("Connection lifetime expired")
+)
+
+/**
+Close every connection once it is open for d, regardless of activity,
+so clients reconnect and authenticate again. Connection is closed without
+disconnect packet, so the client reports transport close and reconnects,
+the server reports DisconnectMaxLifetime. Zero disables it, applies to
+connections open after the call
+*/
+func (s *Server) SetMaxConnectionLifetime(d time.Duration) {
+	s.maxLifetime = d
+}
+
+/**
+Start lifetime timer of new channel, if lifetime is limited
+*/
+func (s *Server) startLifetime(c *Channel) {
+	if s.maxLifetime <= 0 {
+		return
+	}
+
+	timer := s.getClock().AfterFunc(s.maxLifetime, func() {
+		closeChannel(c, &s.methods, DisconnectMaxLifetime, CloseKicked, ErrorMaxLifetime)
+	})
+	c.OnClosed(func() { timer.Stop() })
+}
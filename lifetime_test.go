@@ -0,0 +1,58 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxConnectionLifetime(t *testing.T) {
+	const lifetime = 300 * time.Millisecond
+
+	s := newTestServer()
+	s.SetMaxConnectionLifetime(lifetime)
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	s.On("echo", func(c *Channel, v int) int { return v })
+
+	start := time.Now()
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	sc := <-connected
+
+	//keep exchanging messages until the connection is recycled
+	exchanged := 0
+	for sc.IsAlive() && time.Since(start) < 5*time.Second {
+		if _, err := client.Ack("echo", exchanged, 100*time.Millisecond); err == nil {
+			exchanged++
+		}
+	}
+	waitClosed(t, sc)
+	elapsed := time.Since(start)
+
+	if elapsed < lifetime-50*time.Millisecond || elapsed > lifetime+time.Second {
+		t.Fatal("closed after", elapsed)
+	}
+	if exchanged == 0 {
+		t.Fatal("no messages exchanged")
+	}
+	expectReason(t, sc, DisconnectMaxLifetime, ErrorMaxLifetime)
+
+	//no disconnect packet, so the client sees the transport closed,
+	//or failing if it was writing meanwhile
+	waitClosed(t, &client.Channel)
+	if reason := client.CloseReason(); reason != DisconnectTransportClose && reason != DisconnectTransportError {
+		t.Fatal("client reason", client.CloseReason())
+	}
+}
+
+func TestMaxConnectionLifetimeDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxConnectionLifetime(100 * time.Millisecond)
+	s.SetMaxConnectionLifetime(0)
+
+	h := NewLoopHarness(s)
+	time.Sleep(300 * time.Millisecond)
+	if !h.Channel.IsAlive() {
+		t.Fatal("channel closed with lifetime disabled")
+	}
+}
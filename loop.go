@@ -3,8 +3,11 @@ package gophersocket
 import (
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/whiterabb17/gopher-socket/protocol"
@@ -17,6 +20,8 @@ const (
 
 var (
 	ErrorWrongHeader = errors.New("Wrong header")
+
+	ErrorPayloadTooLarge = errors.New("Message is larger than maxPayload of the server")
 )
 
 /**
@@ -27,6 +32,35 @@ type Header struct {
 	Upgrades     []string `json:"upgrades"`
 	PingInterval int      `json:"pingInterval"`
 	PingTimeout  int      `json:"pingTimeout"`
+
+	ConnectionStateRecovery bool `json:"connectionStateRecovery,omitempty"`
+
+	//largest packet accepted by the server in bytes, sent by v4 servers,
+	//zero if not limited
+	MaxPayload int64 `json:"maxPayload,omitempty"`
+
+	//fields of received header not listed above, not sent
+	Raw map[string]json.RawMessage `json:"-"`
+}
+
+/**
+Message waiting in out queue, with time it was put there,
+and function called once it is written or dropped
+*/
+type outMessage struct {
+	data     string
+	enqueued time.Time
+	done     func(err error)
+
+	//position in out queue, zero for the close sentinel
+	seq uint64
+
+	//sent to many channels, admitted within broadcast share of the queue
+	broadcast bool
+}
+
+func newOutMessage(data string) outMessage {
+	return outMessage{data: data}
 }
 
 /**
@@ -39,15 +73,135 @@ Close message means channel is closed
 ping is automatic
 */
 type Channel struct {
-	conn transport.Connection
+	//accessed atomically, kept first for 64-bit alignment
+	bytesSent       int64
+	bytesReceived   int64
+	outBytes        int64
+	lastActivity    int64
+	lastMessage     int64
+	authenticatedAt int64
+	maxPayload      int64
+
+	outBroadcast      int64
+	outBroadcastBytes int64
+	directEnqueued    int64
+	broadcastEnqueued int64
+	directDropped     int64
+	broadcastDropped  int64
+
+	messagesReceived      int64
+	controlFramesReceived int64
+	writeRetries          int64
+
+	loopsRunning  int32
+	inFlight      int32
+	chunkStreamId uint32
+
+	//*connState, replaced as a whole on swap
+	conn atomic.Value
+
+	out       chan outMessage
+	outClosed bool
+	outLock   sync.RWMutex
+
+	pushedSeq uint64
+	pushLock  sync.Mutex
+
+	writtenSeq   uint64
+	flushWaiters []flushWaiter
+	flushLock    sync.Mutex
+
+	//replaced by reconnection on client
+	header     Header
+	headerLock sync.RWMutex
+
+	connectedAt time.Time
+
+	muted     atomic.Value
+	mutedLock sync.Mutex
+
+	frameTransform atomic.Value
+
+	connectStarted     chan struct{}
+	connectStartedOnce sync.Once
+	loopEventTail      chan struct{}
+	loopEventLock      sync.Mutex
+
+	connectBuffering bool
+	connectPending   []func()
+	connectLock      sync.Mutex
+
+	authRevoke Timer
+	authLock   sync.Mutex
+
+	//non root namespaces the channel is connected to, server side
+	namespaces     map[string]struct{}
+	namespacesLock sync.Mutex
+
+	//client side, replaces lost connection if reconnection is enabled
+	reconnect *reconnector
+
+	alive           bool
+	connectRejected bool
+	closeReason     DisconnectReason
+	closeCause      CloseCause
+	closeErr        error
+	aliveLock       sync.Mutex
+
+	loops     sync.WaitGroup
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	onClosed     []func()
+	finalized    bool
+	onClosedLock sync.Mutex
+
+	events       chan Event
+	eventsAll    bool
+	eventsClosed bool
+	eventsLock   sync.Mutex
 
-	out    chan string
-	header Header
+	ack ackProcessor
 
-	alive     bool
-	aliveLock sync.Mutex
+	handlers     handlerTable
+	handlersLock sync.RWMutex
 
-	ack ackProcessor
+	presenceMeta interface{}
+	metaLock     sync.RWMutex
+
+	pongWaiter     chan struct{}
+	pongWaiterLock sync.Mutex
+
+	residency durationWindow
+
+	coalesced     map[string]*coalescedEvent
+	coalescedLock sync.Mutex
+
+	transportName string
+	eio           string
+	transportLock sync.RWMutex
+
+	connLock sync.Mutex
+
+	stream     *reliableStream
+	streamSeen ReliableState
+	resumeFrom uint64
+	resumePid  string
+	resuming   bool
+	recovered  bool
+	//position comes as socket.io v4 offset, see EnableConnectionStateRecovery
+	streamOffsets bool
+	streamLock    sync.Mutex
+
+	//incoming EmitStream payloads being reassembled, nil value if dropped
+	chunkStreams     map[string][]byte
+	chunkStreamsLock sync.Mutex
+
+	//handlers and options of server or client owning the channel
+	shared *methods
+
+	//loops are stepped by LoopHarness instead of goroutines
+	manualLoops bool
 
 	server  *Server
 	ip      string
@@ -57,18 +211,150 @@ type Channel struct {
 /**
 create channel, map, and set active
 */
-func (c *Channel) initChannel() {
-	//TODO: queueBufferSize from constant to server or client variable
-	c.out = make(chan string, queueBufferSize)
+func (c *Channel) initChannel(queueSize int) {
+	c.out = make(chan outMessage, queueSize)
+	c.closed = make(chan struct{})
+	c.connectStarted = make(chan struct{})
+	c.connectedAt = c.clock().Now()
+	atomic.StoreInt64(&c.lastActivity, c.connectedAt.UnixNano())
+	atomic.StoreInt64(&c.lastMessage, c.connectedAt.UnixNano())
 	//c.ack.resultWaiters = make(map[int](chan string))
 	c.setAliveValue(true)
 }
 
+/**
+Transport connection with its generation, incremented on each swap
+*/
+type connState struct {
+	conn       transport.Connection
+	generation uint64
+}
+
+func (c *Channel) getConn() *connState {
+	state, _ := c.conn.Load().(*connState)
+	return state
+}
+
+/**
+Get current transport connection
+*/
+func (c *Channel) connection() transport.Connection {
+	return c.getConn().conn
+}
+
+/**
+Set initial transport connection
+*/
+func (c *Channel) setConn(conn transport.Connection) {
+	c.conn.Store(&connState{conn: conn})
+}
+
+/**
+Replace transport connection, keeping queue, rooms and ack waiters.
+Old connection is closed, loops finish the operation in progress on it
+and continue on the new one, a write failed on the old one is retried
+on the new one. Returns false if the channel is closed already
+*/
+func (c *Channel) swapConn(conn transport.Connection) bool {
+	c.connLock.Lock()
+	old := c.getConn()
+	c.conn.Store(&connState{conn: conn, generation: old.generation + 1})
+	c.connLock.Unlock()
+
+	old.conn.Close()
+
+	//closed concurrently, new connection may be missed by closeChannel
+	if !c.IsAlive() {
+		conn.Close()
+		return false
+	}
+
+	return true
+}
+
+/**
+Get engine.io header of current connection, on client it is the one
+received from the server, with unknown fields in Raw. Should not be modified
+*/
+func (c *Channel) Handshake() Header {
+	c.headerLock.RLock()
+	defer c.headerLock.RUnlock()
+
+	return c.header
+}
+
+/**
+Set engine.io header of current connection
+*/
+func (c *Channel) setHeader(header Header) {
+	c.headerLock.Lock()
+	defer c.headerLock.Unlock()
+
+	c.header = header
+}
+
 /**
 Get id of current socket connection
 */
 func (c *Channel) Id() string {
-	return c.header.Sid
+	return c.Handshake().Sid
+}
+
+/**
+Get name of transport the channel currently uses
+*/
+func (c *Channel) Transport() string {
+	c.transportLock.RLock()
+	defer c.transportLock.RUnlock()
+
+	return c.transportName
+}
+
+/**
+Set current transport name
+*/
+func (c *Channel) setTransport(tr transport.Transport) {
+	name := ""
+	if named, ok := tr.(transport.Named); ok {
+		name = named.Name()
+	}
+
+	c.transportLock.Lock()
+	defer c.transportLock.Unlock()
+
+	c.transportName = name
+}
+
+/**
+Get current transport connection, e.g. to check it for optional
+interfaces of package transport. It changes on transport upgrade
+*/
+func (c *Channel) Conn() transport.Connection {
+	return c.connection()
+}
+
+/**
+Check that current connection compresses messages,
+false if its transport can not tell
+*/
+func (c *Channel) CompressionEnabled() bool {
+	if inspector, ok := c.connection().(transport.Inspector); ok {
+		return inspector.CompressionEnabled()
+	}
+
+	return false
+}
+
+/**
+Get subprotocol negotiated for current connection,
+empty if none or its transport can not tell
+*/
+func (c *Channel) Subprotocol() string {
+	if inspector, ok := c.connection().(transport.Inspector); ok {
+		return inspector.Subprotocol()
+	}
+
+	return ""
 }
 
 /**
@@ -91,55 +377,220 @@ func (c *Channel) setAliveValue(value bool) {
 /**
 Close channel
 */
-func closeChannel(c *Channel, m *methods, args ...interface{}) error {
-	if !c.IsAlive() {
+func closeChannel(c *Channel, m *methods, reason DisconnectReason, cause CloseCause, err error) error {
+	//mark closed first, so connection swapped concurrently is closed by swap
+	if !c.markClosed(reason, cause, err) {
 		//already closed
 		return nil
 	}
 
-	c.conn.Close()
-
-	c.setAliveValue(false)
+	c.connection().Close()
 
 	//clean outloop
-	for len(c.out) > 0 {
-		<-c.out
-	}
+	c.drainOut()
 
-	c.out <- protocol.CloseMessage
-	m.callLoopEvent(c, OnDisconnection)
+	c.pushClose()
+	//rejected channel was never connected
+	if !c.connectRejected {
+		m.callLoopEvent(c, OnDisconnection)
+	}
 
 	deleteOverflooded(c)
 
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		go c.finalize()
+	})
+
 	return nil
 }
 
+/**
+Start in and out loops, unless they are run step by step by LoopHarness
+*/
+func (c *Channel) startLoops(m *methods) {
+	if c.manualLoops {
+		return
+	}
+
+	c.goLoop(func() { inLoop(c, m) })
+	c.goLoop(func() { outLoop(c, m) })
+}
+
+/**
+Run loop function in goroutine, tracked for final cleanup
+*/
+func (c *Channel) goLoop(f func()) {
+	c.loops.Add(1)
+	atomic.AddInt32(&c.loopsRunning, 1)
+	go func() {
+		defer c.loops.Done()
+		defer atomic.AddInt32(&c.loopsRunning, -1)
+		f()
+	}()
+}
+
+/**
+Wait for loops to return, make sure the channel is not referenced
+by registries anymore, and run OnClosed functions
+*/
+func (c *Channel) finalize() {
+	c.loops.Wait()
+
+	if c.server != nil {
+		deleteSid(c)
+	}
+	deleteOverflooded(c)
+	c.closeEvents()
+
+	c.onClosedLock.Lock()
+	hooks := c.onClosed
+	c.onClosed, c.finalized = nil, true
+	c.onClosedLock.Unlock()
+
+	for _, f := range hooks {
+		f()
+	}
+}
+
+/**
+Add function called once the channel is closed, its loops returned
+and it is removed from rooms and server registry, so resources
+tied to the connection may be released. Called at once, if the channel
+is already finalized
+*/
+func (c *Channel) OnClosed(f func()) {
+	c.onClosedLock.Lock()
+	if !c.finalized {
+		c.onClosed = append(c.onClosed, f)
+		c.onClosedLock.Unlock()
+		return
+	}
+	c.onClosedLock.Unlock()
+
+	f()
+}
+
 //incoming messages loop, puts incoming messages to In channel
 func inLoop(c *Channel, m *methods) error {
 	for {
-		pkg, err := c.conn.GetMessage()
+		state := c.getConn()
+		pkg, err := state.conn.GetMessage()
 		if err != nil {
-			return closeChannel(c, m, err)
+			if c.getConn().generation != state.generation && c.IsAlive() {
+				//connection swapped, continue reading the new one
+				continue
+			}
+			if c.reconnectConn(state.generation, err) {
+				continue
+			}
+			return closeChannel(c, m, readErrorReason(err), readErrorCause(c, err), err)
 		}
-		msg, err := protocol.Decode(pkg)
-		if err != nil {
-			closeChannel(c, m, protocol.ErrorWrongPacket)
+
+		if done, err := c.receivePacket(m, pkg, m.getExecutor().Submit); done {
 			return err
 		}
+	}
+}
 
-		switch msg.Type {
-		case protocol.MessageTypeOpen:
-			if err := json.Unmarshal([]byte(msg.Source[1:]), &c.header); err != nil {
-				closeChannel(c, m, ErrorWrongHeader)
+/**
+Process one packet read from the connection, messages for handlers
+are given to submit. Returns true if the loop should stop
+*/
+func (c *Channel) receivePacket(m *methods, pkg string, submit func(func())) (bool, error) {
+	received := c.clock().Now()
+	atomic.AddInt64(&c.bytesReceived, int64(len(pkg)))
+	atomic.StoreInt64(&c.lastActivity, received.UnixNano())
+	pkg, err := c.transformIn(pkg)
+	if err != nil {
+		closeChannel(c, m, DisconnectTransformError, CloseReadError, err)
+		return true, err
+	}
+	msg, err := protocol.Decode(pkg)
+	if err != nil {
+		msg, err = c.fallbackDecode(pkg, err)
+	}
+	if err != nil {
+		closeChannel(c, m, DisconnectParseError, CloseReadError, err)
+		return true, err
+	}
+
+	c.countReceived(m, msg)
+
+	switch msg.Type {
+	case protocol.MessageTypeEmpty:
+		//client side, open packet is processed by Dial,
+		//connection is accepted by server with empty message
+		if c.server == nil {
+			if c.acceptConnect(m, msg) {
+				m.callLoopEvent(c, OnConnection)
 			}
-			m.callLoopEvent(c, OnConnection)
-		case protocol.MessageTypePing:
-			c.out <- protocol.PongMessage
-		case protocol.MessageTypePong:
-		default:
-			go m.processIncomingMessage(c, msg)
+		} else if !isRootNamespace(msg.Namespace) {
+			c.server.connectNamespace(c, msg.Namespace)
+		} else if msg.Args != "" {
+			c.server.recoverSession(c, msg.Args)
+		}
+	case protocol.MessageTypePing:
+		c.enqueue(m.pongFor(msg.Args))
+	case protocol.MessageTypePong:
+		c.notifyPong()
+	case protocol.MessageTypeDisconnect:
+		if c.server != nil && !isRootNamespace(msg.Namespace) {
+			c.leaveNamespace(msg.Namespace)
+			return false, nil
+		}
+		return true, closeChannel(c, m, peerDisconnectReason(c), peerCloseCause(c), nil)
+	case protocol.MessageTypeClose:
+		if c.reconnect != nil {
+			//in loop fails reading and reconnects
+			c.connection().Close()
+			return false, nil
+		}
+		return true, closeChannel(c, m, DisconnectTransportClose, peerCloseCause(c), nil)
+	case protocol.MessageTypeConnectError:
+		err := m.callConnectError(c, msg.Args)
+		return true, closeChannel(c, m, DisconnectServer, CloseBadHandshake, err)
+	case protocol.MessageTypeUpgrade, protocol.MessageTypeNoop:
+		//connection is websocket from the start, so upgrade has nothing
+		//to switch, both are engine.io control packets, not events
+	default:
+		if c.connectRejected || !c.inNamespace(msg.Namespace) ||
+			!c.acceptReliable(m, msg) || c.acceptChunk(m, msg, received) {
+			return false, nil
+		}
+		atomic.StoreInt64(&c.lastMessage, received.UnixNano())
+		if c.server != nil && c.server.replyHeartbeat(c, msg) {
+			return false, nil
+		}
+		if c.server != nil && c.server.resyncState(c, msg) {
+			return false, nil
+		}
+		if c.server != nil && c.server.collect(c, msg, received) {
+			return false, nil
+		}
+		if c.server != nil && !c.waitConnectStarted() {
+			return false, nil
+		}
+		dispatch := func() {
+			atomic.AddInt32(&c.inFlight, 1)
+			submit(func() {
+				defer atomic.AddInt32(&c.inFlight, -1)
+				m.processIncomingMessage(c, msg, received)
+			})
 		}
+		if c.server != nil {
+			buffered, err := c.bufferUntilConnected(dispatch)
+			if err != nil {
+				return true, closeChannel(c, m, DisconnectTransportError, CloseOverflow, err)
+			}
+			if buffered {
+				return false, nil
+			}
+		}
+		dispatch()
 	}
+
+	return false, nil
 }
 
 var overflooded sync.Map
@@ -153,42 +604,367 @@ func storeOverflow(c *Channel) {
 }
 
 /**
-outgoing messages loop, sends messages from channel to socket
+outgoing messages loop, sends messages from channel to socket,
+on client also sends ping messages for keeping connection alive
 */
 func outLoop(c *Channel, m *methods) error {
+	defer c.finishOutLoop()
+
+	var ping <-chan time.Time
+	interval, timeout := c.connection().PingParams()
+	if c.server == nil {
+		ticker := c.clock().NewTicker(interval)
+		defer ticker.Stop()
+		ping = ticker.C()
+	}
+
 	for {
-		outBufferLen := len(c.out)
-		if outBufferLen >= queueBufferSize-1 {
-			return closeChannel(c, m, ErrorSocketOverflood)
-		} else if outBufferLen > int(queueBufferSize/2) {
-			storeOverflow(c)
-		} else {
-			deleteOverflooded(c)
+		if done, err := c.checkOverflow(m); done {
+			return err
 		}
 
-		msg := <-c.out
-		if msg == protocol.CloseMessage {
-			return nil
+		var msg outMessage
+		select {
+		case msg = <-c.out:
+		case <-ping:
+			//nothing received, not even pong of previous ping
+			if c.idleFor() > interval+timeout && c.reconnect != nil {
+				//in loop fails reading and reconnects
+				c.connection().Close()
+				continue
+			}
+			if c.idleFor() > interval+timeout {
+				return closeChannel(c, m, DisconnectPingTimeout, ClosePingTimeout, nil)
+			}
+			c.enqueue(protocol.PingMessage)
+			continue
 		}
 
-		err := c.conn.WriteMessage(msg)
-		if err != nil {
-			return closeChannel(c, m, err)
+		if done, err := c.writeOut(m, msg); done {
+			return err
+		}
+	}
+}
+
+/**
+Track overflow of out queue, closes the channel if the queue is full.
+Returns true if the loop should stop
+*/
+func (c *Channel) checkOverflow(m *methods) (bool, error) {
+	outBufferLen := len(c.out)
+	maxBytes := c.maxOutBytes()
+	overBytes := maxBytes > 0 && atomic.LoadInt64(&c.outBytes) > maxBytes/2
+	if outBufferLen >= cap(c.out)-1 {
+		return true, closeChannel(c, m, DisconnectTransportError, CloseOverflow, ErrorSocketOverflood)
+	} else if outBufferLen > cap(c.out)/2 || overBytes {
+		storeOverflow(c)
+	} else {
+		deleteOverflooded(c)
+	}
+
+	return false, nil
+}
+
+/**
+Write one message taken from out queue to the connection.
+Returns true if the loop should stop
+*/
+func (c *Channel) writeOut(m *methods, msg outMessage) (bool, error) {
+	if msg.data == protocol.CloseMessage {
+		return true, nil
+	}
+	c.addOutBytes(-int64(len(msg.data)))
+	c.releaseOut(msg)
+
+	residency := c.clock().Now().Sub(msg.enqueued)
+	c.residency.add(residency)
+	m.metricObserve(MetricQueueResidency, residency.Seconds())
+
+	frame, err := c.transformOut(msg.data)
+	if err != nil {
+		msg.finish(err)
+		return true, closeChannel(c, m, DisconnectTransformError, CloseWriteError, err)
+	}
+
+	state := c.getConn()
+	started := c.clock().Now()
+	err = writePacket(m, state.conn, frame)
+	err = c.retryWrite(m, state.conn, frame, started, err)
+	if err != nil && c.getConn().generation == state.generation {
+		c.reconnectConn(state.generation, err)
+	}
+	if err != nil && c.getConn().generation != state.generation {
+		//connection swapped during the write, retry once on the new one
+		err = writePacket(m, c.connection(), frame)
+	}
+	msg.finish(err)
+	if err != nil {
+		return true, closeChannel(c, m, DisconnectTransportError, CloseWriteError, err)
+	}
+	c.markWritten(msg.seq)
+	atomic.AddInt64(&c.bytesSent, int64(len(frame)))
+
+	return false, nil
+}
+
+/**
+Put message to out queue, numbering it if reliable delivery is enabled
+*/
+func (c *Channel) enqueue(data string) error {
+	return c.enqueueMessage(newOutMessage(data))
+}
+
+func (c *Channel) enqueueMessage(msg outMessage) error {
+	if stream := c.getStream(); stream != nil && strings.HasPrefix(msg.data, reliableEmitPrefix) {
+		return stream.send(c, msg)
+	}
+
+	return c.pushMessage(msg)
+}
+
+/**
+Put message to out queue as is
+*/
+func (c *Channel) push(data string) error {
+	return c.pushMessage(newOutMessage(data))
+}
+
+/**
+Put message to out queue, fails if the channel is closed
+or out loop is finished, so the message would never be sent,
+and on overflow, by count of messages or by their total size.
+On failure done function of the message is not called
+*/
+func (c *Channel) pushMessage(msg outMessage) (err error) {
+	data := msg.data
+	defer func() {
+		c.countPushed(msg.broadcast, 1, err)
+	}()
+
+	c.outLock.RLock()
+	defer c.outLock.RUnlock()
+
+	if c.outClosed || !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	size := int64(len(data))
+	if err := c.checkPayload(size); err != nil {
+		return err
+	}
+	if msg.broadcast && !c.admitBroadcast(size) {
+		return ErrorSocketOverflood
+	}
+	if maxBytes := c.maxOutBytes(); maxBytes > 0 {
+		if c.addOutBytes(size) > maxBytes {
+			c.addOutBytes(-size)
+			c.releaseOut(msg)
+			return ErrorSocketOverflood
+		}
+	} else {
+		c.addOutBytes(size)
+	}
+
+	//sequence follows queue order, so written sequence tells what is flushed
+	c.pushLock.Lock()
+	msg.seq = c.pushedSeq + 1
+	err = c.sendOut(msg)
+	if err == nil {
+		c.pushedSeq = msg.seq
+	}
+	c.pushLock.Unlock()
+	if err != nil {
+		c.addOutBytes(-size)
+		c.releaseOut(msg)
+	}
+
+	return err
+}
+
+/**
+Put messages to out queue one after another, with no other message
+between them. Either all are queued, or none, with error of pushMessage
+*/
+func (c *Channel) pushBatch(msgs []outMessage) (err error) {
+	defer func() {
+		c.countPushed(false, len(msgs), err)
+	}()
+
+	c.outLock.RLock()
+	defer c.outLock.RUnlock()
+
+	if c.outClosed || !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	var size int64
+	for _, msg := range msgs {
+		if err := c.checkPayload(int64(len(msg.data))); err != nil {
+			return err
+		}
+		size += int64(len(msg.data))
+	}
+	if maxBytes := c.maxOutBytes(); maxBytes > 0 {
+		if c.addOutBytes(size) > maxBytes {
+			c.addOutBytes(-size)
+			return ErrorSocketOverflood
+		}
+	} else {
+		c.addOutBytes(size)
+	}
+
+	//producers send under pushLock, so free space can only grow
+	c.pushLock.Lock()
+	defer c.pushLock.Unlock()
+
+	if cap(c.out)-len(c.out) < len(msgs) {
+		c.addOutBytes(-size)
+		return ErrorSocketOverflood
+	}
+	for i, msg := range msgs {
+		msg.seq = c.pushedSeq + 1
+		if err := c.sendOut(msg); err != nil {
+			//only when the queue is closed, nothing will be written
+			for _, left := range msgs[i:] {
+				c.addOutBytes(-int64(len(left.data)))
+			}
+			return err
+		}
+		c.pushedSeq = msg.seq
+	}
+
+	return nil
+}
+
+/**
+Put close sentinel to out queue, unless the out loop is finished already
+*/
+func (c *Channel) pushClose() {
+	c.outLock.RLock()
+	defer c.outLock.RUnlock()
+
+	if !c.outClosed {
+		c.sendOut(newOutMessage(protocol.CloseMessage))
+	}
+}
+
+/**
+Put message to out queue without blocking. Producers check the close
+state first, recover is the last resort if the queue is closed anyway
+*/
+func (c *Channel) sendOut(msg outMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("socket.io send on closed queue: ", r)
+			err = ErrorChannelClosed
 		}
+	}()
+
+	msg.enqueued = c.clock().Now()
+	select {
+	case c.out <- msg:
+		return nil
+	default:
+		return ErrorSocketOverflood
+	}
+}
+
+/**
+Check size of one message against maxPayload of the server
+*/
+func (c *Channel) checkPayload(size int64) error {
+	if maxPayload := atomic.LoadInt64(&c.maxPayload); maxPayload > 0 && size > maxPayload {
+		return ErrorPayloadTooLarge
+	}
+
+	return nil
+}
+
+/**
+Get limit of bytes buffered in out queue, zero means no limit
+*/
+func (c *Channel) maxOutBytes() int64 {
+	if c.shared != nil {
+		return c.shared.getMaxOutBytes()
 	}
+
+	return 0
 }
 
 /**
-Pinger sends ping messages for keeping connection alive
+Mark out loop finished, no message is enqueued after it returns,
+the ones enqueued concurrently with closing are dropped
 */
-func pinger(c *Channel) {
-	interval, _ := c.conn.PingParams()
-	ticker := time.NewTicker(interval)
+func (c *Channel) finishOutLoop() {
+	c.outLock.Lock()
+	c.outClosed = true
+	c.outLock.Unlock()
+
+	c.drainOut()
+}
+
+/**
+Drop messages waiting in out queue
+*/
+func (c *Channel) drainOut() {
 	for {
-		<-ticker.C
-		if !c.IsAlive() {
+		select {
+		case msg := <-c.out:
+			c.addOutBytes(-int64(len(msg.data)))
+			c.releaseOut(msg)
+			msg.finish(ErrorChannelClosed)
+		default:
 			return
 		}
-		c.out <- protocol.PingMessage
+	}
+}
+
+/**
+Report result of writing the message
+*/
+func (msg outMessage) finish(err error) {
+	if msg.done != nil {
+		msg.done(err)
+	}
+}
+
+/**
+Actively check that the peer is reachable, sends ping and waits for pong
+not longer than timeout. Peer should answer pings, as this library does
+*/
+func (c *Channel) Ping(timeout time.Duration) bool {
+	if !c.IsAlive() {
+		return false
+	}
+
+	c.pongWaiterLock.Lock()
+	if c.pongWaiter == nil {
+		c.pongWaiter = make(chan struct{})
+	}
+	waiter := c.pongWaiter
+	c.pongWaiterLock.Unlock()
+
+	if err := send(protocol.NewPing(""), c, nil); err != nil {
+		return false
+	}
+
+	select {
+	case <-waiter:
+		return true
+	case <-c.clock().After(timeout):
+		return false
+	}
+}
+
+/**
+Release all Ping calls waiting for pong
+*/
+func (c *Channel) notifyPong() {
+	c.pongWaiterLock.Lock()
+	defer c.pongWaiterLock.Unlock()
+
+	if c.pongWaiter != nil {
+		close(c.pongWaiter)
+		c.pongWaiter = nil
 	}
 }
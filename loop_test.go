@@ -0,0 +1,610 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Pump the harness until it writes given frame
+*/
+func waitFrame(t testing.TB, h *LoopHarness, frame string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		h.Pump()
+		for _, f := range h.Frames() {
+			if f == frame {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("frame %q not written", frame)
+}
+
+func TestPingResponsivePeer(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	result := make(chan bool)
+	go func() { result <- h.Channel.Ping(5 * time.Second) }()
+
+	waitFrame(t, h, "2")
+	h.Feed("3")
+
+	if !<-result {
+		t.Fatal("responsive peer reported unreachable")
+	}
+}
+
+func TestPingUnresponsivePeer(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	result := make(chan bool)
+	go func() { result <- h.Channel.Ping(50 * time.Millisecond) }()
+
+	waitFrame(t, h, "2")
+
+	if <-result {
+		t.Fatal("unresponsive peer reported reachable")
+	}
+	if !h.Channel.IsAlive() {
+		t.Fatal("probe closed the channel")
+	}
+}
+
+func TestPingClosedChannel(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Feed("41")
+
+	if h.Channel.Ping(time.Second) {
+		t.Fatal("closed channel reported reachable")
+	}
+}
+
+func TestPingClient(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	_, closeClient := dialTestServer(t, s)
+	defer closeClient()
+
+	c := <-connected
+	if !c.Ping(5 * time.Second) {
+		t.Fatal("client did not answer ping")
+	}
+}
+
+func TestEmitAfterOutLoopExit(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	h.FailWrites(errTestWrite)
+	h.Channel.Emit("ev", "lost")
+	h.Pump()
+
+	if err := h.Channel.Emit("ev", "late"); err != ErrorChannelClosed {
+		t.Fatal("emit after out loop exit:", err)
+	}
+	if _, err := h.Channel.Ack("ev", "late", time.Second); err == nil {
+		t.Fatal("ack after out loop exit succeeded")
+	}
+	if h.Channel.IsAlive() {
+		t.Fatal("channel alive after out loop exit")
+	}
+}
+
+/**
+Connection fed and read by the test through channels
+*/
+type pipeConn struct {
+	in     chan string
+	out    chan string
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeConn() *pipeConn {
+	return &pipeConn{
+		in:     make(chan string, 100),
+		out:    make(chan string, 2000),
+		closed: make(chan struct{}),
+	}
+}
+
+func (p *pipeConn) GetMessage() (string, error) {
+	select {
+	case msg := <-p.in:
+		return msg, nil
+	case <-p.closed:
+		return "", errTestWrite
+	}
+}
+
+func (p *pipeConn) WriteMessage(msg string) error {
+	select {
+	case <-p.closed:
+		return errTestWrite
+	default:
+	}
+	p.out <- msg
+	return nil
+}
+
+func (p *pipeConn) Close() {
+	p.once.Do(func() { close(p.closed) })
+}
+
+func (p *pipeConn) PingParams() (interval, timeout time.Duration) {
+	return time.Hour, time.Hour
+}
+
+/**
+Read next frame written to the pipe
+*/
+func readFrame(t testing.TB, conn *pipeConn) string {
+	t.Helper()
+
+	select {
+	case frame := <-conn.out:
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame written")
+		return ""
+	}
+}
+
+/**
+Set up server channel on pipe connection with its loops running,
+the open sequence is read off the pipe
+*/
+func pipeChannel(t testing.TB, s *Server) (*Channel, *pipeConn) {
+	t.Helper()
+
+	conn := newPipeConn()
+	s.SetupEventLoop(conn, "pipe", nil)
+
+	var hdr Header
+	if err := json.Unmarshal([]byte(readFrame(t, conn)[1:]), &hdr); err != nil {
+		t.Fatal(err)
+	}
+	readFrame(t, conn)
+
+	c, err := s.GetChannel(hdr.Sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, conn
+}
+
+/**
+Transport reporting given engine.io name
+*/
+type namedTransport struct {
+	transport.Transport
+	name string
+}
+
+func (tr namedTransport) Name() string {
+	return tr.name
+}
+
+func TestTransportName(t *testing.T) {
+	s := NewServer(namedTransport{transport.GetDefaultWebsocketTransport(), "polling"})
+	h := newOpenHarness(s)
+
+	if h.Channel.Transport() != "polling" {
+		t.Fatal("transport", h.Channel.Transport())
+	}
+	if stats := h.Channel.Stats(); stats.Transport != "polling" {
+		t.Fatalf("channel stats %+v", stats)
+	}
+}
+
+func TestPingBinaryHeartbeat(t *testing.T) {
+	tr := transport.GetDefaultWebsocketTransport()
+	tr.BinaryHeartbeat = true
+	s := NewServer(tr)
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+
+	clientTr := transport.GetDefaultWebsocketTransport()
+	clientTr.BinaryHeartbeat = true
+	client, err := Dial("ws"+strings.TrimPrefix(hs.URL, "http")+socketioUrl, clientTr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	c := <-connected
+	if !c.Ping(5*time.Second) || !client.Ping(5*time.Second) {
+		t.Fatal("binary ping not answered")
+	}
+	if !c.IsAlive() || !client.IsAlive() {
+		t.Fatal("binary heartbeat closed the connection")
+	}
+}
+
+func TestOnClosedAfterLoopsReturn(t *testing.T) {
+	s := newTestServer()
+	type state struct {
+		loops      int32
+		inRoom     int
+		lookup     error
+		overflowed bool
+	}
+	finalized := make(chan state, 2)
+	s.On(OnConnection, func(c *Channel) {
+		c.Join("room")
+		c.OnClosed(func() {
+			_, overflowed := overflooded.Load(c)
+			_, err := s.GetChannel(c.Id())
+			finalized <- state{
+				loops:      atomic.LoadInt32(&c.loopsRunning),
+				inRoom:     s.Amount("room"),
+				lookup:     err,
+				overflowed: overflowed,
+			}
+		})
+	})
+
+	client, closeClient := dialTestServer(t, s)
+	client.Emit("ev", nil)
+	closeClient()
+
+	var st state
+	select {
+	case st = <-finalized:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnClosed not called")
+	}
+	if st.loops != 0 || st.inRoom != 0 || st.lookup == nil || st.overflowed {
+		t.Fatalf("channel not torn down before OnClosed: %+v", st)
+	}
+
+	select {
+	case <-finalized:
+		t.Fatal("OnClosed called twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOnClosedAfterFinalized(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	_, closeClient := dialTestServer(t, s)
+	c := <-connected
+	closed := make(chan struct{})
+	c.OnClosed(func() { close(closed) })
+	closeClient()
+	<-closed
+
+	//registered after teardown, called at once
+	called := false
+	c.OnClosed(func() { called = true })
+	if !called {
+		t.Fatal("OnClosed of finalized channel not called")
+	}
+}
+
+func TestSwapConnKeepsChannel(t *testing.T) {
+	s := newTestServer()
+	got := make(chan int, 100)
+	s.On("n", func(c *Channel, v int) { got <- v })
+
+	c, first := pipeChannel(t, s)
+	conns := []*pipeConn{first}
+	c.Join("room")
+
+	const total = 1000
+	emitted := make(chan error, 1)
+	go func() {
+		for i := 0; i < total; i++ {
+			if err := c.Emit("x", i); err != nil {
+				emitted <- err
+				return
+			}
+			if i%100 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		emitted <- nil
+	}()
+
+	for i := 1; i <= 10; i++ {
+		conn := newPipeConn()
+		conns = append(conns, conn)
+		if !c.swapConn(conn) {
+			t.Fatal("swap failed on live channel")
+		}
+
+		//reads continue on the new connection
+		conn.in <- fmt.Sprintf(`42["n",%d]`, i)
+		select {
+		case v := <-got:
+			if v != i {
+				t.Fatalf("got %d, want %d", v, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("message on swapped connection not read")
+		}
+	}
+	if err := <-emitted; err != nil {
+		t.Fatal(err)
+	}
+
+	//every message is written once, to one of the connections
+	written := map[string]int{}
+	deadline := time.After(5 * time.Second)
+	for count := 0; count < total; {
+		progress := false
+		for _, conn := range conns {
+			select {
+			case frame := <-conn.out:
+				if strings.HasPrefix(frame, `42["x",`) {
+					written[frame]++
+					count++
+				}
+				progress = true
+			default:
+			}
+		}
+		if progress {
+			continue
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("%d of %d messages written", count, total)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	for frame, n := range written {
+		if n != 1 {
+			t.Fatalf("%s written %d times", frame, n)
+		}
+	}
+
+	if !c.IsAlive() || s.Amount("room") != 1 {
+		t.Fatal("channel state lost on swap")
+	}
+	closeChannel(c, &s.methods, DisconnectServer, CloseKicked, nil)
+	if c.swapConn(newPipeConn()) {
+		t.Fatal("swap succeeded on closed channel")
+	}
+}
+
+func TestClientPingsFromOutLoop(t *testing.T) {
+	s := newTestServer()
+	pinged := make(chan struct{}, 10)
+	s.OnClientPing(func(payload []byte) []byte {
+		pinged <- struct{}{}
+		return nil
+	})
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+
+	tr := transport.GetDefaultWebsocketTransport()
+	tr.PingInterval = 20 * time.Millisecond
+	client, err := Dial("ws"+strings.TrimPrefix(hs.URL, "http")+socketioUrl, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pinged:
+		case <-time.After(5 * time.Second):
+			t.Fatal("client did not ping")
+		}
+	}
+}
+
+func TestConnInspection(t *testing.T) {
+	serverTr := transport.GetDefaultWebsocketTransport()
+	serverTr.Subprotocols = []string{"v2.app", "v1.app"}
+	serverTr.EnableCompression = true
+	s := NewServer(serverTr)
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	clientTr := transport.GetDefaultWebsocketTransport()
+	clientTr.Subprotocols = []string{"v1.app"}
+	clientTr.EnableCompression = true
+	client, err := Dial(url, clientTr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sc := <-connected
+
+	if _, ok := sc.Conn().(transport.Inspector); !ok {
+		t.Fatal("websocket connection is not inspector")
+	}
+	for _, c := range []*Channel{sc, &client.Channel} {
+		if c.Subprotocol() != "v1.app" || !c.CompressionEnabled() {
+			t.Fatalf("got subprotocol %q, compression %v", c.Subprotocol(), c.CompressionEnabled())
+		}
+	}
+
+	//connection unable to tell
+	h := NewLoopHarness(newTestServer())
+	if h.Channel.Subprotocol() != "" || h.Channel.CompressionEnabled() {
+		t.Fatal("harness connection reported negotiation")
+	}
+}
+
+func TestConnInspectionNotNegotiated(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	clientTr := transport.GetDefaultWebsocketTransport()
+	clientTr.Subprotocols = []string{"v1.app"}
+	clientTr.EnableCompression = true
+	client, err := Dial(url, clientTr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sc := <-connected
+
+	for _, c := range []*Channel{sc, &client.Channel} {
+		if c.Subprotocol() != "" || c.CompressionEnabled() {
+			t.Fatalf("got subprotocol %q, compression %v", c.Subprotocol(), c.CompressionEnabled())
+		}
+	}
+}
+
+func TestReservedPacketsNotEvents(t *testing.T) {
+	s := newTestServer()
+	var got []string
+	s.On(OnAny, func(ctx *EventContext) { got = append(got, ctx.Event()) })
+	h := newOpenHarness(s)
+
+	for _, frame := range []string{"5", "6", "5probe", "6x"} {
+		if err := h.Feed(frame); err != nil {
+			t.Fatalf("frame %q: %v", frame, err)
+		}
+	}
+	expectFrames(t, h)
+	if len(got) != 0 || !h.Channel.IsAlive() {
+		t.Fatal("reserved packets handled as events", got)
+	}
+
+	if err := h.Feed("1"); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, h.Channel)
+	if len(got) != 0 || h.Channel.CloseReason() != DisconnectTransportClose {
+		t.Fatal("close packet", got, h.Channel.CloseReason())
+	}
+}
+
+/**
+Dial server supporting v2.app and v1.app subprotocols offering given ones,
+returns the server channel, nil if dial failed
+*/
+func dialSubprotocols(t *testing.T, require bool, offered ...string) (*Channel, error) {
+	t.Helper()
+
+	s := newTestServer()
+	if err := s.SetSubprotocols([]string{"v2.app", "v1.app"}, require); err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	clientTr := transport.GetDefaultWebsocketTransport()
+	clientTr.Subprotocols = offered
+	client, err := Dial(url, clientTr)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return <-connected, nil
+}
+
+func TestSetSubprotocols(t *testing.T) {
+	sc, err := dialSubprotocols(t, false, "v3.app", "v1.app", "v2.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Subprotocol() != "v2.app" {
+		t.Fatal("negotiated", sc.Subprotocol())
+	}
+
+	sc, err = dialSubprotocols(t, false, "v3.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Subprotocol() != "" {
+		t.Fatal("negotiated unsupported", sc.Subprotocol())
+	}
+}
+
+func TestSetSubprotocolsRequired(t *testing.T) {
+	if _, err := dialSubprotocols(t, true, "v3.app"); !errors.Is(err, ErrorHandshakeFailed) {
+		t.Fatal("unsupported subprotocol accepted:", err)
+	}
+	if _, err := dialSubprotocols(t, true); !errors.Is(err, ErrorHandshakeFailed) {
+		t.Fatal("missing subprotocol accepted:", err)
+	}
+
+	sc, err := dialSubprotocols(t, true, "v1.app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Subprotocol() != "v1.app" {
+		t.Fatal("negotiated", sc.Subprotocol())
+	}
+}
+
+func TestCloseUnderPingerActivity(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		clock := newManualClock()
+		conn := newPipeConn()
+		c := newPipeClient(conn, clock)
+		interval, _ := conn.PingParams()
+		clock.waitTimer(t, interval)
+
+		stop := make(chan struct{})
+		var producers sync.WaitGroup
+		producers.Add(3)
+		go func() {
+			defer producers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-conn.out:
+				default:
+					clock.Advance(interval)
+				}
+			}
+		}()
+		for p := 0; p < 2; p++ {
+			go func() {
+				defer producers.Done()
+				for c.Emit("ev", 1) != ErrorChannelClosed {
+				}
+			}()
+		}
+
+		c.Close()
+		waitClosed(t, &c.Channel)
+		close(stop)
+		producers.Wait()
+	}
+}
+
+func TestSendOnClosedQueue(t *testing.T) {
+	h := NewLoopHarness(newTestServer())
+
+	close(h.Channel.out)
+	if err := h.Channel.enqueue("2"); err != ErrorChannelClosed {
+		t.Fatal(err)
+	}
+}
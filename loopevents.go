@@ -0,0 +1,314 @@
+package gophersocket
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	/**
+	Loop events waiting in the queue, see SetLoopEventQueue
+	*/
+	MetricLoopEventQueueDepth = "loop_event_queue_depth"
+
+	/**
+	Informational loop events dropped as the queue was full,
+	labeled by event
+	*/
+	MetricLoopEventsDropped = "loop_events_dropped_total"
+)
+
+type loopEvent struct {
+	c     *Channel
+	event string
+}
+
+/**
+Bounded queue of loop events, split to shards by channel, each
+processed by its own worker, so events of one channel keep their order
+*/
+type loopEventQueue struct {
+	//accessed atomically, kept first for 64-bit alignment
+	depth   int64
+	dropped int64
+
+	m      *methods
+	shards []*loopEventShard
+}
+
+/**
+Events of one worker. Events which must not be lost wait in overflow
+once events is full, so producers never block, even the worker itself
+closing a channel from a handler
+*/
+type loopEventShard struct {
+	events chan loopEvent
+
+	//guards sends to events and overflow
+	lock     sync.Mutex
+	overflow []loopEvent
+}
+
+/**
+Run handlers of loop events on workers instead of the channel loops,
+with queue of given capacity shared by workers equally. Full queue keeps
+OnConnection and OnDisconnection beyond the capacity, so they are never
+lost and producers, handlers included, never block, and drops
+informational events like OnStreamLost, counting them.
+Events of one channel are handled in order, events of different ones
+concurrently. Handlers may run after the channel started processing
+messages or was finalized. Zero capacity or workers runs handlers in
+the loops, as by default. Should be called before serving
+*/
+func (s *Server) SetLoopEventQueue(capacity, workers int) {
+	if capacity <= 0 || workers <= 0 {
+		s.loopEvents = nil
+		return
+	}
+
+	perShard := capacity / workers
+	if perShard < 1 {
+		perShard = 1
+	}
+	q := &loopEventQueue{
+		m:      &s.methods,
+		shards: make([]*loopEventShard, workers),
+	}
+	for i := range q.shards {
+		q.shards[i] = &loopEventShard{events: make(chan loopEvent, perShard)}
+		go q.work(q.shards[i])
+	}
+
+	s.loopEvents = q
+}
+
+/**
+Get amount of informational loop events dropped as the queue was full
+*/
+func (s *Server) LoopEventsDropped() int64 {
+	if s.loopEvents == nil {
+		return 0
+	}
+
+	return atomic.LoadInt64(&s.loopEvents.dropped)
+}
+
+/**
+Events which are kept on full queue instead of being dropped
+*/
+func blockingLoopEvent(event string) bool {
+	return event == OnConnection || event == OnDisconnection
+}
+
+func (q *loopEventQueue) push(c *Channel, event string) {
+	hash := fnv.New32a()
+	hash.Write([]byte(c.Id()))
+	shard := q.shards[hash.Sum32()%uint32(len(q.shards))]
+
+	//counted before the worker may take it, so depth is never negative
+	q.m.metricSet(MetricLoopEventQueueDepth, float64(atomic.AddInt64(&q.depth, 1)))
+
+	ev := loopEvent{c: c, event: event}
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	//events behind the overflow would overtake it
+	if len(shard.overflow) == 0 {
+		select {
+		case shard.events <- ev:
+			return
+		default:
+		}
+	}
+
+	if blockingLoopEvent(event) {
+		shard.overflow = append(shard.overflow, ev)
+		return
+	}
+
+	q.m.metricSet(MetricLoopEventQueueDepth, float64(atomic.AddInt64(&q.depth, -1)))
+	atomic.AddInt64(&q.dropped, 1)
+	q.m.metricAdd(MetricLoopEventsDropped, 1, "event", event)
+}
+
+func (q *loopEventQueue) work(shard *loopEventShard) {
+	for ev := range shard.events {
+		shard.refill()
+		q.m.metricSet(MetricLoopEventQueueDepth, float64(atomic.AddInt64(&q.depth, -1)))
+		q.m.runLoopEvent(ev.c, ev.event)
+	}
+}
+
+/**
+Move overflown events to the freed space of events, in order
+*/
+func (s *loopEventShard) refill() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for len(s.overflow) > 0 {
+		select {
+		case s.events <- s.overflow[0]:
+			s.overflow[0] = loopEvent{}
+			s.overflow = s.overflow[1:]
+		default:
+			return
+		}
+	}
+	s.overflow = nil
+}
+
+/**
+How handlers of loop event are run, see SetLoopEventMode
+*/
+type LoopEventMode int
+
+const (
+	/**
+	In the goroutine raising the event, the default. OnConnection runs
+	in the connection handler, OnDisconnection delays the loop finishing
+	*/
+	LoopEventInline LoopEventMode = iota
+
+	/**
+	In a goroutine, after handlers of earlier ordered events
+	of the same channel returned
+	*/
+	LoopEventOrdered
+
+	/**
+	In a goroutine of their own, with no ordering
+	*/
+	LoopEventUnordered
+
+	/**
+	For OnConnection only, in a goroutine, while the channel keeps
+	reading: its events received meanwhile are buffered and dispatched
+	in order received once the handlers returned, before later ones
+	*/
+	LoopEventBuffered
+)
+
+const (
+	//events buffered while connection handlers run, the channel
+	//is closed on more
+	connectBufferSize = queueBufferSize
+)
+
+var (
+	ErrorLoopEventMode = errors.New("Mode may be set for connection and disconnection only")
+)
+
+/**
+Set how handlers of OnConnection or OnDisconnection are run. With any
+mode, events of the channel are not dispatched before OnConnection
+handlers started. Modes are ignored with SetLoopEventQueue, which runs
+handlers on its workers
+*/
+func (m *methods) SetLoopEventMode(event string, mode LoopEventMode) error {
+	if event != OnConnection && event != OnDisconnection {
+		return ErrorLoopEventMode
+	}
+	if event == OnDisconnection && mode == LoopEventBuffered {
+		return ErrorLoopEventMode
+	}
+
+	m.loopEventModes.Store(event, mode)
+	return nil
+}
+
+func (m *methods) getLoopEventMode(event string) LoopEventMode {
+	mode, _ := m.loopEventModes.Load(event)
+	if mode == nil {
+		return LoopEventInline
+	}
+
+	return mode.(LoopEventMode)
+}
+
+/**
+Run f in a goroutine once the previous ordered one of the channel returned
+*/
+func (c *Channel) runOrdered(f func()) {
+	c.loopEventLock.Lock()
+	prev := c.loopEventTail
+	done := make(chan struct{})
+	c.loopEventTail = done
+	c.loopEventLock.Unlock()
+
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		f()
+	}()
+}
+
+func (c *Channel) markConnectStarted() {
+	c.connectStartedOnce.Do(func() {
+		close(c.connectStarted)
+	})
+}
+
+/**
+Wait until OnConnection handlers started, returns false if
+the channel closed first
+*/
+func (c *Channel) waitConnectStarted() bool {
+	select {
+	case <-c.connectStarted:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+/**
+Run connection handlers in a goroutine, buffering events of the channel
+until they return
+*/
+func (m *methods) runBufferedConnection(c *Channel) {
+	c.connectLock.Lock()
+	c.connectBuffering = true
+	c.connectLock.Unlock()
+
+	go func() {
+		m.runLoopEvent(c, OnConnection)
+
+		c.connectLock.Lock()
+		defer c.connectLock.Unlock()
+
+		//replayed under the lock, so events read meanwhile wait behind
+		pending := c.connectPending
+		c.connectPending, c.connectBuffering = nil, false
+		if !c.IsAlive() {
+			return
+		}
+		for _, dispatch := range pending {
+			dispatch()
+		}
+	}()
+}
+
+/**
+Buffer dispatch of incoming event while connection handlers run,
+returns false if they are done and the event should be dispatched now
+*/
+func (c *Channel) bufferUntilConnected(dispatch func()) (bool, error) {
+	c.connectLock.Lock()
+	defer c.connectLock.Unlock()
+
+	if !c.connectBuffering {
+		return false, nil
+	}
+	if len(c.connectPending) >= connectBufferSize {
+		return true, ErrorSocketOverflood
+	}
+
+	c.connectPending = append(c.connectPending, dispatch)
+	return true, nil
+}
@@ -0,0 +1,282 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoopEventOrderedDoesNotBlockEvents(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventMode(OnConnection, LoopEventOrdered)
+
+	var lock sync.Mutex
+	var order []string
+	record := func(v string) {
+		lock.Lock()
+		order = append(order, v)
+		lock.Unlock()
+	}
+	release := make(chan struct{})
+	s.On(OnConnection, func(c *Channel) {
+		record("connect")
+		<-release
+	})
+	got := make(chan string, 1)
+	s.On("ev", func(c *Channel, v string) {
+		record(v)
+		got <- v
+	})
+	defer close(release)
+
+	//slow connection handler is still running
+	h := NewLoopHarness(s)
+	feedEvent(t, h, "ev", "a")
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event blocked by connection handler")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(order) != 2 || order[0] != "connect" || order[1] != "a" {
+		t.Fatal("order", order)
+	}
+}
+
+func TestLoopEventOrderedDisconnectAfterConnect(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventMode(OnConnection, LoopEventOrdered)
+	s.SetLoopEventMode(OnDisconnection, LoopEventOrdered)
+
+	release := make(chan struct{})
+	connectDone := make(chan struct{})
+	disconnected := make(chan bool, 1)
+	s.On(OnConnection, func(c *Channel) {
+		<-release
+		close(connectDone)
+	})
+	s.On(OnDisconnection, func(c *Channel) {
+		select {
+		case <-connectDone:
+			disconnected <- true
+		default:
+			disconnected <- false
+		}
+	})
+
+	h := NewLoopHarness(s)
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	select {
+	case <-disconnected:
+		t.Fatal("disconnection overtook connection handler")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-disconnected {
+		t.Fatal("disconnection ran before connection handler returned")
+	}
+}
+
+func TestLoopEventUnordered(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventMode(OnConnection, LoopEventUnordered)
+	s.SetLoopEventMode(OnDisconnection, LoopEventUnordered)
+
+	release := make(chan struct{})
+	disconnected := make(chan struct{})
+	s.On(OnConnection, func(c *Channel) { <-release })
+	s.On(OnDisconnection, func(c *Channel) { close(disconnected) })
+	defer close(release)
+
+	h := NewLoopHarness(s)
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnection waited for connection handler")
+	}
+}
+
+func TestLoopEventModeOtherEvent(t *testing.T) {
+	s := newTestServer()
+
+	if err := s.SetLoopEventMode("ev", LoopEventOrdered); err != ErrorLoopEventMode {
+		t.Fatal(err)
+	}
+	if err := s.SetLoopEventMode(OnDisconnection, LoopEventOrdered); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoopEventBufferedReplaysInOrder(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventMode(OnConnection, LoopEventBuffered)
+
+	release := make(chan struct{})
+	got := make(chan string, 10)
+	s.On(OnConnection, func(c *Channel) {
+		<-release
+		got <- "connect"
+	})
+	s.On("ev", func(c *Channel, v string) { got <- v })
+
+	//read loop is not stalled by the connection handler
+	h := NewLoopHarness(s)
+	for _, v := range []string{"a", "b", "c"} {
+		feedEvent(t, h, "ev", v)
+	}
+	select {
+	case v := <-got:
+		t.Fatal("handled before connection handler returned", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	feedEvent(t, h, "ev", "d")
+	for _, want := range []string{"connect", "a", "b", "c", "d"} {
+		select {
+		case v := <-got:
+			if v != want {
+				t.Fatalf("got %s, want %s", v, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("not handled", want)
+		}
+	}
+}
+
+func TestLoopEventBufferedDroppedOnClose(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventMode(OnConnection, LoopEventBuffered)
+
+	release := make(chan struct{})
+	connected := make(chan struct{})
+	got := make(chan string, 1)
+	s.On(OnConnection, func(c *Channel) {
+		<-release
+		close(connected)
+	})
+	s.On("ev", func(c *Channel, v string) { got <- v })
+
+	h := NewLoopHarness(s)
+	feedEvent(t, h, "ev", "a")
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	close(release)
+	<-connected
+
+	select {
+	case v := <-got:
+		t.Fatal("event of closed channel handled", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestLoopEventBufferedConnectionOnly(t *testing.T) {
+	s := newTestServer()
+
+	if err := s.SetLoopEventMode(OnDisconnection, LoopEventBuffered); err != ErrorLoopEventMode {
+		t.Fatal(err)
+	}
+}
+
+func TestLoopEventQueueRunsOffLoop(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventQueue(4, 1)
+
+	release := make(chan struct{})
+	events := make(chan string, 2)
+	s.On(OnConnection, func(c *Channel) {
+		<-release
+		events <- OnConnection
+	})
+	s.On(OnDisconnection, func(c *Channel) { events <- OnDisconnection })
+
+	//connection handler is still running on the worker
+	h := newOpenHarness(s)
+	if err := h.Feed("1"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	for _, want := range []string{OnConnection, OnDisconnection} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("loop event not handled", want)
+		}
+	}
+}
+
+func TestLoopEventQueueDropsInformational(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	s.SetLoopEventQueue(1, 1)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	s.On(OnConnection, func(c *Channel) {
+		close(started)
+		<-release
+	})
+	lost := make(chan struct{}, 2)
+	s.On(OnStreamLost, func(c *Channel) { lost <- struct{}{} })
+	h := newOpenHarness(s)
+	<-started
+
+	//worker is busy, the shard holds one event
+	s.loopEvents.push(h.Channel, OnStreamLost)
+	s.loopEvents.push(h.Channel, OnStreamLost)
+	if s.LoopEventsDropped() != 1 || metrics.get(MetricLoopEventsDropped, "event", OnStreamLost) != 1 {
+		t.Fatal("dropped", s.LoopEventsDropped())
+	}
+	if depth := metrics.gauge(MetricLoopEventQueueDepth); depth != 1 {
+		t.Fatal("depth", depth)
+	}
+
+	close(release)
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued event not handled")
+	}
+	select {
+	case <-lost:
+		t.Fatal("dropped event handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestLoopEventQueueCloseFromHandler(t *testing.T) {
+	s := newTestServer()
+	s.SetLoopEventQueue(1, 1)
+
+	events := make(chan string, 3)
+	s.On(OnConnection, func(c *Channel) {
+		events <- OnConnection
+		//fill the shard, disconnection is pushed by the worker itself
+		s.loopEvents.push(c, OnStreamLost)
+		c.Close()
+	})
+	s.On(OnStreamLost, func(c *Channel) { events <- OnStreamLost })
+	s.On(OnDisconnection, func(c *Channel) { events <- OnDisconnection })
+
+	newOpenHarness(s)
+	for _, want := range []string{OnConnection, OnStreamLost, OnDisconnection} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got %s, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("loop event not handled", want)
+		}
+	}
+	if s.LoopEventsDropped() != 0 {
+		t.Fatal("dropped", s.LoopEventsDropped())
+	}
+}
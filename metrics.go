@@ -0,0 +1,67 @@
+package gophersocket
+
+const (
+	/**
+	Time message spent in out queue before being written, in seconds
+	*/
+	MetricQueueResidency = "queue_residency_seconds"
+
+	/**
+	Packets received, labeled by kind: control for engine.io frames
+	like ping and pong, application for events and acks
+	*/
+	MetricPacketsReceived = "packets_received_total"
+)
+
+/**
+Receiver of library metrics, labels are given as key, value pairs
+*/
+type Metrics interface {
+	/**
+	Add delta to counter with given name
+	*/
+	Add(name string, delta float64, labels ...string)
+
+	/**
+	Set current value of gauge with given name
+	*/
+	Set(name string, value float64, labels ...string)
+
+	/**
+	Record one observation of histogram with given name
+	*/
+	Observe(name string, value float64, labels ...string)
+}
+
+/**
+Set receiver of metrics, nil disables metrics
+*/
+func (m *methods) SetMetrics(metrics Metrics) {
+	storeHeld(&m.metrics, metrics)
+}
+
+/**
+Get current receiver of metrics, if any
+*/
+func (m *methods) getMetrics() Metrics {
+	metrics, _ := loadHeld(&m.metrics).(Metrics)
+	return metrics
+}
+
+func (m *methods) metricAdd(name string, delta float64, labels ...string) {
+	if metrics := m.getMetrics(); metrics != nil {
+		metrics.Add(name, delta, labels...)
+	}
+}
+
+func (m *methods) metricSet(name string, value float64, labels ...string) {
+	if metrics := m.getMetrics(); metrics != nil {
+		metrics.Set(name, value, labels...)
+	}
+}
+
+func (m *methods) metricObserve(name string, value float64, labels ...string) {
+	if metrics := m.getMetrics(); metrics != nil {
+		metrics.Observe(name, value, labels...)
+	}
+}
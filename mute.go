@@ -0,0 +1,86 @@
+package gophersocket
+
+import (
+	"errors"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+var (
+	ErrorEventMuted = errors.New("Event muted")
+)
+
+type mutedEvents map[string]struct{}
+
+/**
+Stop sending event to this channel, others still flow. Emits and
+broadcasts of the event skip the channel silently, ack requests and
+EmitCallback fail with ErrorEventMuted. Raw frames are not checked
+*/
+func (c *Channel) Mute(event string) {
+	c.mutedLock.Lock()
+	defer c.mutedLock.Unlock()
+
+	old := c.getMuted()
+	muted := make(mutedEvents, len(old)+1)
+	for name := range old {
+		muted[name] = struct{}{}
+	}
+	muted[event] = struct{}{}
+
+	c.muted.Store(muted)
+}
+
+/**
+Send event to this channel again
+*/
+func (c *Channel) Unmute(event string) {
+	c.mutedLock.Lock()
+	defer c.mutedLock.Unlock()
+
+	old := c.getMuted()
+	if _, ok := old[event]; !ok {
+		return
+	}
+	muted := make(mutedEvents, len(old))
+	for name := range old {
+		if name != event {
+			muted[name] = struct{}{}
+		}
+	}
+
+	c.muted.Store(muted)
+}
+
+/**
+Check that event is muted for this channel
+*/
+func (c *Channel) Muted(event string) bool {
+	muted := c.getMuted()
+	if len(muted) == 0 {
+		return false
+	}
+
+	_, ok := muted[event]
+	return ok
+}
+
+func (c *Channel) getMuted() mutedEvents {
+	muted, _ := c.muted.Load().(mutedEvents)
+	return muted
+}
+
+/**
+Check that message should not be sent as its event is muted,
+with error to return to the sender
+*/
+func (c *Channel) skipMuted(msg *protocol.Message) (bool, error) {
+	if msg.Method == "" || !c.Muted(msg.Method) {
+		return false, nil
+	}
+	if msg.Type == protocol.MessageTypeAckRequest {
+		return true, ErrorEventMuted
+	}
+
+	return true, nil
+}
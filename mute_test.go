@@ -0,0 +1,67 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMuteSkipsEvent(t *testing.T) {
+	s := newTestServer()
+	muted, other := newOpenHarness(s), newOpenHarness(s)
+	muted.Channel.Join("room")
+	other.Channel.Join("room")
+	muted.Channel.Mute("chat")
+
+	muted.Channel.Emit("chat", "a")
+	muted.Channel.Emit("news", "b")
+	s.BroadcastTo("room", "chat", "c")
+	expectFrames(t, muted, `42["news","b"]`)
+	expectFrames(t, other, `42["chat","c"]`)
+
+	muted.Channel.Unmute("chat")
+	muted.Channel.Emit("chat", "d")
+	expectFrames(t, muted, `42["chat","d"]`)
+}
+
+func TestMuteFailsAckAndCallback(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.Mute("chat")
+
+	if _, err := h.Channel.Ack("chat", "a", time.Second); err != ErrorEventMuted {
+		t.Fatal("ack", err)
+	}
+
+	var cbErr error
+	called := 0
+	h.Channel.EmitCallback("chat", []interface{}{"a"}, func(err error) {
+		called++
+		cbErr = err
+	})
+	if called != 1 || cbErr != ErrorEventMuted {
+		t.Fatal("callback", called, cbErr)
+	}
+	expectFrames(t, h)
+}
+
+func TestMuteConcurrent(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				h.Channel.Mute("chat")
+				h.Channel.Muted("chat")
+				h.Channel.Unmute("chat")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if h.Channel.Muted("chat") {
+		t.Fatal("muted after all unmuted")
+	}
+}
@@ -0,0 +1,346 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+var (
+	ErrorNamespaceNotConnected = errors.New("Channel is not connected to the namespace")
+	ErrorInvalidRoomName       = errors.New("Room name must not contain NUL character")
+	ErrorNamespaceLoopEvent    = errors.New("Loop events are handled by handlers of the server")
+)
+
+/**
+What the server does when client connects to a namespace other than
+the root one, which was not created with Server.Of
+*/
+type NamespacePolicy int
+
+const (
+	/**
+	Answer with connect error, packets of the namespace are dropped
+	*/
+	NamespaceReject NamespacePolicy = iota
+
+	/**
+	Accept the namespace, its events are handled by the root handlers
+	and acks are answered within the namespace. Rooms of the namespace
+	are distinct from root ones of the same name, see Server.Of
+	*/
+	NamespaceAutoCreate
+
+	/**
+	Do not answer the connect, packets of the namespace are dropped
+	*/
+	NamespaceIgnore
+)
+
+const (
+	invalidNamespaceMessage = "Invalid namespace"
+
+	//separates namespace and room name in keys of room registry
+	roomKeySeparator = "\x00"
+)
+
+/**
+Set policy for unknown namespaces clients connect to, NamespaceReject
+by default. Namespaces created with Server.Of are always accepted
+*/
+func (s *Server) SetNamespacePolicy(policy NamespacePolicy) {
+	s.namespacePolicy.Store(policy)
+}
+
+func isRootNamespace(nsp string) bool {
+	return nsp == "" || nsp == "/"
+}
+
+/**
+Process connect of the channel to non root namespace, accepting it
+if it was created with Of, following the policy otherwise
+*/
+func (s *Server) connectNamespace(c *Channel, nsp string) {
+	policy, _ := s.namespacePolicy.Load().(NamespacePolicy)
+	if _, ok := s.namespaces.Load(nsp); ok {
+		policy = NamespaceAutoCreate
+	}
+	switch policy {
+	case NamespaceAutoCreate:
+		c.namespacesLock.Lock()
+		if c.namespaces == nil {
+			c.namespaces = make(map[string]struct{})
+		}
+		c.namespaces[nsp] = struct{}{}
+		c.namespacesLock.Unlock()
+
+		send(protocol.NewConnect(nsp, nil), c, nil)
+	case NamespaceReject:
+		payload, _ := json.Marshal(s.systemMessage(c, SystemMessageNamespaceRejected, map[string]interface{}{
+			"namespace": nsp,
+			"message":   invalidNamespaceMessage,
+		}, &ConnectError{Message: invalidNamespaceMessage}))
+		send(protocol.NewConnectError(nsp, payload), c, nil)
+	}
+}
+
+/**
+Check that packets of the namespace are accepted from the channel
+*/
+func (c *Channel) inNamespace(nsp string) bool {
+	if isRootNamespace(nsp) || c.server == nil {
+		return true
+	}
+
+	c.namespacesLock.Lock()
+	defer c.namespacesLock.Unlock()
+
+	_, ok := c.namespaces[nsp]
+	return ok
+}
+
+/**
+Disconnect the channel from non root namespace, it stays connected
+but leaves rooms of the namespace
+*/
+func (c *Channel) leaveNamespace(nsp string) {
+	c.namespacesLock.Lock()
+	delete(c.namespaces, nsp)
+	c.namespacesLock.Unlock()
+
+	for _, room := range c.server.roomsOf(c, nsp) {
+		c.server.leaveRoom(c, nsp, room)
+	}
+}
+
+/**
+Rooms of one namespace, a room of the same name in another
+namespace is a different room
+*/
+type Namespace struct {
+	server *Server
+	name   string
+}
+
+/**
+Get namespace with given name, "/" or empty is the root one,
+the rooms of which are also used by Channel.Join and BroadcastTo.
+The namespace is created on first call, clients may connect to it
+whatever the NamespacePolicy is
+*/
+func (s *Server) Of(nsp string) *Namespace {
+	if isRootNamespace(nsp) {
+		nsp = "/"
+	} else {
+		s.namespaces.Store(nsp, struct{}{})
+	}
+
+	return &Namespace{server: s, name: nsp}
+}
+
+/**
+Get name of the namespace
+*/
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+/**
+Bind handler to given event of the namespace only, as Server.On does,
+clients connecting to the namespace are accepted whatever the policy.
+Handlers bound with Server.On serve events of all connected namespaces,
+the ones of the namespace run along with them, in order of registration.
+Loop events are raised once per channel, not per namespace, so
+ErrorNamespaceLoopEvent is returned for them
+*/
+func (n *Namespace) On(method string, f interface{}) error {
+	if method == OnConnection || method == OnDisconnection || method == OnStreamLost {
+		return ErrorNamespaceLoopEvent
+	}
+
+	c, err := newCaller(method, f)
+	if err != nil {
+		return err
+	}
+	c.Namespace = n.name
+
+	if !isRootNamespace(n.name) {
+		n.server.namespaces.Store(n.name, struct{}{})
+	}
+	n.server.addCaller(method, c)
+	return nil
+}
+
+/**
+Join the channel to given room of the namespace, as Channel.Join does.
+ErrorNamespaceNotConnected is returned if the channel is not
+connected to the namespace, ErrorInvalidRoomName if the name
+contains NUL character
+*/
+func (n *Namespace) Join(c *Channel, room string) error {
+	if !c.inNamespace(n.name) {
+		return ErrorNamespaceNotConnected
+	}
+
+	return n.server.joinRoom(c, n.name, room)
+}
+
+/**
+Remove the channel from given room of the namespace
+*/
+func (n *Namespace) Leave(c *Channel, room string) {
+	n.server.leaveRoom(c, n.name, room)
+}
+
+/**
+Get rooms of the namespace the channel is joined to, sorted
+*/
+func (n *Namespace) Rooms(c *Channel) []string {
+	return n.server.roomsOf(c, n.name)
+}
+
+/**
+Get amount of channels joined to given room of the namespace
+*/
+func (n *Namespace) Amount(room string) int {
+	return n.server.Amount(roomKey(n.name, room))
+}
+
+/**
+Get list of channels joined to given room of the namespace
+*/
+func (n *Namespace) List(room string) []*Channel {
+	return n.server.List(roomKey(n.name, room))
+}
+
+/**
+Broadcast message to all channels of given room of the namespace,
+they get it as an event of the namespace
+*/
+func (n *Namespace) BroadcastTo(room, method string, args interface{}) error {
+	return n.server.broadcast(n.name, room, method, []interface{}{args}, nil)
+}
+
+/**
+Enable presence tracking for given room of the namespace,
+see Server.EnablePresence. Members get presence events of the namespace
+*/
+func (n *Namespace) EnablePresence(room string) {
+	n.server.EnablePresence(roomKey(n.name, room))
+}
+
+/**
+Disable presence tracking for given room of the namespace
+*/
+func (n *Namespace) DisablePresence(room string) {
+	n.server.DisablePresence(roomKey(n.name, room))
+}
+
+/**
+Get members of given room of the namespace with presence enabled
+*/
+func (n *Namespace) Presence(room string) []PresenceEntry {
+	return n.server.Presence(roomKey(n.name, room))
+}
+
+/**
+Coalesce broadcasts to given room of the namespace,
+see Server.SetRoomCoalescing
+*/
+func (n *Namespace) SetRoomCoalescing(room string, interval time.Duration) {
+	n.server.SetRoomCoalescing(roomKey(n.name, room), interval)
+}
+
+/**
+Create room of the namespace with capacity, see Server.CreateRoom
+*/
+func (n *Namespace) CreateRoom(room string, capacity int) error {
+	return n.server.CreateRoom(roomKey(n.name, room), capacity)
+}
+
+/**
+Change capacity of room of the namespace created with CreateRoom
+*/
+func (n *Namespace) SetRoomCapacity(room string, capacity int) error {
+	return n.server.SetRoomCapacity(roomKey(n.name, room), capacity)
+}
+
+/**
+Remove room of the namespace created with CreateRoom
+*/
+func (n *Namespace) DeleteRoom(room string) error {
+	return n.server.DeleteRoom(roomKey(n.name, room))
+}
+
+/**
+Limit broadcasts to given room of the namespace, see Server.SetRoomLimit
+*/
+func (n *Namespace) SetRoomLimit(room string, perSecond int, burst int) {
+	n.server.SetRoomLimit(roomKey(n.name, room), perSecond, burst)
+}
+
+/**
+Set what to do with broadcasts exceeding limit of given room
+of the namespace
+*/
+func (n *Namespace) SetRoomLimitPolicy(room string, policy LimitPolicy, maxDelay time.Duration) {
+	n.server.SetRoomLimitPolicy(roomKey(n.name, room), policy, maxDelay)
+}
+
+/**
+Keep state of given room of the namespace in sync with its members,
+see Server.NewStateSync. State events are sent within the namespace
+*/
+func (n *Namespace) NewStateSync(room string, snapshot func() interface{}) (*StateSync, error) {
+	return n.server.NewStateSync(roomKey(n.name, room), snapshot)
+}
+
+/**
+Get key of room registry for the room of given namespace,
+rooms of the root namespace are keyed by their name
+*/
+func roomKey(nsp, room string) string {
+	if isRootNamespace(nsp) {
+		return room
+	}
+
+	return nsp + roomKeySeparator + room
+}
+
+/**
+Check that room name can not be taken for a key of other namespace
+*/
+func checkRoomName(room string) error {
+	if strings.Contains(room, roomKeySeparator) {
+		return ErrorInvalidRoomName
+	}
+
+	return nil
+}
+
+/**
+Get namespace and room name of key of room registry
+*/
+func splitRoomKey(key string) (string, string) {
+	if i := strings.Index(key, roomKeySeparator); i >= 0 {
+		return key[:i], key[i+len(roomKeySeparator):]
+	}
+
+	return "/", key
+}
+
+/**
+Get namespace of key of room registry as set in packets, empty
+for the root one, and name of the room within the namespace
+*/
+func packetRoom(key string) (string, string) {
+	nsp, room := splitRoomKey(key)
+	if isRootNamespace(nsp) {
+		nsp = ""
+	}
+
+	return nsp, room
+}
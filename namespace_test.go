@@ -0,0 +1,408 @@
+package gophersocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Open harness channel connected to given namespace too
+*/
+func namespaceHarness(t *testing.T, s *Server, nsp string) *LoopHarness {
+	t.Helper()
+
+	h := newOpenHarness(s)
+	if err := h.Feed("40" + nsp + ","); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, "40"+nsp+",")
+	return h
+}
+
+func TestNamespaceRoomsIsolated(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	chat, game := namespaceHarness(t, s, "/chat"), namespaceHarness(t, s, "/game")
+
+	if err := s.Of("/chat").Join(chat.Channel, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Of("/game").Join(game.Channel, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Of("/chat").Amount("lobby") != 1 || s.Amount("lobby") != 0 {
+		t.Fatal("room of namespace not distinct")
+	}
+
+	if err := s.Of("/chat").BroadcastTo("lobby", "msg", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, chat, `42/chat,["msg","hi"]`)
+	expectFrames(t, game)
+
+	if err := s.Of("/game").BroadcastTo("lobby", "msg", "go"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, chat)
+	expectFrames(t, game, `42/game,["msg","go"]`)
+
+	//root room of the same name is another one
+	if err := s.BroadcastTo("lobby", "msg", "root"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, chat)
+	expectFrames(t, game)
+
+	if rooms := chat.Channel.Rooms(); len(rooms) != 0 {
+		t.Fatal("root rooms", rooms)
+	}
+	if rooms := s.Of("/chat").Rooms(chat.Channel); len(rooms) != 1 || rooms[0] != "lobby" {
+		t.Fatal("namespace rooms", rooms)
+	}
+}
+
+func TestNamespaceRootRoomsOfMultiplexedClient(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	h := namespaceHarness(t, s, "/chat")
+
+	if err := h.Channel.Join("lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Of("/").Join(h.Channel, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.BroadcastTo("lobby", "msg", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Of("/").BroadcastTo("other", "msg", "there"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["msg","hi"]`, `42["msg","there"]`)
+}
+
+func TestNamespaceJoinNotConnected(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	h := newOpenHarness(s)
+
+	if err := s.Of("/chat").Join(h.Channel, "lobby"); err != ErrorNamespaceNotConnected {
+		t.Fatal(err)
+	}
+}
+
+func TestNamespaceRoomNameWithSeparator(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	chat := namespaceHarness(t, s, "/chat")
+	if err := s.Of("/chat").Join(chat.Channel, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+
+	//root room can not be named as the key of a room of other namespace
+	key := "/chat\x00lobby"
+	if err := chat.Channel.Join(key); err != ErrorInvalidRoomName {
+		t.Fatal(err)
+	}
+	if err := s.Of("/chat").Join(chat.Channel, "a\x00b"); err != ErrorInvalidRoomName {
+		t.Fatal(err)
+	}
+	if err := s.BroadcastTo(key, "msg", "leak"); err != ErrorInvalidRoomName {
+		t.Fatal(err)
+	}
+	expectFrames(t, chat)
+	if rooms := chat.Channel.Rooms(); len(rooms) != 0 {
+		t.Fatal("root rooms", rooms)
+	}
+}
+
+func TestNamespaceDisconnectLeavesItsRooms(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	var left []string
+	s.OnLeave(func(c *Channel, room string) { left = append(left, room) })
+	h := namespaceHarness(t, s, "/chat")
+
+	h.Channel.Join("lobby")
+	s.Of("/chat").Join(h.Channel, "lobby")
+	if err := h.Feed("41/chat,"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Of("/chat").Amount("lobby") != 0 || s.Amount("lobby") != 1 {
+		t.Fatal("rooms after namespace disconnect")
+	}
+	if len(left) != 1 || left[0] != "lobby" {
+		t.Fatal("left", left)
+	}
+}
+
+func TestNamespaceCreatedWithOfAccepted(t *testing.T) {
+	//default policy rejects unknown namespaces only
+	s := newTestServer()
+	chat := s.Of("/chat")
+	h := namespaceHarness(t, s, "/chat")
+
+	if err := chat.Join(h.Channel, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.BroadcastTo("lobby", "msg", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42/chat,["msg","hi"]`)
+
+	if err := h.Feed("40/typo,"); err != nil {
+		t.Fatal(err)
+	}
+	h.Pump()
+	if frames := h.Frames(); len(frames) != 1 || !strings.HasPrefix(frames[0], "44/typo,") {
+		t.Fatalf("got frames %q, want connect error", frames)
+	}
+}
+
+func TestNamespaceRejectedByDefault(t *testing.T) {
+	s := newTestServer()
+	got := make(chan string, 1)
+	s.On("ev", func(c *Channel, v string) { got <- v })
+	h := newOpenHarness(s)
+
+	if err := h.Feed("40/typo,"); err != nil {
+		t.Fatal(err)
+	}
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 1 || !strings.HasPrefix(frames[0], "44/typo,") ||
+		!strings.Contains(frames[0], invalidNamespaceMessage) {
+
+		t.Fatalf("got frames %q, want connect error", frames)
+	}
+
+	feedEvent(t, h, "ev", "root")
+	if err := h.Feed(`42/typo,["ev","typo"]`); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; v != "root" {
+		t.Fatal("got", v)
+	}
+	select {
+	case v := <-got:
+		t.Fatal("event of rejected namespace handled", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if !h.Channel.IsAlive() {
+		t.Fatal("channel closed by rejected namespace")
+	}
+}
+
+func TestNamespaceAutoCreate(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	s.On("ev", func(c *Channel, v string) string { return "re:" + v })
+	h := namespaceHarness(t, s, "/chat")
+
+	//handled by root handler, answered within the namespace
+	if err := h.Feed(`42/chat,5["ev","a"]`); err != nil {
+		t.Fatal(err)
+	}
+	waitFrame(t, h, `43/chat,5["re:a"]`)
+}
+
+func TestNamespaceIgnored(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceIgnore)
+	got := make(chan string, 1)
+	s.On("ev", func(c *Channel, v string) { got <- v })
+	h := newOpenHarness(s)
+
+	if err := h.Feed("40/chat,"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(`42/chat,["ev","a"]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h)
+	select {
+	case v := <-got:
+		t.Fatal("event of ignored namespace handled", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestNamespaceRoomSettings(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	chat := s.Of("/chat")
+
+	if err := chat.CreateRoom("lobby", 1); err != nil {
+		t.Fatal(err)
+	}
+	first, second := namespaceHarness(t, s, "/chat"), namespaceHarness(t, s, "/chat")
+	if err := chat.Join(first.Channel, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.Join(second.Channel, "lobby"); err != ErrorRoomFull {
+		t.Fatal("capacity of namespace room", err)
+	}
+	//root room of the same name has no capacity
+	first.Channel.Join("lobby")
+	second.Channel.Join("lobby")
+	if s.Amount("lobby") != 2 {
+		t.Fatal("root room limited by namespace room")
+	}
+
+	chat.SetRoomLimit("lobby", 1, 1)
+	if err := chat.BroadcastTo("lobby", "msg", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.BroadcastTo("lobby", "msg", 2); err != ErrorRoomLimitExceeded {
+		t.Fatal("limit of namespace room", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.BroadcastTo("lobby", "msg", i); err != nil {
+			t.Fatal("root room limited by namespace room", err)
+		}
+	}
+
+	var left []string
+	s.OnLeave(func(c *Channel, room string) { left = append(left, room) })
+	if err := chat.DeleteRoom("lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0] != "lobby" || chat.Amount("lobby") != 0 || s.Amount("lobby") != 2 {
+		t.Fatal("delete of namespace room", left)
+	}
+}
+
+func TestNamespacePresence(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	chat := s.Of("/chat")
+	chat.EnablePresence("lobby")
+
+	first := namespaceHarness(t, s, "/chat")
+	first.Channel.Join("lobby")
+	chat.Join(first.Channel, "lobby")
+	second := namespaceHarness(t, s, "/chat")
+	second.Channel.Join("lobby")
+	expectFrames(t, first)
+
+	chat.Join(second.Channel, "lobby")
+	expectFrames(t, first, `42/chat,["presence:join",{"sid":"`+second.Channel.Id()+`"}]`)
+	if entries := chat.Presence("lobby"); len(entries) != 2 {
+		t.Fatal("presence", entries)
+	}
+	if entries := s.Presence("lobby"); len(entries) != 0 {
+		t.Fatal("presence of root room", entries)
+	}
+
+	chat.DisablePresence("lobby")
+	chat.Leave(second.Channel, "lobby")
+	expectFrames(t, first)
+}
+
+func TestNamespaceStateSync(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	counter := &syncedCounter{}
+	st, err := s.Of("/chat").NewStateSync("doc", counter.snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := namespaceHarness(t, s, "/chat")
+	h.Channel.Join("doc")
+	expectFrames(t, h)
+
+	s.Of("/chat").Join(h.Channel, "doc")
+	expectFrames(t, h, `42/chat,["__state_snapshot",{"room":"doc","version":0,"state":{"n":0}}]`)
+	counter.add(st, 2)
+	expectFrames(t, h, `42/chat,["__state_patch",{"room":"doc","version":1,"patch":{"add":2}}]`)
+
+	if err := h.Feed(`42/chat,["__state_resync",{"room":"doc"}]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42/chat,["__state_snapshot",{"room":"doc","version":1,"state":{"n":2}}]`)
+	//root room of the same name is not synced
+	if err := h.Feed(`42["__state_resync",{"room":"doc"}]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h)
+}
+
+func TestNamespaceCoalescingAndDedupe(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	s.SetBroadcastDedupe("msg", 0)
+	s.Of("/chat").SetRoomCoalescing("lobby", time.Hour)
+	chat, game := namespaceHarness(t, s, "/chat"), namespaceHarness(t, s, "/game")
+	s.Of("/chat").Join(chat.Channel, "lobby")
+	s.Of("/game").Join(game.Channel, "lobby")
+
+	//same payload to rooms of the same name is not a repeat
+	s.Of("/chat").BroadcastTo("lobby", "msg", "hi")
+	s.Of("/game").BroadcastTo("lobby", "msg", "hi")
+	s.Of("/game").BroadcastTo("lobby", "msg", "hi")
+	expectFrames(t, chat, `42/chat,["msg","hi"]`)
+	expectFrames(t, game, `42/game,["msg","hi"]`)
+
+	//coalescing of one namespace room holds its next broadcast only
+	s.Of("/chat").BroadcastTo("lobby", "msg", "again")
+	s.Of("/game").BroadcastTo("lobby", "msg", "again")
+	expectFrames(t, chat)
+	expectFrames(t, game, `42/game,["msg","again"]`)
+	if s.BroadcastsDeduped() != 1 {
+		t.Fatal("deduped", s.BroadcastsDeduped())
+	}
+}
+
+func TestNamespaceHandlers(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	var got []string
+	s.On("msg", func(c *Channel, v string) { got = append(got, "all:"+v) })
+	if err := s.Of("/chat").On("msg", func(c *Channel, v string) string {
+		got = append(got, "chat:"+v)
+		return "ok"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Of("/chat").On(OnConnection, func(c *Channel) {}); err != ErrorNamespaceLoopEvent {
+		t.Fatal(err)
+	}
+	chat, game := namespaceHarness(t, s, "/chat"), namespaceHarness(t, s, "/game")
+
+	for _, frame := range []string{`42/chat,1["msg","a"]`, `42["msg","b"]`} {
+		if err := chat.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := game.Feed(`42/game,["msg","c"]`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, chat, `43/chat,1["ok"]`)
+	if strings.Join(got, " ") != "all:a chat:a all:b all:c" {
+		t.Fatal(got)
+	}
+}
+
+func TestNamespaceHandlersDefaultPolicy(t *testing.T) {
+	s := newTestServer()
+	got := make(chan string, 1)
+	if err := s.Of("/chat").On("msg", func(c *Channel, v string) { got <- v }); err != nil {
+		t.Fatal(err)
+	}
+	h := namespaceHarness(t, s, "/chat")
+
+	if err := h.Feed(`42/chat,["msg","hi"]`); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-got:
+		if v != "hi" {
+			t.Fatal("got", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event of the namespace not handled")
+	}
+}
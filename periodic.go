@@ -0,0 +1,232 @@
+package gophersocket
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	wheelTick  = 10 * time.Millisecond
+	wheelSlots = 512
+)
+
+/**
+Schedule options of periodic emit
+*/
+type PeriodicOptions struct {
+	/**
+	First emit is delayed by random duration up to Jitter, so emitters
+	created together do not write at the same moment. The offset is kept
+	for following emits
+	*/
+	Jitter time.Duration
+
+	/**
+	Emit at wall clock multiples of interval, e.g. at whole seconds,
+	plus jitter offset if any
+	*/
+	Align bool
+}
+
+/**
+Periodic emit scheduled by EmitEvery
+*/
+type PeriodicEmit struct {
+	channel  *Channel
+	event    string
+	interval time.Duration
+	fn       func() (interface{}, bool)
+
+	due     time.Time
+	rounds  int
+	stopped int32
+}
+
+/**
+Cancel periodic emit, it is cancelled automatically on disconnection
+*/
+func (p *PeriodicEmit) Stop() {
+	atomic.StoreInt32(&p.stopped, 1)
+}
+
+func (p *PeriodicEmit) active() bool {
+	return atomic.LoadInt32(&p.stopped) == 0 && p.channel.IsAlive()
+}
+
+/**
+Call fn every interval and emit its result to the channel, false
+returned skips the emit. Schedule follows the monotonic clock, so it
+does not drift, missed ticks are skipped. All periodic emits of the server
+share one timer of its clock, so fn should return fast. Panic in fn
+is recovered and skips the emit
+*/
+func (c *Channel) EmitEvery(event string, interval time.Duration, fn func() (interface{}, bool)) *PeriodicEmit {
+	return c.EmitEveryWithOptions(event, interval, PeriodicOptions{}, fn)
+}
+
+/**
+Same as EmitEvery, with jitter and alignment of the schedule
+*/
+func (c *Channel) EmitEveryWithOptions(event string, interval time.Duration, opts PeriodicOptions,
+	fn func() (interface{}, bool)) *PeriodicEmit {
+
+	if interval < wheelTick {
+		interval = wheelTick
+	}
+
+	now := c.clock().Now()
+	due := now.Add(interval)
+	if opts.Align {
+		//keep monotonic reading of now, so only the offset comes from wall clock
+		due = now.Add(now.Truncate(interval).Add(interval).Sub(now.Round(0)))
+	}
+	if opts.Jitter > 0 {
+		due = due.Add(time.Duration(rand.Int63n(int64(opts.Jitter))))
+	}
+
+	p := &PeriodicEmit{
+		channel:  c,
+		event:    event,
+		interval: interval,
+		fn:       fn,
+		due:      due,
+	}
+	c.emitWheel().add(p)
+
+	return p
+}
+
+/**
+Get timer wheel of the channel, the one of its server
+*/
+func (c *Channel) emitWheel() *timerWheel {
+	if c.shared == nil {
+		return emitWheel
+	}
+
+	m := c.shared
+	m.emitWheelOnce.Do(func() {
+		m.emitWheel = &timerWheel{clock: m.getClock}
+	})
+	return m.emitWheel
+}
+
+/**
+Call fn of the emit and emit its result, recovering from panic
+in either, so the rest of due emits still run
+*/
+func (p *PeriodicEmit) fire() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("socket.io periodic emit panic: ", r)
+		}
+	}()
+
+	if v, ok := p.fn(); ok {
+		p.channel.Emit(p.event, v)
+	}
+}
+
+/**
+Hashed timer wheel running periodic emits, its goroutine runs
+only while there are emits scheduled
+*/
+type timerWheel struct {
+	clock   func() Clock
+	slots   [wheelSlots][]*PeriodicEmit
+	current int
+	count   int
+	running bool
+	lock    sync.Mutex
+}
+
+//wheel of channels having no server or client
+var emitWheel = &timerWheel{clock: func() Clock { return realClock{} }}
+
+func (w *timerWheel) add(p *PeriodicEmit) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.insert(p, w.clock().Now())
+	w.count++
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+/**
+Put emit to the slot of its due time, should be called under lock
+*/
+func (w *timerWheel) insert(p *PeriodicEmit, now time.Time) {
+	ticks := int((p.due.Sub(now) + wheelTick - 1) / wheelTick)
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	p.rounds = (ticks - 1) / wheelSlots
+	slot := (w.current + ticks) % wheelSlots
+	w.slots[slot] = append(w.slots[slot], p)
+}
+
+func (w *timerWheel) run() {
+	ticker := w.clock().NewTicker(wheelTick)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		if !w.tick() {
+			return
+		}
+	}
+}
+
+/**
+Fire emits of the next slot and schedule them again,
+returns false when nothing is scheduled anymore
+*/
+func (w *timerWheel) tick() bool {
+	w.lock.Lock()
+	w.current = (w.current + 1) % wheelSlots
+	slot := w.slots[w.current]
+	w.slots[w.current] = nil
+
+	var due []*PeriodicEmit
+	for _, p := range slot {
+		switch {
+		case !p.active():
+			w.count--
+		case p.rounds > 0:
+			p.rounds--
+			w.slots[w.current] = append(w.slots[w.current], p)
+		default:
+			due = append(due, p)
+		}
+	}
+	w.lock.Unlock()
+
+	for _, p := range due {
+		p.fire()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	now := w.clock().Now()
+	for _, p := range due {
+		p.due = p.due.Add(p.interval)
+		if late := now.Sub(p.due); late >= 0 {
+			p.due = p.due.Add((late/p.interval + 1) * p.interval)
+		}
+		w.insert(p, now)
+	}
+
+	if w.count == 0 {
+		w.running = false
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,145 @@
+package gophersocket
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/**
+Wait until the harness wrote at least n frames, returns them all
+*/
+func pumpFrames(t testing.TB, h *LoopHarness, n int) []string {
+	t.Helper()
+
+	var frames []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(frames) < n && time.Now().Before(deadline) {
+		h.Pump()
+		frames = append(frames, h.Frames()...)
+	}
+	if len(frames) < n {
+		t.Fatalf("got frames %q, want at least %d", frames, n)
+	}
+	return frames
+}
+
+func TestEmitEvery(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var calls int32
+	p := h.Channel.EmitEvery("tick", 20*time.Millisecond, func() (interface{}, bool) {
+		n := atomic.AddInt32(&calls, 1)
+		//every second call is skipped
+		return n, n%2 == 1
+	})
+	defer p.Stop()
+
+	frames := pumpFrames(t, h, 2)
+	if frames[0] != `42["tick",1]` || frames[1] != `42["tick",3]` {
+		t.Fatal("frames", frames)
+	}
+}
+
+func TestEmitEveryStop(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var calls int32
+	p := h.Channel.EmitEvery("tick", 20*time.Millisecond, func() (interface{}, bool) {
+		return atomic.AddInt32(&calls, 1), true
+	})
+	pumpFrames(t, h, 1)
+	p.Stop()
+
+	stopped := atomic.LoadInt32(&calls)
+	time.Sleep(100 * time.Millisecond)
+	//a tick running while Stop was called may still finish
+	if n := atomic.LoadInt32(&calls); n > stopped+1 {
+		t.Fatalf("called %d times after stop", n-stopped)
+	}
+}
+
+func TestEmitEveryStopsOnDisconnection(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var calls int32
+	h.Channel.EmitEvery("tick", 20*time.Millisecond, func() (interface{}, bool) {
+		return atomic.AddInt32(&calls, 1), true
+	})
+	pumpFrames(t, h, 1)
+	h.Channel.Close()
+
+	closed := atomic.LoadInt32(&calls)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n > closed+1 {
+		t.Fatalf("called %d times after disconnection", n-closed)
+	}
+}
+
+func TestEmitEveryAligned(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	fired := make(chan time.Time, 1)
+	p := h.Channel.EmitEveryWithOptions("tick", 100*time.Millisecond, PeriodicOptions{Align: true},
+		func() (interface{}, bool) {
+			select {
+			case fired <- time.Now():
+			default:
+			}
+			return nil, false
+		})
+	defer p.Stop()
+
+	select {
+	case at := <-fired:
+		//the wheel fires within a tick after the due time
+		if offset := at.Sub(at.Truncate(100 * time.Millisecond)); offset > 5*wheelTick {
+			t.Fatal("not aligned, offset", offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not fired")
+	}
+}
+
+func TestEmitEveryFollowsClock(t *testing.T) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	h := newOpenHarness(s)
+	p := h.Channel.EmitEvery("tick", 5*wheelTick, func() (interface{}, bool) {
+		return "now", true
+	})
+	defer p.Stop()
+
+	//real time passing does not move the schedule
+	time.Sleep(10 * wheelTick)
+	expectFrames(t, h)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		clock.waitTimer(t, wheelTick)
+		clock.Advance(wheelTick)
+		time.Sleep(time.Millisecond)
+		h.Pump()
+		if frames := h.Frames(); len(frames) > 0 {
+			if frames[0] != `42["tick","now"]` {
+				t.Fatal("frames", frames)
+			}
+			return
+		}
+	}
+	t.Fatal("not emitted on clock ticks")
+}
+
+func TestEmitEveryRecoversPanic(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var calls int32
+	p := h.Channel.EmitEvery("tick", 20*time.Millisecond, func() (interface{}, bool) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("fn failed")
+		}
+		return "ok", true
+	})
+	defer p.Stop()
+
+	frames := pumpFrames(t, h, 1)
+	if frames[0] != `42["tick","ok"]` {
+		t.Fatal("frames", frames)
+	}
+}
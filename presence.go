@@ -0,0 +1,188 @@
+package gophersocket
+
+import (
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	OnPresenceJoin  = "presence:join"
+	OnPresenceLeave = "presence:leave"
+)
+
+/**
+Member of the room with presence enabled, sent with presence events
+*/
+type PresenceEntry struct {
+	Sid  string      `json:"sid"`
+	Meta interface{} `json:"meta,omitempty"`
+}
+
+/**
+Leave of the member, which is not announced yet due to debounce,
+kept by SessionId of the channel, so the client joining again on
+a new connection cancels it
+*/
+type pendingLeave struct {
+	entry PresenceEntry
+	timer Timer
+}
+
+/**
+Set user metadata, sent along with the sid in presence events
+*/
+func (c *Channel) SetPresenceMeta(v interface{}) {
+	c.metaLock.Lock()
+	defer c.metaLock.Unlock()
+
+	c.presenceMeta = v
+}
+
+func (c *Channel) presenceEntry() PresenceEntry {
+	c.metaLock.RLock()
+	defer c.metaLock.RUnlock()
+
+	return PresenceEntry{Sid: c.Id(), Meta: c.presenceMeta}
+}
+
+/**
+Enable presence tracking for given room, members get presence:join
+and presence:leave events on membership changes
+*/
+func (s *Server) EnablePresence(room string) {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	s.presenceRooms[room] = struct{}{}
+}
+
+/**
+Disable presence tracking for given room, pending leaves are dropped
+*/
+func (s *Server) DisablePresence(room string) {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	delete(s.presenceRooms, room)
+	for session, pending := range s.presencePending[room] {
+		pending.timer.Stop()
+		delete(s.presencePending[room], session)
+	}
+	delete(s.presencePending, room)
+}
+
+/**
+Set time to wait before announcing leave, if the member joins again
+during this time, neither leave nor join is announced. The member is
+the same if SessionId is, e.g. a client which reconnected and resumed
+its session. Zero disables it
+*/
+func (s *Server) SetPresenceDebounce(d time.Duration) {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	s.presenceDebounce = d
+}
+
+/**
+Get members of given room with presence enabled, including the ones
+whose leave is not announced yet
+*/
+func (s *Server) Presence(room string) []PresenceEntry {
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	if _, ok := s.presenceRooms[room]; !ok {
+		return []PresenceEntry{}
+	}
+
+	entries := make([]PresenceEntry, 0, len(s.channels[room])+len(s.presencePending[room]))
+	for c := range s.channels[room] {
+		entries = append(entries, c.presenceEntry())
+	}
+	for _, pending := range s.presencePending[room] {
+		entries = append(entries, pending.entry)
+	}
+
+	return entries
+}
+
+/**
+Announce given event to all room members except the affected one,
+should be called under channelsLock, so events are ordered
+the same way as membership changes
+*/
+func (s *Server) announcePresence(room, event string, c *Channel, entry PresenceEntry) {
+	nsp, _ := packetRoom(room)
+	for cn := range s.channels[room] {
+		if cn != c && cn.IsAlive() {
+			send(protocol.NewEvent(nsp, event, nil), cn, entry)
+		}
+	}
+}
+
+/**
+Announce join of the channel, should be called under channelsLock
+after the channel is added to the room
+*/
+func (s *Server) presenceJoined(c *Channel, room string) {
+	if _, ok := s.presenceRooms[room]; !ok {
+		return
+	}
+
+	session := c.SessionId()
+	if pending, ok := s.presencePending[room][session]; ok {
+		//joined again in time, nothing changed for the rest of members
+		pending.timer.Stop()
+		delete(s.presencePending[room], session)
+		if len(s.presencePending[room]) == 0 {
+			delete(s.presencePending, room)
+		}
+		return
+	}
+
+	s.announcePresence(room, OnPresenceJoin, c, c.presenceEntry())
+}
+
+/**
+Announce leave of the channel, should be called under channelsLock
+after the channel is removed from the room
+*/
+func (s *Server) presenceLeft(c *Channel, room string) {
+	if _, ok := s.presenceRooms[room]; !ok {
+		return
+	}
+
+	entry := c.presenceEntry()
+	if s.presenceDebounce <= 0 {
+		s.announcePresence(room, OnPresenceLeave, c, entry)
+		return
+	}
+
+	if _, ok := s.presencePending[room]; !ok {
+		s.presencePending[room] = make(map[string]*pendingLeave)
+	}
+
+	session := c.SessionId()
+	if old, ok := s.presencePending[room][session]; ok {
+		old.timer.Stop()
+	}
+	pending := &pendingLeave{entry: entry}
+	pending.timer = s.getClock().AfterFunc(s.presenceDebounce, func() {
+		s.channelsLock.Lock()
+		defer s.channelsLock.Unlock()
+
+		if s.presencePending[room][session] != pending {
+			//cancelled by join or presence disabled
+			return
+		}
+		delete(s.presencePending[room], session)
+		if len(s.presencePending[room]) == 0 {
+			delete(s.presencePending, room)
+		}
+
+		s.announcePresence(room, OnPresenceLeave, c, entry)
+	})
+	s.presencePending[room][session] = pending
+}
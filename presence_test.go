@@ -0,0 +1,150 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Check that nothing else is written to the pipe for a while
+*/
+func expectNoFrame(t testing.TB, conn *pipeConn) {
+	t.Helper()
+
+	select {
+	case frame := <-conn.out:
+		t.Fatal("unexpected frame", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPresenceJoinLeave(t *testing.T) {
+	s := newTestServer()
+	s.EnablePresence("lobby")
+	observer, observerConn := pipeChannel(t, s)
+	observer.Join("lobby")
+
+	member, memberConn := pipeChannel(t, s)
+	member.Join("lobby")
+	member.SetPresenceMeta(map[string]string{"name": "bob"})
+	if frame := readFrame(t, observerConn); frame != `42["presence:join",{"sid":"`+member.Id()+`"}]` {
+		t.Fatal("join", frame)
+	}
+	if entries := s.Presence("lobby"); len(entries) != 2 {
+		t.Fatal("presence", entries)
+	}
+
+	member.Leave("lobby")
+	if frame := readFrame(t, observerConn); frame != `42["presence:leave",{"sid":"`+member.Id()+`","meta":{"name":"bob"}}]` {
+		t.Fatal("leave", frame)
+	}
+	expectNoFrame(t, memberConn)
+}
+
+func TestPresenceDebouncedRejoin(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.EnablePresence("lobby")
+	s.SetPresenceDebounce(time.Second)
+	observer, observerConn := pipeChannel(t, s)
+	observer.Join("lobby")
+
+	member, _ := pipeChannel(t, s)
+	member.Join("lobby")
+	readFrame(t, observerConn)
+
+	//same session joining again in time cancels the leave
+	member.Leave("lobby")
+	if entries := s.Presence("lobby"); len(entries) != 2 {
+		t.Fatal("presence", entries)
+	}
+	member.Join("lobby")
+	clock.Advance(2 * time.Second)
+	expectNoFrame(t, observerConn)
+
+	//leave is announced once debounce passes
+	member.Leave("lobby")
+	expectNoFrame(t, observerConn)
+	clock.Advance(time.Second)
+	if frame := readFrame(t, observerConn); frame != `42["presence:leave",{"sid":"`+member.Id()+`"}]` {
+		t.Fatal("leave", frame)
+	}
+	if entries := s.Presence("lobby"); len(entries) != 1 {
+		t.Fatal("presence", entries)
+	}
+}
+
+func TestPresenceDebouncedReconnect(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.EnablePresence("lobby")
+	s.SetPresenceDebounce(time.Second)
+	observer, observerConn := pipeChannel(t, s)
+	observer.Join("lobby")
+
+	first := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "member"})
+	first.Channel.Join("lobby")
+	if frame := readFrame(t, observerConn); frame != `42["presence:join",{"sid":"member"}]` {
+		t.Fatal("join", frame)
+	}
+	first.Channel.Close()
+	waitRegistry(t, s, func(stats RegistryStats) bool { return stats.Size == 1 })
+
+	//the client is back on a new connection of the same session
+	second := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "member"})
+	if second.Channel == first.Channel {
+		t.Fatal("same channel")
+	}
+	second.Channel.Join("lobby")
+	clock.Advance(2 * time.Second)
+	expectNoFrame(t, observerConn)
+	if entries := s.Presence("lobby"); len(entries) != 2 {
+		t.Fatal("presence", entries)
+	}
+
+	//other session leaving is announced once debounce passes
+	other := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "other"})
+	other.Channel.Join("lobby")
+	if frame := readFrame(t, observerConn); frame != `42["presence:join",{"sid":"other"}]` {
+		t.Fatal("join", frame)
+	}
+	other.Channel.Close()
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	if frame := readFrame(t, observerConn); frame != `42["presence:leave",{"sid":"other"}]` {
+		t.Fatal("leave", frame)
+	}
+}
+
+func TestPresenceDebouncedResume(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.EnableReliableDelivery(10, time.Minute)
+	s.EnablePresence("lobby")
+	s.SetPresenceDebounce(time.Second)
+	observer, observerConn := pipeChannel(t, s)
+	observer.Join("lobby")
+	//stream announce
+	readFrame(t, observerConn)
+
+	first := NewLoopHarness(s)
+	first.Pump()
+	stream := announcedStream(t, first.Frames()).Stream
+	first.Channel.Join("lobby")
+	readFrame(t, observerConn)
+	first.Channel.Close()
+	first.Pump()
+
+	//resumed session joining on the new connection cancels the leave
+	second := newOpenHarness(s)
+	feedEvent(t, second, reliableResumeEvent, ReliableState{Stream: stream})
+	if second.Channel.SessionId() != stream {
+		t.Fatal("session", second.Channel.SessionId(), stream)
+	}
+	second.Channel.Join("lobby")
+	clock.Advance(2 * time.Second)
+	expectNoFrame(t, observerConn)
+}
@@ -1,5 +1,10 @@
 package protocol
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 const (
 	/**
 	Message with connection options
@@ -33,13 +38,135 @@ const (
 	ack response
 	*/
 	MessageTypeAckResponse = iota
+	/**
+	Socket.io disconnect, peer is leaving
+	*/
+	MessageTypeDisconnect = iota
+	/**
+	Socket.io connect error, server rejected the connection
+	*/
+	MessageTypeConnectError = iota
+	/**
+	Engine.io upgrade, peer switched to the connection it is sent on
+	*/
+	MessageTypeUpgrade = iota
+	/**
+	Engine.io noop, carries nothing
+	*/
+	MessageTypeNoop = iota
 )
 
+/**
+Packet of engine.io or socket.io, build it with New functions to get
+fields consistent with its type. Args are comma separated JSON values,
+without surrounding brackets, see ArgList
+*/
 type Message struct {
-	Type   int
-	AckId  int
+	Type  int
+	AckId int
+
+	/**
+	Socket.io namespace, empty for the default one
+	*/
+	Namespace string
+
 	Method string
 	Args   string
+
+	/**
+	Packet the message was decoded from
+	*/
 	Source string
+
+	//namespace, ack id and method as written in decoded packet,
+	//reused by Encode if not changed
+	nspToken    string
+	ackToken    string
+	methodToken string
+}
+
+/**
+Event without ack
+*/
+func NewEvent(nsp, event string, args []json.RawMessage) *Message {
+	return &Message{Type: MessageTypeEmit, Namespace: nsp, Method: event, Args: joinArgs(args)}
+}
+
+/**
+Event waiting for ack with given id
+*/
+func NewAckRequest(nsp string, id int, event string, args []json.RawMessage) *Message {
+	return &Message{Type: MessageTypeAckRequest, Namespace: nsp, AckId: id, Method: event, Args: joinArgs(args)}
+}
+
+/**
+Response to ack request with given id
+*/
+func NewAck(nsp string, id int, args ...json.RawMessage) *Message {
+	return &Message{Type: MessageTypeAckResponse, Namespace: nsp, AckId: id, Args: joinArgs(args)}
+}
+
+/**
+Socket.io connect, payload may be nil
+*/
+func NewConnect(nsp string, payload json.RawMessage) *Message {
+	return &Message{Type: MessageTypeEmpty, Namespace: nsp, Args: string(payload)}
+}
+
+/**
+Socket.io disconnect
+*/
+func NewDisconnect(nsp string) *Message {
+	return &Message{Type: MessageTypeDisconnect, Namespace: nsp}
+}
+
+/**
+Socket.io connect error, payload is the error object
+*/
+func NewConnectError(nsp string, payload json.RawMessage) *Message {
+	return &Message{Type: MessageTypeConnectError, Namespace: nsp, Args: string(payload)}
+}
+
+/**
+Engine.io open, header is the handshake data
+*/
+func NewOpen(header json.RawMessage) *Message {
+	return &Message{Type: MessageTypeOpen, Args: string(header)}
+}
+
+/**
+Engine.io ping and pong, payload may be empty
+*/
+func NewPing(payload string) *Message {
+	return &Message{Type: MessageTypePing, Args: payload}
+}
+
+func NewPong(payload string) *Message {
+	return &Message{Type: MessageTypePong, Args: payload}
+}
+
+/**
+Split Args to separate JSON values, parsed on each call
+*/
+func (m *Message) ArgList() ([]json.RawMessage, error) {
+	if m.Args == "" {
+		return nil, nil
+	}
+
+	var args []json.RawMessage
+	if err := json.Unmarshal([]byte("["+m.Args+"]"), &args); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+func joinArgs(args []json.RawMessage) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = string(arg)
+	}
+
+	return strings.Join(parts, ",")
 }
 
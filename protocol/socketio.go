@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"strconv"
@@ -11,12 +12,17 @@ const (
 	open          = "0"
 	msg           = "4"
 	emptyMessage  = "40"
+	disconnect    = "41"
+	connectError  = "44"
 	commonMessage = "42"
 	ackMessage    = "43"
 
-	CloseMessage = "1"
-	PingMessage = "2"
-	PongMessage = "3"
+	CloseMessage      = "1"
+	PingMessage       = "2"
+	PongMessage       = "3"
+	UpgradeMessage    = "5"
+	NoopMessage       = "6"
+	DisconnectMessage = disconnect
 )
 
 var (
@@ -34,8 +40,16 @@ func typeToText(msgType int) (string, error) {
 		return PingMessage, nil
 	case MessageTypePong:
 		return PongMessage, nil
+	case MessageTypeUpgrade:
+		return UpgradeMessage, nil
+	case MessageTypeNoop:
+		return NoopMessage, nil
 	case MessageTypeEmpty:
 		return emptyMessage, nil
+	case MessageTypeDisconnect:
+		return disconnect, nil
+	case MessageTypeConnectError:
+		return connectError, nil
 	case MessageTypeEmit, MessageTypeAckRequest:
 		return commonMessage, nil
 	case MessageTypeAckResponse:
@@ -44,35 +58,101 @@ func typeToText(msgType int) (string, error) {
 	return "", ErrorWrongMessageType
 }
 
+/**
+Encode message to packet. Decoded message which was not changed
+is encoded to the same packet it was decoded from
+*/
 func Encode(msg *Message) (string, error) {
 	result, err := typeToText(msg.Type)
 	if err != nil {
 		return "", err
 	}
 
-	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypePing ||
-		msg.Type == MessageTypePong {
-		return result, nil
+	//ping and pong may carry payload, bare when there is none
+	if msg.Type == MessageTypePing || msg.Type == MessageTypePong ||
+		msg.Type == MessageTypeOpen || msg.Type == MessageTypeClose ||
+		msg.Type == MessageTypeUpgrade || msg.Type == MessageTypeNoop {
+		return result + msg.Args, nil
 	}
 
-	if msg.Type == MessageTypeAckRequest || msg.Type == MessageTypeAckResponse {
-		result += strconv.Itoa(msg.AckId)
+	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypeDisconnect ||
+		msg.Type == MessageTypeConnectError {
+		return result + encodeNamespace(msg, msg.Args != "") + msg.Args, nil
 	}
 
-	if msg.Type == MessageTypeOpen || msg.Type == MessageTypeClose {
-		return result + msg.Args, nil
+	result += encodeNamespace(msg, true)
+
+	if msg.Type == MessageTypeAckRequest || msg.Type == MessageTypeAckResponse {
+		result += encodeAck(msg)
 	}
 
 	if msg.Type == MessageTypeAckResponse {
 		return result + "[" + msg.Args + "]", nil
 	}
 
-	jsonMethod, err := json.Marshal(&msg.Method)
+	jsonMethod, err := encodeMethod(msg)
 	if err != nil {
 		return "", err
 	}
 
-	return result + "[" + string(jsonMethod) + "," + msg.Args + "]", nil
+	if msg.Args == "" {
+		return result + "[" + jsonMethod + "]", nil
+	}
+
+	return result + "[" + jsonMethod + "," + msg.Args + "]", nil
+}
+
+/**
+Get namespace followed by comma, the one of decoded packet if it is
+the same, which may omit the comma when nothing follows
+*/
+func encodeNamespace(msg *Message, followed bool) string {
+	if msg.nspToken != "" && strings.TrimSuffix(msg.nspToken, ",") == msg.Namespace {
+		if followed && !strings.HasSuffix(msg.nspToken, ",") {
+			return msg.nspToken + ","
+		}
+		return msg.nspToken
+	}
+
+	if msg.Namespace == "" || msg.Namespace == "/" {
+		return ""
+	}
+
+	return msg.Namespace + ","
+}
+
+/**
+Get ack id as text, the one of decoded packet if it is the same
+*/
+func encodeAck(msg *Message) string {
+	if msg.ackToken != "" {
+		if id, err := strconv.Atoi(msg.ackToken); err == nil && id == msg.AckId {
+			return msg.ackToken
+		}
+	}
+
+	return strconv.Itoa(msg.AckId)
+}
+
+/**
+Get method as JSON string, the one of decoded packet if it is the same
+*/
+func encodeMethod(msg *Message) (string, error) {
+	if msg.methodToken != "" {
+		var method string
+		if json.Unmarshal([]byte(msg.methodToken), &method) == nil && method == msg.Method {
+			return msg.methodToken, nil
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(msg.Method); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
 }
 
 func MustEncode(msg *Message) string {
@@ -97,6 +177,10 @@ func getMessageType(data string) (int, error) {
 		return MessageTypePing, nil
 	case PongMessage:
 		return MessageTypePong, nil
+	case UpgradeMessage:
+		return MessageTypeUpgrade, nil
+	case NoopMessage:
+		return MessageTypeNoop, nil
 	case msg:
 		if len(data) == 1 {
 			return 0, ErrorWrongMessageType
@@ -104,6 +188,10 @@ func getMessageType(data string) (int, error) {
 		switch data[0:2] {
 		case emptyMessage:
 			return MessageTypeEmpty, nil
+		case disconnect:
+			return MessageTypeDisconnect, nil
+		case connectError:
+			return MessageTypeConnectError, nil
 		case commonMessage:
 			return MessageTypeAckRequest, nil
 		case ackMessage:
@@ -114,62 +202,85 @@ func getMessageType(data string) (int, error) {
 }
 
 /**
-Get ack id of current packet, if present
+Get namespace of socket.io packet without its type, if present
 */
-func getAck(text string) (ackId int, restText string, err error) {
-	if len(text) < 4 {
-		return 0, "", ErrorWrongPacket
+func getNamespace(text string) (nsp, restText string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", text
 	}
-	text = text[2:]
 
-	pos := strings.IndexByte(text, '[')
+	pos := strings.IndexByte(text, ',')
 	if pos == -1 {
-		return 0, "", ErrorWrongPacket
+		return text, ""
+	}
+
+	return text[:pos], text[pos+1:]
+}
+
+/**
+Get ack id of current packet, if present
+*/
+func getAck(text string) (ackId int, restText string, ok bool) {
+	pos := 0
+	for pos < len(text) && text[pos] >= '0' && text[pos] <= '9' {
+		pos++
+	}
+	if pos == 0 {
+		return 0, text, false
 	}
 
-	ack, err := strconv.Atoi(text[0:pos])
+	ack, err := strconv.Atoi(text[:pos])
 	if err != nil {
-		return 0, "", err
+		return 0, text, false
 	}
 
-	return ack, text[pos:], nil
+	return ack, text[pos:], true
 }
 
 /**
-Get message method of current packet, if present
+Get message method and arguments of current packet,
+given as JSON array starting with the method
 */
-func getMethod(text string) (method, restText string, err error) {
-	var start, end, rest, countQuote int
-
-	for i, c := range text {
-		if c == '"' {
-			switch countQuote {
-			case 0:
-				start = i + 1
-			case 1:
-				end = i
-				rest = i + 1
-			default:
-				return "", "", ErrorWrongPacket
-			}
-			countQuote++
+func getMethod(text string) (method, token, args string, err error) {
+	if len(text) < 4 || text[0] != '[' || text[len(text)-1] != ']' || text[1] != '"' {
+		return "", "", "", ErrorWrongPacket
+	}
+	inner := text[1 : len(text)-1]
+
+	end := 1
+	for ; end < len(inner); end++ {
+		if inner[end] == '\\' {
+			end++
+			continue
 		}
-		if c == ',' {
-			if countQuote < 2 {
-				continue
-			}
-			rest = i + 1
+		if inner[end] == '"' {
 			break
 		}
 	}
+	if end >= len(inner) {
+		return "", "", "", ErrorWrongPacket
+	}
+
+	token = inner[:end+1]
+	if err := json.Unmarshal([]byte(token), &method); err != nil {
+		return "", "", "", ErrorWrongPacket
+	}
 
-	if (end < start) || (rest >= len(text)) {
-		return "", "", ErrorWrongPacket
+	rest := inner[end+1:]
+	if rest == "" {
+		return method, token, "", nil
+	}
+	if rest[0] != ',' {
+		return "", "", "", ErrorWrongPacket
 	}
 
-	return text[start:end], text[rest : len(text)-1], nil
+	return method, token, rest[1:], nil
 }
 
+/**
+Decode packet to message. Packets in the form written by Encode
+are encoded back to the same text
+*/
 func Decode(data string) (*Message, error) {
 	var err error
 	msg := &Message{}
@@ -180,32 +291,42 @@ func Decode(data string) (*Message, error) {
 		return nil, err
 	}
 
-	if msg.Type == MessageTypeOpen {
+	switch msg.Type {
+	case MessageTypeOpen, MessageTypePing, MessageTypePong, MessageTypeUpgrade, MessageTypeNoop:
 		msg.Args = data[1:]
 		return msg, nil
+	case MessageTypeClose:
+		return msg, nil
 	}
 
-	if msg.Type == MessageTypeClose || msg.Type == MessageTypePing ||
-		msg.Type == MessageTypePong || msg.Type == MessageTypeEmpty {
+	//socket.io packet, type is followed by namespace
+	var rest string
+	msg.Namespace, rest = getNamespace(data[2:])
+	msg.nspToken = data[2 : len(data)-len(rest)]
+
+	switch msg.Type {
+	case MessageTypeEmpty, MessageTypeDisconnect, MessageTypeConnectError:
+		msg.Args = rest
 		return msg, nil
 	}
 
-	ack, rest, err := getAck(data)
+	ack, afterAck, hasAck := getAck(rest)
 	msg.AckId = ack
+	msg.ackToken = rest[:len(rest)-len(afterAck)]
+	rest = afterAck
 	if msg.Type == MessageTypeAckResponse {
-		if err != nil {
-			return nil, err
+		if !hasAck || len(rest) < 2 || rest[0] != '[' || rest[len(rest)-1] != ']' {
+			return nil, ErrorWrongPacket
 		}
 		msg.Args = rest[1 : len(rest)-1]
 		return msg, nil
 	}
 
-	if err != nil {
+	if !hasAck {
 		msg.Type = MessageTypeEmit
-		rest = data[2:]
 	}
 
-	msg.Method, msg.Args, err = getMethod(rest)
+	msg.Method, msg.methodToken, msg.Args, err = getMethod(rest)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,184 @@
+package protocol
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, packet := range []string{
+		`0{"sid":"x"}`, "1", "2", "3probe", "5", "6",
+		"40", "40/chat", "40/chat,", "40/,", `40{"token":"t"}`, `40/chat,{"token":"t"}`,
+		"41", "41/chat", "41/chat,", `44{"message":"no"}`, `44/chat,{"message":"no"}`,
+		`42["ev"]`, `42["ev",1,"a"]`, `4212["ev",{"a":[1,2]}]`, `4201["a"]`, `4200["a"]`,
+		`42/chat,3["e\"v<>A",null]`, "430[]", "437[1,2]", `4307[1]`, `43/chat,3["x"]`,
+	} {
+		msg, err := Decode(packet)
+		if err != nil {
+			t.Fatal(packet, err)
+		}
+		if out, err := Encode(msg); err != nil || out != packet {
+			t.Fatalf("%q encoded to %q, %v", packet, out, err)
+		}
+	}
+}
+
+func TestRoundTripChanged(t *testing.T) {
+	msg := mustDecode(t, `4201["a"]`)
+	msg.AckId = 2
+	if out := MustEncode(msg); out != `422["a"]` {
+		t.Fatal(out)
+	}
+
+	//namespace written without comma gets it once followed by payload
+	msg = mustDecode(t, "40/chat")
+	msg.Args = `{"a":1}`
+	if out := MustEncode(msg); out != `40/chat,{"a":1}` {
+		t.Fatal(out)
+	}
+
+	msg = mustDecode(t, "40/chat")
+	msg.Namespace = "/game"
+	if out := MustEncode(msg); out != "40/game," {
+		t.Fatal(out)
+	}
+}
+
+func mustDecode(t *testing.T, packet string) *Message {
+	t.Helper()
+
+	msg, err := Decode(packet)
+	if err != nil {
+		t.Fatal(packet, err)
+	}
+	return msg
+}
+
+var testNamespaces = []string{"", "/", "/chat", "/a/b"}
+
+/**
+Build well-formed socket.io packet of random parts
+*/
+func randomPacket(typ, nspIndex uint8, comma bool, ack uint16, zeros uint8, event, arg string, n uint8) string {
+	packetType := []string{emptyMessage, disconnect, connectError, commonMessage, ackMessage}[int(typ)%5]
+	nsp := testNamespaces[int(nspIndex)%len(testNamespaces)]
+
+	var args []string
+	if n%3 > 0 {
+		data, _ := json.Marshal(arg)
+		args = append(args, string(data))
+	}
+	if n%3 > 1 {
+		data, _ := json.Marshal(map[string]interface{}{"k": arg, "n": n})
+		args = append(args, string(data))
+	}
+
+	var body string
+	switch packetType {
+	case emptyMessage, connectError:
+		if len(args) > 0 {
+			body = args[len(args)-1]
+		}
+	case commonMessage, ackMessage:
+		if packetType == ackMessage || n%2 == 0 {
+			body = strings.Repeat("0", int(zeros)%3) + strconv.Itoa(int(ack))
+		}
+		if packetType == commonMessage {
+			data, _ := json.Marshal(event)
+			args = append([]string{string(data)}, args...)
+		}
+		body += "[" + strings.Join(args, ",") + "]"
+	}
+
+	if nsp != "" && (comma || body != "") {
+		nsp += ","
+	}
+
+	return packetType + nsp + body
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	property := func(typ, nspIndex uint8, comma bool, ack uint16, zeros uint8, event, arg string, n uint8) bool {
+		packet := randomPacket(typ, nspIndex, comma, ack, zeros, event, arg, n)
+		msg, err := Decode(packet)
+		if err != nil {
+			t.Logf("%q: %v", packet, err)
+			return false
+		}
+		out, err := Encode(msg)
+		if err != nil || out != packet {
+			t.Logf("%q encoded to %q, %v", packet, out, err)
+			return false
+		}
+		return true
+	}
+
+	config := &quick.Config{MaxCount: 5000, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(property, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConstructorsProperty(t *testing.T) {
+	property := func(typ, nspIndex uint8, id uint16, event, arg string, n uint8) bool {
+		var args []json.RawMessage
+		for i := 0; i < int(n%3); i++ {
+			data, _ := json.Marshal(arg)
+			args = append(args, data)
+		}
+		nsp := testNamespaces[int(nspIndex)%len(testNamespaces)]
+
+		var msg *Message
+		switch typ % 6 {
+		case 0:
+			msg = NewEvent(nsp, event, args)
+		case 1:
+			msg = NewAckRequest(nsp, int(id), event, args)
+		case 2:
+			msg = NewAck(nsp, int(id), args...)
+		case 3:
+			msg = NewConnect(nsp, nil)
+		case 4:
+			msg = NewDisconnect(nsp)
+		case 5:
+			data, _ := json.Marshal(map[string]string{"message": arg})
+			msg = NewConnectError(nsp, data)
+		}
+
+		packet, err := Encode(msg)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		decoded, err := Decode(packet)
+		if err != nil {
+			t.Logf("%q: %v", packet, err)
+			return false
+		}
+		if out := MustEncode(decoded); out != packet {
+			t.Logf("%q encoded to %q", packet, out)
+			return false
+		}
+		if isRoot := nsp == "" || nsp == "/"; !isRoot && decoded.Namespace != nsp {
+			t.Logf("%q: namespace %q", packet, decoded.Namespace)
+			return false
+		}
+		if decoded.Type != msg.Type || decoded.AckId != msg.AckId ||
+			decoded.Method != msg.Method || decoded.Args != msg.Args {
+
+			t.Logf("%q decoded to %+v", packet, decoded)
+			return false
+		}
+		list, err := decoded.ArgList()
+		return typ%6 > 2 || err == nil && len(list) == len(args)
+	}
+
+	config := &quick.Config{MaxCount: 5000, Rand: rand.New(rand.NewSource(2))}
+	if err := quick.Check(property, config); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package gophersocket
+
+import (
+	"reflect"
+)
+
+/**
+Conditions for Server.Query, all of them should match,
+empty filter matches every live channel
+*/
+type QueryFilter struct {
+	//channel is joined to each of the rooms
+	Rooms []string
+
+	//presence metadata is a map with string keys, having these values
+	Meta map[string]interface{}
+}
+
+/**
+Get live channels matching the filter, room membership is checked
+in one pass under the membership lock, so concurrent joins and leaves
+are seen either completely or not at all
+*/
+func (s *Server) Query(filter QueryFilter) []*Channel {
+	var candidates []*Channel
+	if len(filter.Rooms) == 0 {
+		s.sidsLock.RLock()
+		candidates = make([]*Channel, 0, len(s.sids))
+		for _, c := range s.sids {
+			candidates = append(candidates, c)
+		}
+		s.sidsLock.RUnlock()
+	} else {
+		candidates = s.queryRooms(filter.Rooms)
+	}
+
+	result := make([]*Channel, 0, len(candidates))
+	for _, c := range candidates {
+		if c.IsAlive() && c.matchMeta(filter.Meta) {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+/**
+Get channels joined to each of given rooms
+*/
+func (s *Server) queryRooms(rooms []string) []*Channel {
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	//walk the smallest room, check the rest by channel's rooms
+	smallest := s.channels[rooms[0]]
+	for _, room := range rooms[1:] {
+		if len(s.channels[room]) < len(smallest) {
+			smallest = s.channels[room]
+		}
+	}
+
+	result := make([]*Channel, 0, len(smallest))
+	for c := range smallest {
+		joined := s.rooms[c]
+		matches := true
+		for _, room := range rooms {
+			if _, ok := joined[room]; !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+/**
+Check that presence metadata of the channel has given values
+*/
+func (c *Channel) matchMeta(want map[string]interface{}) bool {
+	if len(want) == 0 {
+		return true
+	}
+
+	c.metaLock.RLock()
+	defer c.metaLock.RUnlock()
+
+	meta := reflect.ValueOf(c.presenceMeta)
+	if meta.Kind() != reflect.Map || meta.Type().Key().Kind() != reflect.String {
+		return false
+	}
+
+	for key, value := range want {
+		got := meta.MapIndex(reflect.ValueOf(key).Convert(meta.Type().Key()))
+		if !got.IsValid() || !reflect.DeepEqual(got.Interface(), value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package gophersocket
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/**
+Get sorted ids of the channels
+*/
+func channelIds(channels []*Channel) string {
+	ids := make([]string, len(channels))
+	for i, c := range channels {
+		ids[i] = c.Id()
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestQueryRoomsAndMeta(t *testing.T) {
+	s := newTestServer()
+	harness := func(sid string) *Channel {
+		return NewLoopHarnessWithOptions(s, HarnessOptions{Sid: sid}).Channel
+	}
+	admin, both, user := harness("admin"), harness("both"), harness("user")
+
+	admin.Join("a")
+	both.Join("a")
+	both.Join("b")
+	user.Join("b")
+	admin.SetPresenceMeta(map[string]string{"role": "admin"})
+	both.SetPresenceMeta(map[string]interface{}{"role": "admin", "level": 2})
+	user.SetPresenceMeta(map[string]string{"role": "user"})
+
+	admins := map[string]interface{}{"role": "admin"}
+	cases := []struct {
+		filter QueryFilter
+		want   string
+	}{
+		{QueryFilter{}, "admin,both,user"},
+		{QueryFilter{Rooms: []string{"a"}}, "admin,both"},
+		{QueryFilter{Rooms: []string{"a", "b"}}, "both"},
+		{QueryFilter{Rooms: []string{"c"}}, ""},
+		{QueryFilter{Meta: admins}, "admin,both"},
+		{QueryFilter{Rooms: []string{"b"}, Meta: admins}, "both"},
+		{QueryFilter{Meta: map[string]interface{}{"level": 2}}, "both"},
+		{QueryFilter{Meta: map[string]interface{}{"role": "guest"}}, ""},
+	}
+	for _, c := range cases {
+		if got := channelIds(s.Query(c.filter)); got != c.want {
+			t.Errorf("query %+v: got %q, want %q", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestQuerySkipsClosed(t *testing.T) {
+	s := newTestServer()
+	alive := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "alive"})
+	closed := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "closed"})
+	alive.Channel.Join("a")
+	closed.Channel.Join("a")
+	closed.Feed("41")
+
+	if got := channelIds(s.Query(QueryFilter{Rooms: []string{"a"}})); got != "alive" {
+		t.Fatal(got)
+	}
+	if got := channelIds(s.Query(QueryFilter{})); got != "alive" {
+		t.Fatal(got)
+	}
+}
+
+func TestQueryConcurrentJoins(t *testing.T) {
+	s := newTestServer()
+	var channels []*Channel
+	for i := 0; i < 10; i++ {
+		c := NewLoopHarness(s).Channel
+		c.Join("a")
+		channels = append(channels, c)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range channels {
+		wg.Add(1)
+		go func(c *Channel) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.Join("b")
+				c.Leave("b")
+			}
+		}(c)
+	}
+	for i := 0; i < 100; i++ {
+		if n := len(s.Query(QueryFilter{Rooms: []string{"a"}})); n != len(channels) {
+			t.Fatalf("%d channels in a, want %d", n, len(channels))
+		}
+		if n := len(s.Query(QueryFilter{Rooms: []string{"a", "b"}})); n > len(channels) {
+			t.Fatalf("%d channels in a and b, at most %d expected", n, len(channels))
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,71 @@
+package gophersocket
+
+import (
+	"sync/atomic"
+)
+
+/**
+Out queue sizing of new channels, see SetAdaptiveOutQueue
+*/
+type outQueueSizing struct {
+	ceiling  int64
+	min, max int
+}
+
+/**
+Size out queues of new channels by memory taken by messages waiting
+in out queues of all channels: max while nothing waits, shrinking
+linearly down to min as the total approaches ceiling bytes, and min
+above it, so a connection storm under load does not exhaust memory.
+Channels keep the size they got when connected. Min is at least 2,
+zero or negative ceiling restores the fixed default size
+*/
+func (s *Server) SetAdaptiveOutQueue(ceiling int64, min, max int) {
+	if min < 2 {
+		min = 2
+	}
+	if max < min {
+		max = min
+	}
+
+	s.outQueueSizing.Store(outQueueSizing{ceiling, min, max})
+}
+
+/**
+Get out queue size for new channel
+*/
+func (s *Server) outQueueSize() int {
+	sizing, _ := s.outQueueSizing.Load().(outQueueSizing)
+	if sizing.ceiling <= 0 {
+		return queueBufferSize
+	}
+
+	used := atomic.LoadInt64(&s.outBytes)
+	if used <= 0 {
+		return sizing.max
+	}
+	if used >= sizing.ceiling {
+		return sizing.min
+	}
+
+	return sizing.max - int(float64(sizing.max-sizing.min)*float64(used)/float64(sizing.ceiling))
+}
+
+/**
+Get memory taken by messages waiting in out queues of all channels
+*/
+func (s *Server) QueuedBytes() int64 {
+	return atomic.LoadInt64(&s.outBytes)
+}
+
+/**
+Account size of messages put to or taken from out queue of the channel,
+returns the new size of the channel ones
+*/
+func (c *Channel) addOutBytes(delta int64) int64 {
+	if c.server != nil {
+		atomic.AddInt64(&c.server.outBytes, delta)
+	}
+
+	return atomic.AddInt64(&c.outBytes, delta)
+}
@@ -0,0 +1,55 @@
+package gophersocket
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAdaptiveOutQueue(t *testing.T) {
+	s := newTestServer()
+	s.SetAdaptiveOutQueue(1000, 10, 100)
+
+	busy := newOpenHarness(s)
+	if size := cap(busy.Channel.out); size != 100 {
+		t.Fatal("size with nothing queued", size)
+	}
+
+	//about half of the ceiling waits in out queue
+	busy.Channel.Emit("x", strings.Repeat("a", 490))
+	used := s.QueuedBytes()
+	if used < 500 || used > 510 {
+		t.Fatal("queued bytes", used)
+	}
+	want := 100 - int(90*float64(used)/1000)
+	if size := cap(newOpenHarness(s).Channel.out); size != want {
+		t.Fatalf("size at %d bytes %d, want %d", used, size, want)
+	}
+
+	//over the ceiling
+	busy.Channel.Emit("x", strings.Repeat("a", 600))
+	if size := cap(newOpenHarness(s).Channel.out); size != 10 {
+		t.Fatal("size over ceiling", size)
+	}
+
+	//existing channels keep their size, new ones grow back once written
+	busy.Pump()
+	if cap(busy.Channel.out) != 100 || s.QueuedBytes() != 0 {
+		t.Fatal("after write", cap(busy.Channel.out), s.QueuedBytes())
+	}
+	if size := cap(newOpenHarness(s).Channel.out); size != 100 {
+		t.Fatal("size after write", size)
+	}
+}
+
+func TestAdaptiveOutQueueDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetAdaptiveOutQueue(1000, 1, 1)
+	if size := cap(newOpenHarness(s).Channel.out); size != 2 {
+		t.Fatal("min not raised to 2", size)
+	}
+
+	s.SetAdaptiveOutQueue(0, 10, 100)
+	if size := cap(newOpenHarness(s).Channel.out); size != queueBufferSize {
+		t.Fatal("size with adaptive sizing disabled", size)
+	}
+}
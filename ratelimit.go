@@ -0,0 +1,221 @@
+package gophersocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	MetricBroadcastsDelayed = "room_broadcasts_delayed_total"
+	MetricBroadcastsDropped = "room_broadcasts_dropped_total"
+)
+
+var (
+	ErrorRoomLimitExceeded = errors.New("Room broadcast limit exceeded")
+)
+
+/**
+What to do with broadcast exceeding room limit
+*/
+type LimitPolicy int
+
+const (
+	/**
+	Return ErrorRoomLimitExceeded from broadcast
+	*/
+	LimitReject LimitPolicy = iota
+	/**
+	Skip broadcast silently, calling dropped broadcast handler
+	*/
+	LimitDrop
+	/**
+	Wait for the limit, not longer than max delay, drop if longer
+	*/
+	LimitDelay
+)
+
+/**
+Token bucket, refilled with rate tokens per second up to burst
+*/
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+func newTokenBucket(perSecond, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(perSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+/**
+Change bucket parameters keeping tokens already there
+*/
+func (b *tokenBucket) update(perSecond, burst int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.rate = float64(perSecond)
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+/**
+Take one token at now, returns time to wait until it is available,
+token is not taken if waiting is longer than maxWait
+*/
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if b.rate <= 0 {
+		return 0, false
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	b.tokens--
+
+	return wait, true
+}
+
+/**
+Outbound budget of one room, bucket is nil while only policy is set
+*/
+type roomLimit struct {
+	bucket   *tokenBucket
+	policy   LimitPolicy
+	maxDelay time.Duration
+}
+
+/**
+Limit broadcasts to given room to perSecond on average with bursts up
+to burst, measured by the clock of the server, can be changed at runtime. Zero perSecond removes the limit,
+policy of the room is kept
+*/
+func (s *Server) SetRoomLimit(room string, perSecond int, burst int) {
+	s.limitsLock.Lock()
+	defer s.limitsLock.Unlock()
+
+	limit, ok := s.roomLimits[room]
+	if !ok {
+		limit = &roomLimit{policy: LimitReject}
+	}
+
+	if perSecond <= 0 {
+		if ok {
+			s.roomLimits[room] = &roomLimit{
+				policy:   limit.policy,
+				maxDelay: limit.maxDelay,
+			}
+		}
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	if limit.bucket != nil {
+		limit.bucket.update(perSecond, burst)
+		return
+	}
+	s.roomLimits[room] = &roomLimit{
+		bucket:   newTokenBucket(perSecond, burst, s.getClock().Now()),
+		policy:   limit.policy,
+		maxDelay: limit.maxDelay,
+	}
+}
+
+/**
+Set what to do with broadcasts exceeding limit of given room,
+maxDelay is used by LimitDelay policy only. Can be set before
+or after SetRoomLimit, the policy is kept while the limit changes
+*/
+func (s *Server) SetRoomLimitPolicy(room string, policy LimitPolicy, maxDelay time.Duration) {
+	s.limitsLock.Lock()
+	defer s.limitsLock.Unlock()
+
+	var bucket *tokenBucket
+	if limit, ok := s.roomLimits[room]; ok {
+		bucket = limit.bucket
+	}
+	s.roomLimits[room] = &roomLimit{
+		bucket:   bucket,
+		policy:   policy,
+		maxDelay: maxDelay,
+	}
+}
+
+/**
+Set function called when broadcast is dropped due to room limit
+*/
+func (s *Server) OnBroadcastDropped(f func(room, method string)) {
+	s.limitsLock.Lock()
+	defer s.limitsLock.Unlock()
+
+	s.onBroadcastDropped = f
+}
+
+/**
+Check limit of room with given registry key before broadcast, waits if
+broadcast should be delayed, returns false if broadcast should not be
+done. Metrics and OnBroadcastDropped get the name within the namespace
+*/
+func (s *Server) allowBroadcast(room, method string) (bool, error) {
+	s.limitsLock.RLock()
+	limit, ok := s.roomLimits[room]
+	onDropped := s.onBroadcastDropped
+	s.limitsLock.RUnlock()
+
+	if !ok || limit.bucket == nil {
+		return true, nil
+	}
+
+	_, name := splitRoomKey(room)
+	maxWait := time.Duration(0)
+	if limit.policy == LimitDelay {
+		maxWait = limit.maxDelay
+	}
+
+	clock := s.getClock()
+	wait, allowed := limit.bucket.reserve(clock.Now(), maxWait)
+	if allowed && wait > 0 {
+		s.metricAdd(MetricBroadcastsDelayed, 1, "room", name)
+		<-clock.After(wait)
+	}
+	if allowed {
+		return true, nil
+	}
+
+	if limit.policy == LimitReject {
+		return false, ErrorRoomLimitExceeded
+	}
+
+	s.metricAdd(MetricBroadcastsDropped, 1, "room", name)
+	if onDropped != nil {
+		onDropped(name, method)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,108 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoomLimitReject(t *testing.T) {
+	s := newTestServer()
+	s.SetRoomLimit("room", 1, 2)
+
+	for i := 0; i < 2; i++ {
+		if err := s.BroadcastTo("room", "ev", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.BroadcastTo("room", "ev", 2); err != ErrorRoomLimitExceeded {
+		t.Fatal(err)
+	}
+}
+
+func TestRoomLimitPolicyBeforeLimit(t *testing.T) {
+	s := newTestServer()
+	var dropped []string
+	s.OnBroadcastDropped(func(room, method string) { dropped = append(dropped, room+"/"+method) })
+
+	//policy alone does not limit the room
+	s.SetRoomLimitPolicy("room", LimitDrop, 0)
+	for i := 0; i < 3; i++ {
+		if err := s.BroadcastTo("room", "ev", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.SetRoomLimit("room", 1, 1)
+	s.BroadcastTo("room", "ev", 0)
+	if err := s.BroadcastTo("room", "ev", 1); err != nil {
+		t.Fatal("policy set before limit was not kept:", err)
+	}
+	if len(dropped) != 1 || dropped[0] != "room/ev" {
+		t.Fatal(dropped)
+	}
+}
+
+func TestRoomLimitRemovedKeepsPolicy(t *testing.T) {
+	s := newTestServer()
+	s.SetRoomLimit("room", 1, 1)
+	s.SetRoomLimitPolicy("room", LimitDrop, 0)
+
+	s.SetRoomLimit("room", 0, 0)
+	for i := 0; i < 3; i++ {
+		if err := s.BroadcastTo("room", "ev", i); err != nil {
+			t.Fatal("removed limit still applies:", err)
+		}
+	}
+
+	s.SetRoomLimit("room", 1, 1)
+	s.BroadcastTo("room", "ev", 0)
+	if err := s.BroadcastTo("room", "ev", 1); err != nil {
+		t.Fatal("policy reset by removing the limit:", err)
+	}
+}
+
+func TestRoomLimitFollowsClock(t *testing.T) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	s.SetRoomLimit("room", 2, 1)
+
+	s.BroadcastTo("room", "ev", 0)
+	if err := s.BroadcastTo("room", "ev", 1); err != ErrorRoomLimitExceeded {
+		t.Fatal(err)
+	}
+
+	//refilled by the clock, not the real time
+	clock.Advance(500 * time.Millisecond)
+	if err := s.BroadcastTo("room", "ev", 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoomLimitDelayWaitsForClock(t *testing.T) {
+	s := newTestServer()
+	clock := newManualClock()
+	s.SetClock(clock)
+	s.SetRoomLimit("room", 1, 1)
+	s.SetRoomLimitPolicy("room", LimitDelay, time.Minute)
+
+	s.BroadcastTo("room", "ev", 0)
+	done := make(chan error, 1)
+	go func() { done <- s.BroadcastTo("room", "ev", 1) }()
+
+	clock.waitTimer(t, time.Second)
+	select {
+	case err := <-done:
+		t.Fatal("not delayed", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not sent after delay")
+	}
+}
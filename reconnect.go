@@ -0,0 +1,167 @@
+package gophersocket
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+const (
+	DefaultReconnectBase       = 500 * time.Millisecond
+	DefaultReconnectCap        = 30 * time.Second
+	DefaultReconnectMaxElapsed = 5 * time.Minute
+)
+
+/**
+Source of delays between reconnection attempts of a client
+*/
+type BackoffStrategy interface {
+	/**
+	Get delay before attempt, counted from 1 for each connection loss,
+	lastErr is the error the previous attempt or the connection failed
+	with. False means giving up, the channel is closed then
+	*/
+	NextDelay(attempt int, lastErr error) (time.Duration, bool)
+}
+
+/**
+Decorrelated jitter backoff: each delay is random between base and three
+times the previous one, capped by Cap. Gives up when delays of the current
+loss would sum over MaxElapsed, so the limit does not depend on the clock
+*/
+type DecorrelatedJitter struct {
+	Base       time.Duration
+	Cap        time.Duration
+	MaxElapsed time.Duration
+
+	prev    time.Duration
+	elapsed time.Duration
+	lock    sync.Mutex
+}
+
+/**
+Create decorrelated jitter backoff, zero values use defaults
+*/
+func NewDecorrelatedJitter(base, cap, maxElapsed time.Duration) *DecorrelatedJitter {
+	if base <= 0 {
+		base = DefaultReconnectBase
+	}
+	if cap <= 0 {
+		cap = DefaultReconnectCap
+	}
+	if maxElapsed <= 0 {
+		maxElapsed = DefaultReconnectMaxElapsed
+	}
+
+	return &DecorrelatedJitter{Base: base, Cap: cap, MaxElapsed: maxElapsed}
+}
+
+func (d *DecorrelatedJitter) NextDelay(attempt int, lastErr error) (time.Duration, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if attempt <= 1 {
+		d.prev, d.elapsed = d.Base, 0
+	}
+
+	delay := d.Base
+	if spread := 3*d.prev - d.Base; spread > 0 {
+		delay += time.Duration(rand.Int63n(int64(spread)))
+	}
+	if delay > d.Cap {
+		delay = d.Cap
+	}
+	if d.elapsed+delay > d.MaxElapsed {
+		return 0, false
+	}
+	d.prev = delay
+	d.elapsed += delay
+
+	return delay, true
+}
+
+/**
+Dials new connection for a client whose connection was lost
+*/
+type reconnector struct {
+	client *Client
+	url    string
+	tr     transport.Transport
+	opts   DialOptions
+
+	failed bool
+	lock   sync.Mutex
+}
+
+func newReconnector(c *Client, url string, tr transport.Transport, opts DialOptions) *reconnector {
+	if opts.Backoff == nil {
+		opts.Backoff = NewDecorrelatedJitter(0, 0, 0)
+	}
+
+	return &reconnector{client: c, url: url, tr: tr, opts: opts}
+}
+
+/**
+Replace lost connection of given generation, waiting for delays of the
+strategy. Loops losing the same connection share one reconnection.
+Returns false if the channel should be closed
+*/
+func (r *reconnector) reconnect(generation uint64, cause error) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	c := r.client
+	if r.failed || !c.IsAlive() {
+		return false
+	}
+	if c.getConn().generation != generation {
+		//replaced by the other loop meanwhile
+		return true
+	}
+
+	lastErr := cause
+	for attempt := 1; ; attempt++ {
+		delay, ok := r.opts.Backoff.NextDelay(attempt, lastErr)
+		if !ok {
+			r.failed = true
+			if r.opts.OnReconnectFailed != nil {
+				r.opts.OnReconnectFailed(lastErr)
+			}
+			return false
+		}
+		if r.opts.OnReconnectAttempt != nil {
+			r.opts.OnReconnectAttempt(attempt, delay)
+		}
+
+		select {
+		case <-c.closed:
+			return false
+		case <-c.Channel.clock().After(delay):
+		}
+
+		conn, header, err := dialConn(r.url, r.tr, r.opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.setHeader(header)
+		atomic.StoreInt64(&c.maxPayload, header.MaxPayload)
+		atomic.StoreInt64(&c.lastActivity, c.Channel.clock().Now().UnixNano())
+		return c.swapConn(conn)
+	}
+}
+
+/**
+Try to replace lost connection, if the client reconnects
+*/
+func (c *Channel) reconnectConn(generation uint64, err error) bool {
+	if c.reconnect == nil || !c.IsAlive() {
+		return false
+	}
+
+	return c.reconnect.reconnect(generation, err)
+}
@@ -0,0 +1,131 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+func TestReconnectReplacesHeader(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 4)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		Reconnect: true,
+		Backoff:   fixedBackoff{attempts: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	//header is read while reconnections replace it, run with -race
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				client.Id()
+				client.Handshake()
+			}
+		}
+	}()
+
+	sc := <-connected
+	for i := 0; i < 3; i++ {
+		sc.connection().Close()
+		select {
+		case sc = <-connected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not reconnected")
+		}
+	}
+	close(stop)
+	readers.Wait()
+
+	//server may see the connection before the client swaps to it
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Id() != sc.Id() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if client.Id() != sc.Id() || client.Handshake().Sid != sc.Id() {
+		t.Fatalf("client sid %q, want %q", client.Id(), sc.Id())
+	}
+}
+
+func TestReconnectFailedOnce(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	hs, url := serveTestServer(s)
+
+	var attempts []int
+	failed := make(chan error, 2)
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		Reconnect:          true,
+		Backoff:            fixedBackoff{attempts: 2},
+		OnReconnectAttempt: func(attempt int, delay time.Duration) { attempts = append(attempts, attempt) },
+		OnReconnectFailed:  func(err error) { failed <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := <-connected
+
+	//server is gone, every attempt fails
+	hs.Close()
+	sc.connection().Close()
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Fatal("failed without error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnReconnectFailed not called")
+	}
+	waitClosed(t, &client.Channel)
+
+	select {
+	case <-failed:
+		t.Fatal("OnReconnectFailed called twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Fatal("attempts", attempts)
+	}
+}
+
+func TestDecorrelatedJitterBounds(t *testing.T) {
+	d := NewDecorrelatedJitter(time.Second, 10*time.Second, time.Minute)
+
+	var total time.Duration
+	attempt := 1
+	for ; ; attempt++ {
+		delay, ok := d.NextDelay(attempt, nil)
+		if !ok {
+			break
+		}
+		if delay < time.Second || delay > 10*time.Second {
+			t.Fatalf("attempt %d delay %v out of bounds", attempt, delay)
+		}
+		total += delay
+	}
+	if total > time.Minute || attempt < 7 {
+		t.Fatalf("gave up at attempt %d after %v", attempt, total)
+	}
+
+	//attempt counter restarts after successful reconnection
+	if delay, ok := d.NextDelay(1, nil); !ok || delay < time.Second || delay > 3*time.Second {
+		t.Fatal("after reset", delay, ok)
+	}
+}
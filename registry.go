@@ -0,0 +1,172 @@
+package gophersocket
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	/**
+	Channels removed from sid registry by its defense, labeled by reason:
+	zombie for closed or idle channels, capacity for live ones over the cap
+	*/
+	MetricRegistryEvictions = "registry_evictions_total"
+
+	DefaultRegistrySweepInterval = time.Minute
+)
+
+/**
+Statistics of sid registry
+*/
+type RegistryStats struct {
+	/**
+	Amount of registered sids
+	*/
+	Size int
+
+	/**
+	Channels evicted because they were closed or idle too long
+	*/
+	ZombiesEvicted int64
+
+	/**
+	Live channels evicted and closed because registry was over the cap,
+	should stay zero, alert if it grows
+	*/
+	LiveEvicted int64
+}
+
+/**
+Limit sid registry: every sweep interval entries which are not alive,
+or have not received anything for idle timeout, are evicted, and idle
+ones are closed. Registry over maxEntries evicts least recently active
+channel, closing it. Zero values disable the corresponding check
+*/
+func (s *Server) SetRegistryLimits(maxEntries int, idleTimeout, sweepInterval time.Duration) {
+	if sweepInterval <= 0 {
+		sweepInterval = DefaultRegistrySweepInterval
+	}
+
+	s.sidsLock.Lock()
+	defer s.sidsLock.Unlock()
+
+	s.registryMax, s.registryIdle = maxEntries, idleTimeout
+	if s.registrySweeper != nil {
+		s.registrySweeper.Stop()
+	}
+	s.registrySweeper = s.getClock().AfterFunc(sweepInterval, func() {
+		s.sweepRegistry()
+
+		s.sidsLock.Lock()
+		if s.registrySweeper != nil {
+			s.registrySweeper.Reset(sweepInterval)
+		}
+		s.sidsLock.Unlock()
+	})
+}
+
+/**
+Get statistics of sid registry
+*/
+func (s *Server) RegistryStats() RegistryStats {
+	s.sidsLock.RLock()
+	defer s.sidsLock.RUnlock()
+
+	return RegistryStats{
+		Size:           len(s.sids),
+		ZombiesEvicted: s.zombiesEvicted,
+		LiveEvicted:    s.liveEvicted,
+	}
+}
+
+/**
+Evict closed and idle channels from sid registry
+*/
+func (s *Server) sweepRegistry() {
+	var idle []*Channel
+
+	s.sidsLock.Lock()
+	for sid, c := range s.sids {
+		if !c.IsAlive() {
+			delete(s.sids, sid)
+			s.zombiesEvicted++
+			s.metricAdd(MetricRegistryEvictions, 1, "reason", "zombie")
+		} else if s.registryIdle > 0 && c.idleFor() > s.registryIdle {
+			delete(s.sids, sid)
+			s.zombiesEvicted++
+			s.metricAdd(MetricRegistryEvictions, 1, "reason", "zombie")
+			idle = append(idle, c)
+		}
+	}
+	s.sidsLock.Unlock()
+
+	for _, c := range idle {
+		c.disconnectByServer(ErrorChannelIdle)
+	}
+}
+
+/**
+Evict least recently active channel other than given one,
+if registry is over the cap, should be called under lock.
+Returns live channel evicted, which should be closed after unlock
+*/
+func (s *Server) evictOverCap(keep *Channel) *Channel {
+	if s.registryMax <= 0 || len(s.sids) <= s.registryMax {
+		return nil
+	}
+
+	var oldest *Channel
+	for _, c := range s.sids {
+		if c == keep {
+			continue
+		}
+		if !c.IsAlive() {
+			oldest = c
+			break
+		}
+		if oldest == nil || c.idleFor() > oldest.idleFor() {
+			oldest = c
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+
+	delete(s.sids, oldest.Id())
+	if !oldest.IsAlive() {
+		s.zombiesEvicted++
+		s.metricAdd(MetricRegistryEvictions, 1, "reason", "zombie")
+		return nil
+	}
+
+	s.liveEvicted++
+	s.metricAdd(MetricRegistryEvictions, 1, "reason", "capacity")
+	log.Println("socket.io registry over capacity, evicting live channel: ", oldest.Id())
+
+	return oldest
+}
+
+/**
+Time since the channel received anything
+*/
+func (c *Channel) idleFor() time.Duration {
+	return c.clock().Now().Sub(time.Unix(0, atomic.LoadInt64(&c.lastActivity)))
+}
+
+/**
+Get time the channel was created, on server when the connection
+was accepted
+*/
+func (c *Channel) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+/**
+Get time the last application message was received: event, ack request
+or ack response. Heartbeats are not counted, ConnectedAt before the
+first message
+*/
+func (c *Channel) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastMessage))
+}
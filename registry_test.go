@@ -0,0 +1,130 @@
+package gophersocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistryEvictsLeastRecentlyActive(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetRegistryLimits(2, 0, time.Hour)
+
+	first := NewLoopHarness(s)
+	second := NewLoopHarness(s)
+	clock.Advance(time.Second)
+	//first is active again, so second is the least recently active one
+	if err := first.Feed("2"); err != nil {
+		t.Fatal(err)
+	}
+	third := NewLoopHarness(s)
+
+	pumpUntilClosed(t, second)
+	if reason := second.Channel.CloseReason(); reason != DisconnectServer {
+		t.Fatalf("got reason %q", reason)
+	}
+	if !first.Channel.IsAlive() || !third.Channel.IsAlive() {
+		t.Fatal("active channel closed")
+	}
+	if _, err := s.GetChannel(second.Channel.Id()); err == nil {
+		t.Fatal("evicted channel still registered")
+	}
+
+	stats := s.RegistryStats()
+	if stats.Size != 2 || stats.LiveEvicted != 1 || stats.ZombiesEvicted != 0 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+func TestRegistrySweepsIdleChannels(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetRegistryLimits(0, time.Minute, 10*time.Second)
+
+	idle := NewLoopHarness(s)
+	active := NewLoopHarness(s)
+
+	clock.Advance(30 * time.Second)
+	if err := active.Feed("2"); err != nil {
+		t.Fatal(err)
+	}
+	clock.waitTimer(t, 10*time.Second)
+	clock.Advance(40 * time.Second)
+
+	waitRegistry(t, s, func(stats RegistryStats) bool { return stats.ZombiesEvicted == 1 })
+	pumpUntilClosed(t, idle)
+	expectCause(t, idle.Channel, CloseIdle)
+	if !active.Channel.IsAlive() {
+		t.Fatal("active channel closed")
+	}
+	if _, err := s.GetChannel(active.Channel.Id()); err != nil {
+		t.Fatal("active channel evicted")
+	}
+	if stats := s.RegistryStats(); stats.Size != 1 || stats.LiveEvicted != 0 {
+		t.Fatalf("got %+v", stats)
+	}
+}
+
+/**
+Wait for registry statistics to satisfy ok
+*/
+func waitRegistry(t testing.TB, s *Server, ok func(stats RegistryStats) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ok(s.RegistryStats()) {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("registry statistics %+v not reached", s.RegistryStats())
+}
+
+/**
+Pump the harness until its channel is closed by the server
+*/
+func pumpUntilClosed(t testing.TB, h *LoopHarness) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Channel.IsAlive() && time.Now().Before(deadline) {
+		h.Pump()
+		time.Sleep(time.Millisecond)
+	}
+	if h.Channel.IsAlive() {
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	h := newOpenHarness(s)
+
+	connectedAt := h.Channel.ConnectedAt()
+	if !connectedAt.Equal(clock.Now()) || !h.Channel.LastActivity().Equal(connectedAt) {
+		t.Fatal("connected at", connectedAt, "last activity", h.Channel.LastActivity())
+	}
+
+	clock.Advance(time.Second)
+	feedEvent(t, h, "ev", 1)
+	if !h.Channel.LastActivity().Equal(clock.Now()) {
+		t.Fatal("last activity not advanced by event", h.Channel.LastActivity())
+	}
+
+	//heartbeats are not application messages
+	clock.Advance(time.Second)
+	if err := h.Feed("2"); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Channel.LastActivity().Equal(connectedAt.Add(time.Second)) {
+		t.Fatal("last activity advanced by ping", h.Channel.LastActivity())
+	}
+	if !h.Channel.ConnectedAt().Equal(connectedAt) {
+		t.Fatal("connected at changed", h.Channel.ConnectedAt())
+	}
+}
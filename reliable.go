@@ -0,0 +1,680 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/codec"
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Client side event, called when server could not restore the stream
+	on resume, so some messages are lost and the client continues
+	with the stream of current connection
+	*/
+	OnStreamLost = "stream lost"
+
+	DefaultReliableKeep = time.Minute
+
+	/**
+	History size used by connection state recovery
+	*/
+	DefaultRecoveryHistory = 1000
+
+	reliableSeqField    = "__seq"
+	reliableStreamEvent = "__stream"
+	reliableResumeEvent = "__resume"
+
+	//emit without ack id, only such messages are tagged
+	reliableEmitPrefix = "42["
+)
+
+/**
+Position of the client in reliable stream, keep it to resume
+the stream after reconnect
+*/
+type ReliableState struct {
+	Stream string `json:"stream"`
+	Seq    uint64 `json:"seq"`
+}
+
+/**
+Payload of connect packets of socket.io v4 connection state recovery:
+server sends sid and pid, the stream of the session, client sends pid
+and offset of the last event it got to recover the session
+*/
+type recoveryConnect struct {
+	Sid    string `json:"sid,omitempty"`
+	Pid    string `json:"pid,omitempty"`
+	Offset string `json:"offset,omitempty"`
+}
+
+/**
+Stream state sent by server on connection and on resume
+*/
+type streamAnnounce struct {
+	ReliableState
+	Lost bool `json:"lost,omitempty"`
+}
+
+type reliableEntry struct {
+	seq  uint64
+	data string
+}
+
+/**
+Sequence of emits sent by server, with history of the last ones
+for retransmission, outlives connection for the keep time
+*/
+type reliableStream struct {
+	id      string
+	seq     uint64
+	size    int
+	history []reliableEntry
+
+	channel *Channel
+	expire  Timer
+	rooms   []string
+	meta    interface{}
+	//sequence number sent as offset, see EnableConnectionStateRecovery
+	offsets bool
+
+	lock sync.Mutex
+}
+
+/**
+Enable reliable delivery: emits to each channel are numbered, last
+historySize of them are kept to be sent again to the client which missed
+them, e.g. due to reconnect, during keep time after disconnection.
+Zero or negative history size disables it for new connections
+*/
+func (s *Server) EnableReliableDelivery(historySize int, keep time.Duration) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	if keep <= 0 {
+		keep = DefaultReliableKeep
+	}
+	s.reliableHistory, s.reliableKeep = historySize, keep
+}
+
+/**
+Enable connection state recovery: reliable delivery, and rooms of
+the channel restored on resume, if the client reconnects not later
+than maxDisconnection. Support is advertised in handshake header.
+
+It uses socket.io v4 packets: connect packet of the server carries pid
+of the session, each event its offset as the last argument, and
+the client recovers the session sending connect packet with pid and
+offset. Connect packet of the server answers it, with pid of the
+session the channel continues, a new one if recovery failed
+*/
+func (s *Server) EnableConnectionStateRecovery(maxDisconnection time.Duration) {
+	s.EnableReliableDelivery(DefaultRecoveryHistory, maxDisconnection)
+
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	s.recoverRooms = true
+}
+
+func (s *Server) recoveryEnabled() bool {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	return s.recoverRooms
+}
+
+/**
+Start new stream for the channel, if reliable delivery is enabled,
+it is announced with the open sequence
+*/
+func (s *Server) openStream(c *Channel) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	if s.reliableHistory <= 0 {
+		return
+	}
+	stream := &reliableStream{
+		id:      generateNewId(c.Id()),
+		size:    s.reliableHistory,
+		channel: c,
+		offsets: s.recoverRooms,
+	}
+	s.streams[stream.id] = stream
+
+	c.setStream(stream)
+}
+
+/**
+Process connect packet the client sends to the root namespace, with pid
+and offset it recovers the session of previous connection
+*/
+func (s *Server) recoverSession(c *Channel, payload string) {
+	var req recoveryConnect
+	if err := json.Unmarshal([]byte(payload), &req); err != nil || req.Pid == "" {
+		return
+	}
+	if stream := c.getStream(); stream == nil || !stream.offsets {
+		return
+	}
+
+	var offset uint64
+	if req.Offset != "" {
+		var err error
+		if offset, err = strconv.ParseUint(req.Offset, 10, 64); err != nil {
+			return
+		}
+	}
+
+	s.resumeStream(c, ReliableState{Stream: req.Pid, Seq: offset})
+}
+
+/**
+Continue the stream given by client on the channel, sending messages
+after the client's position again. If they are not kept anymore,
+the client is told to continue with the stream of the channel
+*/
+func (s *Server) resumeStream(c *Channel, state ReliableState) {
+	current := c.getStream()
+
+	s.streamsLock.Lock()
+	stream, ok := s.streams[state.Stream]
+	recoverRooms, store := s.recoverRooms, s.sessionStore
+	s.streamsLock.Unlock()
+
+	if !ok && store != nil {
+		stream, ok = s.loadSession(store, state.Stream)
+	}
+	if !ok {
+		c.announceLost(current)
+		return
+	}
+
+	rooms, ok := stream.attach(c, state.Seq)
+	if !ok {
+		c.announceLost(current)
+		return
+	}
+	if current == stream {
+		return
+	}
+
+	if current != nil {
+		s.closeStream(current)
+	}
+	c.streamLock.Lock()
+	c.recovered = true
+	c.streamLock.Unlock()
+
+	if recoverRooms {
+		//rooms are kept by registry key, guard and hooks get the name
+		for _, key := range rooms {
+			nsp, room := splitRoomKey(key)
+			s.joinRoom(c, nsp, room)
+		}
+	}
+}
+
+/**
+Tell the client its stream can not be resumed, so it continues
+with the stream of current connection
+*/
+func (c *Channel) announceLost(current *reliableStream) {
+	if current == nil {
+		return
+	}
+
+	current.lock.Lock()
+	defer current.lock.Unlock()
+
+	current.announce(c, current.seq, true)
+}
+
+/**
+Check that the channel continues the stream of previous connection,
+so the client does not need initial state again. On client it is known
+after server answered Resume
+*/
+func (c *Channel) Recovered() bool {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	return c.recovered
+}
+
+/**
+Get id of the session the channel belongs to, which outlives the
+connection: id of the reliable stream, kept when the client resumes
+it on a new connection, or sid without reliable delivery, which stays
+the same on reconnect if SetSessionIDGenerator derives it e.g. from
+a cookie
+*/
+func (c *Channel) SessionId() string {
+	if stream := c.getStream(); stream != nil {
+		return stream.id
+	}
+
+	return c.Id()
+}
+
+/**
+Remove stream, so it can not be resumed anymore
+*/
+func (s *Server) closeStream(stream *reliableStream) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	delete(s.streams, stream.id)
+}
+
+/**
+Keep stream of disconnected channel for the keep time, with rooms
+the channel was in, so the client is able to resume it
+*/
+func (s *Server) detachStream(c *Channel, rooms []string) {
+	stream := c.getStream()
+	if stream == nil {
+		return
+	}
+
+	s.streamsLock.Lock()
+	keep, store := s.reliableKeep, s.sessionStore
+	s.streamsLock.Unlock()
+
+	stream.lock.Lock()
+	if stream.channel != c {
+		stream.lock.Unlock()
+		return
+	}
+	stream.channel = nil
+	stream.rooms = rooms
+	stream.meta = c.presenceEntry().Meta
+	stream.expire = s.expireStream(stream, keep)
+
+	if store == nil {
+		stream.lock.Unlock()
+		return
+	}
+	st, err := s.streamState(stream)
+	stream.lock.Unlock()
+
+	if err == nil {
+		s.storeSession(store, stream, st, keep)
+	}
+}
+
+/**
+Start timer removing the stream after keep time, unless a channel
+attaches to it meanwhile
+*/
+func (s *Server) expireStream(stream *reliableStream, keep time.Duration) Timer {
+	return s.getClock().AfterFunc(keep, func() {
+		s.streamsLock.Lock()
+		defer s.streamsLock.Unlock()
+
+		stream.lock.Lock()
+		defer stream.lock.Unlock()
+
+		if stream.channel == nil {
+			delete(s.streams, stream.id)
+		}
+	})
+}
+
+/**
+Bind stream to the channel and send messages after given position,
+fails if some of them are not kept anymore, or the stream is bound
+to other channel still alive. Returns rooms the previous channel
+of the stream was in
+*/
+func (st *reliableStream) attach(c *Channel, seq uint64) ([]string, bool) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.channel != nil && st.channel != c && st.channel.IsAlive() {
+		return nil, false
+	}
+	if seq > st.seq || (seq < st.seq && (len(st.history) == 0 || st.history[0].seq > seq+1)) {
+		return nil, false
+	}
+
+	if st.expire != nil {
+		st.expire.Stop()
+		st.expire = nil
+	}
+	st.channel = c
+	c.setStream(st)
+
+	st.announce(c, seq, false)
+	for _, entry := range st.history {
+		if entry.seq > seq {
+			c.push(entry.data)
+		}
+	}
+
+	rooms := st.rooms
+	st.rooms = nil
+	//metadata set on the new connection already is kept
+	if st.meta != nil && c.presenceEntry().Meta == nil {
+		c.SetPresenceMeta(st.meta)
+	}
+	st.meta = nil
+
+	return rooms, true
+}
+
+/**
+Number the message, keep it in history and put to out queue
+*/
+func (st *reliableStream) send(c *Channel, msg outMessage) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.seq++
+	tagged := st.tag(msg.data, st.seq)
+
+	st.history = append(st.history, reliableEntry{st.seq, tagged})
+	if len(st.history) > st.size {
+		st.history = st.history[len(st.history)-st.size:]
+	}
+
+	msg.data = tagged
+	return c.pushMessage(msg)
+}
+
+/**
+Add sequence number to event packet as its last argument,
+offset is the number as string
+*/
+func (st *reliableStream) tag(data string, seq uint64) string {
+	if st.offsets {
+		return data[:len(data)-1] + `,"` + strconv.FormatUint(seq, 10) + `"]`
+	}
+
+	return data[:len(data)-1] + `,{"` + reliableSeqField + `":` + strconv.FormatUint(seq, 10) + "}]"
+}
+
+/**
+Tell the client position the channel continues the stream from,
+lost if it is not the stream the client asked to resume. With offsets
+it is connect packet with pid of the stream, should be called with
+the stream locked
+*/
+func (st *reliableStream) announce(c *Channel, seq uint64, lost bool) error {
+	if !st.offsets {
+		return c.announceStream(ReliableState{Stream: st.id, Seq: seq}, lost)
+	}
+
+	payload, err := json.Marshal(recoveryConnect{Sid: c.Id(), Pid: st.id})
+	if err != nil {
+		return err
+	}
+
+	return c.push(protocol.MustEncode(protocol.NewConnect("", payload)))
+}
+
+func (c *Channel) getStream() *reliableStream {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	return c.stream
+}
+
+func (c *Channel) setStream(stream *reliableStream) {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	c.stream = stream
+}
+
+/**
+Send stream state, not numbered itself
+*/
+func (c *Channel) announceStream(state ReliableState, lost bool) error {
+	return c.emitSystem(reliableStreamEvent, streamAnnounce{state, lost})
+}
+
+/**
+Send internal event, encoded with JSON regardless of codec,
+bypassing stream numbering
+*/
+func (c *Channel) emitSystem(method string, args interface{}) error {
+	command, err := encode(codec.JSONCodec{}, protocol.NewEvent("", method, nil), args)
+	if err != nil {
+		return err
+	}
+
+	return c.push(command)
+}
+
+/**
+Get position of the client in reliable stream, empty if server
+has not enabled reliable delivery
+*/
+func (c *Channel) ReliableState() ReliableState {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	return c.streamSeen
+}
+
+/**
+Ask server to continue the stream from given position, use state
+of previous connection after reconnect, once the new one is open.
+Messages missed are sent again, or OnStreamLost is called if server
+does not keep them
+*/
+func (c *Channel) Resume(state ReliableState) error {
+	c.streamLock.Lock()
+	c.resumeFrom = state.Seq + 1
+	c.resumePid = state.Stream
+	c.resuming = true
+	offsets := c.streamOffsets
+	c.streamLock.Unlock()
+
+	if !offsets {
+		return c.emitSystem(reliableResumeEvent, state)
+	}
+
+	payload, err := json.Marshal(recoveryConnect{Pid: state.Stream, Offset: strconv.FormatUint(state.Seq, 10)})
+	if err != nil {
+		return err
+	}
+
+	return c.push(protocol.MustEncode(protocol.NewConnect("", payload)))
+}
+
+/**
+Process connect packet on client, returns false if it answers Resume
+rather than opens the connection. Pid in it means the server recovers
+connection state, so events carry offsets
+*/
+func (c *Channel) acceptConnect(m *methods, msg *protocol.Message) bool {
+	var payload recoveryConnect
+	if msg.Args == "" || json.Unmarshal([]byte(msg.Args), &payload) != nil || payload.Pid == "" {
+		return true
+	}
+
+	c.streamLock.Lock()
+	c.streamOffsets = true
+	if !c.resuming {
+		c.streamSeen = ReliableState{Stream: payload.Pid}
+		c.streamLock.Unlock()
+		return true
+	}
+
+	c.recovered, c.resuming = payload.Pid == c.resumePid, false
+	lost := !c.recovered
+	if c.recovered {
+		c.streamSeen = ReliableState{Stream: c.resumePid, Seq: c.resumeFrom - 1}
+	}
+	c.resumeFrom = 0
+	c.streamLock.Unlock()
+
+	if lost {
+		m.getExecutor().Submit(func() {
+			m.callLoopEvent(c, OnStreamLost)
+		})
+	}
+	return false
+}
+
+/**
+Process reliable delivery part of incoming message, returns false
+if the message should not be passed to handlers: internal events,
+duplicates and messages after a gap, which are requested again
+*/
+func (c *Channel) acceptReliable(m *methods, msg *protocol.Message) bool {
+	if msg.Type != protocol.MessageTypeEmit {
+		return true
+	}
+
+	if c.server != nil {
+		if msg.Method != reliableResumeEvent {
+			return true
+		}
+		var state ReliableState
+		if err := json.Unmarshal([]byte(msg.Args), &state); err == nil {
+			c.server.resumeStream(c, state)
+		}
+		return false
+	}
+
+	c.streamLock.Lock()
+	offsets := c.streamOffsets
+	c.streamLock.Unlock()
+	if offsets {
+		return c.acceptOffset(msg)
+	}
+
+	if msg.Method == reliableStreamEvent {
+		var announce streamAnnounce
+		if err := json.Unmarshal([]byte(msg.Args), &announce); err != nil {
+			return false
+		}
+
+		c.streamLock.Lock()
+		c.streamSeen = announce.ReliableState
+		c.resumeFrom = 0
+		if c.resuming {
+			c.recovered, c.resuming = !announce.Lost, false
+		}
+		c.streamLock.Unlock()
+
+		if announce.Lost {
+			m.getExecutor().Submit(func() {
+				m.callLoopEvent(c, OnStreamLost)
+			})
+		}
+		return false
+	}
+
+	seq, args, ok := extractSeq(msg.Args)
+	if !ok {
+		return true
+	}
+	msg.Args = args
+
+	c.streamLock.Lock()
+	expected := c.streamSeen.Seq + 1
+	if seq == expected {
+		c.streamSeen.Seq = seq
+		c.streamLock.Unlock()
+		return true
+	}
+
+	//duplicate, or gap which is already requested to be filled
+	if seq < expected || c.resumeFrom == expected {
+		c.streamLock.Unlock()
+		return false
+	}
+	c.resumeFrom = expected
+	state := ReliableState{Stream: c.streamSeen.Stream, Seq: expected - 1}
+	c.streamLock.Unlock()
+
+	c.emitSystem(reliableResumeEvent, state)
+	return false
+}
+
+/**
+Record offset of the event, given as its last argument,
+duplicates are dropped
+*/
+func (c *Channel) acceptOffset(msg *protocol.Message) bool {
+	if !isRootNamespace(msg.Namespace) {
+		return true
+	}
+	offset, args, ok := extractOffset(msg.Args)
+	if !ok {
+		return true
+	}
+	msg.Args = args
+
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+
+	if offset <= c.streamSeen.Seq {
+		return false
+	}
+	c.streamSeen.Seq = offset
+	return true
+}
+
+/**
+Get offset from the trailing string argument, if present,
+and return the rest of arguments
+*/
+func extractOffset(args string) (offset uint64, rest string, ok bool) {
+	parts, err := splitArgs(args)
+	if err != nil || len(parts) == 0 {
+		return 0, args, false
+	}
+
+	var offsetArg string
+	if err := json.Unmarshal(parts[len(parts)-1], &offsetArg); err != nil {
+		return 0, args, false
+	}
+	if offset, err = strconv.ParseUint(offsetArg, 10, 64); err != nil {
+		return 0, args, false
+	}
+
+	return offset, joinArgs(parts[:len(parts)-1]), true
+}
+
+/**
+Get sequence number from the trailing argument, if present,
+and return the rest of arguments
+*/
+func extractSeq(args string) (seq uint64, rest string, ok bool) {
+	parts, err := splitArgs(args)
+	if err != nil || len(parts) == 0 {
+		return 0, args, false
+	}
+
+	var seqArg map[string]uint64
+	if err := json.Unmarshal(parts[len(parts)-1], &seqArg); err != nil {
+		return 0, args, false
+	}
+	seq, ok = seqArg[reliableSeqField]
+	if !ok || len(seqArg) != 1 {
+		return 0, args, false
+	}
+
+	return seq, joinArgs(parts[:len(parts)-1]), true
+}
+
+func joinArgs(parts []json.RawMessage) string {
+	strParts := make([]string, len(parts))
+	for i, part := range parts {
+		strParts[i] = string(part)
+	}
+
+	return strings.Join(strParts, ",")
+}
@@ -0,0 +1,360 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Get stream announced in frames written by the harness
+*/
+func announcedStream(t testing.TB, frames []string) streamAnnounce {
+	t.Helper()
+
+	prefix := `42["` + reliableStreamEvent + `",`
+	for _, frame := range frames {
+		if strings.HasPrefix(frame, prefix) {
+			var announce streamAnnounce
+			if err := json.Unmarshal([]byte(frame[len(prefix):len(frame)-1]), &announce); err != nil {
+				t.Fatal(err)
+			}
+			return announce
+		}
+	}
+	t.Fatalf("no stream announced in %q", frames)
+	return streamAnnounce{}
+}
+
+func TestReliableRetransmitsGap(t *testing.T) {
+	s := newTestServer()
+	s.EnableReliableDelivery(10, time.Minute)
+
+	h := NewLoopHarness(s)
+	h.Pump()
+	stream := announcedStream(t, h.Frames()).Stream
+
+	for i := 1; i <= 3; i++ {
+		h.Channel.Emit("n", i)
+	}
+	expectFrames(t, h,
+		`42["n",1,{"__seq":1}]`,
+		`42["n",2,{"__seq":2}]`,
+		`42["n",3,{"__seq":3}]`,
+	)
+
+	//client got only the first message
+	feedEvent(t, h, reliableResumeEvent, ReliableState{Stream: stream, Seq: 1})
+	expectFrames(t, h,
+		`42["__stream",{"stream":"`+stream+`","seq":1}]`,
+		`42["n",2,{"__seq":2}]`,
+		`42["n",3,{"__seq":3}]`,
+	)
+}
+
+func TestReliableGapNotKept(t *testing.T) {
+	s := newTestServer()
+	s.EnableReliableDelivery(2, time.Minute)
+
+	h := NewLoopHarness(s)
+	h.Pump()
+	stream := announcedStream(t, h.Frames()).Stream
+
+	for i := 1; i <= 4; i++ {
+		h.Channel.Emit("n", i)
+	}
+	h.Pump()
+	h.Frames()
+
+	//history keeps 3 and 4 only, so 2 is lost
+	feedEvent(t, h, reliableResumeEvent, ReliableState{Stream: stream, Seq: 1})
+	h.Pump()
+	announce := announcedStream(t, h.Frames())
+	if !announce.Lost || announce.Stream != stream || announce.Seq != 4 {
+		t.Fatalf("got %+v, want lost stream at 4", announce)
+	}
+}
+
+func TestReliableResumeAfterReconnect(t *testing.T) {
+	s := newTestServer()
+	s.EnableReliableDelivery(10, time.Minute)
+	connected := make(chan *Channel, 2)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	first, closeFirst := dialTestServer(t, s)
+	got := make(chan int, 10)
+	first.On("n", func(c *Channel, v int) { got <- v })
+	first.SetExecutor(syncExecutor{})
+	sc := <-connected
+
+	sc.Emit("n", 1)
+	sc.Emit("n", 2)
+	for want := 1; want <= 2; want++ {
+		if v := receiveInt(t, got); v != want {
+			t.Fatalf("got %d, want %d", v, want)
+		}
+	}
+	state := first.ReliableState()
+	closeFirst()
+	waitClosed(t, sc)
+
+	//emitted while the client is away, kept in history
+	sc.Emit("n", 3)
+	sc.Emit("n", 4)
+
+	second, closeSecond := dialTestServer(t, s)
+	defer closeSecond()
+	second.On("n", func(c *Channel, v int) { got <- v })
+	second.SetExecutor(syncExecutor{})
+	sc2 := <-connected
+
+	if err := second.Resume(state); err != nil {
+		t.Fatal(err)
+	}
+	for want := 3; want <= 4; want++ {
+		if v := receiveInt(t, got); v != want {
+			t.Fatalf("got %d, want %d", v, want)
+		}
+	}
+	sc2.Emit("n", 5)
+	if v := receiveInt(t, got); v != 5 {
+		t.Fatalf("got %d, want 5", v)
+	}
+	if st := second.ReliableState(); st.Stream != state.Stream || st.Seq != 5 {
+		t.Fatalf("got %+v, want stream %s at 5", st, state.Stream)
+	}
+}
+
+/**
+Executor running handlers in the reading goroutine, in order
+*/
+type syncExecutor struct{}
+
+func (syncExecutor) Submit(f func()) {
+	f()
+}
+
+func receiveInt(t testing.TB, got chan int) int {
+	t.Helper()
+
+	select {
+	case v := <-got:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+		return 0
+	}
+}
+
+func waitClosed(t testing.TB, c *Channel) {
+	t.Helper()
+
+	select {
+	case <-c.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestRecoveryRestoresRooms(t *testing.T) {
+	s := newTestServer()
+	s.EnableConnectionStateRecovery(time.Minute)
+	connected := make(chan *Channel, 2)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	joins := make(chan string, 8)
+	s.SetJoinGuard(func(c *Channel, nsp, room string) error {
+		joins <- "guard " + nsp + " " + room
+		return nil
+	})
+	s.OnJoin(func(c *Channel, room string) { joins <- "join " + room })
+
+	first, closeFirst := dialTestServer(t, s)
+	if !first.header.ConnectionStateRecovery {
+		t.Fatal("recovery not advertised in handshake")
+	}
+	sc := <-connected
+	sc.Join("game")
+	s.joinRoom(sc, "/chat", "lobby")
+	sc.Emit("n", 1)
+	time.Sleep(50 * time.Millisecond)
+	state := first.ReliableState()
+	closeFirst()
+	waitClosed(t, sc)
+
+	if s.Amount("game") != 0 {
+		t.Fatal("closed channel still in room")
+	}
+
+	second, closeSecond := dialTestServer(t, s)
+	defer closeSecond()
+	sc2 := <-connected
+	if sc2.Recovered() || second.Recovered() {
+		t.Fatal("recovered before resume")
+	}
+	waitStream(t, second)
+	second.Resume(state)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !(second.Recovered() && sc2.Recovered()) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !second.Recovered() || !sc2.Recovered() {
+		t.Fatal("session not recovered")
+	}
+	if rooms := sc2.Rooms(); len(rooms) != 1 || rooms[0] != "game" {
+		t.Fatal("rooms not restored:", rooms)
+	}
+	if rooms := s.Of("/chat").Rooms(sc2); len(rooms) != 1 || rooms[0] != "lobby" {
+		t.Fatal("namespace rooms not restored:", rooms)
+	}
+
+	//restored rooms reach guard and hooks by name, not registry key
+	got := map[string]int{}
+	for i := 0; i < 8; i++ {
+		got[<-joins]++
+	}
+	if got["guard / game"] != 2 || got["join game"] != 2 ||
+		got["guard /chat lobby"] != 2 || got["join lobby"] != 2 {
+		t.Fatal(got)
+	}
+}
+
+/**
+Wait for the client to get the stream of its connection
+*/
+func waitStream(t testing.TB, c *Client) ReliableState {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if state := c.ReliableState(); state.Stream != "" {
+			return state
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("stream not announced")
+	return ReliableState{}
+}
+
+/**
+Get pid from connect packet in frames written by the harness
+*/
+func connectPid(t testing.TB, frames []string) string {
+	t.Helper()
+
+	for _, frame := range frames {
+		if strings.HasPrefix(frame, "40{") {
+			var payload recoveryConnect
+			if err := json.Unmarshal([]byte(frame[2:]), &payload); err != nil {
+				t.Fatal(err)
+			}
+			return payload.Pid
+		}
+	}
+	t.Fatalf("no connect packet in %q", frames)
+	return ""
+}
+
+func TestRecoveryPidOffset(t *testing.T) {
+	s := newTestServer()
+	s.EnableConnectionStateRecovery(time.Minute)
+
+	first := NewLoopHarness(s)
+	first.Pump()
+	pid := connectPid(t, first.Frames())
+	first.Channel.Emit("n", 1)
+	first.Channel.Emit("n", 2)
+	//offset is the last argument, as string
+	expectFrames(t, first,
+		`42["n",1,"1"]`,
+		`42["n",2,"2"]`,
+	)
+	first.Channel.Close()
+	first.Pump()
+
+	second := NewLoopHarness(s)
+	second.Pump()
+	if connectPid(t, second.Frames()) == pid {
+		t.Fatal("new connection got pid of the previous one")
+	}
+
+	if err := second.Feed(`40{"pid":"` + pid + `","offset":"1"}`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, second,
+		`40{"sid":"`+second.Channel.Id()+`","pid":"`+pid+`"}`,
+		`42["n",2,"2"]`,
+	)
+	if !second.Channel.Recovered() {
+		t.Fatal("session not recovered")
+	}
+}
+
+func TestRecoveryOfLiveChannelRejected(t *testing.T) {
+	s := newTestServer()
+	s.EnableConnectionStateRecovery(time.Minute)
+
+	first := NewLoopHarness(s)
+	first.Pump()
+	pid := connectPid(t, first.Frames())
+	first.Channel.Emit("n", 1)
+	first.Pump()
+	first.Frames()
+
+	second := NewLoopHarness(s)
+	second.Pump()
+	own := connectPid(t, second.Frames())
+
+	//the session is still bound to the first channel
+	if err := second.Feed(`40{"pid":"` + pid + `","offset":"0"}`); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, second,
+		`40{"sid":"`+second.Channel.Id()+`","pid":"`+own+`"}`,
+	)
+	if second.Channel.Recovered() {
+		t.Fatal("recovered session of live channel")
+	}
+	if first.Channel.getStream().channel != first.Channel {
+		t.Fatal("stream taken from live channel")
+	}
+
+	first.Channel.Emit("n", 2)
+	expectFrames(t, first, `42["n",2,"2"]`)
+}
+
+func TestRecoveryLostOnClient(t *testing.T) {
+	s := newTestServer()
+	s.EnableConnectionStateRecovery(time.Minute)
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	lost := make(chan struct{}, 1)
+	client.On(OnStreamLost, func(c *Channel) { lost <- struct{}{} })
+	state := waitStream(t, client)
+
+	if err := client.Resume(ReliableState{Stream: "unknown", Seq: 3}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream lost not reported")
+	}
+	if client.Recovered() {
+		t.Fatal("recovered unknown session")
+	}
+	if got := client.ReliableState(); got != state {
+		t.Fatalf("got %+v, want %+v", got, state)
+	}
+}
+
+func TestRecoveryNotAdvertised(t *testing.T) {
+	client, closeClient := dialTestServer(t, newTestServer())
+	defer closeClient()
+
+	if client.header.ConnectionStateRecovery {
+		t.Fatal("recovery advertised while disabled")
+	}
+}
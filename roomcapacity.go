@@ -0,0 +1,93 @@
+package gophersocket
+
+import (
+	"errors"
+)
+
+var (
+	ErrorRoomFull     = errors.New("Room is full")
+	ErrorRoomExists   = errors.New("Room already exists")
+	ErrorRoomNotFound = errors.New("Room not found")
+)
+
+/**
+Create room which at most capacity channels may be in, Join returns
+ErrorRoomFull over it. Zero or negative capacity means no limit.
+Rooms are otherwise created on first join, this one may be joined
+already, members over capacity are not removed
+*/
+func (s *Server) CreateRoom(room string, capacity int) error {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	if _, ok := s.roomCapacity[room]; ok {
+		return ErrorRoomExists
+	}
+	s.roomCapacity[room] = capacity
+
+	return nil
+}
+
+/**
+Change capacity of room created with CreateRoom, lowering it below
+the amount of members only rejects new joins
+*/
+func (s *Server) SetRoomCapacity(room string, capacity int) error {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	if _, ok := s.roomCapacity[room]; !ok {
+		return ErrorRoomNotFound
+	}
+	s.roomCapacity[room] = capacity
+
+	return nil
+}
+
+/**
+Remove room created with CreateRoom, its members leave it
+as with Leave. Other rooms are deleted once empty
+*/
+func (s *Server) DeleteRoom(room string) error {
+	s.channelsLock.Lock()
+	if _, ok := s.roomCapacity[room]; !ok {
+		s.channelsLock.Unlock()
+		return ErrorRoomNotFound
+	}
+	delete(s.roomCapacity, room)
+
+	members := make([]*Channel, 0, len(s.channels[room]))
+	for c := range s.channels[room] {
+		members = append(members, c)
+	}
+	for _, c := range members {
+		s.leave(c, room)
+	}
+	s.channelsLock.Unlock()
+
+	if s.onLeave != nil {
+		_, name := splitRoomKey(room)
+		for _, c := range members {
+			s.onLeave(c, name)
+		}
+	}
+
+	return nil
+}
+
+/**
+Check that channel may not join the room as it is full, should be
+called under channelsLock. Joining a room it is in already is allowed
+*/
+func (s *Server) roomFull(c *Channel, room string) bool {
+	capacity, ok := s.roomCapacity[room]
+	if !ok || capacity <= 0 {
+		return false
+	}
+	members := s.channels[room]
+	if _, ok := members[c]; ok {
+		return false
+	}
+
+	return len(members) >= capacity
+}
@@ -0,0 +1,138 @@
+package gophersocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRoomCapacity(t *testing.T) {
+	s := newTestServer()
+	if err := s.CreateRoom("table", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateRoom("table", 3); err != ErrorRoomExists {
+		t.Fatal("created twice", err)
+	}
+	a, b, c := newOpenHarness(s), newOpenHarness(s), newOpenHarness(s)
+
+	if err := a.Channel.Join("table"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Channel.Join("table"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Channel.Join("table"); err != ErrorRoomFull {
+		t.Fatal("joined full room", err)
+	}
+	//member joining again is not rejected
+	if err := b.Channel.Join("table"); err != nil {
+		t.Fatal("rejoin", err)
+	}
+	if s.Amount("table") != 2 {
+		t.Fatal("amount", s.Amount("table"))
+	}
+
+	if err := a.Channel.Leave("table"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Channel.Join("table"); err != nil {
+		t.Fatal("join after leave", err)
+	}
+
+	//rooms without capacity are not limited
+	for _, h := range []*LoopHarness{a, b, c} {
+		if err := h.Channel.Join("lobby"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRoomCapacityChanged(t *testing.T) {
+	s := newTestServer()
+	if err := s.SetRoomCapacity("table", 1); err != ErrorRoomNotFound {
+		t.Fatal("capacity of unknown room", err)
+	}
+	s.CreateRoom("table", 3)
+	a, b, c := newOpenHarness(s), newOpenHarness(s), newOpenHarness(s)
+	a.Channel.Join("table")
+	b.Channel.Join("table")
+
+	//members over the lowered capacity stay
+	if err := s.SetRoomCapacity("table", 1); err != nil {
+		t.Fatal(err)
+	}
+	if s.Amount("table") != 2 {
+		t.Fatal("members removed", s.Amount("table"))
+	}
+	if err := c.Channel.Join("table"); err != ErrorRoomFull {
+		t.Fatal("joined over lowered capacity", err)
+	}
+
+	a.Channel.Leave("table")
+	if err := c.Channel.Join("table"); err != ErrorRoomFull {
+		t.Fatal("joined at capacity", err)
+	}
+
+	if err := s.SetRoomCapacity("table", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Channel.Join("table"); err != nil {
+		t.Fatal("joined unlimited room", err)
+	}
+}
+
+func TestDeleteRoom(t *testing.T) {
+	s := newTestServer()
+	var left []string
+	s.OnLeave(func(c *Channel, room string) { left = append(left, c.Id()+":"+room) })
+	if err := s.DeleteRoom("table"); err != ErrorRoomNotFound {
+		t.Fatal("deleted unknown room", err)
+	}
+	s.CreateRoom("table", 1)
+	a, b := newOpenHarness(s), newOpenHarness(s)
+	a.Channel.Join("table")
+	a.Channel.Join("lobby")
+
+	if err := s.DeleteRoom("table"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Amount("table") != 0 || len(a.Channel.Rooms()) != 1 {
+		t.Fatal("members not removed", a.Channel.Rooms())
+	}
+	if len(left) != 1 || left[0] != a.Channel.Id()+":table" {
+		t.Fatal("left", left)
+	}
+
+	//room joined afterwards has no capacity
+	a.Channel.Join("table")
+	if err := b.Channel.Join("table"); err != nil {
+		t.Fatal("capacity kept after delete", err)
+	}
+	if err := s.CreateRoom("table", 1); err != nil {
+		t.Fatal("create after delete", err)
+	}
+}
+
+func TestRoomCapacityConcurrentJoins(t *testing.T) {
+	s := newTestServer()
+	s.CreateRoom("table", 5)
+
+	var joined int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		h := newOpenHarness(s)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if h.Channel.Join("table") == nil {
+				atomic.AddInt32(&joined, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if joined != 5 || s.Amount("table") != 5 {
+		t.Fatal("joined", joined, s.Amount("table"))
+	}
+}
@@ -0,0 +1,121 @@
+package gophersocket
+
+import (
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+/**
+Set of channels selected by room membership: members of any of Union
+rooms, which are also members of every Intersect room, and of none of
+Except rooms. Without Union rooms, members of Intersect rooms are taken
+*/
+type RoomSpec struct {
+	Union     []string
+	Intersect []string
+	Except    []string
+}
+
+/**
+Send message to every alive channel selected by spec, once even if it
+is in several rooms, the message is encoded once for all of them.
+Selection and sending are done on one snapshot of room membership.
+Room limits, coalescing and deduplication of single room broadcasts
+are not applied. Announcement to members of several rooms:
+
+	s.BroadcastToRooms(RoomSpec{Union: []string{"eu", "us"}}, "notice", msg)
+*/
+func (s *Server) BroadcastToRooms(spec RoomSpec, method string, args ...interface{}) error {
+	command, err := encodeArgs(s.getCodec(), protocol.NewEvent("", method, nil), args)
+	if err != nil {
+		return err
+	}
+
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	var members []*Channel
+	s.selectRooms(spec, func(c *Channel) {
+		if !c.Muted(method) {
+			members = append(members, c)
+		}
+	})
+
+	s.fanOut(members, func(c *Channel) {
+		c.enqueueBroadcast(command)
+	})
+
+	return nil
+}
+
+/**
+Get alive channels selected by spec, on one snapshot of room membership
+*/
+func (s *Server) ListRooms(spec RoomSpec) []*Channel {
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	var chans []*Channel
+	s.selectRooms(spec, func(c *Channel) {
+		chans = append(chans, c)
+	})
+
+	return chans
+}
+
+/**
+Call f for each alive channel selected by spec, should be called under
+channelsLock. Members of base rooms are checked against rooms of the
+channel, so no set of recipients is built
+*/
+func (s *Server) selectRooms(spec RoomSpec, f func(c *Channel)) {
+	base, intersect := spec.Union, spec.Intersect
+	if len(base) == 0 && len(intersect) > 0 {
+		//start from the smallest room, the rest are checked per channel
+		smallest := 0
+		for i, room := range intersect {
+			if len(s.channels[room]) < len(s.channels[intersect[smallest]]) {
+				smallest = i
+			}
+		}
+		base = intersect[smallest : smallest+1]
+	}
+
+	for i, room := range base {
+		for c := range s.channels[room] {
+			if !c.IsAlive() {
+				continue
+			}
+
+			joined := s.rooms[c]
+			//member of earlier union room got it already
+			if inAnyRoom(joined, base[:i]) {
+				continue
+			}
+			if !inAllRooms(joined, intersect) || inAnyRoom(joined, spec.Except) {
+				continue
+			}
+
+			f(c)
+		}
+	}
+}
+
+func inAnyRoom(joined map[string]struct{}, rooms []string) bool {
+	for _, room := range rooms {
+		if _, ok := joined[room]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func inAllRooms(joined map[string]struct{}, rooms []string) bool {
+	for _, room := range rooms {
+		if _, ok := joined[room]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
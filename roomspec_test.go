@@ -0,0 +1,152 @@
+package gophersocket
+
+import (
+	"sort"
+	"testing"
+)
+
+/**
+Connect harness channels, each joining rooms given for it
+*/
+func joinedHarnesses(s *Server, rooms ...[]string) []*LoopHarness {
+	hs := make([]*LoopHarness, len(rooms))
+	for i, joined := range rooms {
+		hs[i] = NewLoopHarness(s)
+		hs[i].Pump()
+		hs[i].Frames()
+		for _, room := range joined {
+			hs[i].Channel.Join(room)
+		}
+	}
+
+	return hs
+}
+
+func TestListRoomsSetOperations(t *testing.T) {
+	s := newTestServer()
+	hs := joinedHarnesses(s,
+		[]string{"a"},
+		[]string{"a", "b"},
+		[]string{"b", "c"},
+		[]string{"a", "b", "c"},
+		[]string{"c"},
+	)
+
+	for _, tc := range []struct {
+		spec RoomSpec
+		want []int
+	}{
+		{RoomSpec{Union: []string{"a", "b"}}, []int{0, 1, 2, 3}},
+		{RoomSpec{Intersect: []string{"a", "b"}}, []int{1, 3}},
+		{RoomSpec{Union: []string{"a", "c"}, Except: []string{"b"}}, []int{0, 4}},
+		{RoomSpec{Union: []string{"a"}, Intersect: []string{"c"}}, []int{3}},
+		{RoomSpec{Union: []string{"missing"}}, nil},
+	} {
+		var got []string
+		for _, c := range s.ListRooms(tc.spec) {
+			got = append(got, c.Id())
+		}
+		var want []string
+		for _, i := range tc.want {
+			want = append(want, hs[i].Channel.Id())
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+
+		if len(got) != len(want) {
+			t.Fatalf("%+v: got %v, want %v", tc.spec, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%+v: got %v, want %v", tc.spec, got, want)
+			}
+		}
+	}
+}
+
+func TestBroadcastToRoomsOnceToOverlappingMembers(t *testing.T) {
+	s := newTestServer()
+	hs := joinedHarnesses(s,
+		[]string{"a"},
+		[]string{"a", "b"},
+		[]string{"a", "b", "c"},
+		[]string{"d"},
+	)
+	closed := joinedHarnesses(s, []string{"a", "b"})[0]
+	closeChannel(closed.Channel, closed.methods, DisconnectServer, CloseKicked, nil)
+	closed.Pump()
+	closed.Frames()
+
+	if err := s.BroadcastToRooms(RoomSpec{Union: []string{"a", "b", "c"}}, "notice", "x"); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hs[:3] {
+		expectFrames(t, h, `42["notice","x"]`)
+	}
+	expectFrames(t, hs[3])
+	expectFrames(t, closed)
+}
+
+/**
+Server with channels spread over three overlapping rooms
+*/
+func benchmarkRoomsServer(b *testing.B, amount int) (*Server, []*LoopHarness) {
+	s := newTestServer()
+	rooms := make([][]string, amount)
+	for i := range rooms {
+		switch i % 4 {
+		case 0:
+			rooms[i] = []string{"a"}
+		case 1:
+			rooms[i] = []string{"a", "b"}
+		case 2:
+			rooms[i] = []string{"b", "c"}
+		default:
+			rooms[i] = []string{"a", "b", "c"}
+		}
+	}
+
+	return s, joinedHarnesses(s, rooms...)
+}
+
+func BenchmarkBroadcastToRoomsUnion(b *testing.B) {
+	benchmarkBroadcastToRooms(b, RoomSpec{Union: []string{"a", "b", "c"}})
+}
+
+func BenchmarkBroadcastToRoomsExcept(b *testing.B) {
+	benchmarkBroadcastToRooms(b, RoomSpec{Union: []string{"a", "b"}, Except: []string{"c"}})
+}
+
+func BenchmarkBroadcastToRoomsIntersect(b *testing.B) {
+	benchmarkBroadcastToRooms(b, RoomSpec{Intersect: []string{"a", "b", "c"}})
+}
+
+func benchmarkBroadcastToRooms(b *testing.B, spec RoomSpec) {
+	s, hs := benchmarkRoomsServer(b, 1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.BroadcastToRooms(spec, "tick", i); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		for _, h := range hs {
+			h.Pump()
+			h.Frames()
+		}
+		b.StartTimer()
+	}
+}
+
+func BenchmarkListRoomsUnion(b *testing.B) {
+	s, _ := benchmarkRoomsServer(b, 1000)
+	spec := RoomSpec{Union: []string{"a", "b", "c"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ListRooms(spec)
+	}
+}
@@ -1,87 +1,236 @@
 package gophersocket
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/whiterabb17/gopher-socket/codec"
 	"github.com/whiterabb17/gopher-socket/protocol"
 )
 
 var (
 	ErrorSendTimeout     = errors.New("Timeout")
 	ErrorSocketOverflood = errors.New("Socket overflood")
+	ErrorChannelClosed   = errors.New("Channel closed")
+	ErrorEncodePanic     = errors.New("Encode panic")
+	ErrorEncodeArgs      = errors.New("Arguments can not be encoded by the codec")
 )
 
 /**
-Send message packet to socket
+Limit total size of messages waiting in out queue of each channel,
+message exceeding it is rejected with ErrorSocketOverflood, same as
+on too many messages. Zero or negative disables the limit
 */
-func send(msg *protocol.Message, c *Channel, args interface{}) error {
+func (m *methods) SetMaxOutBytes(maxBytes int64) {
+	m.maxOutBytes.Store(maxBytes)
+}
+
+func (m *methods) getMaxOutBytes() int64 {
+	maxBytes, _ := m.maxOutBytes.Load().(int64)
+	return maxBytes
+}
+
+/**
+Encode message packet with given arguments
+*/
+func encode(cd codec.Codec, msg *protocol.Message, args interface{}) (command string, err error) {
 	//preventing json/encoding "index out of range" panic
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println("socket.io send panic: ", r)
+			command, err = "", ErrorEncodePanic
 		}
 	}()
 
 	if args != nil {
-		json, err := json.Marshal(&args)
+		data, err := cd.Marshal(args)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrorEncodeArgs, err)
+		}
+
+		msg.Args = string(data)
+	}
+
+	return protocol.Encode(msg)
+}
+
+/**
+Encode message packet with positional arguments
+*/
+func encodeArgs(cd codec.Codec, msg *protocol.Message, args []interface{}) (string, error) {
+	if len(args) == 1 {
+		return encode(cd, msg, args[0])
+	}
+
+	parts := make([]string, len(args))
+	for i := range args {
+		data, err := cd.Marshal(args[i])
 		if err != nil {
-			return err
+			return "", fmt.Errorf("%w: argument %d: %v", ErrorEncodeArgs, i, err)
 		}
+		parts[i] = string(data)
+	}
+	msg.Args = strings.Join(parts, ",")
 
-		msg.Args = string(json)
+	return encode(cd, msg, nil)
+}
+
+/**
+Send message packet to socket
+*/
+func send(msg *protocol.Message, c *Channel, args interface{}) error {
+	if skip, err := c.skipMuted(msg); skip {
+		return err
 	}
 
-	command, err := protocol.Encode(msg)
+	command, err := encode(c.codec(), msg, args)
 	if err != nil {
 		return err
 	}
 
-	if len(c.out) == queueBufferSize {
-		return ErrorSocketOverflood
+	return c.enqueue(command)
+}
+
+/**
+Send message packet with positional arguments to socket
+*/
+func sendArgs(msg *protocol.Message, c *Channel, args []interface{}) error {
+	if skip, err := c.skipMuted(msg); skip {
+		return err
 	}
 
-	c.out <- command
+	command, err := encodeArgs(c.codec(), msg, args)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return c.enqueue(command)
 }
 
 /**
-Create packet based on given data and send it
+Create packet based on given data and send it. Arguments are encoded
+with the codec before the packet is queued, so a value the codec can
+not encode, e.g. func or chan, fails here with ErrorEncodeArgs and
+nothing is queued
 */
 func (c *Channel) Emit(method string, args interface{}) error {
-	msg := &protocol.Message{
-		Type:   protocol.MessageTypeEmit,
-		Method: method,
-	}
+	msg := protocol.NewEvent("", method, nil)
 
 	return send(msg, c, args)
 }
 
 /**
-Create ack packet based on given data and send it and receive response
+Emit event with positional arguments only if pred holds for the
+channel, e.g. checks presence metadata for a feature flag. Returns
+whether the event was sent, muted event is not, with error of sending it
 */
-func (c *Channel) Ack(method string, args interface{}, timeout time.Duration) (string, error) {
-	msg := &protocol.Message{
-		Type:   protocol.MessageTypeAckRequest,
-		AckId:  c.ack.getNextId(),
-		Method: method,
+func (c *Channel) EmitIf(pred func(c *Channel) bool, method string, args ...interface{}) (bool, error) {
+	if (pred != nil && !pred(c)) || c.Muted(method) {
+		return false, nil
+	}
+
+	if err := c.emitArgs(method, args); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/**
+Create packet with any amount of positional arguments and send it
+*/
+func (c *Channel) emitArgs(method string, args []interface{}) error {
+	msg := protocol.NewEvent("", method, nil)
+
+	return sendArgs(msg, c, args)
+}
+
+/**
+Create packet with positional arguments and send it, for messages
+without which the connection is useless. If out queue is full, the
+channel is closed with DisconnectDeliveryFailed and ErrorSocketOverflood
+is returned, instead of dropping the message
+*/
+func (c *Channel) EmitOrClose(method string, args ...interface{}) error {
+	err := c.emitArgs(method, args)
+	if errors.Is(err, ErrorSocketOverflood) && c.shared != nil {
+		closeChannel(c, c.shared, DisconnectDeliveryFailed, CloseOverflow, err)
 	}
 
-	waiter := make(chan string)
-	c.ack.addWaiter(msg.AckId, waiter)
+	return err
+}
+
+/**
+Create packet with positional arguments and send it, cb is called
+exactly once: with nil after the packet is written to transport,
+or with error if it is not, e.g. channel closed before the write,
+or ErrorEventMuted if the event is muted for this channel.
+cb runs in the sending goroutine, so it should not block
+*/
+func (c *Channel) EmitCallback(method string, args []interface{}, cb func(err error)) {
+	if c.Muted(method) {
+		cb(ErrorEventMuted)
+		return
+	}
+
+	command, err := encodeArgs(c.codec(), protocol.NewEvent("", method, nil), args)
+	if err == nil {
+		msg := newOutMessage(command)
+		msg.done = cb
+		err = c.enqueueMessage(msg)
+	}
 
-	err := send(msg, c, args)
+	if err != nil {
+		cb(err)
+	}
+}
+
+/**
+Create ack packet based on given data and send it and receive response,
+zero timeout and retries follow policy of the event, see SetAckPolicy
+*/
+func (c *Channel) Ack(method string, args interface{}, timeout time.Duration) (string, error) {
+	return c.ackWithPolicy(method, timeout, func(timeout time.Duration) (string, error) {
+		msg := protocol.NewAckRequest("", c.ack.getNextId(), method, nil)
+
+		return c.waitAck(msg, func() error {
+			return sendAckRequest(msg, c, args, timeout)
+		}, timeout)
+	})
+}
+
+/**
+Register waiter for ack request, send it with given function
+and wait for response not longer than timeout
+*/
+func (c *Channel) waitAck(msg *protocol.Message, sendFunc func() error, timeout time.Duration) (string, error) {
+	//buffered, so late response does not block when waiter is gone
+	waiter := make(chan string, 1)
+	cancel := c.ack.addWaiter(msg.AckId, waiter)
+
+	clock := c.clock()
+	start := clock.Now()
+	err := sendFunc()
 	if err != nil {
 		c.ack.removeWaiter(msg.AckId)
+		return "", err
 	}
 
 	select {
 	case result := <-waiter:
+		c.ack.removeWaiter(msg.AckId)
+		if c.shared != nil {
+			c.shared.metricObserve(MetricAckRoundTrip, clock.Now().Sub(start).Seconds(), "event", msg.Method)
+		}
 		return result, nil
-	case <-time.After(timeout):
+	case err := <-cancel:
+		return "", err
+	case <-c.closed:
+		c.ack.removeWaiter(msg.AckId)
+		return "", ErrorChannelClosed
+	case <-clock.After(timeout):
 		c.ack.removeWaiter(msg.AckId)
 		return "", ErrorSendTimeout
 	}
@@ -0,0 +1,249 @@
+package gophersocket
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMaxOutBytesFewLargeMessages(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxOutBytes(1000)
+	h := newOpenHarness(s)
+
+	large := strings.Repeat("x", 400)
+	for i := 0; i < 2; i++ {
+		if err := h.Channel.Emit("snapshot", large); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//two messages are far below the count limit, but over the byte one
+	if err := h.Channel.Emit("snapshot", large); err != ErrorSocketOverflood {
+		t.Fatal("expected overflow, got", err)
+	}
+	if queued := len(h.Channel.out); queued != 2 {
+		t.Fatalf("%d messages queued, want 2", queued)
+	}
+
+	//small messages still fit
+	if err := h.Channel.Emit("ping", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	//written messages free their bytes
+	if written := h.Pump(); written != 3 {
+		t.Fatalf("%d messages written, want 3", written)
+	}
+	if err := h.Channel.Emit("snapshot", large); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Channel.IsAlive() {
+		t.Fatal("rejected emit closed the channel")
+	}
+}
+
+func TestMaxOutBytesSingleMessageOverLimit(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxOutBytes(100)
+	h := newOpenHarness(s)
+
+	if err := h.Channel.Emit("snapshot", strings.Repeat("x", 200)); err != ErrorSocketOverflood {
+		t.Fatal("expected overflow, got", err)
+	}
+	if h.Pump() != 0 {
+		t.Fatal("rejected message written")
+	}
+}
+
+func TestMaxOutBytesDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxOutBytes(100)
+	s.SetMaxOutBytes(0)
+	h := newOpenHarness(s)
+
+	if err := h.Channel.Emit("snapshot", strings.Repeat("x", 10000)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/**
+Record calls of EmitCallback callback
+*/
+type callbackRecorder struct {
+	errs []error
+	lock sync.Mutex
+}
+
+func (r *callbackRecorder) callback(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.errs = append(r.errs, err)
+}
+
+func (r *callbackRecorder) calls() []error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]error(nil), r.errs...)
+}
+
+func TestEmitCallbackWritten(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var rec callbackRecorder
+
+	h.Channel.EmitCallback("ev", []interface{}{1, "a"}, rec.callback)
+	if calls := rec.calls(); len(calls) != 0 {
+		t.Fatal("callback called before write:", calls)
+	}
+
+	expectFrames(t, h, `42["ev",1,"a"]`)
+	h.Pump()
+	if calls := rec.calls(); len(calls) != 1 || calls[0] != nil {
+		t.Fatal("want one call with nil, got", calls)
+	}
+}
+
+func TestEmitCallbackChannelClosed(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var rec callbackRecorder
+
+	h.Channel.EmitCallback("ev", nil, rec.callback)
+	h.Feed("41")
+	h.Pump()
+
+	calls := rec.calls()
+	if len(calls) != 1 || calls[0] == nil {
+		t.Fatal("want one call with error, got", calls)
+	}
+
+	var late callbackRecorder
+	h.Channel.EmitCallback("ev", nil, late.callback)
+	if calls := late.calls(); len(calls) != 1 || calls[0] != ErrorChannelClosed {
+		t.Fatal("want one call with ErrorChannelClosed, got", calls)
+	}
+}
+
+func TestEmitCallbackWriteFailed(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	var rec callbackRecorder
+
+	h.FailWrites(errTestWrite)
+	h.Channel.EmitCallback("ev", nil, rec.callback)
+	h.Channel.EmitCallback("ev", nil, rec.callback)
+	h.Pump()
+
+	calls := rec.calls()
+	if len(calls) != 2 || calls[0] == nil || calls[1] == nil {
+		t.Fatal("want two calls with error, got", calls)
+	}
+}
+
+func TestEmitOrCloseQueued(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	if err := h.Channel.EmitOrClose("critical", 1, "two"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["critical",1,"two"]`)
+	if !h.Channel.IsAlive() {
+		t.Fatal("channel closed")
+	}
+}
+
+func TestEmitOrCloseFullQueue(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxOutBytes(100)
+	h := newOpenHarness(s)
+	if err := h.Channel.Emit("fill", strings.Repeat("x", 80)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := h.Channel.EmitOrClose("critical", strings.Repeat("y", 40))
+	if !errors.Is(err, ErrorSocketOverflood) {
+		t.Fatal(err)
+	}
+	expectReason(t, h.Channel, DisconnectDeliveryFailed, ErrorSocketOverflood)
+}
+
+func TestEmitOrCloseFullQueueCount(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	for h.Channel.Emit("fill", 1) == nil {
+	}
+
+	if err := h.Channel.EmitOrClose("critical", 1); !errors.Is(err, ErrorSocketOverflood) {
+		t.Fatal(err)
+	}
+	expectReason(t, h.Channel, DisconnectDeliveryFailed, ErrorSocketOverflood)
+}
+
+func TestEmitOrCloseOtherError(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	if err := h.Channel.EmitOrClose("bad", make(chan int)); err == nil {
+		t.Fatal("unmarshalable argument accepted")
+	}
+	if !h.Channel.IsAlive() {
+		t.Fatal("channel closed on error other than overflow")
+	}
+}
+
+func betaChannel(c *Channel) bool {
+	meta, _ := c.presenceEntry().Meta.(map[string]bool)
+	return meta["beta"]
+}
+
+func TestEmitIf(t *testing.T) {
+	s := newTestServer()
+	beta, other := newOpenHarness(s), newOpenHarness(s)
+	beta.Channel.SetPresenceMeta(map[string]bool{"beta": true})
+
+	if sent, err := beta.Channel.EmitIf(betaChannel, "feature", 1, "two"); !sent || err != nil {
+		t.Fatal("beta channel", sent, err)
+	}
+	expectFrames(t, beta, `42["feature",1,"two"]`)
+
+	if sent, err := other.Channel.EmitIf(betaChannel, "feature", 1, "two"); sent || err != nil {
+		t.Fatal("other channel", sent, err)
+	}
+	expectFrames(t, other)
+
+	if sent, _ := other.Channel.EmitIf(nil, "feature"); !sent {
+		t.Fatal("nil predicate did not send")
+	}
+	expectFrames(t, other, `42["feature"]`)
+}
+
+func TestEmitIfMutedOrFailed(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.Mute("feature")
+
+	if sent, err := h.Channel.EmitIf(nil, "feature", 1); sent || err != nil {
+		t.Fatal("muted", sent, err)
+	}
+	if sent, err := h.Channel.EmitIf(nil, "bad", make(chan int)); sent || err == nil {
+		t.Fatal("unmarshalable argument", sent, err)
+	}
+	expectFrames(t, h)
+}
+
+func TestEmitUnencodableArgs(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	before := h.Channel.Stats().DirectEnqueued
+
+	err := h.Channel.Emit("bad", make(chan int))
+	if !errors.Is(err, ErrorEncodeArgs) || !strings.Contains(err.Error(), "chan int") {
+		t.Fatal(err)
+	}
+	_, err = h.Channel.EmitIf(nil, "bad", 1, func() {})
+	if !errors.Is(err, ErrorEncodeArgs) || !strings.Contains(err.Error(), "argument 1") {
+		t.Fatal(err)
+	}
+
+	if len(h.Channel.out) != 0 || h.Channel.Stats().DirectEnqueued != before {
+		t.Fatal("unencodable message queued")
+	}
+	expectFrames(t, h)
+	if !h.Channel.IsAlive() {
+		t.Fatal("channel closed")
+	}
+}
@@ -2,14 +2,20 @@ package gophersocket
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/whiterabb17/gopher-socket/protocol"
@@ -21,35 +27,133 @@ const (
 )
 
 var (
-	ErrorServerNotSet       = errors.New("Server not set")
-	ErrorConnectionNotFound = errors.New("Connection not found")
+	ErrorServerNotSet             = errors.New("Server not set")
+	ErrorConnectionNotFound       = errors.New("Connection not found")
+	ErrorConnNotSupported         = errors.New("Transport does not support raw connections")
+	ErrorSubprotocolsNotSupported = errors.New("Transport does not support subprotocols")
+	ErrorTooManyRooms             = errors.New("Channel joined maximum number of rooms")
 )
 
 /**
 socket.io server instance
 */
 type Server struct {
+	//accessed atomically, kept first for 64-bit alignment
+	messagesReceived      int64
+	controlFramesReceived int64
+	outBytes              int64
+
 	methods
 	http.Handler
 
-	headers      map[string]string
+	headers map[string]string
+
+	//rooms are keyed by roomKey of namespace and name, so rooms
+	//of the same name in different namespaces are distinct
 	channels     map[string]map[*Channel]struct{}
 	rooms        map[*Channel]map[string]struct{}
 	channelsLock sync.RWMutex
 
-	sids     map[string]*Channel
-	sidsLock sync.RWMutex
+	presenceRooms    map[string]struct{}
+	presencePending  map[string]map[string]*pendingLeave
+	presenceDebounce time.Duration
+
+	roomCoalescing map[string]time.Duration
+	roomCapacity   map[string]int
+
+	stateSyncs map[string]*StateSync
+
+	dedupe broadcastDedupe
+
+	roomLimits         map[string]*roomLimit
+	onBroadcastDropped func(room, method string)
+	limitsLock         sync.RWMutex
+
+	sids            map[string]*Channel
+	registryMax     int
+	registryIdle    time.Duration
+	registrySweeper Timer
+	zombiesEvicted  int64
+	liveEvicted     int64
+	sidsLock        sync.RWMutex
 
 	tr transport.Transport
+
+	fallbackDecoder func(raw string) (*protocol.Message, error)
+	fallbackHandler http.Handler
+
+	connectGuard func(c *Channel) error
+
+	namespacePolicy atomic.Value
+
+	//namespaces created with Of, accepted whatever the policy
+	namespaces sync.Map
+
+	defaultRoom string
+
+	maxRoomsPerChannel int
+
+	directShare      atomic.Value
+	broadcastWorkers atomic.Value
+	outQueueSizing   atomic.Value
+
+	joinGuard func(c *Channel, nsp, room string) error
+	onJoin    func(c *Channel, room string)
+	onLeave   func(c *Channel, room string)
+
+	welcomeEvent   string
+	welcomePayload func(c *Channel) interface{}
+
+	maxLifetime time.Duration
+
+	channelContext func(c *Channel) context.Context
+
+	admission atomic.Value
+
+	appHeartbeat atomic.Value
+
+	headerMarshaler func(c *Channel, h Header) ([]byte, error)
+
+	bus eventBus
+
+	collectors sync.Map
+
+	clientPingMin time.Duration
+	clientPingMax time.Duration
+
+	authVerify          func(c *Channel, auth json.RawMessage) error
+	authGrace           time.Duration
+	authRefreshInterval time.Duration
+
+	sidGenerator func(r *http.Request) string
+
+	breaker *circuitBreaker
+
+	sessions *sessionIndex
+
+	duplicateSid *duplicateSidPolicy
+
+	connMix     map[connKind]int
+	connMixLock sync.Mutex
+
+	idempotency      IdempotencyStore
+	idempotencyCalls map[string]*idempotencyCall
+	idempotencyLock  sync.Mutex
+
+	streams         map[string]*reliableStream
+	reliableHistory int
+	reliableKeep    time.Duration
+	recoverRooms    bool
+	sessionStore    SessionStore
+	streamsLock     sync.Mutex
 }
 
 /**
-Close current channel
+Close current channel, client is sent disconnect packet first,
+so it reports the disconnection as done by server
 */
 func (c *Channel) Close() {
-	if c.server != nil {
-		closeChannel(c, &c.server.methods)
-	}
+	c.disconnectByServer(nil)
 }
 
 /**
@@ -78,7 +182,8 @@ func (c *Channel) Request() *http.Request {
 }
 
 /**
-Get channel by it's sid
+Get channel by it's sid. The channel may be kept and used from any
+goroutine, once it disconnects its emits fail with ErrorChannelClosed
 */
 func (s *Server) GetChannel(sid string) (*Channel, error) {
 	s.sidsLock.RLock()
@@ -93,57 +198,228 @@ func (s *Server) GetChannel(sid string) (*Channel, error) {
 }
 
 /**
-Join this channel to given room
+Emit event with positional arguments to channel with given sid, e.g.
+from a background job without reference to the channel. Safe to call
+from any goroutine, concurrently with connects and disconnects: unknown
+sid fails with ErrorConnectionNotFound, disconnected channel with
+ErrorChannelClosed, and message queued while the channel disconnects
+is dropped with its out queue. Broadcasts are safe in the same way
+*/
+func (s *Server) EmitTo(sid, method string, args ...interface{}) error {
+	c, err := s.GetChannel(sid)
+	if err != nil {
+		return err
+	}
+
+	return c.emitArgs(method, args)
+}
+
+/**
+Join this channel to given room, join guard of the server is consulted
+first, and its error is returned if the join is not allowed
+
+Once Join returns, the channel gets all broadcasts to the room
+started after it, from any goroutine. Rooms of the channel belong
+to the root namespace "/", see Server.Of for rooms of other ones.
+Names containing NUL character are rejected with ErrorInvalidRoomName
 */
 func (c *Channel) Join(room string) error {
 	if c.server == nil {
 		return ErrorServerNotSet
 	}
 
-	c.server.channelsLock.Lock()
-	defer c.server.channelsLock.Unlock()
+	return c.server.joinRoom(c, "/", room)
+}
 
-	cn := c.server.channels
+/**
+Remove this channel from given room
+*/
+func (c *Channel) Leave(room string) error {
+	if c.server == nil {
+		return ErrorServerNotSet
+	}
+
+	c.server.leaveRoom(c, "/", room)
+	return nil
+}
+
+/**
+Join channel to the room of given namespace, consulting join guard
+and calling OnJoin with the room name within the namespace
+*/
+func (s *Server) joinRoom(c *Channel, nsp, room string) error {
+	if err := checkRoomName(room); err != nil {
+		return err
+	}
+	if guard := s.joinGuard; guard != nil {
+		if err := guard(c, nsp, room); err != nil {
+			return err
+		}
+	}
+
+	key := roomKey(nsp, room)
+	s.channelsLock.Lock()
+	if s.roomsFull(c, key) {
+		s.channelsLock.Unlock()
+		return ErrorTooManyRooms
+	}
+	if s.roomFull(c, key) {
+		s.channelsLock.Unlock()
+		return ErrorRoomFull
+	}
+	joined := s.join(c, key)
+	s.channelsLock.Unlock()
+
+	if joined && s.onJoin != nil {
+		s.onJoin(c, room)
+	}
+
+	return nil
+}
+
+/**
+Remove channel from the room of given namespace, calling OnLeave
+*/
+func (s *Server) leaveRoom(c *Channel, nsp, room string) {
+	s.channelsLock.Lock()
+	left := s.leave(c, roomKey(nsp, room))
+	s.channelsLock.Unlock()
+
+	if left && s.onLeave != nil {
+		s.onLeave(c, room)
+	}
+}
+
+/**
+Get rooms of given namespace the channel is joined to, sorted
+*/
+func (s *Server) roomsOf(c *Channel, nsp string) []string {
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	rooms := make([]string, 0, len(s.rooms[c]))
+	for key := range s.rooms[c] {
+		if keyNsp, room := splitRoomKey(key); keyNsp == nsp {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Strings(rooms)
+
+	return rooms
+}
+
+/**
+Add channel to given room, should be called under channelsLock,
+returns false if the channel is already there
+*/
+func (s *Server) join(c *Channel, room string) bool {
+	cn := s.channels
 	if _, ok := cn[room]; !ok {
 		cn[room] = make(map[*Channel]struct{})
 	}
 
-	byRoom := c.server.rooms
+	byRoom := s.rooms
 	if _, ok := byRoom[c]; !ok {
 		byRoom[c] = make(map[string]struct{})
 	}
 
+	if _, ok := cn[room][c]; ok {
+		return false
+	}
+
 	cn[room][c] = struct{}{}
 	byRoom[c][room] = struct{}{}
+	s.presenceJoined(c, room)
+	s.stateSyncJoined(c, room)
 
-	return nil
+	return true
 }
 
 /**
-Remove this channel from given room
+Remove channel from given room, should be called under channelsLock,
+returns false if the channel is not there
 */
-func (c *Channel) Leave(room string) error {
-	if c.server == nil {
-		return ErrorServerNotSet
-	}
-
-	c.server.channelsLock.Lock()
-	defer c.server.channelsLock.Unlock()
-
-	cn := c.server.channels
+func (s *Server) leave(c *Channel, room string) bool {
+	cn := s.channels
+	_, joined := cn[room][c]
 	if _, ok := cn[room]; ok {
 		delete(cn[room], c)
 		if len(cn[room]) == 0 {
 			delete(cn, room)
+			s.forgetDedupe(room)
 		}
 	}
 
-	byRoom := c.server.rooms
+	byRoom := s.rooms
 	if _, ok := byRoom[c]; ok {
 		delete(byRoom[c], room)
 	}
 
-	return nil
+	if joined {
+		s.presenceLeft(c, room)
+	}
+
+	return joined
+}
+
+/**
+Set room every new channel joins before OnConnection, with join guard
+and OnJoin as for Join, it is left on disconnection as other rooms.
+Empty name disables it
+*/
+func (s *Server) SetDefaultRoom(room string) {
+	s.defaultRoom = room
+}
+
+/**
+Limit amount of rooms each channel may join, Join returns
+ErrorTooManyRooms over it. Zero means no limit, as by default
+*/
+func (s *Server) SetMaxRoomsPerChannel(n int) {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	s.maxRoomsPerChannel = n
+}
+
+/**
+Check that channel may not join one more room, should be called
+under channelsLock. Joining a room it is in already is allowed
+*/
+func (s *Server) roomsFull(c *Channel, room string) bool {
+	if s.maxRoomsPerChannel <= 0 {
+		return false
+	}
+	rooms := s.rooms[c]
+	if _, ok := rooms[room]; ok {
+		return false
+	}
+
+	return len(rooms) >= s.maxRoomsPerChannel
+}
+
+/**
+Set function allowing or forbidding channels to join rooms, it gets
+the namespace, "/" for the root one, and the room name within it.
+Non-nil error prevents the join and is returned by Join
+*/
+func (s *Server) SetJoinGuard(guard func(c *Channel, nsp, room string) error) {
+	s.joinGuard = guard
+}
+
+/**
+Set function called after channel joined a room
+*/
+func (s *Server) OnJoin(f func(c *Channel, room string)) {
+	s.onJoin = f
+}
+
+/**
+Set function called after channel left a room, including
+leaving all rooms on disconnection
+*/
+func (s *Server) OnLeave(f func(c *Channel, room string)) {
+	s.onLeave = f
 }
 
 /**
@@ -179,6 +455,17 @@ func (c *Channel) List(room string) []*Channel {
 	return c.server.List(room)
 }
 
+/**
+Get rooms of the root namespace the channel is joined to, sorted
+*/
+func (c *Channel) Rooms() []string {
+	if c.server == nil {
+		return []string{}
+	}
+
+	return c.server.roomsOf(c, "/")
+}
+
 /**
 Get list of channels, joined to given room, using server
 */
@@ -202,43 +489,109 @@ func (s *Server) List(room string) []*Channel {
 
 }
 
-func (c *Channel) BroadcastTo(room, method string, args interface{}) {
+/**
+Broadcast message to all room channels except this one
+*/
+func (c *Channel) BroadcastTo(room, method string, args interface{}) error {
+	return c.BroadcastToRoom(room, method, args)
+}
+
+/**
+Broadcast message with any amount of arguments to all room channels
+except this one, if this channel is not joined to the room,
+all room channels get the message
+*/
+func (c *Channel) BroadcastToRoom(room, method string, args ...interface{}) error {
 	if c.server == nil {
-		return
+		return ErrorServerNotSet
+	}
+
+	return c.server.broadcast("/", room, method, args, c)
+}
+
+/**
+Broadcast message to all room channels, error is returned
+if room limit is exceeded, see SetRoomLimit
+*/
+func (s *Server) BroadcastTo(room, method string, args interface{}) error {
+	return s.broadcast("/", room, method, []interface{}{args}, nil)
+}
+
+/**
+Send message to all alive channels of the room of given namespace
+except the given one
+
+Message is encoded once and put to out queues of all members
+while room membership is locked, so every channel which joined
+the room before the broadcast started gets it, and broadcasts
+from one goroutine reach each channel in order
+*/
+func (s *Server) broadcast(nsp, room, method string, args []interface{}, except *Channel) error {
+	if err := checkRoomName(room); err != nil {
+		return err
+	}
+	room = roomKey(nsp, room)
+	if isRootNamespace(nsp) {
+		nsp = ""
+	}
+	if allowed, err := s.allowBroadcast(room, method); !allowed {
+		return err
 	}
 
-	c.server.channelsLock.RLock()
-	defer c.server.channelsLock.RUnlock()
+	command, err := encodeArgs(s.getCodec(), protocol.NewEvent(nsp, method, nil), args)
+	if err != nil {
+		return err
+	}
+
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
 
-	roomChannels, ok := c.server.channels[room]
+	roomChannels, ok := s.channels[room]
 	if !ok {
-		return
+		return nil
+	}
+	if except == nil && s.dedupeBroadcast(room, method, command) {
+		return nil
 	}
 
+	interval, coalesced := s.roomCoalescing[room]
+	members := make([]*Channel, 0, len(roomChannels))
 	for cn := range roomChannels {
-		if cn.Id() != c.Id() && cn.IsAlive() {
-			go cn.Emit(method, args)
+		if cn != except && cn.IsAlive() && !cn.Muted(method) {
+			members = append(members, cn)
 		}
 	}
+
+	s.fanOut(members, func(cn *Channel) {
+		if coalesced {
+			cn.emitCoalesced(room, method, command, interval)
+		} else {
+			cn.enqueueBroadcast(command)
+		}
+	})
+
+	return nil
 }
 
 /**
-Broadcast message to all room channels
+Put pre-encoded engine.io frame to out queues of all alive room channels
+as is, e.g. to fan out a frame relayed from elsewhere. The caller is
+responsible for the frame being valid, it is not checked
 */
-func (s *Server) BroadcastTo(room, method string, args interface{}) {
+func (s *Server) BroadcastRawTo(room, frame string) {
 	s.channelsLock.RLock()
 	defer s.channelsLock.RUnlock()
 
-	roomChannels, ok := s.channels[room]
-	if !ok {
-		return
-	}
-
-	for cn := range roomChannels {
+	members := make([]*Channel, 0, len(s.channels[room]))
+	for cn := range s.channels[room] {
 		if cn.IsAlive() {
-			go cn.Emit(method, args)
+			members = append(members, cn)
 		}
 	}
+
+	s.fanOut(members, func(cn *Channel) {
+		cn.enqueueBroadcast(frame)
+	})
 }
 
 /**
@@ -250,7 +603,7 @@ func (s *Server) BroadcastToAll(method string, args interface{}) {
 
 	for _, cn := range s.sids {
 		if cn.IsAlive() {
-			go cn.Emit(method, args)
+			go cn.emitBroadcast(method, args)
 		}
 	}
 }
@@ -259,7 +612,7 @@ func (s *Server) BroadcastToAll(method string, args interface{}) {
 Generate new id for socket.io connection
 */
 func generateNewId(custom string) string {
-	hash := fmt.Sprintf("%s %s %n %n", custom, time.Now(), rand.Uint32(), rand.Uint32())
+	hash := fmt.Sprintf("%s %s %d %d", custom, time.Now(), rand.Uint32(), rand.Uint32())
 	buf := bytes.NewBuffer(nil)
 	sum := md5.Sum([]byte(hash))
 	encoder := base64.NewEncoder(base64.URLEncoding, buf)
@@ -268,14 +621,64 @@ func generateNewId(custom string) string {
 	return buf.String()[:20]
 }
 
+/**
+Set function generating sid of new connection from its handshake request,
+e.g. from session cookie. Empty sid is replaced with a random one, so is
+sid used by a connected channel, unless SetDuplicateSidPolicy is set
+*/
+func (s *Server) SetSessionIDGenerator(f func(r *http.Request) string) {
+	s.sidGenerator = f
+}
+
+/**
+Get sid for new connection, from generator if it is set. Error means
+the connection should be rejected, as its sid is used
+*/
+func (s *Server) newSid(remoteAddr string, r *http.Request) (string, error) {
+	if s.sidGenerator == nil {
+		return generateNewId(remoteAddr), nil
+	}
+
+	sid := s.sidGenerator(r)
+	if sid == "" {
+		return generateNewId(remoteAddr), nil
+	}
+
+	s.sidsLock.RLock()
+	old, used := s.sids[sid]
+	s.sidsLock.RUnlock()
+	if !used {
+		return sid, nil
+	}
+	if s.duplicateSid == nil {
+		return generateNewId(remoteAddr), nil
+	}
+
+	if err := s.resolveDuplicateSid(old); err != nil {
+		return generateNewId(remoteAddr), err
+	}
+	return sid, nil
+}
+
 /**
 On connection system handler, store sid
+
+Channel closed before the handler runs is not stored, its cleanup
+may be already done, so it would never be removed
 */
 func onConnectStore(c *Channel) {
 	c.server.sidsLock.Lock()
-	defer c.server.sidsLock.Unlock()
-
+	if !c.IsAlive() {
+		c.server.sidsLock.Unlock()
+		return
+	}
 	c.server.sids[c.Id()] = c
+	evicted := c.server.evictOverCap(c)
+	c.server.sidsLock.Unlock()
+
+	if evicted != nil {
+		go evicted.disconnectByServer(ErrorOverCapacity)
+	}
 }
 
 /**
@@ -283,7 +686,6 @@ On disconnection system handler, clean joins and sid
 */
 func onDisconnectCleanup(c *Channel) {
 	c.server.channelsLock.Lock()
-	defer c.server.channelsLock.Unlock()
 
 	cn := c.server.channels
 	byRoom, ok := c.server.rooms[c]
@@ -293,37 +695,87 @@ func onDisconnectCleanup(c *Channel) {
 				delete(curRoom, c)
 				if len(curRoom) == 0 {
 					delete(cn, room)
+					c.server.forgetDedupe(room)
 				}
 			}
+			c.server.presenceLeft(c, room)
 		}
 
 		delete(c.server.rooms, c)
 	}
 
+	c.server.channelsLock.Unlock()
+
 	go deleteSid(c)
+
+	rooms := make([]string, 0, len(byRoom))
+	for room := range byRoom {
+		rooms = append(rooms, room)
+	}
+	c.server.detachStream(c, rooms)
+
+	if c.server.onLeave != nil {
+		for key := range byRoom {
+			_, room := splitRoomKey(key)
+			c.server.onLeave(c, room)
+		}
+	}
 }
 
 func deleteSid(c *Channel) {
 	c.server.sidsLock.Lock()
 	defer c.server.sidsLock.Unlock()
 
-	delete(c.server.sids, c.Id())
+	if c.server.sids[c.Id()] == c {
+		delete(c.server.sids, c.Id())
+	}
 }
 
 func (s *Server) SendOpenSequence(c *Channel) {
-	jsonHdr, err := json.Marshal(&c.header)
+	s.sendOpenPacket(c)
+
+	stream := c.getStream()
+	if stream == nil || !stream.offsets {
+		c.enqueue(protocol.MustEncode(protocol.NewConnect("", nil)))
+	}
+	if stream != nil {
+		stream.lock.Lock()
+		defer stream.lock.Unlock()
+
+		stream.announce(c, 0, false)
+	}
+}
+
+/**
+Set function encoding handshake header of the channel into open packet,
+e.g. with field names expected by a custom client. Nil restores the
+engine.io names: sid, upgrades, pingInterval, pingTimeout. On error the
+header is encoded with the default names
+*/
+func (s *Server) SetHeaderMarshaler(f func(c *Channel, h Header) ([]byte, error)) {
+	s.headerMarshaler = f
+}
+
+/**
+Send engine.io open packet with the header of the channel
+*/
+func (s *Server) sendOpenPacket(c *Channel) {
+	if s.headerMarshaler != nil {
+		jsonHdr, err := s.headerMarshaler(c, c.Handshake())
+		if err == nil {
+			c.enqueue(protocol.MustEncode(protocol.NewOpen(jsonHdr)))
+			return
+		}
+		log.Println("socket.io header marshaler: ", err)
+	}
+
+	hdr := c.Handshake()
+	jsonHdr, err := json.Marshal(&hdr)
 	if err != nil {
 		panic(err)
 	}
 
-	c.out <- protocol.MustEncode(
-		&protocol.Message{
-			Type: protocol.MessageTypeOpen,
-			Args: string(jsonHdr),
-		},
-	)
-
-	c.out <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmpty})
+	c.enqueue(protocol.MustEncode(protocol.NewOpen(jsonHdr)))
 }
 
 /**
@@ -332,48 +784,218 @@ Setup event loop for given connection
 func (s *Server) SetupEventLoop(conn transport.Connection, remoteAddr string,
 	r *http.Request) {
 
-	interval, timeout := conn.PingParams()
+	s.setupChannel(conn, "", remoteAddr, r, false)
+}
+
+/**
+Set up server channel, with manual loops it is left to the caller
+to run them step by step
+*/
+func (s *Server) setupChannel(conn transport.Connection, sid, remoteAddr string,
+	r *http.Request, manualLoops bool) *Channel {
+
+	interval, timeout := s.negotiatePing(conn, r)
+	var sidErr error
+	if sid == "" {
+		sid, sidErr = s.newSid(remoteAddr, r)
+	}
 	hdr := Header{
-		Sid:          generateNewId(remoteAddr),
+		Sid:          sid,
 		Upgrades:     []string{},
 		PingInterval: int(interval / time.Millisecond),
 		PingTimeout:  int(timeout / time.Millisecond),
+
+		ConnectionStateRecovery: s.recoveryEnabled(),
 	}
 
-	c := &Channel{}
-	c.conn = conn
+	c := &Channel{manualLoops: manualLoops}
+	c.setConn(conn)
 	c.ip = remoteAddr
 	c.request = r
-	c.initChannel()
+	if r != nil {
+		c.eio = r.URL.Query().Get("EIO")
+	}
+	c.shared = &s.methods
+	c.initChannel(s.outQueueSize())
 
 	c.server = s
-	c.header = hdr
+	c.setHeader(hdr)
+	c.setTransport(s.tr)
+
+	if sidErr != nil {
+		s.rejectConnect(c, sidErr)
+		return c
+	}
+	if s.connectGuard != nil {
+		if err := s.connectGuard(c); err != nil {
+			s.rejectConnect(c, err)
+			return c
+		}
+	}
+	if err := s.claimSession(c); err != nil {
+		s.rejectConnect(c, err)
+		return c
+	}
 
+	s.openStream(c)
 	s.SendOpenSequence(c)
+	s.startLifetime(c)
+	s.bindChannelContext(c)
+	s.startAuth(c)
+	s.watchBreaker(c, s.breakerKey(remoteAddr, r))
+	s.countConnection(c)
 
-	go inLoop(c, &s.methods)
-	go outLoop(c, &s.methods)
+	c.startLoops(&s.methods)
 
+	if s.defaultRoom != "" {
+		c.Join(s.defaultRoom)
+	}
+	//queued before handlers run, so emits of OnConnection come after it
+	//with any loop event mode
+	if s.welcomeEvent != "" {
+		s.sendWelcome(c)
+	}
 	s.callLoopEvent(c, OnConnection)
+
+	return c
+}
+
+/**
+Set event emitted to each new channel right after open handshake,
+it is queued before OnConnection handlers run, so it is the first event
+the client gets. payloadFunc gets the channel to build its payload,
+empty event disables it
+*/
+func (s *Server) SetWelcomeMessage(event string, payloadFunc func(c *Channel) interface{}) {
+	s.welcomeEvent = event
+	s.welcomePayload = payloadFunc
+}
+
+func (s *Server) sendWelcome(c *Channel) {
+	var payload interface{}
+	if s.welcomePayload != nil {
+		payload = s.welcomePayload(c)
+	}
+
+	c.Emit(s.welcomeEvent, payload)
 }
 
 /**
 implements ServeHTTP function from http.Handler
 */
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.fallbackHandler != nil && !isEngineIORequest(r) {
+		s.fallbackHandler.ServeHTTP(w, r)
+		return
+	}
+
 	for key, el := range s.headers {
 		w.Header().Set(key, el)
 	}
 
+	key := s.breakerKey(r.RemoteAddr, r)
+	if s.shortCircuit(w, key) {
+		return
+	}
+
+	release, err := s.admit(r.Context())
+	if err != nil {
+		if errors.Is(err, ErrorServerFull) {
+			s.refuseFull(w)
+		}
+		return
+	}
+
 	conn, err := s.tr.HandleConnection(w, r)
 	if err != nil {
+		release()
+		s.breakerFailure(key)
 		return
 	}
 
-	s.SetupEventLoop(conn, r.RemoteAddr, r)
+	c := s.setupChannel(conn, "", r.RemoteAddr, r, false)
+	c.OnClosed(release)
 	s.tr.Serve(w, r)
 }
 
+/**
+Set handler for requests which are not engine.io ones: not GET, or
+without numeric EIO parameter, e.g. status page on the same path.
+Without it such requests fail with transport error
+*/
+func (s *Server) SetFallbackHandler(h http.Handler) {
+	s.fallbackHandler = h
+}
+
+/**
+Check that request is engine.io handshake or upgrade attempt
+*/
+func isEngineIORequest(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+
+	_, err := strconv.Atoi(r.URL.Query().Get("EIO"))
+	return err == nil
+}
+
+/**
+Set subprotocols the server selects from, in order of preference,
+the selected one is given by Channel.Subprotocol. Client offering none
+of them is connected without subprotocol, or rejected with
+400 response if require is set. Should be called before serving
+*/
+func (s *Server) SetSubprotocols(protocols []string, require bool) error {
+	setter, ok := s.tr.(transport.SubprotocolSetter)
+	if !ok {
+		return ErrorSubprotocolsNotSupported
+	}
+
+	setter.SetSubprotocols(protocols, require)
+	return nil
+}
+
+/**
+Serve connection accepted outside of net/http, e.g. by custom TCP
+front end. Request r is the upgrade request already read from conn,
+upgrade response and engine.io handshake are done here.
+Returns once the event loop is set up, conn is closed on error
+*/
+func (s *Server) ServeConn(conn net.Conn, r *http.Request) error {
+	handler, ok := s.tr.(transport.ConnHandler)
+	if !ok {
+		conn.Close()
+		return ErrorConnNotSupported
+	}
+
+	key := s.breakerKey(conn.RemoteAddr().String(), r)
+	if s.shortCircuitConn(conn, key) {
+		conn.Close()
+		return ErrorCircuitOpen
+	}
+
+	release, err := s.admit(r.Context())
+	if err != nil {
+		if errors.Is(err, ErrorServerFull) {
+			s.refuseFullConn(conn)
+		}
+		conn.Close()
+		return err
+	}
+
+	tc, err := handler.HandleConn(conn, r)
+	if err != nil {
+		release()
+		s.breakerFailure(key)
+		conn.Close()
+		return err
+	}
+
+	c := s.setupChannel(tc, "", conn.RemoteAddr().String(), r, false)
+	c.OnClosed(release)
+	return nil
+}
+
 /**
 Get amount of current connected sids
 */
@@ -409,6 +1031,30 @@ func (s *Server) AddHeader(name string, value string) {
 	s.headers[name] = value
 }
 
+/**
+Set decoder for frames rejected by the standard one, e.g. sent
+by legacy clients. If it fails too, the channel is closed
+*/
+func (s *Server) SetFallbackDecoder(f func(raw string) (*protocol.Message, error)) {
+	s.fallbackDecoder = f
+}
+
+/**
+Decode frame with fallback decoder if it is set, or return given error
+*/
+func (c *Channel) fallbackDecode(raw string, err error) (*protocol.Message, error) {
+	if c.server == nil || c.server.fallbackDecoder == nil {
+		return nil, err
+	}
+
+	msg, err := c.server.fallbackDecoder(raw)
+	if err == nil && msg == nil {
+		return nil, protocol.ErrorWrongPacket
+	}
+
+	return msg, err
+}
+
 /**
 Replaces the pre-configured transport
 */
@@ -427,6 +1073,13 @@ func NewServer(tr transport.Transport) *Server {
 	s.channels = make(map[string]map[*Channel]struct{})
 	s.rooms = make(map[*Channel]map[string]struct{})
 	s.sids = make(map[string]*Channel)
+	s.presenceRooms = make(map[string]struct{})
+	s.presencePending = make(map[string]map[string]*pendingLeave)
+	s.roomCoalescing = make(map[string]time.Duration)
+	s.roomCapacity = make(map[string]int)
+	s.stateSyncs = make(map[string]*StateSync)
+	s.roomLimits = make(map[string]*roomLimit)
+	s.streams = make(map[string]*reliableStream)
 	s.onConnection = onConnectStore
 	s.onDisconnection = onDisconnectCleanup
 
@@ -0,0 +1,657 @@
+package gophersocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+/**
+Create harness channel with open sequence already written
+*/
+func newOpenHarness(s *Server) *LoopHarness {
+	h := NewLoopHarness(s)
+	h.Pump()
+	h.Frames()
+	return h
+}
+
+/**
+Check that the harness channel wrote exactly given frames
+*/
+func expectFrames(t testing.TB, h *LoopHarness, want ...string) {
+	t.Helper()
+
+	h.Pump()
+	got := h.Frames()
+	if len(got) != len(want) {
+		t.Fatalf("got frames %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got frames %q, want %q", got, want)
+		}
+	}
+}
+
+func TestBroadcastToRoomExcludesCaller(t *testing.T) {
+	s := newTestServer()
+	sender, member, other := newOpenHarness(s), newOpenHarness(s), newOpenHarness(s)
+	sender.Channel.Join("room")
+	member.Channel.Join("room")
+	other.Channel.Join("other")
+
+	if err := sender.Channel.BroadcastToRoom("room", "ev", 1, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFrames(t, sender)
+	expectFrames(t, member, `42["ev",1,"a"]`)
+	expectFrames(t, other)
+}
+
+func TestBroadcastToRoomFromNonMember(t *testing.T) {
+	s := newTestServer()
+	sender, first, second := newOpenHarness(s), newOpenHarness(s), newOpenHarness(s)
+	first.Channel.Join("room")
+	second.Channel.Join("room")
+
+	if err := sender.Channel.BroadcastToRoom("room", "ev", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFrames(t, sender)
+	expectFrames(t, first, `42["ev","x"]`)
+	expectFrames(t, second, `42["ev","x"]`)
+}
+
+func TestBroadcastToRoomSkipsClosed(t *testing.T) {
+	s := newTestServer()
+	sender, alive, closed := newOpenHarness(s), newOpenHarness(s), newOpenHarness(s)
+	for _, h := range []*LoopHarness{sender, alive, closed} {
+		h.Channel.Join("room")
+	}
+	closed.Feed("41")
+
+	if err := sender.Channel.BroadcastToRoom("room", "ev"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFrames(t, alive, `42["ev"]`)
+	expectFrames(t, closed)
+}
+
+/**
+Decoder of frames like "legacy|event|text"
+*/
+func decodeLegacy(raw string) (*protocol.Message, error) {
+	parts := strings.Split(raw, "|")
+	if len(parts) != 3 || parts[0] != "legacy" {
+		return nil, errors.New("not a legacy frame")
+	}
+
+	arg, _ := json.Marshal(parts[2])
+	return &protocol.Message{Type: protocol.MessageTypeEmit, Method: parts[1], Args: string(arg)}, nil
+}
+
+func TestBroadcastRawTo(t *testing.T) {
+	s := newTestServer()
+	first, second, closed, other := newOpenHarness(s), newOpenHarness(s), newOpenHarness(s), newOpenHarness(s)
+	for _, h := range []*LoopHarness{first, second, closed} {
+		h.Channel.Join("room")
+	}
+	other.Channel.Join("other")
+	closeChannel(closed.Channel, closed.methods, DisconnectServer, CloseKicked, nil)
+	closed.Pump()
+	closed.Frames()
+
+	//written as is, not even checked
+	frame := `42["relayed",{"b":1,"a":2}]  `
+	s.BroadcastRawTo("room", frame)
+
+	expectFrames(t, first, frame)
+	expectFrames(t, second, frame)
+	expectFrames(t, closed)
+	expectFrames(t, other)
+}
+
+func TestFallbackDecoderRescuesFrame(t *testing.T) {
+	s := newTestServer()
+	s.SetFallbackDecoder(decodeLegacy)
+	var got []string
+	s.On("ev", func(c *Channel, v string) { got = append(got, v) })
+
+	h := newOpenHarness(s)
+	if err := h.Feed("legacy|ev|old"); err != nil {
+		t.Fatal(err)
+	}
+	dispatchEvent(t, h.Channel, "ev", "new")
+
+	if strings.Join(got, " ") != "old new" || !h.Channel.IsAlive() {
+		t.Fatal(got, h.Channel.IsAlive())
+	}
+}
+
+func TestFallbackDecoderFails(t *testing.T) {
+	s := newTestServer()
+	s.SetFallbackDecoder(decodeLegacy)
+
+	h := newOpenHarness(s)
+	if err := h.Feed("garbage"); err == nil {
+		t.Fatal("expected error for frame rejected by both decoders")
+	}
+	if h.Channel.IsAlive() {
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestFallbackDecoderNotSet(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	if err := h.Feed("legacy|ev|old"); err == nil || h.Channel.IsAlive() {
+		t.Fatal("non-standard frame accepted without fallback decoder")
+	}
+}
+
+var errRoomForbidden = errors.New("room forbidden")
+
+func TestJoinGuard(t *testing.T) {
+	s := newTestServer()
+	s.SetJoinGuard(func(c *Channel, nsp, room string) error {
+		if room == "private" {
+			return errRoomForbidden
+		}
+		return nil
+	})
+	h := NewLoopHarness(s)
+
+	if err := h.Channel.Join("private"); err != errRoomForbidden {
+		t.Fatal("guarded join:", err)
+	}
+	if err := h.Channel.Join("public"); err != nil {
+		t.Fatal("allowed join:", err)
+	}
+	if s.Amount("private") != 0 || s.Amount("public") != 1 {
+		t.Fatal("members", s.Amount("private"), s.Amount("public"))
+	}
+}
+
+func TestJoinGuardNamespace(t *testing.T) {
+	s := newTestServer()
+	s.SetNamespacePolicy(NamespaceAutoCreate)
+	var joins []string
+	s.SetJoinGuard(func(c *Channel, nsp, room string) error {
+		joins = append(joins, nsp+" "+room)
+		if nsp == "/admin" {
+			return errRoomForbidden
+		}
+		return nil
+	})
+	h := newOpenHarness(s)
+	for _, frame := range []string{"40/admin,", "40/chat,"} {
+		if err := h.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Of("/admin").Join(h.Channel, "lobby"); err != errRoomForbidden {
+		t.Fatal("guarded join:", err)
+	}
+	if err := s.Of("/chat").Join(h.Channel, "lobby"); err != nil {
+		t.Fatal(err)
+	}
+	h.Channel.Join("lobby")
+	if strings.Join(joins, ",") != "/admin lobby,/chat lobby,/ lobby" {
+		t.Fatal(joins)
+	}
+}
+
+func TestJoinLeaveHooks(t *testing.T) {
+	s := newTestServer()
+	var events []string
+	s.SetJoinGuard(func(c *Channel, nsp, room string) error {
+		if room == "private" {
+			return errRoomForbidden
+		}
+		return nil
+	})
+	s.OnJoin(func(c *Channel, room string) { events = append(events, "join:"+room) })
+	s.OnLeave(func(c *Channel, room string) { events = append(events, "leave:"+room) })
+	h := NewLoopHarness(s)
+
+	//repeated and rejected changes are not reported
+	h.Channel.Join("a")
+	h.Channel.Join("a")
+	h.Channel.Join("private")
+	h.Channel.Leave("a")
+	h.Channel.Leave("a")
+	h.Channel.Join("b")
+	h.Channel.Close()
+
+	if strings.Join(events, " ") != "join:a leave:a join:b leave:b" {
+		t.Fatal(events)
+	}
+}
+
+func TestWelcomeMessageArrivesFirst(t *testing.T) {
+	s := newTestServer()
+	s.SetWelcomeMessage("welcome", func(c *Channel) interface{} {
+		return map[string]string{"sid": c.Id()}
+	})
+	s.On("ev", func(c *Channel) { c.Emit("reply", nil) })
+
+	h := NewLoopHarness(s)
+	feedEvent(t, h, "ev")
+	h.Pump()
+
+	var events []string
+	for _, frame := range h.Frames() {
+		if strings.HasPrefix(frame, "42") {
+			events = append(events, frame)
+		}
+	}
+	want := `42["welcome",{"sid":"` + h.Channel.Id() + `"}]`
+	if len(events) != 2 || events[0] != want || events[1] != `42["reply"]` {
+		t.Fatalf("got events %q, want %q first", events, want)
+	}
+}
+
+/**
+Pump the harness until it wrote given amount of event frames, returns them
+*/
+func waitEvents(t testing.TB, h *LoopHarness, n int) []string {
+	t.Helper()
+
+	var events []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(events) < n && time.Now().Before(deadline) {
+		h.Pump()
+		for _, frame := range h.Frames() {
+			if strings.HasPrefix(frame, "42") {
+				events = append(events, frame)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(events) < n {
+		t.Fatalf("got events %q, want %d", events, n)
+	}
+
+	return events
+}
+
+func TestWelcomeMessageBeforeConnectionEmits(t *testing.T) {
+	s := newTestServer()
+	s.SetWelcomeMessage("welcome", nil)
+	s.On(OnConnection, func(c *Channel) { c.Emit("hello", nil) })
+
+	h := NewLoopHarness(s)
+	events := waitEvents(t, h, 2)
+	if events[0] != `42["welcome"]` || events[1] != `42["hello"]` {
+		t.Fatalf("got events %q, want welcome first", events)
+	}
+}
+
+func TestWelcomeMessageDisabled(t *testing.T) {
+	s := newTestServer()
+	s.SetWelcomeMessage("welcome", nil)
+	s.SetWelcomeMessage("", nil)
+
+	h := NewLoopHarness(s)
+	h.Pump()
+	for _, frame := range h.Frames() {
+		if strings.HasPrefix(frame, "42") {
+			t.Fatal("welcome sent while disabled:", frame)
+		}
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	s.On("echo", func(c *Channel, v string) string { return "re:" + v })
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	served := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		r, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			served <- err
+			return
+		}
+		served <- s.ServeConn(conn, r)
+	}()
+
+	client, err := Dial("ws://"+ln.Addr().String()+socketioUrl, transport.GetDefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	c := <-connected
+	if c.Id() != client.Id() {
+		t.Fatal("sid", c.Id(), client.Id())
+	}
+	if !c.Ping(5 * time.Second) {
+		t.Fatal("served connection not answering")
+	}
+	if reply, err := client.Ack("echo", "hi", 5*time.Second); err != nil || reply != `"re:hi"` {
+		t.Fatal("ack over served connection", reply, err)
+	}
+}
+
+func TestServeConnBadUpgrade(t *testing.T) {
+	s := newTestServer()
+	server, client := net.Pipe()
+	defer client.Close()
+	go ioutil.ReadAll(client)
+
+	//plain request, no websocket upgrade
+	r := httptest.NewRequest(http.MethodGet, socketioUrl, nil)
+	if err := s.ServeConn(server, r); err == nil {
+		t.Fatal("connection without upgrade served")
+	}
+	if n := s.AmountOfSids(); n != 0 {
+		t.Fatal("channel set up", n)
+	}
+}
+
+/**
+Transport without support for raw connections
+*/
+type httpOnlyTransport struct {
+	transport.Transport
+}
+
+func TestServeConnNotSupported(t *testing.T) {
+	s := NewServer(httpOnlyTransport{transport.GetDefaultWebsocketTransport()})
+	server, client := net.Pipe()
+	defer client.Close()
+
+	if err := s.ServeConn(server, nil); err != ErrorConnNotSupported {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("x")); err == nil {
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestJoinThenBroadcastStress(t *testing.T) {
+	s := newTestServer()
+
+	const workers, rounds = 8, 100
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			for i := 0; i < rounds; i++ {
+				h := newOpenHarness(s)
+				if err := h.Channel.Join("room"); err != nil {
+					errs <- err
+					return
+				}
+				id := fmt.Sprintf("%d-%d", w, i)
+				if err := s.BroadcastTo("room", "ev", id); err != nil {
+					errs <- err
+					return
+				}
+
+				//member joined before the broadcast started must get it
+				h.Pump()
+				want := `42["ev","` + id + `"]`
+				found := false
+				for _, frame := range h.Frames() {
+					found = found || frame == want
+				}
+				//disconnect by peer, Close would wait for out loop to flush
+				h.Feed("41")
+				if !found {
+					errs <- fmt.Errorf("member missed broadcast %s", id)
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFallbackHandler(t *testing.T) {
+	s := newTestServer()
+	s.SetFallbackHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("status page"))
+	}))
+
+	for _, tc := range []struct {
+		method, target string
+		fallback       bool
+	}{
+		{http.MethodGet, "/health", true},
+		{http.MethodGet, "/socket.io/", true},
+		{http.MethodGet, "/socket.io/?EIO=x", true},
+		{http.MethodPost, socketioUrl, true},
+		//engine.io request without upgrade gets transport error
+		{http.MethodGet, socketioUrl, false},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if got := w.Body.String() == "status page"; got != tc.fallback {
+			t.Errorf("%s %s: fallback %v, want %v", tc.method, tc.target, got, tc.fallback)
+		}
+	}
+
+	//clients still connect
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	if !client.IsAlive() {
+		t.Fatal("client not connected")
+	}
+}
+
+func TestIsEngineIORequest(t *testing.T) {
+	for target, want := range map[string]bool{
+		"/socket.io/?EIO=4&transport=websocket": true,
+		"/other/path?EIO=3":                     true,
+		"/socket.io/?transport=websocket":       false,
+		"/socket.io/?EIO=":                      false,
+		"/":                                     false,
+	} {
+		if got := isEngineIORequest(httptest.NewRequest(http.MethodGet, target, nil)); got != want {
+			t.Errorf("%s: %v, want %v", target, got, want)
+		}
+	}
+	if isEngineIORequest(httptest.NewRequest(http.MethodPost, "/socket.io/?EIO=4", nil)) {
+		t.Error("polling post taken for engine.io request")
+	}
+}
+
+func TestFallbackHandlerNotSet(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestServer().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/socket.io/", nil))
+	if w.Code == http.StatusOK {
+		t.Fatal("plain request served without fallback handler")
+	}
+}
+
+func TestSessionIDGeneratorFromCookie(t *testing.T) {
+	s := newTestServer()
+	s.SetSessionIDGenerator(func(r *http.Request) string {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			return ""
+		}
+		return "session-" + cookie.Value
+	})
+	connected := make(chan *Channel, 3)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+	dial := func(cookie string) *Client {
+		t.Helper()
+
+		opts := DialOptions{}
+		if cookie != "" {
+			opts.Header = http.Header{"Cookie": {"session=" + cookie}}
+		}
+		client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	client := dial("abc")
+	defer client.Close()
+	sc := <-connected
+	if client.header.Sid != "session-abc" || client.Id() != "session-abc" || sc.Id() != "session-abc" {
+		t.Fatal(client.header.Sid, client.Id(), sc.Id())
+	}
+	if registered, err := s.GetChannel("session-abc"); err != nil || registered != sc {
+		t.Fatal("channel not registered by derived sid")
+	}
+
+	//sid of connected channel is not reused
+	dup := dial("abc")
+	defer dup.Close()
+	<-connected
+	if dup.Id() == "session-abc" || dup.Id() == "" {
+		t.Fatal("collision not regenerated:", dup.Id())
+	}
+
+	//no cookie, random sid
+	anon := dial("")
+	defer anon.Close()
+	<-connected
+	if anon.Id() == "" || strings.HasPrefix(anon.Id(), "session-") {
+		t.Fatal("sid without cookie:", anon.Id())
+	}
+}
+
+func TestDefaultRoom(t *testing.T) {
+	s := newTestServer()
+	s.SetDefaultRoom("global")
+	var events []string
+	s.OnJoin(func(c *Channel, room string) { events = append(events, "join:"+room) })
+	s.OnLeave(func(c *Channel, room string) { events = append(events, "leave:"+room) })
+	s.On(OnConnection, func(c *Channel) {
+		events = append(events, fmt.Sprint("connected:", c.Rooms()))
+	})
+
+	h1 := newOpenHarness(s)
+	h2 := newOpenHarness(s)
+	if s.Amount("global") != 2 {
+		t.Fatal("members", s.Amount("global"))
+	}
+	s.BroadcastTo("global", "hi", 1)
+	expectFrames(t, h1, `42["hi",1]`)
+	expectFrames(t, h2, `42["hi",1]`)
+
+	h1.Feed("41")
+	if s.Amount("global") != 1 {
+		t.Fatal("members after leave", s.Amount("global"))
+	}
+
+	want := "join:global connected:[global] join:global connected:[global] leave:global"
+	if strings.Join(events, " ") != want {
+		t.Fatal(events)
+	}
+}
+
+func TestDefaultRoomUnset(t *testing.T) {
+	s := newTestServer()
+	s.SetDefaultRoom("global")
+	s.SetDefaultRoom("")
+
+	h := NewLoopHarness(s)
+	if rooms := h.Channel.Rooms(); len(rooms) != 0 {
+		t.Fatal(rooms)
+	}
+}
+
+func TestMaxRoomsPerChannel(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxRoomsPerChannel(2)
+	h := newOpenHarness(s)
+
+	for _, room := range []string{"a", "b", "a"} {
+		if err := h.Channel.Join(room); err != nil {
+			t.Fatal(room, err)
+		}
+	}
+	if err := h.Channel.Join("c"); err != ErrorTooManyRooms {
+		t.Fatal("over limit", err)
+	}
+	if s.Amount("c") != 0 {
+		t.Fatal("joined over limit")
+	}
+
+	h.Channel.Leave("a")
+	if err := h.Channel.Join("c"); err != nil {
+		t.Fatal("after leave", err)
+	}
+
+	//zero is unlimited
+	s.SetMaxRoomsPerChannel(0)
+	for i := 0; i < 10; i++ {
+		if err := h.Channel.Join(fmt.Sprint("room", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHeaderMarshaler(t *testing.T) {
+	s := newTestServer()
+	s.SetHeaderMarshaler(func(c *Channel, h Header) ([]byte, error) {
+		return json.Marshal(map[string]interface{}{"id": h.Sid, "heartbeat": h.PingInterval})
+	})
+	h := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "abc"})
+
+	h.Pump()
+	frames := h.Frames()
+	want := fmt.Sprintf(`0{"heartbeat":%d,"id":"abc"}`, h.Channel.Handshake().PingInterval)
+	if len(frames) == 0 || frames[0] != want {
+		t.Fatalf("got frames %q, want %q first", frames, want)
+	}
+}
+
+func TestHeaderMarshalerFails(t *testing.T) {
+	s := newTestServer()
+	s.SetHeaderMarshaler(func(c *Channel, h Header) ([]byte, error) {
+		return nil, errors.New("broken")
+	})
+	h := NewLoopHarnessWithOptions(s, HarnessOptions{Sid: "abc"})
+
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) == 0 || !strings.HasPrefix(frames[0], `0{"sid":"abc","upgrades":`) {
+		t.Fatalf("got frames %q, want default open packet first", frames)
+	}
+}
@@ -0,0 +1,169 @@
+package gophersocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+/**
+What to do with connection of identity which has connected channel already
+*/
+type SessionPolicy int
+
+const (
+	/**
+	Reject the new connection with connect error ErrorDuplicateSession
+	*/
+	SessionRejectNew SessionPolicy = iota
+	/**
+	Accept the new connection, and disconnect the old one
+	with ErrorSessionReplaced
+	*/
+	SessionKickOld
+)
+
+var (
+	ErrorDuplicateSession = errors.New("Duplicate session")
+	ErrorSessionReplaced  = errors.New("Session replaced")
+)
+
+/**
+Identity to channel index of single session enforcement
+*/
+type sessionIndex struct {
+	identity  func(c *Channel) (string, bool)
+	policy    SessionPolicy
+	kickEvent string
+
+	channels map[string]*Channel
+	lock     sync.Mutex
+}
+
+/**
+Allow one connected channel per identity. Identity of new channel is
+taken with given function after connect guard, channels without one
+are not limited. Duplicate is handled with policy, on SessionKickOld
+the old channel is sent kickEvent with ErrorSessionReplaced text before
+it is disconnected, unless kickEvent is empty. Nil identity disables it.
+Should be set before serving
+*/
+func (s *Server) SetSingleSession(identity func(c *Channel) (string, bool), policy SessionPolicy, kickEvent string) {
+	if identity == nil {
+		s.sessions = nil
+		return
+	}
+
+	s.sessions = &sessionIndex{
+		identity:  identity,
+		policy:    policy,
+		kickEvent: kickEvent,
+		channels:  make(map[string]*Channel),
+	}
+}
+
+/**
+Get connected channel of identity, if single session is enforced
+*/
+func (s *Server) SessionChannel(identity string) (*Channel, bool) {
+	if s.sessions == nil {
+		return nil, false
+	}
+
+	s.sessions.lock.Lock()
+	defer s.sessions.lock.Unlock()
+
+	c, ok := s.sessions.channels[identity]
+	return c, ok && c.IsAlive()
+}
+
+/**
+Register new channel in session index, returns error if it should be
+rejected. Check and registration are done under one lock, so of two
+channels connecting with the same identity only one survives
+*/
+func (s *Server) claimSession(c *Channel) error {
+	sessions := s.sessions
+	if sessions == nil {
+		return nil
+	}
+	identity, ok := sessions.identity(c)
+	if !ok {
+		return nil
+	}
+
+	sessions.lock.Lock()
+	old, exists := sessions.channels[identity]
+	if exists && old.IsAlive() && sessions.policy == SessionRejectNew {
+		sessions.lock.Unlock()
+		return ErrorDuplicateSession
+	}
+	sessions.channels[identity] = c
+	sessions.lock.Unlock()
+
+	c.OnClosed(func() {
+		sessions.lock.Lock()
+		defer sessions.lock.Unlock()
+
+		//replaced by newer channel meanwhile
+		if sessions.channels[identity] == c {
+			delete(sessions.channels, identity)
+		}
+	})
+
+	if exists && old.IsAlive() {
+		go s.kickSession(old, sessions.kickEvent)
+	}
+
+	return nil
+}
+
+/**
+Disconnect channel replaced by newer one of the same identity
+*/
+func (s *Server) kickSession(c *Channel, kickEvent string) {
+	if kickEvent != "" {
+		c.Emit(kickEvent, s.systemMessage(c, SystemMessageSessionReplaced, map[string]interface{}{
+			"message": ErrorSessionReplaced.Error(),
+			"error":   ErrorSessionReplaced,
+		}, ErrorSessionReplaced.Error()))
+	}
+	c.disconnectByServer(ErrorSessionReplaced)
+}
+
+type duplicateSidPolicy struct {
+	policy SessionPolicy
+	grace  time.Duration
+}
+
+/**
+Set what happens when sid given by SetSessionIDGenerator belongs to
+a channel still registered, e.g. on fast reconnect before teardown of
+the old connection. The new connection waits up to grace for the old
+channel to close, then takes the sid, otherwise policy decides: the old
+channel is disconnected with ErrorSessionReplaced, or the new connection
+is rejected with ErrorDuplicateSession. By default the new connection
+gets a random sid instead
+*/
+func (s *Server) SetDuplicateSidPolicy(policy SessionPolicy, grace time.Duration) {
+	s.duplicateSid = &duplicateSidPolicy{policy: policy, grace: grace}
+}
+
+/**
+Wait for channel holding sid to close, apply the policy if it does not
+within grace. Error means the new connection is rejected
+*/
+func (s *Server) resolveDuplicateSid(old *Channel) error {
+	select {
+	case <-old.closed:
+		return nil
+	case <-s.getClock().After(s.duplicateSid.grace):
+	}
+
+	if s.duplicateSid.policy == SessionRejectNew && old.IsAlive() {
+		return ErrorDuplicateSession
+	}
+
+	old.disconnectByServer(ErrorSessionReplaced)
+	return nil
+}
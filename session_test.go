@@ -0,0 +1,237 @@
+package gophersocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Server allowing one session per user given in query of upgrade request
+*/
+func newSingleSessionServer(policy SessionPolicy) *Server {
+	s := newTestServer()
+	s.SetSingleSession(func(c *Channel) (string, bool) {
+		user := c.request.URL.Query().Get("user")
+		return user, user != ""
+	}, policy, "kicked")
+
+	return s
+}
+
+func userHarness(s *Server, user string) *LoopHarness {
+	return NewLoopHarnessWithOptions(s, HarnessOptions{
+		Request: httptest.NewRequest("GET", "/socket.io/?EIO=3&transport=websocket&user="+user, nil),
+	})
+}
+
+func TestSingleSessionRejectNew(t *testing.T) {
+	s := newSingleSessionServer(SessionRejectNew)
+
+	first := userHarness(s, "u1")
+	second := userHarness(s, "u1")
+	other := userHarness(s, "u2")
+
+	if first.Channel.connectRejected || other.Channel.connectRejected {
+		t.Fatal("first session rejected")
+	}
+	if !second.Channel.connectRejected {
+		t.Fatal("duplicate session accepted")
+	}
+	second.Pump()
+	frames := second.Frames()
+	if len(frames) != 2 || !strings.HasPrefix(frames[1], "44") ||
+		!strings.Contains(frames[1], ErrorDuplicateSession.Error()) {
+
+		t.Fatalf("got frames %q, want connect error", frames)
+	}
+	if c, ok := s.SessionChannel("u1"); !ok || c != first.Channel {
+		t.Fatal("session channel replaced")
+	}
+
+	//identity is free once its channel is closed
+	closeChannel(first.Channel, first.methods, DisconnectServer, CloseKicked, nil)
+	if _, ok := s.SessionChannel("u1"); ok {
+		t.Fatal("closed channel still holds the session")
+	}
+	third := userHarness(s, "u1")
+	if third.Channel.connectRejected {
+		t.Fatal("session rejected after previous one closed")
+	}
+}
+
+func TestSingleSessionKickOld(t *testing.T) {
+	s := newSingleSessionServer(SessionKickOld)
+
+	first := drainHarness(userHarness(s, "u1"))
+	second := userHarness(s, "u1")
+	if second.Channel.connectRejected {
+		t.Fatal("new session rejected")
+	}
+
+	pumpUntilClosed(t, first)
+	frames := first.Frames()
+	if len(frames) != 2 || !strings.HasPrefix(frames[0], `42["kicked",`) || frames[1] != "41" {
+		t.Fatalf("got frames %q, want kick event and disconnect", frames)
+	}
+	if first.Channel.CloseError() != ErrorSessionReplaced {
+		t.Fatal("close error", first.Channel.CloseError())
+	}
+	if c, ok := s.SessionChannel("u1"); !ok || c != second.Channel {
+		t.Fatal("session channel not replaced")
+	}
+}
+
+func TestSingleSessionWithoutIdentity(t *testing.T) {
+	s := newSingleSessionServer(SessionRejectNew)
+
+	for i := 0; i < 2; i++ {
+		if h := userHarness(s, ""); h.Channel.connectRejected {
+			t.Fatal("channel without identity limited")
+		}
+	}
+}
+
+func TestSingleSessionConcurrent(t *testing.T) {
+	s := newSingleSessionServer(SessionRejectNew)
+
+	hs := make([]*LoopHarness, 20)
+	var wg sync.WaitGroup
+	for i := range hs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hs[i] = userHarness(s, "u1")
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, h := range hs {
+		if !h.Channel.connectRejected {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("%d channels accepted, want 1", accepted)
+	}
+}
+
+/**
+Write open sequence of the harness and drop it
+*/
+func drainHarness(h *LoopHarness) *LoopHarness {
+	h.Pump()
+	h.Frames()
+	return h
+}
+
+/**
+Server taking sid of new connections from user in query, with manual
+clock for grace of duplicate sid policy
+*/
+func newDuplicateSidServer(policy SessionPolicy, grace time.Duration) (*Server, *manualClock) {
+	clock := newManualClock()
+	s := newTestServer()
+	s.SetClock(clock)
+	s.SetSessionIDGenerator(func(r *http.Request) string {
+		return r.URL.Query().Get("user")
+	})
+	s.SetDuplicateSidPolicy(policy, grace)
+
+	return s, clock
+}
+
+func waitSid(t testing.TB, s *Server, sid string, c *Channel) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if registered, err := s.GetChannel(sid); err == nil && registered == c {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("channel not registered with sid %s", sid)
+}
+
+/**
+Connect harness in background, as it waits for the grace
+*/
+func userHarnessAsync(s *Server, user string) chan *LoopHarness {
+	result := make(chan *LoopHarness, 1)
+	go func() { result <- userHarness(s, user) }()
+	return result
+}
+
+func TestDuplicateSidSupersede(t *testing.T) {
+	s, clock := newDuplicateSidServer(SessionKickOld, time.Second)
+	old := drainHarness(userHarness(s, "u1"))
+	waitSid(t, s, "u1", old.Channel)
+
+	result := userHarnessAsync(s, "u1")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	pumpUntilClosed(t, old)
+	h := <-result
+
+	if h.Channel.connectRejected || h.Channel.Id() != "u1" {
+		t.Fatalf("new connection rejected %v with sid %s", h.Channel.connectRejected, h.Channel.Id())
+	}
+	if old.Channel.CloseError() != ErrorSessionReplaced {
+		t.Fatal("close error", old.Channel.CloseError())
+	}
+	if frames := old.Frames(); len(frames) != 1 || frames[0] != "41" {
+		t.Fatalf("got frames %q, want disconnect", frames)
+	}
+	waitSid(t, s, "u1", h.Channel)
+}
+
+func TestDuplicateSidReject(t *testing.T) {
+	s, clock := newDuplicateSidServer(SessionRejectNew, time.Second)
+	old := drainHarness(userHarness(s, "u1"))
+	waitSid(t, s, "u1", old.Channel)
+
+	result := userHarnessAsync(s, "u1")
+	clock.waitTimer(t, time.Second)
+	clock.Advance(time.Second)
+	h := <-result
+
+	if !h.Channel.connectRejected {
+		t.Fatal("duplicate sid accepted")
+	}
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 2 || !strings.HasPrefix(frames[1], "44") ||
+		!strings.Contains(frames[1], ErrorDuplicateSession.Error()) {
+
+		t.Fatalf("got frames %q, want connect error", frames)
+	}
+	if !old.Channel.IsAlive() {
+		t.Fatal("old channel closed")
+	}
+	waitSid(t, s, "u1", old.Channel)
+}
+
+func TestDuplicateSidClosedWithinGrace(t *testing.T) {
+	for _, policy := range []SessionPolicy{SessionRejectNew, SessionKickOld} {
+		s, clock := newDuplicateSidServer(policy, time.Second)
+		old := drainHarness(userHarness(s, "u1"))
+		waitSid(t, s, "u1", old.Channel)
+
+		//fast reconnect, old connection is torn down meanwhile
+		result := userHarnessAsync(s, "u1")
+		clock.waitTimer(t, time.Second)
+		closeChannel(old.Channel, old.methods, DisconnectTransportClose, CloseClientClose, nil)
+		h := <-result
+
+		if h.Channel.connectRejected || h.Channel.Id() != "u1" {
+			t.Fatalf("policy %v: new connection rejected %v with sid %s",
+				policy, h.Channel.connectRejected, h.Channel.Id())
+		}
+		waitSid(t, s, "u1", h.Channel)
+	}
+}
@@ -0,0 +1,185 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+/**
+Storage of resumable sessions of disconnected channels, keyed by id
+of their reliable stream, which clients resume with. Sessions are
+opaque JSON, in the form of streams of ExportState. With a store
+shared by servers, e.g. Redis backed, a client resumes on any of them
+*/
+type SessionStore interface {
+	Get(id string) (session []byte, ok bool, err error)
+	Set(id string, session []byte) error
+	Delete(id string) error
+	Expire(id string, ttl time.Duration) error
+}
+
+/**
+Keep sessions of disconnected channels in given store, for keep time
+of reliable delivery, instead of server memory. A session is removed
+from the store once resumed. If the store fails, the session is kept
+in memory, as without it. Nil restores the default. Should be set
+before serving
+*/
+func (s *Server) SetSessionStore(store SessionStore) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	s.sessionStore = store
+}
+
+/**
+Move state of detached stream to the store, it stays in memory
+if that fails or a channel attaches to the stream meanwhile
+*/
+func (s *Server) storeSession(store SessionStore, stream *reliableStream, st streamState, keep time.Duration) {
+	data, err := json.Marshal(st)
+	if err == nil {
+		err = store.Set(st.Id, data)
+	}
+	if err == nil {
+		err = store.Expire(st.Id, keep)
+	}
+	if err != nil {
+		log.Println("socket.io session store: ", err)
+		return
+	}
+
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+
+	if stream.channel != nil {
+		store.Delete(st.Id)
+		return
+	}
+	if stream.expire != nil {
+		stream.expire.Stop()
+		stream.expire = nil
+	}
+	delete(s.streams, stream.id)
+}
+
+/**
+Take session from the store back to memory, so it can be resumed
+*/
+func (s *Server) loadSession(store SessionStore, id string) (*reliableStream, bool) {
+	data, ok, err := store.Get(id)
+	if err != nil {
+		log.Println("socket.io session store: ", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	store.Delete(id)
+
+	var st streamState
+	if err := json.Unmarshal(data, &st); err != nil || st.Id != id {
+		return nil, false
+	}
+
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	if stream, ok := s.streams[id]; ok {
+		return stream, true
+	}
+	if st.Size <= 0 {
+		st.Size = s.reliableHistory
+	}
+	s.importStream(st, s.reliableKeep)
+
+	return s.streams[id], true
+}
+
+/**
+In-memory SessionStore, e.g. for tests of code using a shared one
+*/
+type MemorySessionStore struct {
+	clock    Clock
+	sessions map[string]*memorySession
+	lock     sync.Mutex
+}
+
+type memorySession struct {
+	data   []byte
+	expire Timer
+}
+
+/**
+Create empty in-memory store, nil clock means the real one
+*/
+func NewMemorySessionStore(clock Clock) *MemorySessionStore {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &MemorySessionStore{
+		clock:    clock,
+		sessions: make(map[string]*memorySession),
+	}
+}
+
+func (ms *MemorySessionStore) Get(id string) ([]byte, bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	session, ok := ms.sessions[id]
+	if !ok {
+		return nil, false, nil
+	}
+	return session.data, true, nil
+}
+
+func (ms *MemorySessionStore) Set(id string, session []byte) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if old, ok := ms.sessions[id]; ok && old.expire != nil {
+		old.expire.Stop()
+	}
+	ms.sessions[id] = &memorySession{data: session}
+	return nil
+}
+
+func (ms *MemorySessionStore) Delete(id string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if session, ok := ms.sessions[id]; ok && session.expire != nil {
+		session.expire.Stop()
+	}
+	delete(ms.sessions, id)
+	return nil
+}
+
+func (ms *MemorySessionStore) Expire(id string, ttl time.Duration) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	session, ok := ms.sessions[id]
+	if !ok {
+		return nil
+	}
+	if session.expire != nil {
+		session.expire.Stop()
+	}
+	session.expire = ms.clock.AfterFunc(ttl, func() {
+		ms.lock.Lock()
+		defer ms.lock.Unlock()
+
+		if ms.sessions[id] == session {
+			delete(ms.sessions, id)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,161 @@
+package gophersocket
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Store failing writes, recording calls
+*/
+type failingSessionStore struct {
+	lock  sync.Mutex
+	calls []string
+}
+
+func (fs *failingSessionStore) record(call string) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	fs.calls = append(fs.calls, call)
+}
+
+func (fs *failingSessionStore) called() []string {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return append([]string(nil), fs.calls...)
+}
+
+func (fs *failingSessionStore) Get(id string) ([]byte, bool, error) {
+	fs.record("get")
+	return nil, false, nil
+}
+
+func (fs *failingSessionStore) Set(id string, session []byte) error {
+	fs.record("set")
+	return errors.New("store down")
+}
+
+func (fs *failingSessionStore) Delete(id string) error {
+	fs.record("delete")
+	return nil
+}
+
+func (fs *failingSessionStore) Expire(id string, ttl time.Duration) error {
+	fs.record("expire")
+	return nil
+}
+
+func waitStored(t testing.TB, store SessionStore, id string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok, _ := store.Get(id); ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("session not stored", id)
+}
+
+/**
+Harness of reliable server, with two messages written,
+returns it and its stream
+*/
+func reliableHarness(t testing.TB, s *Server) (*LoopHarness, string) {
+	t.Helper()
+
+	h := NewLoopHarness(s)
+	h.Pump()
+	stream := announcedStream(t, h.Frames()).Stream
+
+	h.Channel.Emit("n", 1)
+	h.Channel.Emit("n", 2)
+	expectFrames(t, h,
+		`42["n",1,{"__seq":1}]`,
+		`42["n",2,{"__seq":2}]`,
+	)
+	return h, stream
+}
+
+func TestSessionStoreSharedByServers(t *testing.T) {
+	store := NewMemorySessionStore(nil)
+	first, second := newTestServer(), newTestServer()
+	for _, s := range []*Server{first, second} {
+		s.EnableReliableDelivery(10, time.Minute)
+		s.SetSessionStore(store)
+	}
+
+	h, stream := reliableHarness(t, first)
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	waitStored(t, store, stream)
+
+	//client got only the first message, resumes on the other server
+	resumed := drainHarness(NewLoopHarness(second))
+	feedEvent(t, resumed, reliableResumeEvent, ReliableState{Stream: stream, Seq: 1})
+	expectFrames(t, resumed,
+		`42["__stream",{"stream":"`+stream+`","seq":1}]`,
+		`42["n",2,{"__seq":2}]`,
+	)
+	if _, ok, _ := store.Get(stream); ok {
+		t.Fatal("resumed session left in store")
+	}
+}
+
+func TestSessionStoreFailureKeepsSession(t *testing.T) {
+	store := &failingSessionStore{}
+	s := newTestServer()
+	s.EnableReliableDelivery(10, time.Minute)
+	s.SetSessionStore(store)
+
+	h, stream := reliableHarness(t, s)
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.called()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if calls := store.called(); len(calls) != 1 || calls[0] != "set" {
+		t.Fatal("store calls", calls)
+	}
+
+	resumed := drainHarness(NewLoopHarness(s))
+	feedEvent(t, resumed, reliableResumeEvent, ReliableState{Stream: stream, Seq: 1})
+	expectFrames(t, resumed,
+		`42["__stream",{"stream":"`+stream+`","seq":1}]`,
+		`42["n",2,{"__seq":2}]`,
+	)
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	clock := newManualClock()
+	store := NewMemorySessionStore(clock)
+
+	store.Set("a", []byte("one"))
+	store.Set("b", []byte("two"))
+	store.Expire("a", time.Minute)
+	if data, ok, err := store.Get("a"); !ok || err != nil || string(data) != "one" {
+		t.Fatal(string(data), ok, err)
+	}
+
+	clock.waitTimer(t, time.Minute)
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok, _ := store.Get("a"); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok, _ := store.Get("a"); ok {
+		t.Fatal("expired session kept")
+	}
+
+	store.Delete("b")
+	if _, ok, _ := store.Get("b"); ok {
+		t.Fatal("deleted session kept")
+	}
+}
@@ -0,0 +1,79 @@
+package gophersocket
+
+import (
+	"sort"
+	"time"
+)
+
+/**
+Point-in-time view of the server, see Snapshot
+*/
+type ServerSnapshot struct {
+	//registered channels, sorted by sid
+	Channels []ChannelSnapshot `json:"channels"`
+
+	//sorted sids of members by room
+	Rooms map[string][]string `json:"rooms"`
+}
+
+/**
+Registered channel in ServerSnapshot
+*/
+type ChannelSnapshot struct {
+	Sid         string    `json:"sid"`
+	Ip          string    `json:"ip"`
+	Alive       bool      `json:"alive"`
+	ConnectedAt time.Time `json:"connectedAt"`
+
+	//sorted rooms the channel is in
+	Rooms []string `json:"rooms"`
+
+	//value set with SetPresenceMeta, not copied
+	Meta interface{} `json:"meta,omitempty"`
+}
+
+/**
+Describe registered channels, their rooms and metadata, e.g. to assert
+on in tests. Taken under sid registry and membership locks, so joins,
+leaves and connections are seen either completely or not at all
+*/
+func (s *Server) Snapshot() ServerSnapshot {
+	s.sidsLock.RLock()
+	defer s.sidsLock.RUnlock()
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	snap := ServerSnapshot{
+		Channels: make([]ChannelSnapshot, 0, len(s.sids)),
+		Rooms:    make(map[string][]string, len(s.channels)),
+	}
+
+	for _, c := range s.sids {
+		rooms := make([]string, 0, len(s.rooms[c]))
+		for room := range s.rooms[c] {
+			rooms = append(rooms, room)
+		}
+		sort.Strings(rooms)
+
+		snap.Channels = append(snap.Channels, ChannelSnapshot{
+			Sid:         c.Id(),
+			Ip:          c.Ip(),
+			Alive:       c.IsAlive(),
+			ConnectedAt: c.ConnectedAt(),
+			Rooms:       rooms,
+			Meta:        c.presenceEntry().Meta,
+		})
+	}
+	sort.Slice(snap.Channels, func(i, j int) bool { return snap.Channels[i].Sid < snap.Channels[j].Sid })
+
+	for room, members := range s.channels {
+		sids := make([]string, 0, len(members))
+		for c := range members {
+			sids = append(sids, c.Id())
+		}
+		sort.Strings(sids)
+		snap.Rooms[room] = sids
+	}
+
+	return snap
+}
@@ -0,0 +1,72 @@
+package gophersocket
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func snapshotHarness(s *Server, sid string) *LoopHarness {
+	h := NewLoopHarnessWithOptions(s, HarnessOptions{
+		Sid:        sid,
+		RemoteAddr: "10.0.0.1",
+		Request:    httptest.NewRequest("GET", "/socket.io/", nil),
+	})
+	h.Pump()
+	h.Frames()
+	return h
+}
+
+func TestSnapshot(t *testing.T) {
+	s := newTestServer()
+	a, b := snapshotHarness(s, "a"), snapshotHarness(s, "b")
+	a.Channel.Join("lobby")
+	a.Channel.Join("game")
+	b.Channel.Join("lobby")
+	a.Channel.SetPresenceMeta(map[string]string{"name": "alice"})
+
+	snap := s.Snapshot()
+	want := ServerSnapshot{
+		Channels: []ChannelSnapshot{
+			{
+				Sid:         "a",
+				Ip:          "10.0.0.1",
+				Alive:       true,
+				ConnectedAt: a.Channel.ConnectedAt(),
+				Rooms:       []string{"game", "lobby"},
+				Meta:        map[string]string{"name": "alice"},
+			},
+			{
+				Sid:         "b",
+				Ip:          "10.0.0.1",
+				Alive:       true,
+				ConnectedAt: b.Channel.ConnectedAt(),
+				Rooms:       []string{"lobby"},
+			},
+		},
+		Rooms: map[string][]string{
+			"game":  {"a"},
+			"lobby": {"a", "b"},
+		},
+	}
+	if !reflect.DeepEqual(snap, want) {
+		t.Fatalf("got %+v, want %+v", snap, want)
+	}
+
+	if _, err := json.Marshal(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	//snapshot is not updated afterwards
+	b.Channel.Leave("lobby")
+	if len(snap.Rooms["lobby"]) != 2 {
+		t.Fatal("snapshot changed", snap.Rooms)
+	}
+	closeChannel(b.Channel, b.methods, DisconnectServer, CloseKicked, nil)
+	waitRegistry(t, s, func(stats RegistryStats) bool { return stats.Size == 1 })
+	snap = s.Snapshot()
+	if len(snap.Channels) != 1 || snap.Channels[0].Sid != "a" || !reflect.DeepEqual(snap.Rooms["lobby"], []string{"a"}) {
+		t.Fatalf("got %+v after leave and close", snap)
+	}
+}
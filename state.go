@@ -0,0 +1,292 @@
+package gophersocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	/**
+	Version of state blob written by ExportState. Fields may be added
+	without changing it, readers ignore the ones they do not know
+	*/
+	StateVersion = 1
+
+	stateFormat = "gopher-socket/state"
+)
+
+var (
+	ErrorStateFormat   = errors.New("Not a server state blob")
+	ErrorStateVersion  = errors.New("Unsupported server state version")
+	ErrorStateCorrupt  = errors.New("Server state blob is corrupt")
+	ErrorStateConflict = errors.New("Server state conflicts with existing one")
+)
+
+/**
+Envelope of state blob, checksum is sha256 of the state as written
+*/
+type stateEnvelope struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	State    json.RawMessage `json:"state"`
+}
+
+type serverState struct {
+	Streams       []streamState `json:"streams"`
+	PresenceRooms []string      `json:"presenceRooms,omitempty"`
+}
+
+/**
+Resumable session: reliable stream with its history, rooms and
+presence metadata of the channel it belongs to
+*/
+type streamState struct {
+	Id      string          `json:"id"`
+	Seq     uint64          `json:"seq"`
+	Size    int             `json:"size"`
+	History []entryState    `json:"history,omitempty"`
+	Rooms   []string        `json:"rooms,omitempty"`
+	Meta    json.RawMessage `json:"meta,omitempty"`
+	Offsets bool            `json:"offsets,omitempty"`
+}
+
+type entryState struct {
+	Seq  uint64 `json:"seq"`
+	Data string `json:"data"`
+}
+
+/**
+Dump resumable sessions, with rooms and presence metadata of their
+channels, and rooms with presence enabled, to a versioned blob for
+ImportState on the replacement node. Joins and leaves wait while
+the snapshot is taken. Connections themselves are not included,
+so only channels with reliable delivery (see EnableReliableDelivery
+and EnableConnectionStateRecovery) can get their state back, when
+clients reconnect to the new node and resume
+
+Handover for rolling deploys, with state kept in external store:
+
+ 1. Both nodes enable connection state recovery
+ 2. The old node stops accepting, ExportState and store the blob
+ 3. The new node ImportState before clients reconnect to it
+ 4. Clients reconnect and Resume with ReliableState of the previous
+    connection, messages they missed are sent again, unflushed ones
+    included, and rooms and presence metadata are restored
+
+Acks the old node waits for can not be moved, their waiters fail with
+ErrorChannelClosed when the channel closes, the application retries
+*/
+func (s *Server) ExportState(ctx context.Context) (io.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	state, err := s.snapshotState()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	blob, err := json.Marshal(stateEnvelope{
+		Format:   stateFormat,
+		Version:  StateVersion,
+		Checksum: hex.EncodeToString(sum[:]),
+		State:    raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(blob), nil
+}
+
+/**
+Collect state under locks, so membership and streams are consistent
+*/
+func (s *Server) snapshotState() (*serverState, error) {
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	state := &serverState{Streams: make([]streamState, 0, len(s.streams))}
+	for room := range s.presenceRooms {
+		state.PresenceRooms = append(state.PresenceRooms, room)
+	}
+
+	for _, stream := range s.streams {
+		st, err := s.snapshotStream(stream)
+		if err != nil {
+			return nil, err
+		}
+		state.Streams = append(state.Streams, st)
+	}
+
+	return state, nil
+}
+
+func (s *Server) snapshotStream(stream *reliableStream) (streamState, error) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+
+	return s.streamState(stream)
+}
+
+/**
+Get state of the stream, should be called with the stream locked
+*/
+func (s *Server) streamState(stream *reliableStream) (streamState, error) {
+	st := streamState{
+		Id:      stream.id,
+		Seq:     stream.seq,
+		Size:    stream.size,
+		History: make([]entryState, 0, len(stream.history)),
+		Offsets: stream.offsets,
+	}
+	for _, entry := range stream.history {
+		st.History = append(st.History, entryState{entry.seq, entry.data})
+	}
+
+	meta := stream.meta
+	if c := stream.channel; c != nil {
+		for room := range s.rooms[c] {
+			st.Rooms = append(st.Rooms, room)
+		}
+		meta = c.presenceEntry().Meta
+	} else {
+		st.Rooms = append(st.Rooms, stream.rooms...)
+	}
+
+	if meta != nil {
+		raw, err := json.Marshal(meta)
+		if err != nil {
+			return st, fmt.Errorf("presence meta of stream %s: %w", stream.id, err)
+		}
+		st.Meta = raw
+	}
+
+	return st, nil
+}
+
+/**
+Load state dumped by ExportState: sessions can be resumed during keep
+time of reliable delivery, which should be enabled on this server,
+and rooms get presence enabled. Presence metadata of resumed channels
+is json.RawMessage of the exported value. The blob is validated as
+a whole before anything is applied, so on error nothing is changed
+*/
+func (s *Server) ImportState(ctx context.Context, r io.Reader) error {
+	state, err := decodeState(r)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	for _, st := range state.Streams {
+		if _, ok := s.streams[st.Id]; ok {
+			return fmt.Errorf("%w: stream %s", ErrorStateConflict, st.Id)
+		}
+	}
+
+	keep := s.reliableKeep
+	if keep <= 0 {
+		keep = DefaultReliableKeep
+	}
+	for _, st := range state.Streams {
+		s.importStream(st, keep)
+	}
+	for _, room := range state.PresenceRooms {
+		s.presenceRooms[room] = struct{}{}
+	}
+
+	return nil
+}
+
+/**
+Read and validate state blob
+*/
+func decodeState(r io.Reader) (*serverState, error) {
+	var envelope stateEnvelope
+	if err := json.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorStateCorrupt, err)
+	}
+	if envelope.Format != stateFormat {
+		return nil, ErrorStateFormat
+	}
+	if envelope.Version < 1 || envelope.Version > StateVersion {
+		return nil, fmt.Errorf("%w: %d", ErrorStateVersion, envelope.Version)
+	}
+
+	sum := sha256.Sum256(envelope.State)
+	if hex.EncodeToString(sum[:]) != envelope.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrorStateCorrupt)
+	}
+
+	var state serverState
+	if err := json.Unmarshal(envelope.State, &state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorStateCorrupt, err)
+	}
+
+	seen := make(map[string]struct{}, len(state.Streams))
+	for _, st := range state.Streams {
+		if _, ok := seen[st.Id]; ok || st.Id == "" || st.Size <= 0 {
+			return nil, fmt.Errorf("%w: bad stream %q", ErrorStateCorrupt, st.Id)
+		}
+		seen[st.Id] = struct{}{}
+
+		last := uint64(0)
+		for _, entry := range st.History {
+			if entry.Seq <= last || entry.Seq > st.Seq {
+				return nil, fmt.Errorf("%w: bad history of stream %q", ErrorStateCorrupt, st.Id)
+			}
+			last = entry.Seq
+		}
+	}
+
+	return &state, nil
+}
+
+/**
+Add stream detached, as if its channel disconnected just now,
+should be called under streamsLock
+*/
+func (s *Server) importStream(st streamState, keep time.Duration) {
+	stream := &reliableStream{
+		id:      st.Id,
+		seq:     st.Seq,
+		size:    st.Size,
+		history: make([]reliableEntry, 0, len(st.History)),
+		rooms:   st.Rooms,
+		offsets: st.Offsets,
+	}
+	for _, entry := range st.History {
+		stream.history = append(stream.history, reliableEntry{entry.Seq, entry.Data})
+	}
+	if len(st.Meta) > 0 {
+		stream.meta = st.Meta
+	}
+
+	stream.expire = s.expireStream(stream, keep)
+	s.streams[stream.id] = stream
+}
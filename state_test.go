@@ -0,0 +1,108 @@
+package gophersocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandoverBetweenServers(t *testing.T) {
+	old, next := newTestServer(), newTestServer()
+	connected := make(chan *Channel, 2)
+	for _, s := range []*Server{old, next} {
+		s.EnableConnectionStateRecovery(time.Minute)
+		s.On(OnConnection, func(c *Channel) { connected <- c })
+	}
+
+	first, closeFirst := dialTestServer(t, old)
+	got := make(chan int, 10)
+	first.On("n", func(c *Channel, v int) { got <- v })
+	first.SetExecutor(syncExecutor{})
+	sc := <-connected
+	sc.Join("game")
+	sc.SetPresenceMeta(map[string]interface{}{"name": "alice"})
+
+	sc.Emit("n", 1)
+	if v := receiveInt(t, got); v != 1 {
+		t.Fatalf("got %d, want 1", v)
+	}
+	state := first.ReliableState()
+	closeFirst()
+	waitClosed(t, sc)
+	//emitted while the client moves, kept in history
+	sc.Emit("n", 2)
+
+	blob, err := old.ExportState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	//kept in external store meanwhile
+	stored, err := ioutil.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := next.ImportState(context.Background(), bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+
+	second, closeSecond := dialTestServer(t, next)
+	defer closeSecond()
+	second.On("n", func(c *Channel, v int) { got <- v })
+	second.SetExecutor(syncExecutor{})
+	sc2 := <-connected
+	waitStream(t, second)
+	if err := second.Resume(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := receiveInt(t, got); v != 2 {
+		t.Fatalf("got %d, want missed 2", v)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !sc2.Recovered() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if !sc2.Recovered() {
+		t.Fatal("session not recovered on the new server")
+	}
+	if rooms := sc2.Rooms(); len(rooms) != 1 || rooms[0] != "game" || next.Amount("game") != 1 {
+		t.Fatal("rooms not restored:", rooms)
+	}
+	if meta, _ := sc2.presenceEntry().Meta.(json.RawMessage); string(meta) != `{"name":"alice"}` {
+		t.Fatal("presence meta not restored:", sc2.presenceEntry().Meta)
+	}
+
+	sc2.Emit("n", 3)
+	if v := receiveInt(t, got); v != 3 {
+		t.Fatalf("got %d, want 3", v)
+	}
+}
+
+func TestImportStateCorrupt(t *testing.T) {
+	s := newTestServer()
+	s.EnableConnectionStateRecovery(time.Minute)
+	NewLoopHarness(s).Channel.Emit("n", 1)
+
+	blob, err := s.ExportState(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ioutil.ReadAll(blob)
+	tampered := strings.Replace(string(raw), `"seq":1`, `"seq":7`, 1)
+	if tampered == string(raw) {
+		t.Fatal("nothing to tamper in", string(raw))
+	}
+
+	err = newTestServer().ImportState(context.Background(), strings.NewReader(tampered))
+	if !errors.Is(err, ErrorStateCorrupt) {
+		t.Fatal(err)
+	}
+	if err := newTestServer().ImportState(context.Background(), strings.NewReader(`{"format":"other"}`)); err != ErrorStateFormat {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,238 @@
+package gophersocket
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	/**
+	Full state of the room, sent to channels joining it and on resync
+	*/
+	StateSnapshotEvent = "__state_snapshot"
+
+	/**
+	Change of the state, broadcast to members which got the snapshot
+	*/
+	StatePatchEvent = "__state_patch"
+
+	/**
+	Sent by clients detecting a gap in versions, with StateResync,
+	answered with StateSnapshotEvent
+	*/
+	StateResyncEvent = "__state_resync"
+)
+
+var (
+	ErrorStateSyncExists = errors.New("Room state is synced already")
+	ErrorStateSyncClosed = errors.New("Room state sync is closed")
+)
+
+/**
+Payload of StateSnapshotEvent
+*/
+type StateSnapshot struct {
+	Room    string      `json:"room"`
+	Version uint64      `json:"version"`
+	State   interface{} `json:"state"`
+}
+
+/**
+Payload of StatePatchEvent, versions of patches of one room increase
+by one, so a client missing one should ask for resync
+*/
+type StatePatch struct {
+	Room    string      `json:"room"`
+	Version uint64      `json:"version"`
+	Patch   interface{} `json:"patch"`
+}
+
+/**
+Payload of StateResyncEvent
+*/
+type StateResync struct {
+	Room string `json:"room"`
+}
+
+/**
+State of one room kept in sync with its members: joining channels get
+the snapshot, members get patches, each tagged with the version
+*/
+type StateSync struct {
+	s *Server
+	//key of the room in registry, namespace and name within it
+	room     string
+	nsp      string
+	name     string
+	snapshot func() interface{}
+
+	version uint64
+	//snapshot packet of the version, built once for all joins
+	encoded string
+	closed  bool
+	lock    sync.Mutex
+}
+
+/**
+Keep state of the room in sync with its members. snapshot returns the
+full current state, it is called under room lock, so it should not
+join or leave rooms. Snapshot events of the room may be compressed
+with SetEventCompression of StateSnapshotEvent
+*/
+func (s *Server) NewStateSync(room string, snapshot func() interface{}) (*StateSync, error) {
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	if _, ok := s.stateSyncs[room]; ok {
+		return nil, ErrorStateSyncExists
+	}
+
+	st := &StateSync{s: s, room: room, snapshot: snapshot}
+	st.nsp, st.name = packetRoom(room)
+	s.stateSyncs[room] = st
+
+	return st, nil
+}
+
+/**
+Broadcast patch to members, the state returned by snapshot should
+include it once Update is called. See Apply for changes racing joins
+*/
+func (st *StateSync) Update(patch interface{}) error {
+	return st.Apply(func() interface{} { return patch })
+}
+
+/**
+Change the state with f and broadcast patch returned by it. Joins wait
+for f, so joining channel gets either the state before the change and
+then the patch, or the state after the change without the patch.
+As snapshot, f should not join or leave rooms
+*/
+func (st *StateSync) Apply(f func() (patch interface{})) error {
+	s := st.s
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.closed {
+		return ErrorStateSyncClosed
+	}
+
+	patch := f()
+	command, err := encode(s.getCodec(), protocol.NewEvent(st.nsp, StatePatchEvent, nil),
+		StatePatch{Room: st.name, Version: st.version + 1, Patch: patch})
+	if err != nil {
+		return err
+	}
+	st.version++
+	st.encoded = ""
+
+	for c := range s.channels[st.room] {
+		if c.IsAlive() && !c.Muted(StatePatchEvent) {
+			c.enqueueBroadcast(command)
+		}
+	}
+
+	return nil
+}
+
+/**
+Get version of the last patch, zero before the first one
+*/
+func (st *StateSync) Version() uint64 {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	return st.version
+}
+
+/**
+Stop syncing the room, members are not notified
+*/
+func (st *StateSync) Close() {
+	s := st.s
+	s.channelsLock.Lock()
+	defer s.channelsLock.Unlock()
+
+	st.lock.Lock()
+	st.closed = true
+	st.lock.Unlock()
+
+	if s.stateSyncs[st.room] == st {
+		delete(s.stateSyncs, st.room)
+	}
+}
+
+/**
+Send snapshot of the current version to the channel
+*/
+func (st *StateSync) sendSnapshot(c *Channel) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.closed {
+		return ErrorStateSyncClosed
+	}
+	if c.Muted(StateSnapshotEvent) {
+		return nil
+	}
+
+	if st.encoded == "" {
+		command, err := encode(st.s.getCodec(), protocol.NewEvent(st.nsp, StateSnapshotEvent, nil),
+			StateSnapshot{Room: st.name, Version: st.version, State: st.snapshot()})
+		if err != nil {
+			return err
+		}
+		st.encoded = command
+	}
+
+	return c.enqueue(st.encoded)
+}
+
+/**
+Send snapshot to channel joined the room, should be called
+under channelsLock, so no patch is broadcast meanwhile
+*/
+func (s *Server) stateSyncJoined(c *Channel, room string) {
+	if st, ok := s.stateSyncs[room]; ok {
+		st.sendSnapshot(c)
+	}
+}
+
+/**
+Answer resync request of a member of the room of the namespace the
+request came in, returns false if the message is not one. Handled here
+rather than by a handler, so handler tables swapped by SwapHandlers or
+ReplaceHandler can not lose it. Without any StateSync the event goes
+to handlers as others do
+*/
+func (s *Server) resyncState(c *Channel, msg *protocol.Message) bool {
+	if msg.Method != StateResyncEvent {
+		return false
+	}
+
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	if len(s.stateSyncs) == 0 {
+		return false
+	}
+
+	var req StateResync
+	if err := s.getCodec().Unmarshal([]byte(msg.Args), &req); err != nil {
+		return true
+	}
+	room := roomKey(msg.Namespace, req.Room)
+	st, ok := s.stateSyncs[room]
+	if !ok {
+		return true
+	}
+	if _, member := s.channels[room][c]; member {
+		st.sendSnapshot(c)
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+)
+
+/**
+State of a counter synced to room "doc"
+*/
+type syncedCounter struct {
+	n    int
+	lock sync.Mutex
+}
+
+func (sc *syncedCounter) snapshot() interface{} {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	return map[string]int{"n": sc.n}
+}
+
+func (sc *syncedCounter) add(st *StateSync, d int) error {
+	return st.Apply(func() interface{} {
+		sc.lock.Lock()
+		defer sc.lock.Unlock()
+
+		sc.n += d
+		return map[string]int{"add": d}
+	})
+}
+
+func TestStateSyncSnapshotAndPatches(t *testing.T) {
+	s := newTestServer()
+	counter := &syncedCounter{}
+	st, err := s.NewStateSync("doc", counter.snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewStateSync("doc", counter.snapshot); err != ErrorStateSyncExists {
+		t.Fatal("synced twice", err)
+	}
+
+	first := newOpenHarness(s)
+	first.Channel.Join("doc")
+	expectFrames(t, first, `42["__state_snapshot",{"room":"doc","version":0,"state":{"n":0}}]`)
+
+	counter.add(st, 2)
+	expectFrames(t, first, `42["__state_patch",{"room":"doc","version":1,"patch":{"add":2}}]`)
+
+	second := newOpenHarness(s)
+	second.Channel.Join("doc")
+	expectFrames(t, second, `42["__state_snapshot",{"room":"doc","version":1,"state":{"n":2}}]`)
+
+	counter.add(st, 3)
+	expectFrames(t, first, `42["__state_patch",{"room":"doc","version":2,"patch":{"add":3}}]`)
+	expectFrames(t, second, `42["__state_patch",{"room":"doc","version":2,"patch":{"add":3}}]`)
+	if st.Version() != 2 {
+		t.Fatal("version", st.Version())
+	}
+
+	st.Close()
+	if err := counter.add(st, 1); err != ErrorStateSyncClosed {
+		t.Fatal("update after close", err)
+	}
+	third := newOpenHarness(s)
+	third.Channel.Join("doc")
+	expectFrames(t, third)
+}
+
+func TestStateResyncSurvivesHandlerSwap(t *testing.T) {
+	s := newTestServer()
+	counter := &syncedCounter{}
+	st, _ := s.NewStateSync("doc", counter.snapshot)
+	member, other := newOpenHarness(s), newOpenHarness(s)
+	member.Channel.Join("doc")
+	member.Pump()
+	member.Frames()
+
+	if err := s.SwapHandlers(func(r *Registry) {
+		r.On("other", func(c *Channel) {})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	counter.add(st, 1)
+	member.Pump()
+	member.Frames()
+
+	feedEvent(t, member, StateResyncEvent, StateResync{Room: "doc"})
+	expectFrames(t, member, `42["__state_snapshot",{"room":"doc","version":1,"state":{"n":1}}]`)
+
+	//only members get the snapshot
+	feedEvent(t, other, StateResyncEvent, StateResync{Room: "doc"})
+	expectFrames(t, other)
+}
+
+func TestStateResyncWithoutStateSync(t *testing.T) {
+	s := newTestServer()
+	got := make(chan StateResync, 1)
+	s.On(StateResyncEvent, func(c *Channel, req StateResync) { got <- req })
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, StateResyncEvent, StateResync{Room: "doc"})
+	if req := <-got; req.Room != "doc" {
+		t.Fatal("got", req)
+	}
+}
+
+func TestStateSyncSkipsMuted(t *testing.T) {
+	s := newTestServer()
+	counter := &syncedCounter{}
+	st, _ := s.NewStateSync("doc", counter.snapshot)
+	muted, other := newOpenHarness(s), newOpenHarness(s)
+	muted.Channel.Mute(StateSnapshotEvent)
+	muted.Channel.Mute(StatePatchEvent)
+	muted.Channel.Join("doc")
+	other.Channel.Join("doc")
+	other.Pump()
+	other.Frames()
+
+	counter.add(st, 1)
+	expectFrames(t, muted)
+	expectFrames(t, other, `42["__state_patch",{"room":"doc","version":1,"patch":{"add":1}}]`)
+}
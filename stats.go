@@ -0,0 +1,235 @@
+package gophersocket
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	residencyWindow = 256
+)
+
+/**
+Statistics of one channel
+*/
+type ChannelStats struct {
+	/**
+	Amount of messages waiting in out queue, and their total size,
+	limited by SetMaxOutBytes
+	*/
+	QueueLength int
+	QueuedBytes int64
+
+	/**
+	Time messages spent in out queue before being written,
+	over the last written messages
+	*/
+	ResidencyP50 time.Duration
+	ResidencyP95 time.Duration
+	ResidencyMax time.Duration
+
+	/**
+	Name of current transport, see Channel.Transport
+	*/
+	Transport string
+
+	/**
+	Total length of frames written and read since the channel was created
+	*/
+	BytesSent     int64
+	BytesReceived int64
+
+	/**
+	Messages put to out queue and rejected as it was full, by origin:
+	sent to the channel itself, or broadcast, see SetDirectEmitShare
+	*/
+	DirectEnqueued    int64
+	BroadcastEnqueued int64
+	DirectDropped     int64
+	BroadcastDropped  int64
+
+	/**
+	Writes retried after temporary transport error
+	*/
+	WriteRetries int64
+
+	/**
+	Loop goroutines of the channel currently running, and messages
+	submitted to executor which are not processed yet
+	*/
+	Goroutines       int
+	InFlightMessages int
+
+	/**
+	Application messages received: events and acks, and engine.io
+	control frames received, like ping and pong
+	*/
+	MessagesReceived      int64
+	ControlFramesReceived int64
+}
+
+/**
+Statistics of the server
+*/
+type ServerStats struct {
+	/**
+	Amount of connected channels
+	*/
+	Channels int
+
+	/**
+	Application messages and control frames received by all channels
+	since the server was created, see ChannelStats
+	*/
+	MessagesReceived      int64
+	ControlFramesReceived int64
+
+	/**
+	Amount of connected channels by current transport name,
+	and by EIO version of their handshake
+	*/
+	Transports  map[string]int
+	EIOVersions map[string]int
+}
+
+/**
+Sliding window of the last measured durations
+*/
+type durationWindow struct {
+	samples [residencyWindow]time.Duration
+	next    int
+	full    bool
+	lock    sync.Mutex
+}
+
+func (w *durationWindow) add(d time.Duration) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+/**
+Get median, 95th percentile and maximum of durations in the window
+*/
+func (w *durationWindow) percentiles() (p50, p95, max time.Duration) {
+	w.lock.Lock()
+	amount := w.next
+	if w.full {
+		amount = len(w.samples)
+	}
+	sorted := make([]time.Duration, amount)
+	copy(sorted, w.samples[:amount])
+	w.lock.Unlock()
+
+	if amount == 0 {
+		return 0, 0, 0
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(amount-1)*50/100], sorted[(amount-1)*95/100], sorted[amount-1]
+}
+
+/**
+Get statistics of the channel
+*/
+func (c *Channel) Stats() ChannelStats {
+	stats := ChannelStats{
+		QueueLength:   len(c.out),
+		QueuedBytes:   atomic.LoadInt64(&c.outBytes),
+		BytesSent:     c.BytesSent(),
+		BytesReceived: c.BytesReceived(),
+		WriteRetries:  atomic.LoadInt64(&c.writeRetries),
+
+		DirectEnqueued:    atomic.LoadInt64(&c.directEnqueued),
+		BroadcastEnqueued: atomic.LoadInt64(&c.broadcastEnqueued),
+		DirectDropped:     atomic.LoadInt64(&c.directDropped),
+		BroadcastDropped:  atomic.LoadInt64(&c.broadcastDropped),
+
+		Goroutines:       int(atomic.LoadInt32(&c.loopsRunning)),
+		InFlightMessages: int(atomic.LoadInt32(&c.inFlight)),
+
+		MessagesReceived:      atomic.LoadInt64(&c.messagesReceived),
+		ControlFramesReceived: atomic.LoadInt64(&c.controlFramesReceived),
+	}
+	stats.ResidencyP50, stats.ResidencyP95, stats.ResidencyMax = c.residency.percentiles()
+
+	c.transportLock.RLock()
+	stats.Transport = c.transportName
+	c.transportLock.RUnlock()
+
+	return stats
+}
+
+/**
+Get total length of frames written to the channel transport
+*/
+func (c *Channel) BytesSent() int64 {
+	return atomic.LoadInt64(&c.bytesSent)
+}
+
+/**
+Get total length of frames read from the channel transport
+*/
+func (c *Channel) BytesReceived() int64 {
+	return atomic.LoadInt64(&c.bytesReceived)
+}
+
+/**
+Get statistics of the server
+*/
+func (s *Server) Stats() ServerStats {
+	stats := ServerStats{
+		Channels:              int(s.AmountOfSids()),
+		MessagesReceived:      atomic.LoadInt64(&s.messagesReceived),
+		ControlFramesReceived: atomic.LoadInt64(&s.controlFramesReceived),
+	}
+	stats.Transports, stats.EIOVersions = s.connectionMix()
+
+	return stats
+}
+
+/**
+Get bytes queued by channels of the server whose out queue is over
+half of its limit, by messages or by bytes, by sid
+*/
+func (s *Server) Overflooded() map[string]int64 {
+	queued := make(map[string]int64)
+	overflooded.Range(func(key, _ interface{}) bool {
+		if c := key.(*Channel); c.server == s {
+			queued[c.Id()] = atomic.LoadInt64(&c.outBytes)
+		}
+		return true
+	})
+
+	return queued
+}
+
+/**
+Count received packet as application message or control frame
+*/
+func (c *Channel) countReceived(m *methods, msg *protocol.Message) {
+	switch msg.Type {
+	case protocol.MessageTypeEmit, protocol.MessageTypeAckRequest, protocol.MessageTypeAckResponse:
+		atomic.AddInt64(&c.messagesReceived, 1)
+		if c.server != nil {
+			atomic.AddInt64(&c.server.messagesReceived, 1)
+		}
+		m.metricAdd(MetricPacketsReceived, 1, "kind", "application")
+	default:
+		atomic.AddInt64(&c.controlFramesReceived, 1)
+		if c.server != nil {
+			atomic.AddInt64(&c.server.controlFramesReceived, 1)
+		}
+		m.metricAdd(MetricPacketsReceived, 1, "kind", "control")
+	}
+}
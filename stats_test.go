@@ -0,0 +1,172 @@
+package gophersocket
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTestWrite = errors.New("write failed")
+
+func TestChannelByteCounts(t *testing.T) {
+	s := newTestServer()
+	s.On("echo", func(c *Channel, v string) { c.Emit("echo", v) })
+
+	h := NewLoopHarness(s)
+	in := []string{`42["echo","hello"]`, `42["echo","some longer text"]`, "2"}
+	received := 0
+	for _, frame := range in {
+		if err := h.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+		received += len(frame)
+	}
+	h.Pump()
+
+	sent := 0
+	frames := h.Frames()
+	for _, frame := range frames {
+		sent += len(frame)
+	}
+	//open sequence, two echoes and pong
+	if len(frames) < 4 {
+		t.Fatal(frames)
+	}
+
+	if got := h.Channel.BytesReceived(); got != int64(received) {
+		t.Fatalf("received %d bytes, counted %d", received, got)
+	}
+	if got := h.Channel.BytesSent(); got != int64(sent) {
+		t.Fatalf("sent %d bytes, counted %d", sent, got)
+	}
+	stats := h.Channel.Stats()
+	if stats.BytesSent != int64(sent) || stats.BytesReceived != int64(received) {
+		t.Fatal(stats.BytesSent, stats.BytesReceived)
+	}
+}
+
+func TestChannelByteCountsFailedWrite(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	before := h.Channel.BytesSent()
+
+	h.FailWrites(errTestWrite)
+	h.Channel.Emit("ev", "lost")
+	h.Pump()
+
+	if got := h.Channel.BytesSent(); got != before {
+		t.Fatalf("failed write counted, %d bytes before, %d after", before, got)
+	}
+}
+
+func TestOverflooded(t *testing.T) {
+	s := newTestServer()
+	s.SetMaxOutBytes(40)
+	h, quiet := newOpenHarness(s), newOpenHarness(s)
+	big := strings.Repeat("x", 20)
+
+	//queued, not written yet, over half of the limit
+	if err := h.Channel.Emit("big", big); err != nil {
+		t.Fatal(err)
+	}
+	queued := h.Channel.Stats().QueuedBytes
+	if want := int64(len(`42["big",""]` + big)); queued != want {
+		t.Fatalf("queued %d bytes, want %d", queued, want)
+	}
+	h.Channel.checkOverflow(h.methods)
+	quiet.Channel.checkOverflow(quiet.methods)
+	if got := s.Overflooded(); len(got) != 1 || got[h.Channel.Id()] != queued {
+		t.Fatalf("got %v, want %s with %d bytes", got, h.Channel.Id(), queued)
+	}
+
+	//written
+	expectFrames(t, h, `42["big","`+big+`"]`)
+	if got := h.Channel.Stats().QueuedBytes; got != 0 || len(s.Overflooded()) != 0 {
+		t.Fatal("after write", got, s.Overflooded())
+	}
+
+	//drained on close
+	if err := h.Channel.Emit("big", big); err != nil {
+		t.Fatal(err)
+	}
+	h.Channel.checkOverflow(h.methods)
+	closeChannel(h.Channel, h.methods, DisconnectServer, CloseKicked, nil)
+	if got := h.Channel.Stats().QueuedBytes; got != 0 || len(s.Overflooded()) != 0 {
+		t.Fatal("after drain", got, s.Overflooded())
+	}
+}
+
+func TestChannelGoroutineCounts(t *testing.T) {
+	s := newTestServer()
+	connected := make(chan *Channel, 1)
+	release := make(chan struct{})
+	handling := make(chan struct{}, 1)
+	s.On(OnConnection, func(c *Channel) { connected <- c })
+	s.On("block", func(c *Channel) {
+		handling <- struct{}{}
+		<-release
+	})
+
+	client, closeClient := dialTestServer(t, s)
+	defer closeClient()
+	c := <-connected
+
+	//in and out loops only, client pings from its out loop
+	if n := c.Stats().Goroutines; n != 2 {
+		t.Fatal("server goroutines", n)
+	}
+	if n := client.Stats().Goroutines; n != 2 {
+		t.Fatal("client goroutines", n)
+	}
+
+	client.Emit("block", nil)
+	<-handling
+	if n := c.Stats().InFlightMessages; n != 1 {
+		t.Fatal("in flight", n)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Stats().InFlightMessages != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := c.Stats().InFlightMessages; n != 0 {
+		t.Fatal("in flight after handler returned", n)
+	}
+}
+
+func TestReceivedCountsByKind(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	s.On("ev", func(c *Channel) {})
+	s.On("ask", func(c *Channel) string { return "ok" })
+
+	h1 := NewLoopHarness(s)
+	h2 := NewLoopHarness(s)
+	for _, frame := range []string{"2", `42["ev"]`, "2", "3", `421["ask"]`, "2", `42["unknown"]`} {
+		if err := h1.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, frame := range []string{"2", `42["ev"]`} {
+		if err := h2.Feed(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := h1.Channel.Stats()
+	if stats.MessagesReceived != 3 || stats.ControlFramesReceived != 4 {
+		t.Fatalf("channel counted %d messages, %d control frames",
+			stats.MessagesReceived, stats.ControlFramesReceived)
+	}
+	total := s.Stats()
+	if total.MessagesReceived != 4 || total.ControlFramesReceived != 5 {
+		t.Fatalf("server counted %d messages, %d control frames",
+			total.MessagesReceived, total.ControlFramesReceived)
+	}
+	if metrics.get(MetricPacketsReceived, "kind", "application") != 4 ||
+		metrics.get(MetricPacketsReceived, "kind", "control") != 5 {
+		t.Fatal(metrics.counters)
+	}
+}
@@ -0,0 +1,104 @@
+package gophersocket
+
+import (
+	"github.com/whiterabb17/gopher-socket/codec"
+)
+
+/**
+Handler table being built for SwapHandlers, not published until
+the build function returns
+*/
+type Registry struct {
+	handlers handlerTable
+	err      error
+}
+
+/**
+Same as On of server or client, for the table being built
+*/
+func (r *Registry) On(method string, f interface{}) error {
+	c, err := newCaller(method, f)
+	if err != nil {
+		r.fail(err)
+		return err
+	}
+
+	r.handlers[method] = appendCaller(r.handlers[method], c)
+	return nil
+}
+
+/**
+Same as OnWithCodec of server or client, for the table being built
+*/
+func (r *Registry) OnWithCodec(method string, f interface{}, cd codec.Codec) error {
+	c, err := newCaller(method, f)
+	if err != nil {
+		r.fail(err)
+		return err
+	}
+	c.Codec = cd
+
+	r.handlers[method] = appendCaller(r.handlers[method], c)
+	return nil
+}
+
+func (r *Registry) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+/**
+Replace all shared handlers at once, including loop events like
+OnConnection, with the ones registered by build. Messages already
+being dispatched finish with the old handlers, the following ones use
+the new ones, no message sees both. If any registration in build fails,
+its error is returned and the old handlers stay
+*/
+func (m *methods) SwapHandlers(build func(r *Registry)) error {
+	r := &Registry{handlers: make(handlerTable)}
+	build(r)
+	if r.err != nil {
+		return r.err
+	}
+
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+
+	m.messageHandlers.Store(r.handlers)
+	return nil
+}
+
+/**
+Replace all shared handlers of one event with f, other events keep
+theirs. As with SwapHandlers, messages already being dispatched finish
+with the old handlers and the following ones use f, so no message is
+lost or handled by both. Nil f removes handlers of the event
+*/
+func (m *methods) ReplaceHandler(method string, f Handler) error {
+	var callers []*caller
+	if f != nil {
+		c, err := newCaller(method, f)
+		if err != nil {
+			return err
+		}
+		callers = []*caller{c}
+	}
+
+	m.messageHandlersLock.Lock()
+	defer m.messageHandlersLock.Unlock()
+
+	old := m.getHandlers()
+	table := make(handlerTable, len(old)+1)
+	for name, existing := range old {
+		table[name] = existing
+	}
+	if callers == nil {
+		delete(table, method)
+	} else {
+		table[method] = callers
+	}
+
+	m.messageHandlers.Store(table)
+	return nil
+}
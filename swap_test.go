@@ -0,0 +1,202 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSwapHandlersUnderLoad(t *testing.T) {
+	s := newTestServer()
+	var lock sync.Mutex
+	handled := map[int]int{}
+	byVersion := map[int]int{}
+	done := make(chan struct{}, 1000)
+	handler := func(version int) func(c *Channel, id int) {
+		return func(c *Channel, id int) {
+			lock.Lock()
+			handled[id]++
+			byVersion[version]++
+			lock.Unlock()
+			done <- struct{}{}
+		}
+	}
+	s.On("job", handler(0))
+	h := newOpenHarness(s)
+
+	const jobs = 1000
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		for version := 1; version <= 20; version++ {
+			err := s.SwapHandlers(func(r *Registry) {
+				r.On("job", handler(version))
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	for id := 0; id < jobs; id++ {
+		if id == jobs/2 {
+			s.SwapHandlers(func(r *Registry) { r.On("job", handler(jobs)) })
+		}
+		feedEvent(t, h, "job", id)
+	}
+	<-swapped
+
+	for i := 0; i < jobs; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d jobs handled, want %d", i, jobs)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for id := 0; id < jobs; id++ {
+		if handled[id] != 1 {
+			t.Fatalf("job %d handled %d times", id, handled[id])
+		}
+	}
+	if len(byVersion) < 2 {
+		t.Fatal("all jobs handled by one table", byVersion)
+	}
+}
+
+func TestSwapHandlersLoopEvents(t *testing.T) {
+	s := newTestServer()
+	s.On(OnConnection, func(c *Channel) { t.Error("old handler called") })
+
+	connected := make(chan *Channel, 1)
+	err := s.SwapHandlers(func(r *Registry) {
+		r.On(OnConnection, func(c *Channel) { connected <- c })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewLoopHarness(s)
+	select {
+	case c := <-connected:
+		if c != h.Channel {
+			t.Fatal("other channel connected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new OnConnection not called")
+	}
+}
+
+func TestSwapHandlersFailedBuild(t *testing.T) {
+	s := newTestServer()
+	got := make(chan string, 1)
+	s.On("ev", func(c *Channel, v string) { got <- "old:" + v })
+
+	err := s.SwapHandlers(func(r *Registry) {
+		r.On("ev", func(c *Channel, v string) { got <- "new:" + v })
+		r.On("bad", "not a function")
+	})
+	if err == nil {
+		t.Fatal("invalid handler accepted")
+	}
+
+	h := newOpenHarness(s)
+	feedEvent(t, h, "ev", "a")
+	select {
+	case v := <-got:
+		if v != "old:a" {
+			t.Fatal("got", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not handled")
+	}
+}
+
+func TestReplaceHandlerUnderLoad(t *testing.T) {
+	s := newTestServer()
+	var lock sync.Mutex
+	handled := map[int]int{}
+	versions := map[int]bool{}
+	done := make(chan struct{}, 1000)
+	handler := func(version int) func(c *Channel, id int) {
+		return func(c *Channel, id int) {
+			lock.Lock()
+			handled[id]++
+			versions[version] = true
+			lock.Unlock()
+			done <- struct{}{}
+		}
+	}
+	s.On("job", handler(0))
+	s.On("other", func(c *Channel) {})
+	h := newOpenHarness(s)
+
+	const jobs = 1000
+	replaced := make(chan struct{})
+	go func() {
+		defer close(replaced)
+		for version := 1; version <= 20; version++ {
+			if err := s.ReplaceHandler("job", handler(version)); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+	for id := 0; id < jobs; id++ {
+		if id == jobs/2 {
+			s.ReplaceHandler("job", handler(jobs))
+		}
+		feedEvent(t, h, "job", id)
+	}
+	<-replaced
+
+	for i := 0; i < jobs; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d jobs handled, want %d", i, jobs)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	for id := 0; id < jobs; id++ {
+		if handled[id] != 1 {
+			t.Fatalf("job %d handled %d times", id, handled[id])
+		}
+	}
+	if len(versions) < 2 {
+		t.Fatal("all jobs handled by one handler", versions)
+	}
+	//other events keep their handlers
+	if _, ok := s.getHandlers()["other"]; !ok {
+		t.Fatal("handler of other event removed")
+	}
+}
+
+func TestReplaceHandlerRemove(t *testing.T) {
+	s := newTestServer()
+	got := make(chan string, 1)
+	s.On("ev", func(c *Channel, v string) { got <- v })
+
+	if err := s.ReplaceHandler("ev", nil); err != nil {
+		t.Fatal(err)
+	}
+	h := newOpenHarness(s)
+	feedEvent(t, h, "ev", "a")
+	select {
+	case v := <-got:
+		t.Fatal("removed handler called", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := s.ReplaceHandler("ev", "not a function"); err == nil {
+		t.Fatal("invalid handler accepted")
+	}
+}
@@ -0,0 +1,60 @@
+package gophersocket
+
+/**
+Kind of payload the library itself sends to clients, see
+SetSystemMessageFormatter
+*/
+type SystemMessageKind string
+
+const (
+	/**
+	Error event or ack result on handler error, details are event,
+	message, code and error
+	*/
+	SystemMessageHandlerError SystemMessageKind = "handler_error"
+
+	/**
+	Connect error packet sent to connection rejected by connect guard,
+	details are message, data and error
+	*/
+	SystemMessageConnectRejected SystemMessageKind = "connect_rejected"
+
+	/**
+	Connect error packet sent on connect to namespace rejected by
+	NamespaceReject, details are namespace and message
+	*/
+	SystemMessageNamespaceRejected SystemMessageKind = "namespace_rejected"
+
+	/**
+	Kick event sent to channel replaced by newer one of the same
+	session, details are message and error
+	*/
+	SystemMessageSessionReplaced SystemMessageKind = "session_replaced"
+)
+
+type systemMessageFormatter func(c *Channel, kind SystemMessageKind, details map[string]interface{}) interface{}
+
+/**
+Set function building payloads the library sends to clients on its own,
+e.g. to localize them by channel or follow API conventions. Details hold
+the default English message under "message". Returned nil keeps the
+default payload. SetErrorFormatter takes precedence for handler errors
+*/
+func (m *methods) SetSystemMessageFormatter(f func(c *Channel, kind SystemMessageKind, details map[string]interface{}) interface{}) {
+	m.systemFormatter.Store(systemMessageFormatter(f))
+}
+
+/**
+Build payload of the kind with formatter set, or return the default one
+*/
+func (m *methods) systemMessage(c *Channel, kind SystemMessageKind, details map[string]interface{}, payload interface{}) interface{} {
+	f, _ := m.systemFormatter.Load().(systemMessageFormatter)
+	if f == nil {
+		return payload
+	}
+
+	if custom := f(c, kind, details); custom != nil {
+		return custom
+	}
+	return payload
+}
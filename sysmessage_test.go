@@ -0,0 +1,83 @@
+package gophersocket
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+Format system messages in language given in query of upgrade request,
+english ones are kept as is
+*/
+func localizedServer() *Server {
+	s := newTestServer()
+	s.SetSystemMessageFormatter(func(c *Channel, kind SystemMessageKind, details map[string]interface{}) interface{} {
+		if c.request.URL.Query().Get("lang") != "fr" {
+			return nil
+		}
+		return map[string]interface{}{
+			"kind":    kind,
+			"message": "fr:" + details["message"].(string),
+		}
+	})
+
+	return s
+}
+
+func localeHarness(s *Server, lang, user string) *LoopHarness {
+	return NewLoopHarnessWithOptions(s, HarnessOptions{
+		Request: httptest.NewRequest("GET", "/socket.io/?lang="+lang+"&user="+user, nil),
+	})
+}
+
+func TestSystemMessageHandlerError(t *testing.T) {
+	s := localizedServer()
+	s.On("work", func(c *Channel, v string) error { return errors.New("secret") })
+	fr, en := drainHarness(localeHarness(s, "fr", "")), drainHarness(localeHarness(s, "en", ""))
+
+	feedEvent(t, fr, "work", "a")
+	expectFrames(t, fr, `42["error",{"kind":"handler_error","message":"fr:Internal error"}]`)
+	feedEvent(t, en, "work", "a")
+	expectFrames(t, en, `42["error",{"event":"work","message":"Internal error"}]`)
+}
+
+func TestSystemMessageConnectRejected(t *testing.T) {
+	s := localizedServer()
+	s.SetConnectGuard(func(c *Channel) error { return errors.New("banned") })
+	h := localeHarness(s, "fr", "")
+
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 2 || frames[1] != `44{"kind":"connect_rejected","message":"fr:banned"}` {
+		t.Fatalf("got frames %q", frames)
+	}
+}
+
+func TestSystemMessageNamespaceRejected(t *testing.T) {
+	s := localizedServer()
+	h := drainHarness(localeHarness(s, "fr", ""))
+
+	if err := h.Feed("40/typo,"); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `44/typo,{"kind":"namespace_rejected","message":"fr:`+invalidNamespaceMessage+`"}`)
+}
+
+func TestSystemMessageSessionReplaced(t *testing.T) {
+	s := localizedServer()
+	s.SetSingleSession(func(c *Channel) (string, bool) {
+		user := c.request.URL.Query().Get("user")
+		return user, user != ""
+	}, SessionKickOld, "kicked")
+
+	first := drainHarness(localeHarness(s, "fr", "u1"))
+	localeHarness(s, "fr", "u1")
+
+	pumpUntilClosed(t, first)
+	frames := first.Frames()
+	want := `42["kicked",{"kind":"session_replaced","message":"fr:` + ErrorSessionReplaced.Error() + `"}]`
+	if len(frames) == 0 || frames[0] != want {
+		t.Fatalf("got frames %q, want %q first", frames, want)
+	}
+}
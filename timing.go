@@ -0,0 +1,77 @@
+package gophersocket
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	/**
+	Time from receipt of the packet to start of its handlers, in seconds
+	*/
+	MetricHandlerQueueDelay = "handler_queue_delay_seconds"
+
+	/**
+	Execution time of one handler, in seconds
+	*/
+	MetricHandlerDuration = "handler_duration_seconds"
+
+	/**
+	Time from sending ack request to receiving its response, in seconds
+	*/
+	MetricAckRoundTrip = "ack_round_trip_seconds"
+)
+
+/**
+Threshold and function of slow handler notification, stored together
+*/
+type slowHandlerConfig struct {
+	threshold time.Duration
+	notify    func(c *Channel, event string, took time.Duration)
+}
+
+/**
+Set duration of handler execution considered slow, such handlers
+are logged with event name and execution time, or passed to
+OnSlowHandler if it is set. Zero disables it
+*/
+func (m *methods) SetSlowHandlerThreshold(d time.Duration) {
+	m.slowHandlerLock.Lock()
+	defer m.slowHandlerLock.Unlock()
+
+	config, _ := m.slowHandler.Load().(slowHandlerConfig)
+	config.threshold = d
+	m.slowHandler.Store(config)
+}
+
+/**
+Set function called after a handler runs longer than the threshold,
+with the event name and execution time, including panicked handlers,
+instead of logging it
+*/
+func (m *methods) OnSlowHandler(f func(c *Channel, event string, took time.Duration)) {
+	m.slowHandlerLock.Lock()
+	defer m.slowHandlerLock.Unlock()
+
+	config, _ := m.slowHandler.Load().(slowHandlerConfig)
+	config.notify = f
+	m.slowHandler.Store(config)
+}
+
+/**
+Record execution time of a handler, notify if it is slow
+*/
+func (m *methods) observeHandler(ctx *EventContext, took time.Duration) {
+	m.metricObserve(MetricHandlerDuration, took.Seconds(), "event", ctx.event)
+
+	config, _ := m.slowHandler.Load().(slowHandlerConfig)
+	if config.threshold <= 0 || took <= config.threshold {
+		return
+	}
+
+	if config.notify != nil {
+		config.notify(ctx.channel, ctx.event, took)
+	} else {
+		log.Println("socket.io slow handler: ", ctx.event, took)
+	}
+}
@@ -0,0 +1,111 @@
+package gophersocket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+Metrics keeping observations by name, counters and gauges are dropped
+*/
+type observedMetrics struct {
+	lock     sync.Mutex
+	observed map[string][]float64
+}
+
+func (om *observedMetrics) Add(name string, delta float64, labels ...string) {}
+
+func (om *observedMetrics) Set(name string, value float64, labels ...string) {}
+
+func (om *observedMetrics) Observe(name string, value float64, labels ...string) {
+	om.lock.Lock()
+	defer om.lock.Unlock()
+
+	if om.observed == nil {
+		om.observed = map[string][]float64{}
+	}
+	om.observed[name] = append(om.observed[name], value)
+}
+
+func (om *observedMetrics) get(name string) []float64 {
+	om.lock.Lock()
+	defer om.lock.Unlock()
+
+	return om.observed[name]
+}
+
+func TestSlowHandlerNotified(t *testing.T) {
+	s := newTestServer()
+	type slow struct {
+		event string
+		took  time.Duration
+	}
+	var got []slow
+	s.SetSlowHandlerThreshold(20 * time.Millisecond)
+	s.OnSlowHandler(func(c *Channel, event string, took time.Duration) {
+		got = append(got, slow{event, took})
+	})
+	s.On("slow", func(c *Channel) { time.Sleep(40 * time.Millisecond) })
+	s.On("fast", func(c *Channel) {})
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, "fast")
+	feedEvent(t, h, "slow")
+	if len(got) != 1 || got[0].event != "slow" || got[0].took < 40*time.Millisecond {
+		t.Fatalf("got %+v", got)
+	}
+
+	//zero threshold disables it
+	s.SetSlowHandlerThreshold(0)
+	feedEvent(t, h, "slow")
+	if len(got) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestHandlerTimings(t *testing.T) {
+	s := newTestServer()
+	metrics := &observedMetrics{}
+	s.SetMetrics(metrics)
+	var ctx *EventContext
+	s.On("ev", func(c *EventContext) {
+		ctx = c
+		time.Sleep(10 * time.Millisecond)
+	})
+	h := newOpenHarness(s)
+
+	feedEvent(t, h, "ev")
+	if ctx == nil || ctx.ReceivedAt().IsZero() || ctx.QueueDelay() < 0 {
+		t.Fatal("event context timings", ctx)
+	}
+	if delays := metrics.get(MetricHandlerQueueDelay); len(delays) != 1 {
+		t.Fatal("queue delays", delays)
+	}
+	if durations := metrics.get(MetricHandlerDuration); len(durations) != 1 || durations[0] < 0.01 {
+		t.Fatal("handler durations", durations)
+	}
+}
+
+func TestAckRoundTripObserved(t *testing.T) {
+	s := newTestServer()
+	metrics := &observedMetrics{}
+	s.SetMetrics(metrics)
+	h := newOpenHarness(s)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Channel.Ack("ev", "v", time.Second)
+		done <- err
+	}()
+	waitFrame(t, h, `421["ev","v"]`)
+	if err := h.Feed(`431["ok"]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if rtts := metrics.get(MetricAckRoundTrip); len(rtts) != 1 {
+		t.Fatal("ack round trips", rtts)
+	}
+}
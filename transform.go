@@ -0,0 +1,71 @@
+package gophersocket
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorFrameTransform = errors.New("Frame transform failed")
+)
+
+/**
+Functions of SetFrameTransform, stored together
+*/
+type frameTransform struct {
+	out func([]byte) ([]byte, error)
+	in  func([]byte) ([]byte, error)
+}
+
+/**
+Transform frames of this channel, e.g. with application cipher: out is
+applied to each frame right before it is written, in to each frame
+right after it is read. Failure of either closes the channel with
+DisconnectTransformError. Nil function leaves frames as is.
+
+Frames are written as text, so out should produce text, e.g. base64.
+Only frames written or read after the call are transformed, on server
+set it in connect guard to cover the handshake too, on client use
+DialOptions.FrameOut and FrameIn. Transformed frames are not
+compressible, per event compression does not apply to them
+*/
+func (c *Channel) SetFrameTransform(out, in func([]byte) ([]byte, error)) {
+	c.frameTransform.Store(frameTransform{out: out, in: in})
+}
+
+func (c *Channel) transformOut(frame string) (string, error) {
+	frame, err := c.wrapEnvelope(frame)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrorFrameTransform, err)
+	}
+
+	transform, _ := c.frameTransform.Load().(frameTransform)
+	if transform.out == nil {
+		return frame, nil
+	}
+
+	data, err := transform.out([]byte(frame))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrorFrameTransform, err)
+	}
+
+	return string(data), nil
+}
+
+func (c *Channel) transformIn(frame string) (string, error) {
+	transform, _ := c.frameTransform.Load().(frameTransform)
+	if transform.in != nil {
+		data, err := transform.in([]byte(frame))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrorFrameTransform, err)
+		}
+		frame = string(data)
+	}
+
+	frame, err := c.unwrapEnvelope(frame)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrorFrameTransform, err)
+	}
+
+	return frame, nil
+}
@@ -0,0 +1,123 @@
+package gophersocket
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+const testXorKey = 0x5a
+
+func xorBytes(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ testXorKey
+	}
+	return out
+}
+
+/**
+Test cipher, xor followed by base64 so frames stay text
+*/
+func xorOut(data []byte) ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(xorBytes(data))), nil
+}
+
+func xorIn(data []byte) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return xorBytes(decoded), nil
+}
+
+func TestFrameTransformRoundTrip(t *testing.T) {
+	s := newTestServer()
+	s.On("echo", func(c *Channel, v string) string { return "re:" + v })
+	s.SetConnectGuard(func(c *Channel) error {
+		c.SetFrameTransform(xorOut, xorIn)
+		return nil
+	})
+
+	hs, url := serveTestServer(s)
+	defer hs.Close()
+	client, err := DialWithOptions(url, transport.GetDefaultWebsocketTransport(), DialOptions{
+		FrameOut: xorOut,
+		FrameIn:  xorIn,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	reply, err := client.Ack("echo", "hi", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != `"re:hi"` {
+		t.Fatal("reply", reply)
+	}
+}
+
+func TestFrameTransformOnWire(t *testing.T) {
+	got := make(chan string, 1)
+	s := newTestServer()
+	s.On("ev", func(c *Channel, v string) { got <- v })
+	h := newOpenHarness(s)
+	h.Channel.SetFrameTransform(xorOut, xorIn)
+
+	h.Channel.Emit("msg", "a")
+	h.Pump()
+	frames := h.Frames()
+	if len(frames) != 1 || frames[0] == `42["msg","a"]` {
+		t.Fatalf("got frames %q, want transformed", frames)
+	}
+	if plain, err := xorIn([]byte(frames[0])); err != nil || string(plain) != `42["msg","a"]` {
+		t.Fatalf("recovered %q, %v", plain, err)
+	}
+
+	frame, _ := xorOut([]byte(`42["ev","b"]`))
+	if err := h.Feed(string(frame)); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-got; v != "b" {
+		t.Fatal("got", v)
+	}
+}
+
+func TestFrameTransformErrorCloses(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.SetFrameTransform(xorOut, xorIn)
+
+	//plain frame is not valid base64
+	if err := h.Feed(`42["ev","b"]`); !errors.Is(err, ErrorFrameTransform) {
+		t.Fatal("feed", err)
+	}
+	waitClosed(t, h.Channel)
+	if h.Channel.CloseReason() != DisconnectTransformError {
+		t.Fatal("reason", h.Channel.CloseReason())
+	}
+	if !errors.Is(h.Channel.CloseError(), ErrorFrameTransform) {
+		t.Fatal("close error", h.Channel.CloseError())
+	}
+}
+
+func TestFrameTransformOutErrorCloses(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	h.Channel.SetFrameTransform(func([]byte) ([]byte, error) {
+		return nil, errors.New("cipher broken")
+	}, nil)
+
+	h.Channel.Emit("msg", "a")
+	h.Pump()
+	waitClosed(t, h.Channel)
+	if h.Channel.CloseReason() != DisconnectTransformError {
+		t.Fatal("reason", h.Channel.CloseReason())
+	}
+	if frames := h.Frames(); len(frames) != 0 {
+		t.Fatalf("frames written %q", frames)
+	}
+}
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+/**
+Response writer on top of raw connection, used to run standard http
+upgrade on connections accepted outside of net/http
+*/
+type connResponseWriter struct {
+	conn        net.Conn
+	header      http.Header
+	wroteHeader bool
+}
+
+func newConnResponseWriter(conn net.Conn) *connResponseWriter {
+	return &connResponseWriter{conn: conn, header: make(http.Header)}
+}
+
+func (w *connResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *connResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	fmt.Fprintf(w.conn, "HTTP/1.1 %03d %s\r\n", status, http.StatusText(status))
+	w.header.Set("Connection", "close")
+	w.header.Write(w.conn)
+	fmt.Fprint(w.conn, "\r\n")
+}
+
+func (w *connResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.conn.Write(data)
+}
+
+/**
+Give the connection away, as http.Hijacker does
+*/
+func (w *connResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"net"
 	"net/http"
 	"time"
 )
@@ -49,3 +50,116 @@ type Transport interface {
 	*/
 	Serve(w http.ResponseWriter, r *http.Request)
 }
+
+/**
+Optional transport interface, for transports having engine.io name
+*/
+type Named interface {
+	/**
+	Get transport name as used in engine.io handshake
+	*/
+	Name() string
+}
+
+/**
+Optional transport interface, for transports able to handle connections
+accepted outside of net/http
+*/
+type ConnHandler interface {
+	/**
+	Handle one server connection, given upgrade request was read from conn,
+	but no response was written yet
+	*/
+	HandleConn(conn net.Conn, r *http.Request) (Connection, error)
+}
+
+/**
+Optional connection interface, for client connections keeping
+response to the connection request
+*/
+type ResponseProvider interface {
+	/**
+	Get response to the connection request, e.g. upgrade response
+	*/
+	Response() *http.Response
+}
+
+/**
+Optional transport interface, for transports able to send custom headers
+with the connection request
+*/
+type HeaderConnector interface {
+	/**
+	Get client connection, given headers are added to the request
+	*/
+	ConnectWithHeader(url string, header http.Header) (conn Connection, err error)
+}
+
+/**
+Optional connection interface, for connections able to tell
+what was negotiated on connect
+*/
+type Inspector interface {
+	/**
+	Check that messages are compressed, e.g. with permessage-deflate
+	*/
+	CompressionEnabled() bool
+
+	/**
+	Get negotiated subprotocol, empty if none
+	*/
+	Subprotocol() string
+}
+
+/**
+Optional transport interface, for transports negotiating
+subprotocol on upgrade
+*/
+type SubprotocolSetter interface {
+	/**
+	Set subprotocols supported by server in order of preference.
+	Client offering none of them is upgraded without subprotocol,
+	or rejected if required
+	*/
+	SetSubprotocols(protocols []string, require bool)
+}
+
+/**
+Optional connection interface, for connections able to decide
+on compression of each message
+*/
+type CompressionWriter interface {
+	/**
+	Same as WriteMessage, with compression enabled or disabled for
+	this message only, ignored if compression was not negotiated
+	*/
+	WriteMessageCompressed(message string, compress bool) error
+}
+
+/**
+Optional connection interface, for connections with ping params
+negotiated per connection instead of the transport ones
+*/
+type PingParamsSetter interface {
+	/**
+	Set params returned by PingParams, should be called before the
+	connection is used. Reads should wait at least interval+timeout
+	*/
+	SetPingParams(interval, timeout time.Duration)
+}
+
+/**
+Optional connection interface, for connections whose writes may fail
+temporarily and succeed when retried on the same connection
+*/
+type WriteRetrier interface {
+	/**
+	Check that write error is temporary, connection is still usable
+	*/
+	TemporaryWriteError(err error) bool
+
+	/**
+	Get overall time limit of writing one message, including retries
+	*/
+	WriteTimeout() time.Duration
+}
@@ -3,8 +3,12 @@ package transport
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -13,6 +17,12 @@ import (
 const (
 	upgradeFailed = "Upgrade failed: "
 
+	WebsocketName = "websocket"
+
+	handshakeBodySnippet = 256
+
+	deflateExtension = "permessage-deflate"
+
 	WsDefaultPingInterval   = 30 * time.Second
 	WsDefaultPingTimeout    = 60 * time.Second
 	WsDefaultReceiveTimeout = 60 * time.Second
@@ -26,22 +36,36 @@ var (
 	ErrorPacketWrong       = errors.New("Wrong packet type error")
 	ErrorMethodNotAllowed  = errors.New("Method not allowed")
 	ErrorHttpUpgradeFailed = errors.New("Http upgrade failed")
+	ErrorClosedByPeer      = errors.New("Connection closed by peer")
+	ErrorSubprotocol       = errors.New("Subprotocol not negotiated")
 )
 
 type WebsocketConnection struct {
 	socket    *websocket.Conn
 	transport *WebsocketTransport
+
+	//upgrade response, client side only
+	response *http.Response
+
+	compressed bool
+
+	//negotiated ping params, transport ones if zero
+	pingInterval time.Duration
+	pingTimeout  time.Duration
 }
 
 func (wsc *WebsocketConnection) GetMessage() (message string, err error) {
-	wsc.socket.SetReadDeadline(time.Now().Add(wsc.transport.ReceiveTimeout))
+	wsc.socket.SetReadDeadline(time.Now().Add(wsc.receiveTimeout()))
 	msgType, reader, err := wsc.socket.NextReader()
+	if _, ok := err.(*websocket.CloseError); ok {
+		return "", fmt.Errorf("%w: %v", ErrorClosedByPeer, err)
+	}
 	if err != nil {
 		return "", err
 	}
 
-	//support only text messages exchange
-	if msgType != websocket.TextMessage {
+	//support only text messages exchange, and binary heartbeat if enabled
+	if msgType != websocket.TextMessage && !wsc.transport.BinaryHeartbeat {
 		return "", ErrorBinaryMessage
 	}
 
@@ -51,6 +75,10 @@ func (wsc *WebsocketConnection) GetMessage() (message string, err error) {
 	}
 	text := string(data)
 
+	if msgType != websocket.TextMessage && !isHeartbeat(text) {
+		return "", ErrorBinaryMessage
+	}
+
 	//empty messages are not allowed
 	if len(text) == 0 {
 		return "", ErrorPacketWrong
@@ -61,7 +89,11 @@ func (wsc *WebsocketConnection) GetMessage() (message string, err error) {
 
 func (wsc *WebsocketConnection) WriteMessage(message string) error {
 	wsc.socket.SetWriteDeadline(time.Now().Add(wsc.transport.SendTimeout))
-	writer, err := wsc.socket.NextWriter(websocket.TextMessage)
+	frameType := websocket.TextMessage
+	if wsc.transport.BinaryHeartbeat && isHeartbeat(message) {
+		frameType = websocket.BinaryMessage
+	}
+	writer, err := wsc.socket.NextWriter(frameType)
 	if err != nil {
 		return err
 	}
@@ -75,14 +107,86 @@ func (wsc *WebsocketConnection) WriteMessage(message string) error {
 	return nil
 }
 
+func (wsc *WebsocketConnection) WriteMessageCompressed(message string, compress bool) error {
+	if !wsc.compressed {
+		return wsc.WriteMessage(message)
+	}
+
+	wsc.socket.EnableWriteCompression(compress)
+	defer wsc.socket.EnableWriteCompression(true)
+
+	return wsc.WriteMessage(message)
+}
+
+/**
+Check that packet is engine.io ping or pong, including ones with payload
+*/
+func isHeartbeat(packet string) bool {
+	return packet != "" && (packet[0] == '2' || packet[0] == '3')
+}
+
+/**
+Get response to upgrade request, nil on server side
+*/
+func (wsc *WebsocketConnection) Response() *http.Response {
+	return wsc.response
+}
+
+/**
+Check that permessage-deflate was negotiated for the connection
+*/
+func (wsc *WebsocketConnection) CompressionEnabled() bool {
+	return wsc.compressed
+}
+
+/**
+Get subprotocol negotiated for the connection, empty if none
+*/
+func (wsc *WebsocketConnection) Subprotocol() string {
+	return wsc.socket.Subprotocol()
+}
+
+/**
+Check that given extensions header has permessage-deflate
+*/
+func hasDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(value, deflateExtension) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (wsc *WebsocketConnection) Close() {
 	wsc.socket.Close()
 }
 
 func (wsc *WebsocketConnection) PingParams() (interval, timeout time.Duration) {
+	if wsc.pingInterval > 0 {
+		return wsc.pingInterval, wsc.pingTimeout
+	}
+
 	return wsc.transport.PingInterval, wsc.transport.PingTimeout
 }
 
+func (wsc *WebsocketConnection) SetPingParams(interval, timeout time.Duration) {
+	wsc.pingInterval, wsc.pingTimeout = interval, timeout
+}
+
+/**
+Get read timeout, extended to negotiated ping interval and timeout
+*/
+func (wsc *WebsocketConnection) receiveTimeout() time.Duration {
+	timeout := wsc.transport.ReceiveTimeout
+	if negotiated := wsc.pingInterval + wsc.pingTimeout; wsc.pingInterval > 0 && negotiated > timeout {
+		timeout = negotiated
+	}
+
+	return timeout
+}
+
 type WebsocketTransport struct {
 	PingInterval   time.Duration
 	PingTimeout    time.Duration
@@ -92,17 +196,67 @@ type WebsocketTransport struct {
 	BufferSize  int
 	UnsecureTLS bool
 
+	//ping and pong are sent as binary frames, for binary-only peers
+	BinaryHeartbeat bool
+
+	//subprotocols offered by client, or supported by server in order of preference
+	Subprotocols []string
+
+	//fail the upgrade if none of Subprotocols is agreed on with the peer
+	RequireSubprotocol bool
+
+	//negotiate permessage-deflate, if the peer supports it
+	EnableCompression bool
+
 	RequestHeader http.Header
 }
 
 func (wst *WebsocketTransport) Connect(url string) (conn Connection, err error) {
-	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: wst.UnsecureTLS}}
-	socket, _, err := dialer.Dial(url, wst.RequestHeader)
+	return wst.ConnectWithHeader(url, nil)
+}
+
+/**
+Connect with RequestHeader of the transport and given headers,
+the given ones replace transport headers with the same name
+*/
+func (wst *WebsocketTransport) ConnectWithHeader(url string, header http.Header) (conn Connection, err error) {
+	requestHeader := wst.RequestHeader.Clone()
+	for name, values := range header {
+		if requestHeader == nil {
+			requestHeader = make(http.Header)
+		}
+		requestHeader[http.CanonicalHeaderKey(name)] = values
+	}
+
+	dialer := websocket.Dialer{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: wst.UnsecureTLS},
+		Subprotocols:      wst.Subprotocols,
+		EnableCompression: wst.EnableCompression,
+	}
+	socket, resp, err := dialer.Dial(url, requestHeader)
+	if err == websocket.ErrBadHandshake && resp != nil {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, handshakeBodySnippet))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: status %d, body %q", ErrorHttpUpgradeFailed, resp.StatusCode, body)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return &WebsocketConnection{socket, wst}, nil
+	//server may only select one of offered subprotocols, RFC 6455 4.1
+	selected := socket.Subprotocol()
+	if (selected != "" && !containsString(wst.Subprotocols, selected)) ||
+		(selected == "" && wst.RequireSubprotocol) {
+		socket.Close()
+		return nil, fmt.Errorf("%w: offered %v, selected %q", ErrorSubprotocol, wst.Subprotocols, selected)
+	}
+
+	return &WebsocketConnection{
+		socket:     socket,
+		transport:  wst,
+		response:   resp,
+		compressed: wst.EnableCompression && hasDeflate(resp.Header),
+	}, nil
 }
 
 func (wst *WebsocketTransport) HandleConnection(
@@ -113,13 +267,80 @@ func (wst *WebsocketTransport) HandleConnection(
 		return nil, ErrorMethodNotAllowed
 	}
 
-	socket, err := websocket.Upgrade(w, r, nil, wst.BufferSize, wst.BufferSize)
+	if wst.RequireSubprotocol && !wst.agreesSubprotocol(r) {
+		http.Error(w, upgradeFailed+ErrorSubprotocol.Error(), http.StatusBadRequest)
+		return nil, ErrorSubprotocol
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    wst.BufferSize,
+		WriteBufferSize:   wst.BufferSize,
+		Subprotocols:      wst.Subprotocols,
+		EnableCompression: wst.EnableCompression,
+		//same as websocket.Upgrade, origin is checked by the application
+		CheckOrigin: func(r *http.Request) bool { return true },
+		Error:       func(w http.ResponseWriter, r *http.Request, status int, reason error) {},
+	}
+
+	//connection is hijacked, so headers set on w are sent only this way
+	socket, err := upgrader.Upgrade(w, r, w.Header())
 	if err != nil {
 		http.Error(w, upgradeFailed+err.Error(), 503)
 		return nil, ErrorHttpUpgradeFailed
 	}
 
-	return &WebsocketConnection{socket, wst}, nil
+	return &WebsocketConnection{
+		socket:     socket,
+		transport:  wst,
+		compressed: wst.EnableCompression && hasDeflate(r.Header),
+	}, nil
+}
+
+/**
+Handle connection accepted outside of net/http, the upgrade request
+should be read from conn already, upgrade response is written here
+*/
+func (wst *WebsocketTransport) HandleConn(conn net.Conn, r *http.Request) (Connection, error) {
+	return wst.HandleConnection(newConnResponseWriter(conn), r)
+}
+
+/**
+Set Subprotocols and RequireSubprotocol, should be called
+before the transport is used
+*/
+func (wst *WebsocketTransport) SetSubprotocols(protocols []string, require bool) {
+	wst.Subprotocols = append([]string(nil), protocols...)
+	wst.RequireSubprotocol = require
+}
+
+/**
+Check that client offers one of supported subprotocols
+*/
+func (wst *WebsocketTransport) agreesSubprotocol(r *http.Request) bool {
+	for _, offered := range websocket.Subprotocols(r) {
+		if containsString(wst.Subprotocols, offered) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+Get transport name as used in engine.io handshake
+*/
+func (wst *WebsocketTransport) Name() string {
+	return WebsocketName
 }
 
 /**
@@ -0,0 +1,196 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+/**
+Serve one connection of given transport, calling serve with it,
+and dial it with plain websocket client
+*/
+func dialTestTransport(t *testing.T, tr *WebsocketTransport, serve func(conn Connection)) (*websocket.Conn, func()) {
+	t.Helper()
+
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := tr.HandleConnection(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}))
+
+	socket, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		hs.Close()
+		t.Fatal(err)
+	}
+
+	return socket, func() {
+		socket.Close()
+		hs.Close()
+	}
+}
+
+func expectFrame(t *testing.T, socket *websocket.Conn, frameType int, data string) {
+	t.Helper()
+
+	gotType, got, err := socket.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotType != frameType || string(got) != data {
+		t.Fatalf("got frame %d %q, want %d %q", gotType, got, frameType, data)
+	}
+}
+
+func TestBinaryHeartbeat(t *testing.T) {
+	tr := GetDefaultWebsocketTransport()
+	tr.BinaryHeartbeat = true
+
+	received := make(chan string, 2)
+	socket, done := dialTestTransport(t, tr, func(conn Connection) {
+		conn.WriteMessage("2")
+		conn.WriteMessage(`42["ev"]`)
+		for i := 0; i < 2; i++ {
+			msg, err := conn.GetMessage()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			received <- msg
+		}
+	})
+	defer done()
+
+	//heartbeat goes as binary, other packets as text
+	expectFrame(t, socket, websocket.BinaryMessage, "2")
+	expectFrame(t, socket, websocket.TextMessage, `42["ev"]`)
+
+	socket.WriteMessage(websocket.BinaryMessage, []byte("3"))
+	socket.WriteMessage(websocket.TextMessage, []byte("2"))
+	if got := <-received; got != "3" {
+		t.Fatal("binary pong not read:", got)
+	}
+	if got := <-received; got != "2" {
+		t.Fatal("text ping not read:", got)
+	}
+}
+
+func TestBinaryHeartbeatRejectsBinaryPackets(t *testing.T) {
+	tr := GetDefaultWebsocketTransport()
+	tr.BinaryHeartbeat = true
+
+	result := make(chan error, 1)
+	socket, done := dialTestTransport(t, tr, func(conn Connection) {
+		_, err := conn.GetMessage()
+		result <- err
+	})
+	defer done()
+
+	socket.WriteMessage(websocket.BinaryMessage, []byte(`42["ev"]`))
+	if err := <-result; err != ErrorBinaryMessage {
+		t.Fatal("binary event accepted:", err)
+	}
+}
+
+func TestTextHeartbeat(t *testing.T) {
+	tr := GetDefaultWebsocketTransport()
+
+	result := make(chan error, 1)
+	socket, done := dialTestTransport(t, tr, func(conn Connection) {
+		conn.WriteMessage("2")
+		_, err := conn.GetMessage()
+		result <- err
+	})
+	defer done()
+
+	expectFrame(t, socket, websocket.TextMessage, "2")
+	socket.WriteMessage(websocket.BinaryMessage, []byte("3"))
+	if err := <-result; err != ErrorBinaryMessage {
+		t.Fatal("binary pong accepted without BinaryHeartbeat:", err)
+	}
+}
+
+/**
+Serve websocket upgrades selecting given subprotocol whatever is offered
+*/
+func serveSubprotocol(selected string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{}
+		if selected != "" {
+			header.Set("Sec-Websocket-Protocol", selected)
+		}
+		socket, err := (&websocket.Upgrader{}).Upgrade(w, r, header)
+		if err != nil {
+			return
+		}
+		socket.Close()
+	}))
+}
+
+func TestClientRejectsSubprotocolNotOffered(t *testing.T) {
+	hs := serveSubprotocol("v9.app")
+	defer hs.Close()
+
+	tr := GetDefaultWebsocketTransport()
+	tr.Subprotocols = []string{"v1.app"}
+	if _, err := tr.Connect("ws" + strings.TrimPrefix(hs.URL, "http")); !errors.Is(err, ErrorSubprotocol) {
+		t.Fatal("selected subprotocol not offered accepted:", err)
+	}
+}
+
+func TestClientRequiresSubprotocol(t *testing.T) {
+	hs := serveSubprotocol("")
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	tr := GetDefaultWebsocketTransport()
+	tr.Subprotocols = []string{"v1.app"}
+	conn, err := tr.Connect(url)
+	if err != nil {
+		t.Fatal("no subprotocol selected without requiring one:", err)
+	}
+	conn.Close()
+
+	tr.RequireSubprotocol = true
+	if _, err := tr.Connect(url); !errors.Is(err, ErrorSubprotocol) {
+		t.Fatal("missing subprotocol accepted:", err)
+	}
+}
+
+func TestServerRequiresSubprotocol(t *testing.T) {
+	tr := GetDefaultWebsocketTransport()
+	tr.Subprotocols = []string{"v2.app", "v1.app"}
+	tr.RequireSubprotocol = true
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := tr.HandleConnection(w, r); err == nil {
+			conn.Close()
+		}
+	}))
+	defer hs.Close()
+	url := "ws" + strings.TrimPrefix(hs.URL, "http")
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v3.app"}}
+	_, resp, err := dialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("unsupported subprotocol upgraded:", err)
+	}
+
+	dialer.Subprotocols = []string{"v3.app", "v1.app"}
+	socket, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	if socket.Subprotocol() != "v1.app" {
+		t.Fatal("negotiated", socket.Subprotocol())
+	}
+}
@@ -0,0 +1,197 @@
+package gophersocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/whiterabb17/gopher-socket/protocol"
+)
+
+const (
+	MetricWriterDropped = "writer_events_dropped_total"
+
+	//partial line longer than this is sent without waiting for newline
+	maxWriterLine = 64 * 1024
+)
+
+var (
+	ErrorWriterClosed = errors.New("Writer closed")
+)
+
+/**
+Writer framing written data as events, see Channel.EventWriter
+*/
+type eventWriter struct {
+	lines bool
+	send  func(payload string) error
+
+	partial []byte
+	closed  bool
+	lock    sync.Mutex
+}
+
+/**
+Get writer emitting each Write as given event with string argument,
+e.g. for log forwarding. While out queue of the channel is congested,
+events are dropped instead of queued, so bursts can not overflow it.
+Writes are safe for concurrent use and emitted in the order they are
+done. Close stops forwarding, the channel is left open. Write fails
+once the channel is closed
+*/
+func (c *Channel) EventWriter(event string) io.WriteCloser {
+	return &eventWriter{send: func(payload string) error {
+		return c.emitVolatile(event, payload)
+	}}
+}
+
+/**
+Same as EventWriter, but each newline delimited line is emitted,
+without the newline. Incomplete line is kept until its newline comes,
+or the writer is closed, so concurrent writers should write whole lines
+*/
+func (c *Channel) EventLineWriter(event string) io.WriteCloser {
+	w := c.EventWriter(event).(*eventWriter)
+	w.lines = true
+	return w
+}
+
+/**
+Get writer broadcasting each Write to room members as given event,
+members with congested out queue miss it. Same as EventWriter otherwise,
+except that it does not fail when the room is empty
+*/
+func (s *Server) RoomWriter(room, event string) io.WriteCloser {
+	return &eventWriter{send: func(payload string) error {
+		return s.broadcastVolatile(room, event, payload)
+	}}
+}
+
+/**
+Same as RoomWriter, but each newline delimited line is broadcast
+*/
+func (s *Server) RoomLineWriter(room, event string) io.WriteCloser {
+	w := s.RoomWriter(room, event).(*eventWriter)
+	w.lines = true
+	return w
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, ErrorWriterClosed
+	}
+	if !w.lines {
+		return len(p), w.send(string(p))
+	}
+
+	w.partial = append(w.partial, p...)
+	start := 0
+	var err error
+	for err == nil {
+		i := bytes.IndexByte(w.partial[start:], '\n')
+		if i < 0 {
+			if len(w.partial)-start > maxWriterLine {
+				err = w.send(string(w.partial[start:]))
+				start = len(w.partial)
+			}
+			break
+		}
+		err = w.send(string(bytes.TrimSuffix(w.partial[start:start+i], []byte("\r"))))
+		start += i + 1
+	}
+	w.partial = w.partial[:copy(w.partial, w.partial[start:])]
+
+	return len(p), err
+}
+
+/**
+Stop forwarding, incomplete line is sent first
+*/
+func (w *eventWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if len(w.partial) == 0 {
+		return nil
+	}
+	partial := string(w.partial)
+	w.partial = nil
+	return w.send(partial)
+}
+
+/**
+Check if out queue of the channel is over half full,
+by count of messages or by their size
+*/
+func (c *Channel) congested() bool {
+	if len(c.out) > cap(c.out)/2 {
+		return true
+	}
+
+	maxBytes := c.maxOutBytes()
+	return maxBytes > 0 && atomic.LoadInt64(&c.outBytes) > maxBytes/2
+}
+
+/**
+Emit event unless the channel is congested, dropped event is not an error
+*/
+func (c *Channel) emitVolatile(event string, payload string) error {
+	if !c.IsAlive() {
+		return ErrorChannelClosed
+	}
+
+	if !c.congested() {
+		err := c.Emit(event, payload)
+		if !errors.Is(err, ErrorSocketOverflood) {
+			return err
+		}
+	}
+
+	if c.shared != nil {
+		c.shared.metricAdd(MetricWriterDropped, 1, "event", event)
+	}
+	return nil
+}
+
+/**
+Send event to alive room members, except the congested ones
+*/
+func (s *Server) broadcastVolatile(room, event string, payload string) error {
+	if allowed, _ := s.allowBroadcast(room, event); !allowed {
+		s.metricAdd(MetricWriterDropped, 1, "event", event)
+		return nil
+	}
+
+	command, err := encode(s.getCodec(), protocol.NewEvent("", event, nil), payload)
+	if err != nil {
+		return err
+	}
+
+	s.channelsLock.RLock()
+	defer s.channelsLock.RUnlock()
+
+	dropped := 0
+	for cn := range s.channels[room] {
+		if !cn.IsAlive() || cn.Muted(event) {
+			continue
+		}
+		if cn.congested() || cn.enqueueBroadcast(command) != nil {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		s.metricAdd(MetricWriterDropped, float64(dropped), "event", event)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package gophersocket
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventWriter(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	w := h.Channel.EventWriter("log")
+
+	for _, p := range []string{"a\nb", "c"} {
+		if n, err := w.Write([]byte(p)); err != nil || n != len(p) {
+			t.Fatal(n, err)
+		}
+	}
+	//each write is one event, newlines are kept
+	expectFrames(t, h, `42["log","a\nb"]`, `42["log","c"]`)
+}
+
+func TestEventLineWriter(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	w := h.Channel.EventLineWriter("log")
+
+	for _, p := range []string{"a\nb", "c\r\n", "\nd"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectFrames(t, h, `42["log","a"]`, `42["log","bc"]`, `42["log",""]`)
+
+	//incomplete line is flushed on close
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["log","d"]`)
+}
+
+func TestEventLineWriterLongLine(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	w := h.Channel.EventLineWriter("log")
+
+	line := strings.Repeat("x", maxWriterLine+1)
+	if _, err := w.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["log","`+line+`"]`)
+}
+
+func TestEventWriterClosed(t *testing.T) {
+	h := newOpenHarness(newTestServer())
+	w := h.Channel.EventWriter("log")
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("a")); err != ErrorWriterClosed {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("second close", err)
+	}
+	expectFrames(t, h)
+
+	//writer of closed channel fails
+	w = h.Channel.EventWriter("log")
+	h.Channel.Close()
+	if _, err := w.Write([]byte("a")); err != ErrorChannelClosed {
+		t.Fatal(err)
+	}
+}
+
+func TestEventWriterDropsOnCongestion(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	s.SetMaxOutBytes(40)
+	h := newOpenHarness(s)
+	w := h.Channel.EventWriter("log")
+
+	//queued, not written yet, over half of the limit
+	if err := h.Channel.Emit("big", strings.Repeat("x", 20)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Fatal("dropped write failed", err)
+	}
+	if metrics.get(MetricWriterDropped, "event", "log") != 1 {
+		t.Fatal("drop not counted")
+	}
+	expectFrames(t, h, `42["big","`+strings.Repeat("x", 20)+`"]`)
+
+	//written once the queue drained
+	if _, err := w.Write([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	expectFrames(t, h, `42["log","b"]`)
+}
+
+func TestRoomLineWriter(t *testing.T) {
+	s := newTestServer()
+	metrics := &counterMetrics{}
+	s.SetMetrics(metrics)
+	s.SetMaxOutBytes(40)
+	free, congested := newOpenHarness(s), newOpenHarness(s)
+	for _, h := range []*LoopHarness{free, congested} {
+		if err := h.Channel.Join("logs"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := congested.Channel.Emit("big", strings.Repeat("x", 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	w := s.RoomLineWriter("logs", "log")
+	for _, p := range []string{"a", "b\nc"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("d\n")); err != ErrorWriterClosed {
+		t.Fatal(err)
+	}
+
+	expectFrames(t, free, `42["log","ab"]`, `42["log","c"]`)
+	expectFrames(t, congested, `42["big","`+strings.Repeat("x", 20)+`"]`)
+	if n := metrics.get(MetricWriterDropped, "event", "log"); n != 2 {
+		t.Fatal("dropped", n)
+	}
+
+	//empty room is not an error
+	if _, err := s.RoomWriter("empty", "log").Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+}
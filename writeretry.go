@@ -0,0 +1,77 @@
+package gophersocket
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/whiterabb17/gopher-socket/transport"
+)
+
+const (
+	/**
+	Writes retried after temporary transport error
+	*/
+	MetricWriteRetries = "write_retries_total"
+
+	DefaultWriteRetries      = 3
+	DefaultWriteRetryBackoff = 50 * time.Millisecond
+)
+
+type writeRetry struct {
+	retries int
+	backoff time.Duration
+}
+
+/**
+Set how many times a write failed with temporary error is retried, and
+delay before each retry. Zero values use defaults, negative retries
+disable retrying. Has effect only on connections implementing
+transport.WriteRetrier, retries stop at its WriteTimeout
+*/
+func (m *methods) SetWriteRetry(retries int, backoff time.Duration) {
+	m.writeRetry.Store(writeRetry{retries: retries, backoff: backoff})
+}
+
+func (m *methods) getWriteRetry() writeRetry {
+	policy, _ := m.writeRetry.Load().(writeRetry)
+	if policy.retries == 0 {
+		policy.retries = DefaultWriteRetries
+	}
+	if policy.backoff <= 0 {
+		policy.backoff = DefaultWriteRetryBackoff
+	}
+
+	return policy
+}
+
+/**
+Retry write started at given time while it fails with temporary error,
+returns error of the last attempt
+*/
+func (c *Channel) retryWrite(m *methods, conn transport.Connection, data string, started time.Time, err error) error {
+	retrier, ok := conn.(transport.WriteRetrier)
+	if err == nil || !ok {
+		return err
+	}
+
+	policy := m.getWriteRetry()
+	clock := c.clock()
+	deadline := started.Add(retrier.WriteTimeout())
+	for retry := 0; retry < policy.retries && retrier.TemporaryWriteError(err); retry++ {
+		if clock.Now().Add(policy.backoff).After(deadline) {
+			return err
+		}
+
+		select {
+		case <-c.closed:
+			return err
+		case <-clock.After(policy.backoff):
+		}
+
+		atomic.AddInt64(&c.writeRetries, 1)
+		m.metricAdd(MetricWriteRetries, 1)
+		err = writePacket(m, conn, data)
+	}
+
+	return err
+}
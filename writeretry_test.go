@@ -0,0 +1,128 @@
+package gophersocket
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTestTemporary = errors.New("write temporarily failed")
+
+/**
+Pipe connection failing writes of "42" frames with given errors first
+*/
+type flakyConn struct {
+	*pipeConn
+	errs    chan error
+	timeout time.Duration
+	writes  int32
+}
+
+func newFlakyConn(timeout time.Duration, errs ...error) *flakyConn {
+	conn := &flakyConn{pipeConn: newPipeConn(), errs: make(chan error, len(errs)), timeout: timeout}
+	for _, err := range errs {
+		conn.errs <- err
+	}
+	return conn
+}
+
+func (f *flakyConn) WriteMessage(msg string) error {
+	if strings.HasPrefix(msg, "42") {
+		atomic.AddInt32(&f.writes, 1)
+		select {
+		case err := <-f.errs:
+			return err
+		default:
+		}
+	}
+	return f.pipeConn.WriteMessage(msg)
+}
+
+func (f *flakyConn) TemporaryWriteError(err error) bool {
+	return err == errTestTemporary
+}
+
+func (f *flakyConn) WriteTimeout() time.Duration {
+	return f.timeout
+}
+
+/**
+Wait for written frame, skipping others
+*/
+func readWritten(t testing.TB, conn *flakyConn, frame string) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-conn.out:
+			if got == frame {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("frame %q not written", frame)
+		}
+	}
+}
+
+func TestWriteRetryTemporaryError(t *testing.T) {
+	s := newTestServer()
+	s.SetWriteRetry(3, time.Millisecond)
+	conn := newFlakyConn(time.Second, errTestTemporary, errTestTemporary)
+	c := s.setupChannel(conn, "", "pipe", nil, false)
+
+	c.Emit("ev", 1)
+	readWritten(t, conn, `42["ev",1]`)
+	if !c.IsAlive() {
+		t.Fatal("closed by temporary error")
+	}
+	if n := c.Stats().WriteRetries; n != 2 {
+		t.Fatalf("%d retries, want 2", n)
+	}
+	c.Close()
+}
+
+func TestWriteRetryFatalError(t *testing.T) {
+	s := newTestServer()
+	s.SetWriteRetry(3, time.Millisecond)
+	conn := newFlakyConn(time.Second, errTestWrite)
+	c := s.setupChannel(conn, "", "pipe", nil, false)
+
+	c.Emit("ev", 1)
+	waitClosed(t, c)
+	if c.CloseError() != errTestWrite {
+		t.Fatal("close error", c.CloseError())
+	}
+	if n := atomic.LoadInt32(&conn.writes); n != 1 || c.Stats().WriteRetries != 0 {
+		t.Fatalf("fatal error retried, %d writes", n)
+	}
+}
+
+func TestWriteRetryExhausted(t *testing.T) {
+	s := newTestServer()
+	s.SetWriteRetry(1, time.Millisecond)
+	conn := newFlakyConn(time.Second, errTestTemporary, errTestTemporary)
+	c := s.setupChannel(conn, "", "pipe", nil, false)
+
+	c.Emit("ev", 1)
+	waitClosed(t, c)
+	if n := atomic.LoadInt32(&conn.writes); n != 2 || c.Stats().WriteRetries != 1 {
+		t.Fatalf("%d writes, %d retries", n, c.Stats().WriteRetries)
+	}
+}
+
+func TestWriteRetryStopsAtWriteTimeout(t *testing.T) {
+	s := newTestServer()
+	s.SetWriteRetry(5, 50*time.Millisecond)
+	//next retry would pass the timeout, so none is done
+	conn := newFlakyConn(10*time.Millisecond, errTestTemporary)
+	c := s.setupChannel(conn, "", "pipe", nil, false)
+
+	c.Emit("ev", 1)
+	waitClosed(t, c)
+	if n := atomic.LoadInt32(&conn.writes); n != 1 {
+		t.Fatalf("%d writes past write timeout", n)
+	}
+}